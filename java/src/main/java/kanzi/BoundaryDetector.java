@@ -0,0 +1,29 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi;
+
+
+// Content defined block boundaries (EG. at record or page boundaries). The
+// compressed stream calls the detector for each candidate end of block with a
+// rolling window holding the last bytes of the block. The decoder does not
+// depend on the way the boundaries are chosen (the size of each block is
+// recorded in its header).
+public interface BoundaryDetector
+{
+   // Return true to end the current block after array[index+length-1]. The
+   // window holds the last 'length' bytes of the block.
+   public boolean cut(byte[] array, int index, int length);
+}
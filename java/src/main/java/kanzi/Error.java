@@ -37,6 +37,8 @@ public final class Error
    public static final int ERR_CREATE_STREAM       = 17;
    public static final int ERR_INVALID_PARAM       = 18;
    public static final int ERR_CRC_CHECK           = 19;
+   public static final int ERR_OUTPUT_LIMIT        = 20;
+   public static final int ERR_SHORT_BUFFER        = 21;
    public static final int ERR_UNKNOWN             = 127;
    
    private Error()
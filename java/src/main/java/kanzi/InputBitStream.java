@@ -30,6 +30,11 @@ public interface InputBitStream
    // Throws if the stream is closed.
    public int readBits(byte[] bits, int start, int length) throws BitStreamException;
 
+   // Read a word of 'bytes' (in [1..8]) bytes in the given byte order.
+   // Return the value of the word as a long.
+   // Throws if the stream is closed.
+   public long readWord(int bytes, boolean bigEndian) throws BitStreamException;
+
    public void close() throws BitStreamException;
 
    // Number of bits read
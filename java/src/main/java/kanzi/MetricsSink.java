@@ -0,0 +1,54 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi;
+
+
+// Destination of the stream metrics (EG. an adapter to Prometheus gauges).
+// The compressed streams report the metrics below when they are closed.
+// Per codec metrics use the Prometheus label syntax in the name, EG.
+// kanzi_compress_entropy_blocks_total{codec="ANS0"}
+public interface MetricsSink
+{
+   // Number of blocks
+   public static final String COMPRESS_BLOCKS = "kanzi_compress_blocks_total";
+   public static final String DECOMPRESS_BLOCKS = "kanzi_decompress_blocks_total";
+
+   // Uncompressed and compressed sizes in bytes
+   public static final String COMPRESS_INPUT_BYTES = "kanzi_compress_input_bytes_total";
+   public static final String COMPRESS_OUTPUT_BYTES = "kanzi_compress_output_bytes_total";
+   public static final String DECOMPRESS_INPUT_BYTES = "kanzi_decompress_input_bytes_total";
+   public static final String DECOMPRESS_OUTPUT_BYTES = "kanzi_decompress_output_bytes_total";
+
+   // Compressed size / uncompressed size
+   public static final String COMPRESS_RATIO = "kanzi_compress_ratio";
+   public static final String DECOMPRESS_RATIO = "kanzi_decompress_ratio";
+
+   // Blocks per second (from the first block to the end of the stream)
+   public static final String COMPRESS_BLOCKS_PER_SECOND = "kanzi_compress_blocks_per_second";
+   public static final String DECOMPRESS_BLOCKS_PER_SECOND = "kanzi_decompress_blocks_per_second";
+
+   // Number of blocks per entropy codec and per transform (label 'codec')
+   public static final String COMPRESS_ENTROPY_BLOCKS = "kanzi_compress_entropy_blocks_total";
+   public static final String COMPRESS_TRANSFORM_BLOCKS = "kanzi_compress_transform_blocks_total";
+   public static final String DECOMPRESS_ENTROPY_BLOCKS = "kanzi_decompress_entropy_blocks_total";
+   public static final String DECOMPRESS_TRANSFORM_BLOCKS = "kanzi_decompress_transform_blocks_total";
+
+   // Number of blocks with a checksum mismatch
+   public static final String DECOMPRESS_CHECKSUM_FAILURES = "kanzi_decompress_checksum_failures_total";
+
+
+   public void observe(String name, double value);
+}
@@ -31,6 +31,12 @@ public interface OutputBitStream
    // Throws if the stream is closed.
    public int writeBits(byte[] bits, int start, int nbBits) throws BitStreamException;
 
+   // Write the 'bytes' (in [1..8]) least significant bytes of the value as a
+   // word in the given byte order (EG. a field of a foreign binary format).
+   // Return the number of bits written.
+   // Throws if the stream is closed.
+   public int writeWord(long value, int bytes, boolean bigEndian) throws BitStreamException;
+
    public void close() throws BitStreamException;
 
    // Number of bits written
@@ -0,0 +1,34 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi;
+
+import java.io.IOException;
+import java.io.InputStream;
+import java.io.OutputStream;
+
+
+// Storage of the shards of a sharded compressed stream (EG. one file or one
+// object per block). Each compressed block is written to its own shard and
+// the compressed stream itself becomes a manifest listing the shards.
+public interface ShardFactory
+{
+   // Return a new stream to write the shard of a block (block ids start at 1).
+   // The stream is closed once the block is written.
+   public OutputStream createShard(int blockId) throws IOException;
+
+   // Return a stream to read the shard of a block
+   public InputStream openShard(int blockId) throws IOException;
+}
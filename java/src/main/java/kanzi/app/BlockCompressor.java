@@ -37,11 +37,14 @@ import java.util.concurrent.Future;
 import kanzi.Event;
 import kanzi.SliceByteArray;
 import kanzi.function.ByteFunctionFactory;
+import kanzi.io.ChecksumKind;
 import kanzi.io.CompressedOutputStream;
 import kanzi.Error;
 import kanzi.Global;
 import kanzi.io.NullOutputStream;
 import kanzi.Listener;
+import kanzi.transform.BWT;
+import kanzi.transform.BWTS;
 
 
 public class BlockCompressor implements Runnable, Callable<Integer>
@@ -49,6 +52,7 @@ public class BlockCompressor implements Runnable, Callable<Integer>
    private static final int DEFAULT_BUFFER_SIZE = 65536;
    private static final int DEFAULT_BLOCK_SIZE  = 1024*1024; 
    private static final int DEFAULT_CONCURRENCY = 1;
+   private static final int MIN_BLOCK_SIZE = 1024;
    private static final int MAX_CONCURRENCY = 64;  
    private static final String STDOUT = "STDOUT";
    private static final String STDIN = "STDIN";
@@ -56,17 +60,20 @@ public class BlockCompressor implements Runnable, Callable<Integer>
    
    private int verbosity;
    private final boolean overwrite;
-   private final boolean checksum;
+   private final ChecksumKind checksumKind;
    private final boolean skipBlocks;
+   private final boolean storeOnly;
    private final String inputName;
    private final String outputName;
    private final String codec;
    private final String transform;
-   private final int blockSize;
+   private int blockSize;
    private final int level; // command line compression level
    private final int jobs;
    private final List<Listener> listeners;
    private final ExecutorService pool;
+   private long memoryBudget;
+   private boolean lowMemoryBWT;
 
    
    public BlockCompressor(Map<String, Object> map)
@@ -104,8 +111,18 @@ public class BlockCompressor implements Runnable, Callable<Integer>
       // Extract transform names. Curate input (EG. NONE+NONE+xxxx => xxxx)          
       ByteFunctionFactory bff = new ByteFunctionFactory();      
       this.transform = (strTransf == null) ? "BWT+RANK+ZRLT" : bff.getName(bff.getType(strTransf));
+      // No transform and no entropy codec (EG. level 0): store the blocks
+      Boolean bStore = (Boolean) map.remove("storeOnly");
+      this.storeOnly = (bStore != null) ? bStore :
+         ("NONE".equals(this.transform) && "NONE".equalsIgnoreCase(this.codec));
       Boolean bChecksum = (Boolean) map.remove("checksum");
-      this.checksum = (bChecksum == null) ? false : bChecksum;
+      String strChecksum = (String) map.remove("checksumKind");
+
+      if (strChecksum != null)
+         this.checksumKind = ChecksumKind.getKind(strChecksum);
+      else
+         this.checksumKind = (Boolean.TRUE.equals(bChecksum)) ? ChecksumKind.XXHASH32 : ChecksumKind.NONE;
+
       this.verbosity = (Integer) map.remove("verbose");
       int concurrency = (Integer) map.remove("jobs");
 
@@ -129,6 +146,111 @@ public class BlockCompressor implements Runnable, Callable<Integer>
    }
  
 
+   // Cap the estimated peak memory used by the block buffers and the transform.
+   // If the budget is exceeded, the low memory BWT (serialized suffix sorting,
+   // buffers released after each block) is selected and, if required, the
+   // block size is reduced. BWTS has no low memory mode: only the block size
+   // is reduced. A budget of 0 means no limit.
+   public void setMemoryBudget(long bytes)
+   {
+      if (bytes < 0)
+         throw new IllegalArgumentException("Invalid memory budget: "+bytes);
+
+      this.memoryBudget = bytes;
+      this.lowMemoryBWT = false;
+
+      if (bytes == 0)
+         return;
+
+      final int bwt = this.getStages("BWT");
+      final int bwts = this.getStages("BWTS");
+
+      if (estimateMemory(this.blockSize, this.jobs, bwt, bwts, false) <= bytes)
+         return;
+
+      this.lowMemoryBWT = bwt > 0;
+      int bSize = this.blockSize;
+
+      while (estimateMemory(bSize, this.jobs, bwt, bwts, this.lowMemoryBWT) > bytes)
+      {
+         if (bSize == MIN_BLOCK_SIZE)
+         {
+            throw new IllegalArgumentException("The memory budget ("+bytes+" bytes) is too small, at least "+
+               estimateMemory(MIN_BLOCK_SIZE, this.jobs, bwt, bwts, this.lowMemoryBWT)+" bytes are required");
+         }
+
+         bSize = Math.max(((bSize>>1) + 15) & -16, MIN_BLOCK_SIZE);
+      }
+
+      this.blockSize = bSize;
+   }
+
+
+   public long getMemoryBudget()
+   {
+      return this.memoryBudget;
+   }
+
+
+   public int getBlockSize()
+   {
+      return this.blockSize;
+   }
+
+
+   public boolean isLowMemoryBWT()
+   {
+      return this.lowMemoryBWT;
+   }
+
+
+   // Number of stages of a transform in the sequence (eg. 2 BWT stages for
+   // BWT+MTFT+BWT)
+   private int getStages(String name)
+   {
+      int res = 0;
+
+      for (String t : this.transform.split("\\+"))
+      {
+         if (name.equals(t))
+            res++;
+      }
+
+      return res;
+   }
+
+
+   public static long estimateMemory(int blockSize, int jobs, boolean bwt, boolean lowMemory)
+   {
+      return estimateMemory(blockSize, jobs, (bwt == true) ? 1 : 0, lowMemory);
+   }
+
+
+   // Estimated peak memory: block buffers (3 bytes per symbol) for each job
+   // plus the BWT working set for each BWT stage of each job (the transforms
+   // are created for each block and the BWT stages of a block keep their
+   // buffers until the end of the block), or only once in low memory mode
+   // (the BWTs of a run share a lock and release their buffers after use).
+   public static long estimateMemory(int blockSize, int jobs, int bwtStages, boolean lowMemory)
+   {
+      return estimateMemory(blockSize, jobs, bwtStages, 0, lowMemory);
+   }
+
+
+   // Same as above with BWTS stages: BWTS has no low memory mode, its working
+   // set is counted for each BWTS stage of each job
+   public static long estimateMemory(int blockSize, int jobs, int bwtStages, int bwtsStages, boolean lowMemory)
+   {
+      long res = 3L * blockSize * jobs;
+
+      if (bwtStages > 0)
+         res += BWT.getWorkingSetSize(blockSize) * ((lowMemory == true) ? 1 : (long) jobs*bwtStages);
+
+      res += BWTS.getWorkingSetSize(blockSize) * jobs * bwtsStages;
+      return res;
+   }
+
+
    public void dispose()
    {
       if (this.pool != null)
@@ -176,9 +298,18 @@ public class BlockCompressor implements Runnable, Callable<Integer>
       }
       
       printOut("Block size set to " + this.blockSize + " bytes", printFlag);
+
+      if (this.memoryBudget > 0)
+      {
+         printOut("Memory budget set to " + this.memoryBudget + " bytes", printFlag);
+
+         if (this.lowMemoryBWT == true)
+            printOut("Using low memory BWT", printFlag);
+      }
+
       printOut("Verbosity set to " + this.verbosity, printFlag);
       printOut("Overwrite set to " + this.overwrite, printFlag);
-      printOut("Checksum set to " +  this.checksum, printFlag);
+      printOut("Checksum set to " +  this.checksumKind, printFlag);
 
       if (printFlag == true)
       {
@@ -258,11 +389,25 @@ public class BlockCompressor implements Runnable, Callable<Integer>
          ctx.put("overwrite", this.overwrite);
          ctx.put("skipBlocks", this.skipBlocks);
          ctx.put("blockSize", this.blockSize);
-         ctx.put("checksum", this.checksum);
+         ctx.put("checksum", this.checksumKind != ChecksumKind.NONE);
+         ctx.put("checksumKind", this.checksumKind);
          ctx.put("pool", this.pool);
          ctx.put("codec", this.codec);
          ctx.put("transform", this.transform);
          ctx.put("extra", "TPAQX".equals(this.codec));
+
+         // The low memory BWTs of all the files of this run share a lock
+         if (this.lowMemoryBWT == true)
+         {
+            ctx.put("bwtLowMemory", true);
+            ctx.put("bwtLock", new Object());
+         }
+
+         if (this.storeOnly == true)
+            ctx.put("storeOnly", true);
+
+         if (this.level >= 0)
+            ctx.put("level", this.level);
                
          // Run the task(s)
          if (nbFiles == 1)
@@ -139,6 +139,7 @@ public class Kanzi
         int verbose = 1;
         boolean overwrite = false;
         boolean checksum = false;
+        String checksumKind = null;
         boolean skip = false;
         String inputName = null;
         String outputName = null;
@@ -282,14 +283,16 @@ public class Kanzi
                   printOut("        3=TEXT+ROLZX, 4=TEXT+BWT+RANK+ZRLT&ANS0, 5=TEXT+BWT+SRT+ZRLT&FPAQ", true);
                   printOut("        6=LZP+TEXT+BWT&CM, 7=X86+RLT+TEXT&TPAQ, 8=X86+RLT+TEXT&TPAQX\n", true);
                   printOut("   -e, --entropy=<codec>", true);
-                  printOut("        entropy codec [None|Huffman|ANS0|ANS1|Range|FPAQ|TPAQ|TPAQX|CM]", true);
+                  printOut("        entropy codec [None|Huffman|ANS0|ANS1|Range|MixRange|FPAQ|TPAQ|TPAQX|CM]", true);
                   printOut("        (default is ANS0)\n", true);
                   printOut("   -t, --transform=<codec>", true);
                   printOut("        transform [None|BWT|BWTS|LZ|LZP|ROLZ|ROLZX|RLT|ZRLT]", true);
-                  printOut("                  [MTFT|RANK|SRT|TEXT|X86]", true);
+                  printOut("                  [MTFT|RANK|SRT|TEXT|X86|BRLT|JOINT|BPE|LOGMAP]", true);
+                  printOut("                  [COUNTER|TRANSPOSE|ADAPT|MTFZRLE|SIGNMAG|TSDELTA|HILBERT|JSONNUM|COLDELTA|DECIMAL|IPFIELD|TEMPLATE|WSFOLD|MORTON|SPARSE|LINEDEDUP|TEXTBLOB|UTF8REMAP|FIXEDPOINT|LANEGROUP|ANYTIME|SORTEDSET|ZIGZAG]", true);
                   printOut("        EG: BWT+RANK or BWTS+MTFT (default is BWT+RANK+ZRLT)\n", true);
-                  printOut("   -x, --checksum", true);
-                  printOut("        enable block checksum\n", true);
+                  printOut("   -x, --checksum[=<type>]", true);
+                  printOut("        enable block checksum [CRC32C|XXHash32|XXHash64]", true);
+                  printOut("        (default is XXHash32)\n", true);
                   printOut("   -s, --skip", true);
                   printOut("        copy blocks with high entropy instead of compressing them.\n", true);
                }
@@ -357,6 +360,17 @@ public class Kanzi
                continue;
            }
 
+           if (arg.startsWith("--checksum="))
+           {
+               if (ctx != -1)
+                  printOut("Warning: ignoring option [" + CMD_LINE_ARGS[ctx] + "] with no value.", verbose>0);
+
+               checksumKind = arg.substring(11).trim().toUpperCase();
+               checksum = true;
+               ctx = -1;
+               continue;
+           }
+
            if (ctx == -1)
            {
                int idx = -1;
@@ -660,6 +674,9 @@ public class Kanzi
         if (checksum == true)
            map.put("checksum", checksum);
 
+        if (checksumKind != null)
+           map.put("checksumKind", checksumKind);
+
         if (skip == true)
            map.put("skipBlocks", skip);
 
@@ -240,6 +240,19 @@ public final class DebugInputBitStream implements InputBitStream
    }
 
 
+   // The bits are read most significant first, so the big endian order
+   // is the natural order. Little endian words are byte swapped.
+   @Override
+   public synchronized long readWord(int bytes, boolean bigEndian) throws BitStreamException
+   {
+      if ((bytes < 1) || (bytes > 8))
+         throw new IllegalArgumentException("Invalid word size: "+bytes+" (must be in [1..8])");
+
+      final long value = this.readBits(8*bytes);
+      return (bigEndian == true) ? value : Long.reverseBytes(value) >>> (64-8*bytes);
+   }
+
+
    @Override
    public void close() throws BitStreamException
    {
@@ -240,6 +240,21 @@ public final class DebugOutputBitStream implements OutputBitStream
    }
 
 
+   // The bits are written most significant first, so the big endian order
+   // is the natural order. Little endian words are byte swapped first.
+   @Override
+   public synchronized int writeWord(long value, int bytes, boolean bigEndian) throws BitStreamException
+   {
+      if ((bytes < 1) || (bytes > 8))
+         throw new IllegalArgumentException("Invalid word size: "+bytes+" (must be in [1..8])");
+
+      if (bigEndian == false)
+         value = Long.reverseBytes(value) >>> (64-8*bytes);
+
+      return this.writeBits(value, 8*bytes);
+   }
+
+
    @Override
    public void close() throws BitStreamException
    {
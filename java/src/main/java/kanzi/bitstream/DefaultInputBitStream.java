@@ -200,7 +200,20 @@ public final class DefaultInputBitStream implements InputBitStream
       return count;
    }
 
-   
+
+   // The bits are read most significant first, so the big endian order
+   // is the natural order. Little endian words are byte swapped.
+   @Override
+   public long readWord(int bytes, boolean bigEndian)
+   {
+      if ((bytes < 1) || (bytes > 8))
+         throw new IllegalArgumentException("Invalid word size: "+bytes+" (must be in [1..8])");
+
+      final long value = this.readBits(8*bytes);
+      return (bigEndian == true) ? value : Long.reverseBytes(value) >>> (64-8*bytes);
+   }
+
+
    // Pull 64 bits of current value from buffer.
    private void pullCurrent()
    {
@@ -171,6 +171,21 @@ public final class DefaultOutputBitStream implements OutputBitStream
    }
 
 
+   // The bits are written most significant first, so the big endian order
+   // is the natural order. Little endian words are byte swapped first.
+   @Override
+   public int writeWord(long value, int bytes, boolean bigEndian)
+   {
+      if ((bytes < 1) || (bytes > 8))
+         throw new IllegalArgumentException("Invalid word size: "+bytes+" (must be in [1..8])");
+
+      if (bigEndian == false)
+         value = Long.reverseBytes(value) >>> (64-8*bytes);
+
+      return this.writeBits(value, 8*bytes);
+   }
+
+
    // Push 64 bits of current value into buffer.
    private void pushCurrent()
    {
@@ -34,6 +34,7 @@ public class EntropyCodecFactory
    public static final byte TPAQ_TYPE    = 7; // Tangelo PAQ
    public static final byte ANS1_TYPE    = 8; // Asymmetric Numerical System order 1
    public static final byte TPAQX_TYPE   = 9; // Tangelo PAQ Extra
+   public static final byte MIXRANGE_TYPE = 10; // Range with order 0-2 mixing
 
 
    public EntropyDecoder newDecoder(InputBitStream ibs, Map<String, Object> ctx, int entropyType)
@@ -57,6 +58,9 @@ public class EntropyCodecFactory
          case RANGE_TYPE:
             return new RangeDecoder(ibs);
             
+         case MIXRANGE_TYPE:
+            return new MixedRangeDecoder(ibs);
+            
          case FPAQ_TYPE:
             return new FPAQDecoder(ibs);
             
@@ -97,6 +101,9 @@ public class EntropyCodecFactory
          case RANGE_TYPE:
             return new RangeEncoder(obs);
 
+         case MIXRANGE_TYPE:
+            return new MixedRangeEncoder(obs);
+
          case FPAQ_TYPE:
             return new FPAQEncoder(obs);
 
@@ -134,6 +141,9 @@ public class EntropyCodecFactory
          case RANGE_TYPE:
             return "RANGE";
 
+         case MIXRANGE_TYPE:
+            return "MIXRANGE";
+
          case FPAQ_TYPE:
             return "FPAQ";
 
@@ -177,6 +187,9 @@ public class EntropyCodecFactory
          case "RANGE":
              return RANGE_TYPE;
 
+         case "MIXRANGE":
+             return MIXRANGE_TYPE;
+
          case "CM":
              return CM_TYPE;
 
@@ -0,0 +1,196 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.entropy;
+
+
+// Adaptive frequency model shared by the mixed order range encoder and decoder.
+// Order 0, order 1 and (hashed) order 2 statistics are blended with fixed
+// weights, each weight being scaled by the confidence in its context (number
+// of symbols already seen in this context). The blended frequencies always sum
+// to at most 1<<16 and each symbol has a frequency of at least 1.
+// Encoder and decoder must call computeFrequencies() and update() in the
+// same order to stay in sync.
+
+// Not thread safe
+final class MixedOrderModel
+{
+   private static final int TOTAL = 1 << 16;
+   private static final int SCALE = TOTAL - 256;
+   private static final int LIMIT0 = 1 << 16;
+   private static final int LIMIT = 1 << 12;
+   private static final int CONFIDENCE = 16;
+   private static final int HASH_BITS = 12;
+   private static final int[] WEIGHTS = { 1, 4, 8 };
+
+   private final int order;
+   private final int[] freqs0;
+   private final char[] freqs1;
+   private final char[] freqs2;
+   private int total0;
+   private final int[] totals1;
+   private final int[] totals2;
+   private int ctx1;
+   private int ctx2;
+
+
+   public MixedOrderModel(int order)
+   {
+      if ((order < 0) || (order > 2))
+         throw new IllegalArgumentException("Mixed range codec: Invalid order: "+order+" (must be in [0..2])");
+
+      this.order = order;
+      this.freqs0 = new int[256];
+      this.freqs1 = new char[(order >= 1) ? 256*256 : 0];
+      this.freqs2 = new char[(order >= 2) ? 256<<HASH_BITS : 0];
+      this.totals1 = new int[(order >= 1) ? 256 : 0];
+      this.totals2 = new int[(order >= 2) ? 1<<HASH_BITS : 0];
+   }
+
+
+   public int getOrder()
+   {
+      return this.order;
+   }
+
+
+   // Fill 'freqs' with the blended frequencies for the current context.
+   // Return the sum of all frequencies.
+   public int computeFrequencies(int[] freqs)
+   {
+      final int t0 = this.total0;
+      final int t1 = (this.order >= 1) ? this.totals1[this.ctx1] : 0;
+      final int t2 = (this.order >= 2) ? this.totals2[this.ctx2] : 0;
+      final long w0 = weight(0, t0);
+      final long w1 = weight(1, t1);
+      final long w2 = weight(2, t2);
+      final long wsum = w0 + w1 + w2;
+
+      if (wsum == 0)
+      {
+         for (int i=0; i<256; i++)
+            freqs[i] = 1;
+
+         return 256;
+      }
+
+      final long sc0 = (t0 == 0) ? 0 : ((w0<<16) * SCALE) / (wsum * t0);
+      final long sc1 = (t1 == 0) ? 0 : ((w1<<16) * SCALE) / (wsum * t1);
+      final long sc2 = (t2 == 0) ? 0 : ((w2<<16) * SCALE) / (wsum * t2);
+      final int[] f0 = this.freqs0;
+      int total = 0;
+
+      if (sc2 != 0)
+      {
+         final char[] f1 = this.freqs1;
+         final char[] f2 = this.freqs2;
+         final int idx1 = this.ctx1 << 8;
+         final int idx2 = this.ctx2 << 8;
+
+         for (int i=0; i<256; i++)
+         {
+            freqs[i] = 1 + (int) ((f0[i]*sc0 + f1[idx1+i]*sc1 + f2[idx2+i]*sc2) >>> 16);
+            total += freqs[i];
+         }
+      }
+      else if (sc1 != 0)
+      {
+         final char[] f1 = this.freqs1;
+         final int idx1 = this.ctx1 << 8;
+
+         for (int i=0; i<256; i++)
+         {
+            freqs[i] = 1 + (int) ((f0[i]*sc0 + f1[idx1+i]*sc1) >>> 16);
+            total += freqs[i];
+         }
+      }
+      else
+      {
+         for (int i=0; i<256; i++)
+         {
+            freqs[i] = 1 + (int) ((f0[i]*sc0) >>> 16);
+            total += freqs[i];
+         }
+      }
+
+      return total;
+   }
+
+
+   // The weight of an order grows with the number of symbols seen in the context
+   private long weight(int n, int t)
+   {
+      return (t == 0) ? 0 : ((long) (WEIGHTS[n]*t) << 8) / (t+CONFIDENCE);
+   }
+
+
+   // Update the statistics with the symbol and move to the next context
+   public void update(int symbol)
+   {
+      this.freqs0[symbol]++;
+      this.total0++;
+
+      if (this.total0 >= LIMIT0)
+      {
+         this.total0 = 0;
+
+         for (int i=0; i<256; i++)
+         {
+            this.freqs0[i] >>= 1;
+            this.total0 += this.freqs0[i];
+         }
+      }
+
+      if (this.order >= 1)
+      {
+         final int idx1 = this.ctx1 << 8;
+         this.freqs1[idx1+symbol]++;
+         this.totals1[this.ctx1]++;
+
+         if (this.totals1[this.ctx1] >= LIMIT)
+            this.totals1[this.ctx1] = rescale(this.freqs1, idx1);
+      }
+
+      if (this.order >= 2)
+      {
+         final int idx2 = this.ctx2 << 8;
+         this.freqs2[idx2+symbol]++;
+         this.totals2[this.ctx2]++;
+
+         if (this.totals2[this.ctx2] >= LIMIT)
+            this.totals2[this.ctx2] = rescale(this.freqs2, idx2);
+
+         // Hash the last 2 symbols
+         final int ctx = (this.ctx1 << 8) | symbol;
+         this.ctx2 = (ctx * 0x9E3779B1) >>> (32-HASH_BITS);
+      }
+
+      this.ctx1 = symbol;
+   }
+
+
+   private static int rescale(char[] freqs, int idx)
+   {
+      int total = 0;
+
+      for (int i=idx; i<idx+256; i++)
+      {
+         freqs[i] >>= 1;
+         total += freqs[i];
+      }
+
+      return total;
+   }
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.entropy;
+
+import kanzi.EntropyDecoder;
+import kanzi.InputBitStream;
+
+
+// Adaptive range decoder driven by a model mixing order 0, order 1 and order 2
+// statistics (see MixedOrderModel). The model must be created with the same
+// order as the one used by the encoder.
+
+// Not thread safe
+public final class MixedRangeDecoder implements EntropyDecoder
+{
+    private static final long TOP_RANGE    = 0x0FFFFFFFFFFFFFFFL;
+    private static final long BOTTOM_RANGE = 0x000000000000FFFFL;
+    private static final long RANGE_MASK   = 0x0FFFFFFF00000000L;
+
+    private long code;
+    private long low;
+    private long range;
+    private final int[] freqs;
+    private final MixedOrderModel model;
+    private final InputBitStream bitstream;
+
+
+    public MixedRangeDecoder(InputBitStream bitstream)
+    {
+       this(bitstream, 2);
+    }
+
+
+    public MixedRangeDecoder(InputBitStream bitstream, int order)
+    {
+      if (bitstream == null)
+         throw new NullPointerException("Mixed range codec: Invalid null bitstream parameter");
+
+      this.bitstream = bitstream;
+      this.freqs = new int[256];
+      this.model = new MixedOrderModel(order);
+    }
+
+
+    @Override
+    public int decode(byte[] block, int blkptr, int count)
+    {
+      if ((block == null) || (blkptr+count > block.length) || (blkptr < 0) || (count < 0))
+         return -1;
+
+      if (count == 0)
+         return 0;
+
+      final int end = blkptr + count;
+      this.range = TOP_RANGE;
+      this.low = 0;
+      this.code = this.bitstream.readBits(60);
+
+      for (int i=blkptr; i<end; i++)
+         block[i] = this.decodeByte();
+
+      return count;
+    }
+
+
+    protected byte decodeByte()
+    {
+       final int[] f = this.freqs;
+       final int total = this.model.computeFrequencies(f);
+       this.range /= total;
+       long count = (this.code - this.low) / this.range;
+
+       // Corrupted bitstream
+       if (count >= total)
+          count = total - 1;
+
+       int symbol = 0;
+       long cumFreq = 0;
+
+       while (cumFreq+f[symbol] <= count)
+          cumFreq += f[symbol++];
+
+       // Compute next low and range
+       this.low += (cumFreq * this.range);
+       this.range *= f[symbol];
+
+       // If the left-most digits are the same throughout the range, read bits from bitstream
+       while (true)
+       {
+          if (((this.low ^ (this.low + this.range)) & RANGE_MASK) != 0)
+          {
+             if (this.range > BOTTOM_RANGE)
+                break;
+
+             // Normalize
+             this.range = -this.low & BOTTOM_RANGE;
+          }
+
+          this.code = (this.code << 28) | this.bitstream.readBits(28);
+          this.range <<= 28;
+          this.low <<= 28;
+       }
+
+       this.model.update(symbol);
+       return (byte) symbol;
+    }
+
+
+    @Override
+    public InputBitStream getBitStream()
+    {
+       return this.bitstream;
+    }
+
+
+    @Override
+    public void dispose()
+    {
+    }
+}
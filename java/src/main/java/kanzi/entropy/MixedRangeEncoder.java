@@ -0,0 +1,129 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.entropy;
+
+import kanzi.EntropyEncoder;
+import kanzi.OutputBitStream;
+
+
+// Adaptive range coder driven by a model mixing order 0, order 1 and order 2
+// statistics (see MixedOrderModel). Uses the same arithmetic as RangeEncoder
+// but the frequencies are updated after each symbol, so no header is emitted.
+// Slower than RangeEncoder since the blended frequencies are recomputed for
+// each byte.
+
+// Not thread safe
+public final class MixedRangeEncoder implements EntropyEncoder
+{
+    private static final long TOP_RANGE    = 0x0FFFFFFFFFFFFFFFL;
+    private static final long BOTTOM_RANGE = 0x000000000000FFFFL;
+    private static final long RANGE_MASK   = 0x0FFFFFFF00000000L;
+
+    private long low;
+    private long range;
+    private final int[] freqs;
+    private final MixedOrderModel model;
+    private final OutputBitStream bitstream;
+
+
+    public MixedRangeEncoder(OutputBitStream bitstream)
+    {
+       this(bitstream, 2);
+    }
+
+
+    // The order is the highest order of the statistics mixed by the model
+    public MixedRangeEncoder(OutputBitStream bitstream, int order)
+    {
+      if (bitstream == null)
+         throw new NullPointerException("Mixed range codec: Invalid null bitstream parameter");
+
+      this.bitstream = bitstream;
+      this.freqs = new int[256];
+      this.model = new MixedOrderModel(order);
+    }
+
+
+    @Override
+    public int encode(byte[] block, int blkptr, int count)
+    {
+       if ((block == null) || (blkptr+count > block.length) || (blkptr < 0) || (count < 0))
+          return -1;
+
+       if (count == 0)
+          return 0;
+
+       final int end = blkptr + count;
+       this.range = TOP_RANGE;
+       this.low = 0;
+
+       for (int i=blkptr; i<end; i++)
+          this.encodeByte(block[i]);
+
+       // Flush 'low'
+       this.bitstream.writeBits(this.low, 60);
+       return count;
+    }
+
+
+    protected void encodeByte(byte b)
+    {
+        final int symbol = b & 0xFF;
+        final int[] f = this.freqs;
+        final int total = this.model.computeFrequencies(f);
+        long cumFreq = 0;
+
+        for (int i=0; i<symbol; i++)
+           cumFreq += f[i];
+
+        // Compute next low and range
+        this.range /= total;
+        this.low += (cumFreq * this.range);
+        this.range *= f[symbol];
+
+        // If the left-most digits are the same throughout the range, write bits to bitstream
+        while (true)
+        {
+            if (((this.low ^ (this.low + this.range)) & RANGE_MASK) != 0)
+            {
+               if (this.range > BOTTOM_RANGE)
+                  break;
+
+               // Normalize
+               this.range = -this.low & BOTTOM_RANGE;
+            }
+
+            this.bitstream.writeBits(this.low >>> 32, 28);
+            this.range <<= 28;
+            this.low <<= 28;
+        }
+
+        this.model.update(symbol);
+    }
+
+
+    @Override
+    public OutputBitStream getBitStream()
+    {
+       return this.bitstream;
+    }
+
+
+    @Override
+    public void dispose()
+    {
+    }
+}
@@ -21,6 +21,8 @@ import kanzi.OutputBitStream;
 
 // Null entropy encoder and decoder
 // Pass through that writes the data directly to the bitstream
+// Selecting the 'NONE' codec at the stream level yields a transform only output:
+// each block contains the (byte aligned) output of the transform sequence.
 public final class NullEntropyEncoder implements EntropyEncoder
 {
    private final OutputBitStream bitstream;
@@ -0,0 +1,164 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.entropy;
+
+import java.io.ByteArrayInputStream;
+import java.util.ArrayList;
+import java.util.HashMap;
+import java.util.List;
+import java.util.Map;
+import java.util.concurrent.Callable;
+import java.util.concurrent.ExecutorService;
+import java.util.concurrent.Future;
+import kanzi.EntropyDecoder;
+import kanzi.InputBitStream;
+import kanzi.bitstream.DefaultInputBitStream;
+
+
+// Decode the sub-streams produced by ParallelEntropyEncoder, concurrently if
+// a thread pool is provided in the context.
+public class ParallelEntropyDecoder implements EntropyDecoder
+{
+   private static final int MAX_CHUNK_SIZE = 1 << 30;
+
+   private final InputBitStream bitstream;
+   private final Map<String, Object> ctx;
+   private final int entropyType;
+   private final int jobs;
+   private final ExecutorService pool;
+   private int chunkSize;
+
+
+   public ParallelEntropyDecoder(InputBitStream bitstream, Map<String, Object> ctx, int entropyType)
+   {
+      if (bitstream == null)
+         throw new NullPointerException("Parallel entropy codec: Invalid null bitstream parameter");
+
+      final int tasks = (Integer) ctx.getOrDefault("jobs", 1);
+      ExecutorService threadPool = (ExecutorService) ctx.get("pool");
+      this.bitstream = bitstream;
+      this.ctx = ctx;
+      this.entropyType = entropyType;
+      this.pool = ((tasks > 1) && (threadPool != null)) ? threadPool : null;
+      this.jobs = (this.pool == null) ? 1 : tasks;
+   }
+
+
+   // Return the chunk size read from the bitstream by the last call to decode
+   public int getChunkSize()
+   {
+      return this.chunkSize;
+   }
+
+
+   @Override
+   public int decode(final byte[] block, final int blkptr, final int count)
+   {
+      if ((block == null) || (blkptr+count > block.length) || (blkptr < 0) || (count < 0))
+         return -1;
+
+      final long cs = this.bitstream.readBits(32);
+
+      if ((cs < ParallelEntropyEncoder.MIN_CHUNK_SIZE) || (cs > MAX_CHUNK_SIZE))
+         return -1;
+
+      this.chunkSize = (int) cs;
+      final int chunks = (count+this.chunkSize-1) / this.chunkSize;
+      final byte[][] inputs = new byte[chunks][];
+
+      for (int i=0; i<chunks; i++)
+      {
+         final long sz = this.bitstream.readBits(32);
+
+         // A sub-stream cannot be much bigger than its chunk
+         if (sz > 2L*this.chunkSize+1024)
+            return -1;
+
+         inputs[i] = new byte[(int) sz];
+      }
+
+      for (byte[] input : inputs)
+      {
+         if (input.length > 0)
+            this.bitstream.readBits(input, 0, 8*input.length);
+      }
+
+      List<Callable<Boolean>> tasks = new ArrayList<>(chunks);
+
+      for (int i=0; i<chunks; i++)
+      {
+         final byte[] input = inputs[i];
+         final int start = blkptr + i*this.chunkSize;
+         final int len = Math.min(this.chunkSize, blkptr+count-start);
+
+         tasks.add(new Callable<Boolean>()
+         {
+            @Override
+            public Boolean call()
+            {
+               Map<String, Object> map = new HashMap<>(ParallelEntropyDecoder.this.ctx);
+               map.put("size", len);
+               InputBitStream ibs = new DefaultInputBitStream(new ByteArrayInputStream(input), 16384);
+               EntropyDecoder ed = new EntropyCodecFactory().newDecoder(ibs, map,
+                  ParallelEntropyDecoder.this.entropyType);
+               final boolean res = ed.decode(block, start, len) == len;
+               ed.dispose();
+               ibs.close();
+               return res;
+            }
+         });
+      }
+
+      try
+      {
+         if (this.jobs == 1)
+         {
+            for (Callable<Boolean> task : tasks)
+            {
+               if (task.call() == false)
+                  return -1;
+            }
+         }
+         else
+         {
+            for (Future<Boolean> result : this.pool.invokeAll(tasks))
+            {
+               if (result.get() == false)
+                  return -1;
+            }
+         }
+      }
+      catch (Exception e)
+      {
+         return -1;
+      }
+
+      return count;
+   }
+
+
+   @Override
+   public InputBitStream getBitStream()
+   {
+      return this.bitstream;
+   }
+
+
+   @Override
+   public void dispose()
+   {
+   }
+}
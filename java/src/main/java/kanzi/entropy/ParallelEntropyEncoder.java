@@ -0,0 +1,156 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.entropy;
+
+import java.io.ByteArrayOutputStream;
+import java.util.ArrayList;
+import java.util.HashMap;
+import java.util.List;
+import java.util.Map;
+import java.util.concurrent.Callable;
+import java.util.concurrent.ExecutorService;
+import java.util.concurrent.Future;
+import kanzi.EntropyEncoder;
+import kanzi.OutputBitStream;
+import kanzi.bitstream.DefaultOutputBitStream;
+
+
+// Split the data into chunks of fixed size, each chunk being entropy coded
+// independently (with its own model) in a separate sub-stream. The chunks can
+// be coded concurrently. The output only depends on the chunk size (not on the
+// number of jobs).
+// Format: chunk size (32 bits), size in bytes of each sub-stream (32 bits each),
+//         sub-streams
+public class ParallelEntropyEncoder implements EntropyEncoder
+{
+   public static final int MIN_CHUNK_SIZE = 1024;
+
+   private final OutputBitStream bitstream;
+   private final Map<String, Object> ctx;
+   private final int entropyType;
+   private final int chunkSize;
+   private final int jobs;
+   private final ExecutorService pool;
+
+
+   // The number of jobs and the thread pool are provided in the context
+   public ParallelEntropyEncoder(OutputBitStream bitstream, Map<String, Object> ctx,
+      int entropyType, int chunkSize)
+   {
+      if (bitstream == null)
+         throw new NullPointerException("Parallel entropy codec: Invalid null bitstream parameter");
+
+      if (chunkSize < MIN_CHUNK_SIZE)
+         throw new IllegalArgumentException("Parallel entropy codec: The chunk size must be at least "+MIN_CHUNK_SIZE);
+
+      final int tasks = (Integer) ctx.getOrDefault("jobs", 1);
+      ExecutorService threadPool = (ExecutorService) ctx.get("pool");
+      this.bitstream = bitstream;
+      this.ctx = ctx;
+      this.entropyType = entropyType;
+      this.chunkSize = chunkSize;
+      this.pool = ((tasks > 1) && (threadPool != null)) ? threadPool : null;
+      this.jobs = (this.pool == null) ? 1 : tasks;
+   }
+
+
+   @Override
+   public int encode(final byte[] block, final int blkptr, final int count)
+   {
+      if ((block == null) || (blkptr+count > block.length) || (blkptr < 0) || (count < 0))
+         return -1;
+
+      final int chunks = (count+this.chunkSize-1) / this.chunkSize;
+      final byte[][] outputs = new byte[chunks][];
+      List<Callable<Boolean>> tasks = new ArrayList<>(chunks);
+
+      for (int i=0; i<chunks; i++)
+      {
+         final int idx = i;
+         final int start = blkptr + i*this.chunkSize;
+         final int len = Math.min(this.chunkSize, blkptr+count-start);
+
+         tasks.add(new Callable<Boolean>()
+         {
+            @Override
+            public Boolean call()
+            {
+               Map<String, Object> map = new HashMap<>(ParallelEntropyEncoder.this.ctx);
+               map.put("size", len);
+               ByteArrayOutputStream baos = new ByteArrayOutputStream(len+(len>>3)+64);
+               OutputBitStream obs = new DefaultOutputBitStream(baos, 16384);
+               EntropyEncoder ee = new EntropyCodecFactory().newEncoder(obs, map,
+                  ParallelEntropyEncoder.this.entropyType);
+               final boolean res = ee.encode(block, start, len) == len;
+               ee.dispose();
+               obs.close();
+               outputs[idx] = baos.toByteArray();
+               return res;
+            }
+         });
+      }
+
+      try
+      {
+         if (this.jobs == 1)
+         {
+            for (Callable<Boolean> task : tasks)
+            {
+               if (task.call() == false)
+                  return -1;
+            }
+         }
+         else
+         {
+            for (Future<Boolean> result : this.pool.invokeAll(tasks))
+            {
+               if (result.get() == false)
+                  return -1;
+            }
+         }
+      }
+      catch (Exception e)
+      {
+         return -1;
+      }
+
+      this.bitstream.writeBits(this.chunkSize, 32);
+
+      for (byte[] output : outputs)
+         this.bitstream.writeBits(output.length, 32);
+
+      for (byte[] output : outputs)
+      {
+         if (output.length > 0)
+            this.bitstream.writeBits(output, 0, 8*output.length);
+      }
+
+      return count;
+   }
+
+
+   @Override
+   public OutputBitStream getBitStream()
+   {
+      return this.bitstream;
+   }
+
+
+   @Override
+   public void dispose()
+   {
+   }
+}
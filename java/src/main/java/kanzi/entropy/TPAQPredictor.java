@@ -15,6 +15,11 @@ limitations under the License.
 package kanzi.entropy;
 
 import kanzi.Predictor;
+import java.io.DataInputStream;
+import java.io.DataOutputStream;
+import java.io.IOException;
+import java.io.InputStream;
+import java.io.OutputStream;
 import java.util.Map;
 import kanzi.Global;
 
@@ -471,11 +476,44 @@ public class TPAQPredictor implements Predictor
    }
 
 
+   // Save the state of all the mixers (see Mixer.saveWeights). The weights
+   // can be loaded into a predictor with the same number of mixers (same
+   // codec and block size parameters) for a warm start.
+   public void saveMixerWeights(OutputStream os) throws IOException
+   {
+      DataOutputStream dos = new DataOutputStream(os);
+      dos.writeInt(Mixer.WEIGHTS_TAG);
+      dos.writeByte(Mixer.WEIGHTS_VERSION);
+      dos.writeInt(this.mixers.length);
+
+      for (Mixer m : this.mixers)
+         m.writeState(dos);
+
+      dos.flush();
+   }
+
+
+   public void loadMixerWeights(InputStream is) throws IOException
+   {
+      DataInputStream dis = new DataInputStream(is);
+      Mixer.readTag(dis);
+      final int count = dis.readInt();
+
+      if (count != this.mixers.length)
+         throw new IOException("Mixer weights: expected "+this.mixers.length+" mixers, got "+count);
+
+      for (Mixer m : this.mixers)
+         m.readState(dis);
+   }
+
+
    // Mixer combines models using a neural network with 8 inputs.
-   static class Mixer
+   public static class Mixer
    {
       private static final int BEGIN_LEARN_RATE = 60 << 7;
       static final int END_LEARN_RATE = 11 << 7;  // 8 << 7 for text, else 14 << 7
+      static final int WEIGHTS_TAG = 0x4D495857; // "MIXW"
+      static final int WEIGHTS_VERSION = 1;
 
       private int pr;  // squashed prediction
       private int skew; 
@@ -484,7 +522,7 @@ public class TPAQPredictor implements Predictor
       private int learnRate;
       
       
-      Mixer()
+      public Mixer()
       {
          this.pr = 2048;
          this.w0 = this.w1 = this.w2 = this.w3 = 32768;
@@ -494,7 +532,7 @@ public class TPAQPredictor implements Predictor
 
       
       // Adjust weights to minimize coding cost of last prediction
-      void update(int bit)
+      public void update(int bit)
       {
          final int err = (((bit<<12) - this.pr) * this.learnRate) >> 10;
          
@@ -535,6 +573,76 @@ public class TPAQPredictor implements Predictor
          
          return this.pr;
       }
+
+
+      // Save the state of the mixer (weights, skew, learning rate, last inputs
+      // and prediction) after a version tag. A mixer loaded from this state
+      // computes the same predictions as the saved mixer for the same inputs
+      // and bits.
+      public void saveWeights(OutputStream os) throws IOException
+      {
+         DataOutputStream dos = new DataOutputStream(os);
+         dos.writeInt(WEIGHTS_TAG);
+         dos.writeByte(WEIGHTS_VERSION);
+         this.writeState(dos);
+         dos.flush();
+      }
+
+
+      public void loadWeights(InputStream is) throws IOException
+      {
+         DataInputStream dis = new DataInputStream(is);
+         readTag(dis);
+         this.readState(dis);
+      }
+
+
+      static void readTag(DataInputStream dis) throws IOException
+      {
+         if (dis.readInt() != WEIGHTS_TAG)
+            throw new IOException("Mixer weights: invalid tag");
+
+         final int version = dis.readUnsignedByte();
+
+         if (version != WEIGHTS_VERSION)
+            throw new IOException("Mixer weights: unsupported version "+version+
+               " (expected "+WEIGHTS_VERSION+")");
+      }
+
+
+      void writeState(DataOutputStream dos) throws IOException
+      {
+         final int[] state = { this.w0, this.w1, this.w2, this.w3, this.w4, this.w5, this.w6, this.w7,
+            this.p0, this.p1, this.p2, this.p3, this.p4, this.p5, this.p6, this.p7,
+            this.pr, this.skew, this.learnRate };
+
+         for (int v : state)
+            dos.writeInt(v);
+      }
+
+
+      void readState(DataInputStream dis) throws IOException
+      {
+         this.w0 = dis.readInt();
+         this.w1 = dis.readInt();
+         this.w2 = dis.readInt();
+         this.w3 = dis.readInt();
+         this.w4 = dis.readInt();
+         this.w5 = dis.readInt();
+         this.w6 = dis.readInt();
+         this.w7 = dis.readInt();
+         this.p0 = dis.readInt();
+         this.p1 = dis.readInt();
+         this.p2 = dis.readInt();
+         this.p3 = dis.readInt();
+         this.p4 = dis.readInt();
+         this.p5 = dis.readInt();
+         this.p6 = dis.readInt();
+         this.p7 = dis.readInt();
+         this.pr = dis.readInt();
+         this.skew = dis.readInt();
+         this.learnRate = dis.readInt();
+      }
    }
 
 }
@@ -0,0 +1,254 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.HashMap;
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.ByteTransform;
+import kanzi.SliceByteArray;
+import kanzi.entropy.EntropyUtils;
+import kanzi.transform.SBRT;
+
+
+// Per block selection between a BWT based sequence (BWT+RANK+ZRLT) and LZ.
+// The block is classified quickly: text like blocks (mostly printable
+// characters, not too repetitive) go to the BWT path, binary, incompressible
+// and highly repetitive blocks (long matches) go to the LZ path.
+// Format: path (1 byte), skip flags of the BWT sequence (1 byte), data
+public class AdaptiveCodec implements ByteFunction
+{
+   public static final int BWT_PATH = 0;
+   public static final int LZ_PATH = 1;
+
+   private static final int MIN_BLOCK_SIZE = 256;
+   private static final int HASH_LOG = 16;
+   private static final int MIN_LONG_MATCH = 32;
+   private static final int MAX_MATCH = 1024;
+
+   private final Map<String, Object> ctx;
+   private int[] hashes;
+   private byte[] buffer;
+   private ByteTransformSequence bwtSeq;
+   private LZCodec lz;
+
+
+   public AdaptiveCodec()
+   {
+      this.ctx = new HashMap<>();
+      this.ctx.put("jobs", 1);
+      this.hashes = new int[0];
+      this.buffer = new byte[0];
+   }
+
+
+   public AdaptiveCodec(Map<String, Object> ctx)
+   {
+      this.ctx = ctx;
+      this.hashes = new int[0];
+      this.buffer = new byte[0];
+   }
+
+
+   private ByteTransformSequence getBWTSequence()
+   {
+      if (this.bwtSeq == null)
+      {
+         this.bwtSeq = new ByteTransformSequence(new ByteTransform[]
+         {
+            new BWTBlockCodec(this.ctx), new SBRT(SBRT.MODE_RANK), new ZRLT()
+         });
+      }
+
+      return this.bwtSeq;
+   }
+
+
+   private LZCodec getLZCodec()
+   {
+      if (this.lz == null)
+         this.lz = new LZCodec();
+
+      return this.lz;
+   }
+
+
+   // Return the path (BWT_PATH or LZ_PATH) selected for the block
+   public int classify(byte[] block, int start, int count)
+   {
+      int[] histo = new int[256];
+      final int entropy = EntropyUtils.computeFirstOrderEntropy1024(block, start, count, histo);
+
+      if (entropy >= EntropyUtils.INCOMPRESSIBLE_THRESHOLD)
+         return LZ_PATH;
+
+      int printable = histo[9] + histo[10] + histo[13];
+
+      for (int i=32; i<127; i++)
+         printable += histo[i];
+
+      // Binary data
+      if (8L*printable < 7L*count)
+         return LZ_PATH;
+
+      // Match density: fraction of the block covered by long matches
+      if (this.hashes.length == 0)
+         this.hashes = new int[1<<HASH_LOG];
+
+      final int[] h = this.hashes;
+      final int end = start + count - 4;
+      long covered = 0;
+
+      for (int i=0; i<h.length; i++)
+         h[i] = -1;
+
+      for (int i=start; i<end; )
+      {
+         final int val = ((block[i]&0xFF)<<24) | ((block[i+1]&0xFF)<<16) |
+            ((block[i+2]&0xFF)<<8) | (block[i+3]&0xFF);
+         final int key = (val*0x9E3779B1) >>> (32-HASH_LOG);
+         final int ref = h[key];
+         h[key] = i;
+
+         if (ref >= 0)
+         {
+            final int maxLen = Math.min(MAX_MATCH, start+count-i);
+            int len = 0;
+
+            while ((len < maxLen) && (block[ref+len] == block[i+len]))
+               len++;
+
+            if (len >= MIN_LONG_MATCH)
+            {
+               covered += len;
+               i += len;
+               continue;
+            }
+         }
+
+         i++;
+      }
+
+      // Highly repetitive data
+      return (2*covered >= count) ? LZ_PATH : BWT_PATH;
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (count < MIN_BLOCK_SIZE)
+         return false;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] dst = output.array;
+      final int dstStart = output.index;
+      final int path = this.classify(input.array, input.index, count);
+      dst[dstStart] = (byte) path;
+      dst[dstStart+1] = 0;
+      SliceByteArray sa1;
+      SliceByteArray sa2 = new SliceByteArray(dst, output.length, dstStart+2);
+
+      if (path == LZ_PATH)
+      {
+         sa1 = new SliceByteArray(input.array, count, input.index);
+
+         if (this.getLZCodec().forward(sa1, sa2) == false)
+            return false;
+      }
+      else
+      {
+         // The sequence uses its input as work buffer: protect the input
+         if (this.buffer.length < count)
+            this.buffer = new byte[count];
+
+         System.arraycopy(input.array, input.index, this.buffer, 0, count);
+         sa1 = new SliceByteArray(this.buffer, count, 0);
+         ByteTransformSequence seq = this.getBWTSequence();
+
+         if (seq.forward(sa1, sa2) == false)
+            return false;
+
+         dst[dstStart+1] = seq.getSkipFlags();
+      }
+
+      if (sa2.index-dstStart >= count)
+         return false;
+
+      input.index += count;
+      output.index = sa2.index;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < 2)
+         return false;
+
+      final byte[] src = input.array;
+      final int srcIdx = input.index;
+      final int path = src[srcIdx];
+      SliceByteArray sa1 = new SliceByteArray(src, input.length-2, srcIdx+2);
+      SliceByteArray sa2 = new SliceByteArray(output.array, output.length, output.index);
+      boolean res;
+
+      if (path == LZ_PATH)
+      {
+         res = this.getLZCodec().inverse(sa1, sa2);
+      }
+      else if (path == BWT_PATH)
+      {
+         ByteTransformSequence seq = this.getBWTSequence();
+         seq.setSkipFlags(src[srcIdx+1]);
+         res = seq.inverse(sa1, sa2);
+      }
+      else
+         return false;
+
+      if (res == false)
+         return false;
+
+      input.index += input.length;
+      output.index = sa2.index;
+      return true;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return 2 + Math.max(this.getLZCodec().getMaxEncodedLength(srcLen),
+         this.getBWTSequence().getMaxEncodedLength(srcLen));
+   }
+}
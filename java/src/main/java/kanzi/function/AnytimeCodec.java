@@ -0,0 +1,245 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.HashMap;
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.ByteTransform;
+import kanzi.SliceByteArray;
+import kanzi.entropy.EntropyUtils;
+import kanzi.transform.SBRT;
+
+
+// Anytime compression: each block is first transformed with a fast
+// configuration then, while the time budget allows it, with progressively
+// better (and slower) configurations. The best result (smallest order 0
+// entropy estimate) obtained before the deadline is kept. A configuration is
+// not tried if the previous one suggests that it would not complete in time and
+// a result produced after the deadline is discarded.
+// The deadline (System.nanoTime() value) is shared by all the blocks of a
+// stream: CompressedOutputStream sets 'anytimeDeadline' from 'anytimeBudget'
+// (in milliseconds). Without deadline, all the configurations are tried.
+// The decoder only reads the configuration selected for the block.
+// Format: level (1 byte), skip flags of the sequence (1 byte), data
+public class AnytimeCodec implements ByteFunction
+{
+   public static final int LZ_LEVEL = 0;
+   public static final int BWT_LEVEL = 1;
+   public static final int ROLZX_LEVEL = 2;
+
+   private static final int NB_LEVELS = 3;
+   private static final int MIN_BLOCK_SIZE = 256;
+
+   // Expected ratio of the duration of a level to the duration of the previous one
+   private static final int SLOWDOWN = 2;
+
+   private final Map<String, Object> ctx;
+   private final boolean bounded;
+   private final long deadline;
+   private final ByteTransformSequence[] sequences;
+   private byte[] buffer1;
+   private byte[] buffer2;
+   private int lastLevel;
+
+
+   public AnytimeCodec()
+   {
+      this.ctx = new HashMap<>();
+      this.ctx.put("jobs", 1);
+      this.bounded = false;
+      this.deadline = 0;
+      this.sequences = new ByteTransformSequence[NB_LEVELS];
+      this.buffer1 = new byte[0];
+      this.buffer2 = new byte[0];
+      this.lastLevel = -1;
+   }
+
+
+   public AnytimeCodec(Map<String, Object> ctx)
+   {
+      this.ctx = ctx;
+      this.bounded = ctx.containsKey("anytimeDeadline");
+      this.deadline = (this.bounded == true) ? (Long) ctx.get("anytimeDeadline") : 0;
+      this.sequences = new ByteTransformSequence[NB_LEVELS];
+      this.buffer1 = new byte[0];
+      this.buffer2 = new byte[0];
+      this.lastLevel = -1;
+   }
+
+
+   private ByteTransformSequence getSequence(int level)
+   {
+      if (this.sequences[level] == null)
+      {
+         ByteTransform[] transforms;
+
+         switch (level)
+         {
+            case LZ_LEVEL:
+               transforms = new ByteTransform[] { new LZCodec() };
+               break;
+
+            case BWT_LEVEL:
+               transforms = new ByteTransform[]
+               {
+                  new BWTBlockCodec(this.ctx), new SBRT(SBRT.MODE_RANK), new ZRLT()
+               };
+               break;
+
+            default:
+               transforms = new ByteTransform[] { new ROLZCodec(true) };
+               break;
+         }
+
+         this.sequences[level] = new ByteTransformSequence(transforms);
+      }
+
+      return this.sequences[level];
+   }
+
+
+   // Return the level selected for the last block encoded (-1 if none)
+   public int getLastLevel()
+   {
+      return this.lastLevel;
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (count < MIN_BLOCK_SIZE)
+         return false;
+
+      final int maxLength = this.getMaxEncodedLength(count);
+
+      if (output.length - output.index < maxLength)
+         return false;
+
+      // The sequences use their input as work buffer: protect the input
+      if (this.buffer1.length < count)
+         this.buffer1 = new byte[count];
+
+      if (this.buffer2.length < maxLength)
+         this.buffer2 = new byte[maxLength];
+
+      final byte[] dst = output.array;
+      final int dstStart = output.index;
+      long bestScore = Long.MAX_VALUE;
+      int bestLength = 0;
+      long duration = 0;
+      this.lastLevel = -1;
+
+      for (int level=0; level<NB_LEVELS; level++)
+      {
+         final long start = System.nanoTime();
+
+         // The fastest level is always tried
+         if ((level > LZ_LEVEL) && (this.bounded == true) && (start + SLOWDOWN*duration - this.deadline > 0))
+            break;
+
+         System.arraycopy(input.array, input.index, this.buffer1, 0, count);
+         SliceByteArray sa1 = new SliceByteArray(this.buffer1, count, 0);
+         SliceByteArray sa2 = new SliceByteArray(this.buffer2, 0);
+         ByteTransformSequence seq = this.getSequence(level);
+         final boolean res = seq.forward(sa1, sa2);
+         final long end = System.nanoTime();
+         duration = end - start;
+
+         // Result obtained too late (except for the fastest level)
+         if ((level > LZ_LEVEL) && (this.bounded == true) && (end - this.deadline > 0))
+            break;
+
+         // Failure or all the transforms skipped
+         if ((res == false) || (seq.getSkipFlags() == (byte) 0xFF) || (sa2.index+2 >= count))
+            continue;
+
+         final int entropy = EntropyUtils.computeFirstOrderEntropy1024(this.buffer2, 0, sa2.index, new int[256]);
+         final long score = (long) sa2.index * entropy;
+
+         if (score < bestScore)
+         {
+            bestScore = score;
+            bestLength = sa2.index;
+            this.lastLevel = level;
+            dst[dstStart] = (byte) level;
+            dst[dstStart+1] = seq.getSkipFlags();
+            System.arraycopy(this.buffer2, 0, dst, dstStart+2, bestLength);
+         }
+      }
+
+      if (this.lastLevel < 0)
+         return false;
+
+      input.index += count;
+      output.index = dstStart + 2 + bestLength;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < 2)
+         return false;
+
+      final byte[] src = input.array;
+      final int srcIdx = input.index;
+      final int level = src[srcIdx];
+
+      if ((level < 0) || (level >= NB_LEVELS))
+         return false;
+
+      SliceByteArray sa1 = new SliceByteArray(src, input.length-2, srcIdx+2);
+      SliceByteArray sa2 = new SliceByteArray(output.array, output.length, output.index);
+      ByteTransformSequence seq = this.getSequence(level);
+      seq.setSkipFlags(src[srcIdx+1]);
+
+      if (seq.inverse(sa1, sa2) == false)
+         return false;
+
+      input.index += input.length;
+      output.index = sa2.index;
+      return true;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      int res = 0;
+
+      for (int level=0; level<NB_LEVELS; level++)
+         res = Math.max(res, this.getSequence(level).getMaxEncodedLength(srcLen));
+
+      return 2 + res;
+   }
+}
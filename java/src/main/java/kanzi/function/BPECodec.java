@@ -0,0 +1,502 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.Arrays;
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.Global;
+import kanzi.SliceByteArray;
+
+
+// Byte Pair Encoding: frequent pairs of symbols are iteratively replaced with
+// new symbols. The merge table is either learned on the block or provided
+// (pretrained). A merge table is an array of pairs of symbols (left, right)
+// where symbols 0..255 are bytes and symbol 256+i is the result of merge i.
+// New symbols are first assigned to byte values absent from the block. When
+// none is left, they are coded as escape + index (the alphabet grows beyond
+// 256 symbols).
+// Format: escape (1 byte), merges M (1 byte), single byte codes F (1 byte),
+//         F code bytes, M pairs (3 bytes each), encoded symbols
+// Encoded symbols: escape 0xFF -> escape literal (only if escape is present
+//                                 in the block)
+//                  escape n    -> merge F+n
+//                  code byte   -> merge assigned to this byte
+//                  other byte  -> literal
+public class BPECodec implements ByteFunction
+{
+   public static final int MAX_MERGES = 255;
+   private static final int MAX_SYMBOLS = 256 + MAX_MERGES;
+   private static final int MAX_EXPANSION = 255;
+   private static final int MIN_BLOCK_SIZE = 64;
+   private static final int DEFAULT_MERGES = 128;
+   private static final int ESCAPE_LITERAL = 0xFF;
+
+   // Bound on the number of symbols scanned to learn the merges of a block:
+   // each merge requires a pass on the block, so fewer merges are learned on
+   // large blocks
+   private static final long MAX_BLOCK_LEARN_WORK = 1L << 26;
+
+   private final int maxMerges;
+   private final int[] table; // pretrained merges or null
+   private final int[] freqs;
+
+
+   public BPECodec()
+   {
+      this(DEFAULT_MERGES);
+   }
+
+
+   public BPECodec(int maxMerges)
+   {
+      if ((maxMerges < 1) || (maxMerges > MAX_MERGES))
+         throw new IllegalArgumentException("BPE codec: Invalid number of merges: "+
+            maxMerges+" (must be in [1.."+MAX_MERGES+"])");
+
+      this.maxMerges = maxMerges;
+      this.table = null;
+      this.freqs = new int[256];
+   }
+
+
+   // Use a pretrained merge table (see learn())
+   public BPECodec(int[] table)
+   {
+      if (table == null)
+         throw new NullPointerException("BPE codec: Invalid null merge table");
+
+      checkTable(table);
+      this.maxMerges = table.length >> 1;
+      this.table = table.clone();
+      this.freqs = new int[256];
+   }
+
+
+   public BPECodec(Map<String, Object> ctx)
+   {
+      final int[] t = (int[]) ctx.get("bpeTable");
+
+      if (t != null)
+         checkTable(t);
+
+      this.table = (t != null) ? t.clone() : null;
+      this.maxMerges = (this.table != null) ? this.table.length >> 1 :
+         (Integer) ctx.getOrDefault("bpeMerges", DEFAULT_MERGES);
+      this.freqs = new int[256];
+
+      if ((this.maxMerges < 0) || (this.maxMerges > MAX_MERGES))
+         throw new IllegalArgumentException("BPE codec: Invalid number of merges: "+
+            this.maxMerges+" (must be in [0.."+MAX_MERGES+"])");
+   }
+
+
+   private static void checkTable(int[] table)
+   {
+      if (((table.length & 1) != 0) || (table.length > 2*MAX_MERGES))
+         throw new IllegalArgumentException("BPE codec: Invalid merge table length: "+table.length);
+
+      for (int i=0; i<table.length; i++)
+      {
+         if ((table[i] < 0) || (table[i] >= 256+(i>>1)))
+            throw new IllegalArgumentException("BPE codec: Invalid symbol in merge table at index "+i);
+      }
+   }
+
+
+   // Learn a merge table on the provided data (EG. a sample of a corpus).
+   // The result can be provided to the BPECodec(int[]) constructor.
+   public static int[] learn(byte[] data, int start, int end, int maxMerges)
+   {
+      if ((maxMerges < 1) || (maxMerges > MAX_MERGES))
+         throw new IllegalArgumentException("BPE codec: Invalid number of merges: "+
+            maxMerges+" (must be in [1.."+MAX_MERGES+"])");
+
+      final int[] histo = new int[256];
+      Global.computeHistogramOrder0(data, start, end, histo, false);
+      int nbFree = 0;
+
+      for (int i=0; i<256; i++)
+         nbFree += (histo[i] == 0) ? 1 : 0;
+
+      final int[] seq = new int[end-start];
+
+      for (int i=start; i<end; i++)
+         seq[i-start] = data[i] & 0xFF;
+
+      final int[] merges = new int[2*maxMerges];
+      final int[] length = new int[] { seq.length };
+      final int nbMerges = learnMerges(seq, length, -1, Math.max(nbFree-1, 0), maxMerges,
+         Long.MAX_VALUE, merges);
+      return Arrays.copyOf(merges, 2*nbMerges);
+   }
+
+
+   // Cost in bytes of a symbol once encoded
+   private static int cost(int symbol, int escape, int nbCodes)
+   {
+      if (symbol < 256)
+         return (symbol == escape) ? 2 : 1;
+
+      return (symbol-256 < nbCodes) ? 1 : 2;
+   }
+
+
+   // Iteratively merge the pair with the best gain (in bytes). The sequence
+   // is updated in place and its new length returned in length[0].
+   // An escape of -1 means that the escape symbol is absent from the data.
+   // Learning stops once 'maxWork' symbols have been scanned.
+   // Only the pairs present in the sequence are visited (not all the pairs
+   // of the alphabet): each merge costs a pass on the sequence.
+   private static int learnMerges(int[] seq, int[] length, int escape, int nbCodes,
+      int maxMerges, long maxWork, int[] merges)
+   {
+      final int[] counts = new int[MAX_SYMBOLS*MAX_SYMBOLS];
+      final int[] lengths = new int[MAX_SYMBOLS];
+      int n = length[0];
+      final int[] pairs = new int[Math.max(n-1, 0)]; // pairs with a non zero count
+      int nbMerges = 0;
+      long work = 0;
+      Arrays.fill(lengths, 0, 256, 1);
+
+      while ((nbMerges < maxMerges) && (nbMerges-nbCodes < ESCAPE_LITERAL) && (work < maxWork))
+      {
+         int nbPairs = 0;
+         work += n;
+
+         for (int i=0; i<n-1; i++)
+         {
+            final int p = seq[i]*MAX_SYMBOLS + seq[i+1];
+
+            if (counts[p]++ == 0)
+               pairs[nbPairs++] = p;
+         }
+
+         final int newCost = (nbMerges < nbCodes) ? 1 : 2;
+         final int tableCost = (nbMerges < nbCodes) ? 4 : 3;
+         int bestGain = 0;
+         int bestPair = -1;
+
+         for (int k=0; k<nbPairs; k++)
+         {
+            final int p = pairs[k];
+            final int c = counts[p];
+            final int l = p / MAX_SYMBOLS;
+            final int r = p % MAX_SYMBOLS;
+            counts[p] = 0;
+
+            if (lengths[l]+lengths[r] > MAX_EXPANSION)
+               continue;
+
+            final int gain = (cost(l, escape, nbCodes)+cost(r, escape, nbCodes)-newCost)*c - tableCost;
+
+            // Ties go to the smallest pair (independent of the order of the pairs)
+            if ((gain > bestGain) || ((gain == bestGain) && (bestPair >= 0) && (p < bestPair)))
+            {
+               bestGain = gain;
+               bestPair = p;
+            }
+         }
+
+         if (bestPair < 0)
+            break;
+
+         final int l = bestPair / MAX_SYMBOLS;
+         final int r = bestPair % MAX_SYMBOLS;
+         merges[2*nbMerges] = l;
+         merges[2*nbMerges+1] = r;
+         lengths[256+nbMerges] = lengths[l] + lengths[r];
+         n = applyMerge(seq, n, l, r, 256+nbMerges);
+         nbMerges++;
+      }
+
+      length[0] = n;
+      return nbMerges;
+   }
+
+
+   private static int applyMerge(int[] seq, int n, int l, int r, int symbol)
+   {
+      int j = 0;
+      int i = 0;
+
+      while (i < n)
+      {
+         if ((i+1 < n) && (seq[i] == l) && (seq[i+1] == r))
+         {
+            seq[j++] = symbol;
+            i += 2;
+         }
+         else
+         {
+            seq[j++] = seq[i++];
+         }
+      }
+
+      return j;
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (count < MIN_BLOCK_SIZE)
+         return false;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      Global.computeHistogramOrder0(src, srcIdx, srcIdx+count, this.freqs, false);
+
+      // Byte values absent from the block. The first one is the escape.
+      final int[] codes = new int[256];
+      int nbFree = 0;
+      int minIdx = 0;
+
+      for (int i=0; i<256; i++)
+      {
+         if (this.freqs[i] == 0)
+            codes[nbFree++] = i;
+
+         if (this.freqs[i] < this.freqs[minIdx])
+            minIdx = i;
+      }
+
+      final int escape = (nbFree > 0) ? codes[0] : minIdx;
+      final int nbCodes = Math.max(nbFree-1, 0);
+      final int[] seq = new int[count];
+
+      for (int i=0; i<count; i++)
+         seq[i] = src[srcIdx+i] & 0xFF;
+
+      final int[] length = new int[] { count };
+      final int[] merges;
+      int nbMerges;
+
+      if (this.table == null)
+      {
+         merges = new int[2*this.maxMerges];
+         nbMerges = learnMerges(seq, length, (nbFree > 0) ? -1 : escape, nbCodes,
+            this.maxMerges, MAX_BLOCK_LEARN_WORK, merges);
+      }
+      else
+      {
+         merges = this.table;
+         nbMerges = Math.min(this.table.length>>1, nbCodes+ESCAPE_LITERAL);
+
+         for (int i=0; i<nbMerges; i++)
+            length[0] = applyMerge(seq, length[0], merges[2*i], merges[2*i+1], 256+i);
+      }
+
+      final int n = length[0];
+      final int nbSingle = Math.min(nbMerges, nbCodes);
+      final int dstEnd = output.index + count;
+      int dstIdx = output.index;
+
+      // Write header
+      dst[dstIdx++] = (byte) escape;
+      dst[dstIdx++] = (byte) nbMerges;
+      dst[dstIdx++] = (byte) nbSingle;
+
+      for (int i=0; i<nbSingle; i++)
+         dst[dstIdx++] = (byte) codes[i+1];
+
+      for (int i=0; i<nbMerges; i++)
+      {
+         final int l = merges[2*i];
+         final int r = merges[2*i+1];
+         dst[dstIdx++] = (byte) (((l>>8)<<1) | (r>>8));
+         dst[dstIdx++] = (byte) l;
+         dst[dstIdx++] = (byte) r;
+      }
+
+      // Encode symbols, abort if the output is not smaller than the input
+      for (int i=0; i<n; i++)
+      {
+         if (dstIdx+2 > dstEnd)
+            return false;
+
+         final int s = seq[i];
+
+         if (s < 256)
+         {
+            dst[dstIdx++] = (byte) s;
+
+            if (s == escape)
+               dst[dstIdx++] = (byte) ESCAPE_LITERAL;
+         }
+         else if (s-256 < nbSingle)
+         {
+            dst[dstIdx++] = (byte) codes[s-256+1];
+         }
+         else
+         {
+            dst[dstIdx++] = (byte) escape;
+            dst[dstIdx++] = (byte) (s-256-nbSingle);
+         }
+      }
+
+      if (dstIdx >= dstEnd)
+         return false;
+
+      input.index += count;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < 3)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcEnd = input.index + input.length;
+      int srcIdx = input.index;
+      final int escape = src[srcIdx++] & 0xFF;
+      final int nbMerges = src[srcIdx++] & 0xFF;
+      final int nbSingle = src[srcIdx++] & 0xFF;
+
+      if ((nbSingle > nbMerges) || (nbMerges-nbSingle > ESCAPE_LITERAL) ||
+         (srcIdx+nbSingle+3*nbMerges > srcEnd))
+         return false;
+
+      final int[] codeToMerge = new int[256];
+      Arrays.fill(codeToMerge, -1);
+
+      for (int i=0; i<nbSingle; i++)
+      {
+         final int c = src[srcIdx++] & 0xFF;
+
+         if ((c == escape) || (codeToMerge[c] >= 0))
+            return false;
+
+         codeToMerge[c] = i;
+      }
+
+      // Build the expansion of each merge
+      final byte[][] expansions = new byte[nbMerges][];
+
+      for (int i=0; i<nbMerges; i++)
+      {
+         final int hi = src[srcIdx++] & 0xFF;
+         final int l = ((hi>>1)<<8) | (src[srcIdx++] & 0xFF);
+         final int r = ((hi&1)<<8) | (src[srcIdx++] & 0xFF);
+
+         if ((l >= 256+i) || (r >= 256+i))
+            return false;
+
+         final byte[] expL = (l < 256) ? new byte[] { (byte) l } : expansions[l-256];
+         final byte[] expR = (r < 256) ? new byte[] { (byte) r } : expansions[r-256];
+
+         if (expL.length+expR.length > MAX_EXPANSION)
+            return false;
+
+         expansions[i] = new byte[expL.length+expR.length];
+         System.arraycopy(expL, 0, expansions[i], 0, expL.length);
+         System.arraycopy(expR, 0, expansions[i], expL.length, expR.length);
+      }
+
+      final int dstEnd = dst.length;
+      int dstIdx = output.index;
+      boolean res = true;
+
+      while (srcIdx < srcEnd)
+      {
+         final int b = src[srcIdx++] & 0xFF;
+         int m = -1;
+
+         if (b == escape)
+         {
+            if (srcIdx >= srcEnd)
+            {
+               res = false;
+               break;
+            }
+
+            final int idx = src[srcIdx++] & 0xFF;
+
+            if (idx != ESCAPE_LITERAL)
+            {
+               m = nbSingle + idx;
+
+               if (m >= nbMerges)
+               {
+                  res = false;
+                  break;
+               }
+            }
+         }
+         else
+         {
+            m = codeToMerge[b];
+         }
+
+         if (m < 0)
+         {
+            if (dstIdx >= dstEnd)
+            {
+               res = false;
+               break;
+            }
+
+            dst[dstIdx++] = (byte) b;
+            continue;
+         }
+
+         final byte[] exp = expansions[m];
+
+         if (dstIdx+exp.length > dstEnd)
+         {
+            res = false;
+            break;
+         }
+
+         System.arraycopy(exp, 0, dst, dstIdx, exp.length);
+         dstIdx += exp.length;
+      }
+
+      input.index = srcIdx;
+      output.index = dstIdx;
+      return res;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen + 3 + 4*MAX_MERGES;
+   }
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.Map;
+import kanzi.ByteTransform;
+import kanzi.Global;
+import kanzi.SliceByteArray;
+
+
+// Escaped RLE that never expands the data.
+// The forward transform keeps track of the output size and aborts (returns
+// false) as soon as the output would not be smaller than the input. Hence,
+// the output buffer never needs to be larger than the input block and it is
+// safe to always include this transform in a sequence.
+// Format: escape (1 byte) followed by
+//   val              -> literal (val != escape)
+//   escape 0         -> escape literal
+//   escape n         -> repeat previous byte n+2 times (n in [1..254])
+//   escape 255 hi lo -> repeat previous byte ((hi<<8)|lo)+257 times
+
+public class BoundedRLT implements ByteTransform
+{
+   private static final int RUN_THRESHOLD = 4;
+   private static final int RUN_LEN_ESCAPE = 0xFF;
+   private static final int MAX_RUN = 0xFFFF + RUN_LEN_ESCAPE + 3;
+
+   private final int[] freqs;
+
+
+   public BoundedRLT()
+   {
+      this.freqs = new int[256];
+   }
+
+
+   public BoundedRLT(Map<String, Object> ctx)
+   {
+      this.freqs = new int[256];
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (count < 2)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      int srcIdx = input.index;
+      int dstIdx = output.index;
+      final int srcEnd = srcIdx + count;
+
+      // Abort as soon as the output is as big as the input
+      final int dstEnd = Math.min(dst.length, output.index+count-1);
+
+      if (dstIdx >= dstEnd)
+         return false;
+
+      Global.computeHistogramOrder0(src, srcIdx, srcEnd, this.freqs, false);
+      int minIdx = 0;
+
+      // Select least frequent symbol as escape
+      for (int i=1; (i<256) && (this.freqs[minIdx]>0); i++)
+      {
+         if (this.freqs[i] < this.freqs[minIdx])
+            minIdx = i;
+      }
+
+      final byte escape = (byte) minIdx;
+      boolean res = true;
+      dst[dstIdx++] = escape;
+
+      while (srcIdx < srcEnd)
+      {
+         final int runStart = srcIdx;
+         final byte val = src[srcIdx++];
+         int run = 1;
+
+         while ((srcIdx < srcEnd) && (src[srcIdx] == val) && (run < MAX_RUN))
+         {
+            srcIdx++;
+            run++;
+         }
+
+         final int litSize = (val == escape) ? 2 : 1;
+
+         if (run >= RUN_THRESHOLD)
+         {
+            final int len = run - 3;
+            final int runSize = (len < RUN_LEN_ESCAPE) ? 2 : 4;
+
+            if (dstIdx+litSize+runSize > dstEnd)
+            {
+               srcIdx = runStart;
+               res = false;
+               break;
+            }
+
+            dstIdx = emitLiteral(dst, dstIdx, val, escape);
+            dst[dstIdx++] = escape;
+
+            if (len < RUN_LEN_ESCAPE)
+            {
+               dst[dstIdx++] = (byte) len;
+            }
+            else
+            {
+               dst[dstIdx++] = (byte) RUN_LEN_ESCAPE;
+               dst[dstIdx++] = (byte) ((len-RUN_LEN_ESCAPE) >> 8);
+               dst[dstIdx++] = (byte) (len-RUN_LEN_ESCAPE);
+            }
+         }
+         else
+         {
+            if (dstIdx+run*litSize > dstEnd)
+            {
+               srcIdx = runStart;
+               res = false;
+               break;
+            }
+
+            while (run-- > 0)
+               dstIdx = emitLiteral(dst, dstIdx, val, escape);
+         }
+      }
+
+      input.index = srcIdx;
+      output.index = dstIdx;
+      return res;
+   }
+
+
+   private static int emitLiteral(byte[] dst, int dstIdx, byte val, byte escape)
+   {
+      dst[dstIdx++] = val;
+
+      if (val == escape)
+         dst[dstIdx++] = 0;
+
+      return dstIdx;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      int srcIdx = input.index;
+      int dstIdx = output.index;
+      final int srcEnd = srcIdx + input.length;
+      final int dstEnd = dst.length;
+      final byte escape = src[srcIdx++];
+      boolean res = true;
+
+      while (srcIdx < srcEnd)
+      {
+         final byte val = src[srcIdx++];
+
+         if (val != escape)
+         {
+            if (dstIdx >= dstEnd)
+            {
+               res = false;
+               break;
+            }
+
+            dst[dstIdx++] = val;
+            continue;
+         }
+
+         if (srcIdx >= srcEnd)
+         {
+            res = false;
+            break;
+         }
+
+         int len = src[srcIdx++] & 0xFF;
+
+         if (len == 0)
+         {
+            // Escape literal
+            if (dstIdx >= dstEnd)
+            {
+               res = false;
+               break;
+            }
+
+            dst[dstIdx++] = escape;
+            continue;
+         }
+
+         if (len == RUN_LEN_ESCAPE)
+         {
+            if (srcIdx+2 > srcEnd)
+            {
+               res = false;
+               break;
+            }
+
+            len = (((src[srcIdx]&0xFF)<<8) | (src[srcIdx+1]&0xFF)) + RUN_LEN_ESCAPE;
+            srcIdx += 2;
+         }
+
+         int run = len + 2;
+
+         // A run must follow a literal
+         if ((dstIdx == output.index) || (dstIdx+run > dstEnd))
+         {
+            res = false;
+            break;
+         }
+
+         final byte prev = dst[dstIdx-1];
+
+         while (run-- > 0)
+            dst[dstIdx++] = prev;
+      }
+
+      input.index = srcIdx;
+      output.index = dstIdx;
+      return res;
+   }
+}
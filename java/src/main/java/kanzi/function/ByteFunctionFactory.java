@@ -15,10 +15,18 @@ limitations under the License.
 
 package kanzi.function;
 
+import java.util.ArrayList;
+import java.util.List;
 import java.util.Map;
 import kanzi.ByteTransform;
 import kanzi.transform.BWTS;
+import kanzi.transform.HilbertCurveCodec;
+import kanzi.transform.LaneGroupCodec;
+import kanzi.transform.MortonOrderCodec;
+import kanzi.transform.StridedColumnDeltaCodec;
 import kanzi.transform.SBRT;
+import kanzi.transform.TransposeCodec;
+import kanzi.transform.ZigZagScanCodec;
 
 
 public class ByteFunctionFactory
@@ -43,6 +51,33 @@ public class ByteFunctionFactory
    public static final short ROLZX_TYPE   = 12; // ROLZ Extra codec
    public static final short SRT_TYPE     = 13; // Sorted Rank
    public static final short LZP_TYPE     = 14; // Lempel Ziv Predict
+   public static final short BRLT_TYPE    = 15; // Bounded Run Length
+   public static final short JOINT_TYPE   = 16; // Joint columns
+   public static final short BPE_TYPE     = 17; // Byte Pair Encoding
+   public static final short LOGMAP_TYPE  = 18; // Logarithmic mapping
+   public static final short COUNTER_TYPE = 19; // Counter field
+   public static final short TRANSPOSE_TYPE = 20; // Matrix transposition
+   public static final short ADAPT_TYPE   = 21; // Adaptive BWT/LZ selection
+   public static final short MTFZRLE_TYPE = 22; // Fused MTF and zero run length
+   public static final short SIGNMAG_TYPE = 23; // Sign/magnitude split
+   public static final short TSDELTA_TYPE = 24; // Timestamp delta
+   public static final short HILBERT_TYPE = 25; // Hilbert curve reordering
+   public static final short JSONNUM_TYPE = 26; // Numeric literal extraction
+   public static final short COLDELTA_TYPE = 27; // Strided column delta
+   public static final short DECIMAL_TYPE = 28; // Decimal number split
+   public static final short IPFIELD_TYPE = 29; // IP address field split
+   public static final short TEMPLATE_TYPE = 30; // Log line templates
+   public static final short WSFOLD_TYPE  = 31; // Whitespace runs folding
+   public static final short MORTON_TYPE  = 32; // Morton (Z-order) reordering
+   public static final short SPARSE_TYPE  = 33; // Sparse data bitmap split
+   public static final short LINEDEDUP_TYPE = 34; // Repeated lines deduplication
+   public static final short TEXTBLOB_TYPE = 35; // Base64/hex blob decoding
+   public static final short UTF8REMAP_TYPE = 36; // UTF-8 code point remapping
+   public static final short FIXEDPOINT_TYPE = 37; // Fixed point decimals as integers
+   public static final short LANEGROUP_TYPE = 38; // Grouping of bytes by lane
+   public static final short ANYTIME_TYPE = 39; // Best configuration within a time budget
+   public static final short SORTEDSET_TYPE = 40; // Sorted integer set
+   public static final short ZIGZAG_TYPE  = 41; // Zig-zag scan of DCT coefficients
  
 
    // The returned type contains 8 transform values
@@ -121,6 +156,87 @@ public class ByteFunctionFactory
          case "RLT":
             return RLT_TYPE;
 
+         case "BRLT":
+            return BRLT_TYPE;
+
+         case "JOINT":
+            return JOINT_TYPE;
+
+         case "BPE":
+            return BPE_TYPE;
+
+         case "LOGMAP":
+            return LOGMAP_TYPE;
+
+         case "COUNTER":
+            return COUNTER_TYPE;
+
+         case "TRANSPOSE":
+            return TRANSPOSE_TYPE;
+
+         case "ADAPT":
+            return ADAPT_TYPE;
+
+         case "MTFZRLE":
+            return MTFZRLE_TYPE;
+
+         case "SIGNMAG":
+            return SIGNMAG_TYPE;
+
+         case "TSDELTA":
+            return TSDELTA_TYPE;
+
+         case "HILBERT":
+            return HILBERT_TYPE;
+
+         case "JSONNUM":
+            return JSONNUM_TYPE;
+
+         case "COLDELTA":
+            return COLDELTA_TYPE;
+
+         case "DECIMAL":
+            return DECIMAL_TYPE;
+
+         case "IPFIELD":
+            return IPFIELD_TYPE;
+
+         case "TEMPLATE":
+            return TEMPLATE_TYPE;
+
+         case "WSFOLD":
+            return WSFOLD_TYPE;
+
+         case "MORTON":
+            return MORTON_TYPE;
+
+         case "SPARSE":
+            return SPARSE_TYPE;
+
+         case "LINEDEDUP":
+            return LINEDEDUP_TYPE;
+
+         case "TEXTBLOB":
+            return TEXTBLOB_TYPE;
+
+         case "UTF8REMAP":
+            return UTF8REMAP_TYPE;
+
+         case "FIXEDPOINT":
+            return FIXEDPOINT_TYPE;
+
+         case "LANEGROUP":
+            return LANEGROUP_TYPE;
+
+         case "ANYTIME":
+            return ANYTIME_TYPE;
+
+         case "SORTEDSET":
+            return SORTEDSET_TYPE;
+
+         case "ZIGZAG":
+            return ZIGZAG_TYPE;
+
          case "X86":
             return X86_TYPE;
 
@@ -163,21 +279,26 @@ public class ByteFunctionFactory
    }
    
    
+   // Select text encoding based on entropy codec.
+   public static int getTextCodecType(String entropyType)
+   {
+      entropyType = String.valueOf(entropyType).toUpperCase();
+
+      if (entropyType.equals("NONE") || entropyType.equals("ANS0") ||
+          entropyType.equals("HUFFMAN") || entropyType.equals("RANGE"))
+         return 2;
+
+      return 1;
+   }
+
+
    private static ByteTransform newFunctionToken(Map<String, Object> ctx, int functionType)
    {
       switch (functionType)
       {
          case DICT_TYPE:            
             String entropyType = (String) ctx.getOrDefault("codec", "NONE");
-            entropyType = entropyType.toUpperCase();
-            int textCodecType  = 1;
-            
-            // Select text encoding based on entropy codec.
-            if (entropyType.equals("NONE") || entropyType.equals("ANS0") ||
-                entropyType.equals("HUFFMAN") || entropyType.equals("RANGE"))
-               textCodecType = 2;
-               
-            ctx.put("textcodec", textCodecType);
+            ctx.put("textcodec", getTextCodecType(entropyType));
             return new TextCodec(ctx);
 
          case ROLZ_TYPE:
@@ -208,6 +329,87 @@ public class ByteFunctionFactory
             
          case RLT_TYPE:
             return new RLT(ctx);
+
+         case BRLT_TYPE:
+            return new BoundedRLT(ctx);
+
+         case JOINT_TYPE:
+            return new JointColumnCodec(ctx);
+
+         case BPE_TYPE:
+            return new BPECodec(ctx);
+
+         case LOGMAP_TYPE:
+            return new LogMapCodec(ctx);
+
+         case COUNTER_TYPE:
+            return new CounterFieldCodec(ctx);
+
+         case TRANSPOSE_TYPE:
+            return new TransposeCodec(ctx);
+
+         case ADAPT_TYPE:
+            return new AdaptiveCodec(ctx);
+
+         case MTFZRLE_TYPE:
+            return new MTFZeroRLE(ctx);
+
+         case SIGNMAG_TYPE:
+            return new SignMagnitudeSplitCodec(ctx);
+
+         case TSDELTA_TYPE:
+            return new TimestampDeltaCodec(ctx);
+
+         case HILBERT_TYPE:
+            return new HilbertCurveCodec(ctx);
+
+         case JSONNUM_TYPE:
+            return new JSONNumberExtractCodec(ctx);
+
+         case COLDELTA_TYPE:
+            return new StridedColumnDeltaCodec(ctx);
+
+         case DECIMAL_TYPE:
+            return new DecimalTextCodec(ctx);
+
+         case IPFIELD_TYPE:
+            return new IPFieldCodec(ctx);
+
+         case TEMPLATE_TYPE:
+            return new TemplateCodec(ctx);
+
+         case WSFOLD_TYPE:
+            return new WhitespaceFoldCodec(ctx);
+
+         case MORTON_TYPE:
+            return new MortonOrderCodec(ctx);
+
+         case SPARSE_TYPE:
+            return new SparseMaskCodec(ctx);
+
+         case LINEDEDUP_TYPE:
+            return new LineDedupCodec(ctx);
+
+         case TEXTBLOB_TYPE:
+            return new TextBlobDecodeCodec(ctx);
+
+         case UTF8REMAP_TYPE:
+            return new UTF8RemapCodec(ctx);
+
+         case FIXEDPOINT_TYPE:
+            return new FixedPointCodec(ctx);
+
+         case LANEGROUP_TYPE:
+            return new LaneGroupCodec(ctx);
+
+         case ANYTIME_TYPE:
+            return new AnytimeCodec(ctx);
+
+         case SORTEDSET_TYPE:
+            return new SortedIntSetCodec(ctx);
+
+         case ZIGZAG_TYPE:
+            return new ZigZagScanCodec(ctx);
                         
          case LZ_TYPE:
             ctx.put("lz", LZ_TYPE);
@@ -229,6 +431,77 @@ public class ByteFunctionFactory
    }
 
    
+   // Return the names of all the declared transforms (except NONE)
+   public static List<String> getTransformNames()
+   {
+      List<String> res = new ArrayList<>();
+
+      for (int t=NONE_TYPE+1; t<=MASK; t++)
+      {
+         try
+         {
+            res.add(getNameToken(t));
+         }
+         catch (IllegalArgumentException e)
+         {
+            // Unknown or obsolete type
+         }
+      }
+
+      return res;
+   }
+
+
+   // Return the names of the context parameters (integer values) required by
+   // the inverse of the transforms. The other transforms record their
+   // parameters in the transformed data.
+   public static List<String> getInverseParameters(long functionType)
+   {
+      List<String> res = new ArrayList<>();
+
+      for (int i=0; i<8; i++)
+      {
+         final int t = (int) ((functionType >>> (MAX_SHIFT-ONE_SHIFT*i)) & MASK);
+
+         switch (t)
+         {
+            case TRANSPOSE_TYPE:
+               res.add("transposeRows");
+               res.add("transposeCols");
+               break;
+
+            case HILBERT_TYPE:
+               res.add("hilbertWidth");
+               res.add("hilbertHeight");
+               break;
+
+            case COLDELTA_TYPE:
+               res.add("columnStride");
+               break;
+
+            case MORTON_TYPE:
+               res.add("mortonWidth");
+               res.add("mortonHeight");
+               break;
+
+            case LANEGROUP_TYPE:
+               res.add("laneCount");
+               break;
+
+            case ZIGZAG_TYPE:
+               res.add("zigzagDim");
+               res.add("zigzagWidth");
+               break;
+
+            default:
+               break;
+         }
+      }
+
+      return res;
+   }
+
+
    public String getName(long functionType)
    {              
       StringBuilder sb = new StringBuilder();
@@ -288,6 +561,87 @@ public class ByteFunctionFactory
             
          case RLT_TYPE:
             return "RLT";
+
+         case BRLT_TYPE:
+            return "BRLT";
+
+         case JOINT_TYPE:
+            return "JOINT";
+
+         case BPE_TYPE:
+            return "BPE";
+
+         case LOGMAP_TYPE:
+            return "LOGMAP";
+
+         case COUNTER_TYPE:
+            return "COUNTER";
+
+         case TRANSPOSE_TYPE:
+            return "TRANSPOSE";
+
+         case ADAPT_TYPE:
+            return "ADAPT";
+
+         case MTFZRLE_TYPE:
+            return "MTFZRLE";
+
+         case SIGNMAG_TYPE:
+            return "SIGNMAG";
+
+         case TSDELTA_TYPE:
+            return "TSDELTA";
+
+         case HILBERT_TYPE:
+            return "HILBERT";
+
+         case JSONNUM_TYPE:
+            return "JSONNUM";
+
+         case COLDELTA_TYPE:
+            return "COLDELTA";
+
+         case DECIMAL_TYPE:
+            return "DECIMAL";
+
+         case IPFIELD_TYPE:
+            return "IPFIELD";
+
+         case TEMPLATE_TYPE:
+            return "TEMPLATE";
+
+         case WSFOLD_TYPE:
+            return "WSFOLD";
+
+         case MORTON_TYPE:
+            return "MORTON";
+
+         case SPARSE_TYPE:
+            return "SPARSE";
+
+         case LINEDEDUP_TYPE:
+            return "LINEDEDUP";
+
+         case TEXTBLOB_TYPE:
+            return "TEXTBLOB";
+
+         case UTF8REMAP_TYPE:
+            return "UTF8REMAP";
+
+         case FIXEDPOINT_TYPE:
+            return "FIXEDPOINT";
+
+         case LANEGROUP_TYPE:
+            return "LANEGROUP";
+
+         case ANYTIME_TYPE:
+            return "ANYTIME";
+
+         case SORTEDSET_TYPE:
+            return "SORTEDSET";
+
+         case ZIGZAG_TYPE:
+            return "ZIGZAG";
             
          case X86_TYPE:
             return "X86";
@@ -21,6 +21,9 @@ import kanzi.SliceByteArray;
 
 
 // Encapsulates a sequence of transforms or functions in a function 
+// A transform may appear several times (eg. BWT+MTFT+BWT): each stage is a 
+// distinct instance with its own state (such as the BWT primary indexes, 
+// which every BWT stage writes in its own block header).
 public class ByteTransformSequence implements ByteFunction
 {
    private static final int SKIP_MASK = 0xFF;
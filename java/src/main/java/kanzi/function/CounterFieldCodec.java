@@ -0,0 +1,400 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.HashMap;
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Compact coding of a counter (EG. sequence number, incrementing ID) stored
+// at a fixed position in fixed size records. The field (unsigned, big endian,
+// 1 to 8 bytes) is removed from the records and replaced by a start value and
+// a step. Values that do not follow the counter (EG. gaps) are stored as
+// exceptions and the counter resumes from the exception value.
+// The transform fails if the field is not a clean counter.
+// Format: stride (2 bytes), offset (2 bytes), width (1 byte), number of
+//         records n (4 bytes), start (width bytes), step (width bytes),
+//         number of exceptions (4 bytes), exceptions (record index delta as
+//         varint + value), records without the field, remaining bytes
+//         (block size % stride)
+public class CounterFieldCodec implements ByteFunction
+{
+   private static final int MAX_STRIDE = 65535;
+   private static final int MIN_RECORDS = 8;
+   private static final int STEP_SAMPLES = 64;
+   private static final int HEADER_SIZE = 13;
+
+   private final int stride;
+   private final int offset;
+   private final int width;
+
+
+   public CounterFieldCodec()
+   {
+      this(16, 0, 4);
+   }
+
+
+   public CounterFieldCodec(int stride, int offset, int width)
+   {
+      if ((stride < 1) || (stride > MAX_STRIDE))
+         throw new IllegalArgumentException("Counter field codec: Invalid stride: "+stride+" (must be in [1.."+MAX_STRIDE+"])");
+
+      if ((width < 1) || (width > 8))
+         throw new IllegalArgumentException("Counter field codec: Invalid field width: "+width+" (must be in [1..8])");
+
+      if ((offset < 0) || (offset+width > stride))
+         throw new IllegalArgumentException("Counter field codec: The field must fit in the record");
+
+      this.stride = stride;
+      this.offset = offset;
+      this.width = width;
+   }
+
+
+   public CounterFieldCodec(Map<String, Object> ctx)
+   {
+      this((Integer) ctx.getOrDefault("counterStride", 16),
+         (Integer) ctx.getOrDefault("counterOffset", 0),
+         (Integer) ctx.getOrDefault("counterWidth", 4));
+   }
+
+
+   private static long readField(byte[] buf, int idx, int width)
+   {
+      long v = 0;
+
+      for (int i=0; i<width; i++)
+         v = (v<<8) | (buf[idx+i]&0xFF);
+
+      return v;
+   }
+
+
+   private static void writeField(byte[] buf, int idx, int width, long v)
+   {
+      for (int i=width-1; i>=0; i--)
+      {
+         buf[idx+i] = (byte) v;
+         v >>>= 8;
+      }
+   }
+
+
+   private static int getVarIntSize(int v)
+   {
+      int res = 1;
+
+      while (v >= 0x80)
+      {
+         v >>>= 7;
+         res++;
+      }
+
+      return res;
+   }
+
+
+   // Return the most frequent difference between consecutive values in the
+   // first records
+   private long findStep(byte[] src, int srcIdx, int n, long mask)
+   {
+      Map<Long, Integer> freqs = new HashMap<>();
+      final int end = Math.min(n, STEP_SAMPLES+1);
+      long prev = readField(src, srcIdx+this.offset, this.width);
+      long step = 0;
+      int max = 0;
+
+      for (int i=1; i<end; i++)
+      {
+         final long v = readField(src, srcIdx+i*this.stride+this.offset, this.width);
+         final long delta = (v-prev) & mask;
+         final int f = freqs.getOrDefault(delta, 0) + 1;
+         freqs.put(delta, f);
+
+         if (f > max)
+         {
+            max = f;
+            step = delta;
+         }
+
+         prev = v;
+      }
+
+      return step;
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+      final int n = count / this.stride;
+
+      if (n < MIN_RECORDS)
+         return false;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int w = this.width;
+      final long mask = (w == 8) ? -1L : (1L<<(8*w)) - 1;
+      final long step = this.findStep(src, srcIdx, n, mask);
+
+      // First pass: count exceptions and compute the encoded size
+      final long start = readField(src, srcIdx+this.offset, w);
+      long prev = start;
+      int nbExceptions = 0;
+      int lastIdx = 0;
+      long size = HEADER_SIZE + 2*w + ((long) n)*(this.stride-w) + (count-n*this.stride);
+
+      for (int i=1; i<n; i++)
+      {
+         final long v = readField(src, srcIdx+i*this.stride+this.offset, w);
+
+         if (v != ((prev+step) & mask))
+         {
+            nbExceptions++;
+            size += getVarIntSize(i-lastIdx) + w;
+            lastIdx = i;
+         }
+
+         prev = v;
+      }
+
+      // Not a clean counter
+      if ((nbExceptions > (n>>2)) || (size >= count))
+         return false;
+
+      final int dstStart = output.index;
+      int dstIdx = dstStart;
+
+      // Write header
+      dst[dstIdx++] = (byte) (this.stride>>8);
+      dst[dstIdx++] = (byte) this.stride;
+      dst[dstIdx++] = (byte) (this.offset>>8);
+      dst[dstIdx++] = (byte) this.offset;
+      dst[dstIdx++] = (byte) w;
+      dst[dstIdx++] = (byte) (n>>24);
+      dst[dstIdx++] = (byte) (n>>16);
+      dst[dstIdx++] = (byte) (n>>8);
+      dst[dstIdx++] = (byte) n;
+      writeField(dst, dstIdx, w, start);
+      dstIdx += w;
+      writeField(dst, dstIdx, w, step);
+      dstIdx += w;
+      dst[dstIdx++] = (byte) (nbExceptions>>24);
+      dst[dstIdx++] = (byte) (nbExceptions>>16);
+      dst[dstIdx++] = (byte) (nbExceptions>>8);
+      dst[dstIdx++] = (byte) nbExceptions;
+
+      // Exceptions
+      prev = start;
+      lastIdx = 0;
+
+      for (int i=1; i<n; i++)
+      {
+         final long v = readField(src, srcIdx+i*this.stride+this.offset, w);
+
+         if (v != ((prev+step) & mask))
+         {
+            int delta = i - lastIdx;
+
+            while (delta >= 0x80)
+            {
+               dst[dstIdx++] = (byte) (0x80|(delta&0x7F));
+               delta >>>= 7;
+            }
+
+            dst[dstIdx++] = (byte) delta;
+            writeField(dst, dstIdx, w, v);
+            dstIdx += w;
+            lastIdx = i;
+         }
+
+         prev = v;
+      }
+
+      // Records without the counter field
+      final int after = this.stride - this.offset - w;
+
+      for (int i=0; i<n; i++)
+      {
+         final int idx = srcIdx + i*this.stride;
+         System.arraycopy(src, idx, dst, dstIdx, this.offset);
+         dstIdx += this.offset;
+         System.arraycopy(src, idx+this.offset+w, dst, dstIdx, after);
+         dstIdx += after;
+      }
+
+      // Remaining bytes
+      for (int i=n*this.stride; i<count; i++)
+         dst[dstIdx++] = src[srcIdx+i];
+
+      input.index += count;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < HEADER_SIZE+2)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcEnd = input.index + input.length;
+      int srcIdx = input.index;
+      final int stride = ((src[srcIdx]&0xFF)<<8) | (src[srcIdx+1]&0xFF);
+      final int offset = ((src[srcIdx+2]&0xFF)<<8) | (src[srcIdx+3]&0xFF);
+      final int w = src[srcIdx+4] & 0xFF;
+      srcIdx += 5;
+
+      if ((w < 1) || (w > 8) || (offset+w > stride))
+         return false;
+
+      final int n = ((src[srcIdx]&0xFF)<<24) | ((src[srcIdx+1]&0xFF)<<16) |
+         ((src[srcIdx+2]&0xFF)<<8) | (src[srcIdx+3]&0xFF);
+      srcIdx += 4;
+
+      if ((n < 0) || ((long) n*stride > dst.length-output.index) || (srcIdx+2*w+4 > srcEnd))
+         return false;
+
+      final long mask = (w == 8) ? -1L : (1L<<(8*w)) - 1;
+      final long start = readField(src, srcIdx, w);
+      srcIdx += w;
+      final long step = readField(src, srcIdx, w);
+      srcIdx += w;
+      final int nbExceptions = ((src[srcIdx]&0xFF)<<24) | ((src[srcIdx+1]&0xFF)<<16) |
+         ((src[srcIdx+2]&0xFF)<<8) | (src[srcIdx+3]&0xFF);
+      srcIdx += 4;
+
+      if ((nbExceptions < 0) || (nbExceptions >= n))
+         return false;
+
+      // Skip exceptions to find the records
+      int recIdx = srcIdx;
+
+      for (int i=0; i<nbExceptions; i++)
+      {
+         while ((recIdx < srcEnd) && ((src[recIdx]&0x80) != 0))
+            recIdx++;
+
+         recIdx += 1 + w;
+      }
+
+      if ((long) recIdx + (long) n*(stride-w) > srcEnd)
+         return false;
+
+      final int dstStart = output.index;
+      final int after = stride - offset - w;
+      int excLeft = nbExceptions;
+      int nextExc = -1;
+      long prev = 0;
+
+      for (int i=0; i<n; i++)
+      {
+         if ((nextExc < i) && (excLeft > 0))
+         {
+            // Read next exception index
+            int delta = 0;
+            int shift = 0;
+
+            while (true)
+            {
+               final int b = src[srcIdx++] & 0xFF;
+               delta |= ((b&0x7F) << shift);
+
+               if (b < 0x80)
+                  break;
+
+               shift += 7;
+
+               if (shift > 28)
+                  return false;
+            }
+
+            if ((delta <= 0) || (delta > n-1-Math.max(nextExc, 0)))
+               return false;
+
+            nextExc = Math.max(nextExc, 0) + delta;
+            excLeft--;
+         }
+
+         long v;
+
+         if (i == 0)
+            v = start;
+         else if (i == nextExc)
+         {
+            v = readField(src, srcIdx, w);
+            srcIdx += w;
+         }
+         else
+            v = (prev+step) & mask;
+
+         final int idx = dstStart + i*stride;
+         System.arraycopy(src, recIdx, dst, idx, offset);
+         recIdx += offset;
+         writeField(dst, idx+offset, w, v);
+         System.arraycopy(src, recIdx, dst, idx+offset+w, after);
+         recIdx += after;
+         prev = v;
+      }
+
+      if (excLeft != 0)
+         return false;
+
+      // Remaining bytes
+      int dstIdx = dstStart + n*stride;
+      final int remaining = srcEnd - recIdx;
+
+      if ((remaining >= stride) || (dstIdx+remaining > dst.length))
+         return false;
+
+      System.arraycopy(src, recIdx, dst, dstIdx, remaining);
+      dstIdx += remaining;
+      input.index = srcEnd;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen + HEADER_SIZE + 16;
+   }
+}
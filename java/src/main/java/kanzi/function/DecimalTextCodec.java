@@ -0,0 +1,459 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Separation of the components of the decimal numbers of a text (EG. CSV,
+// scientific data). Each decimal number is replaced by a placeholder in the
+// text. Its format (signs, decimal point, exponent case) goes to a flag
+// stream and its digits go to 3 streams (integer parts, fractional parts,
+// exponents) packed as nibbles (digits, 0xF terminates a part). The exact
+// formatting is preserved (leading and trailing zeros, signs, 'e' or 'E').
+// A decimal number is an optional sign, digits, and a decimal point and/or
+// an exponent. It must not follow a letter, a digit, '_' or '.'. Integers
+// remain in the text. Literal placeholder and escape bytes in the text are
+// escaped.
+// The transform fails if there are too few decimal numbers or if the output
+// is not smaller than the input.
+// Format: number of decimals n (4 bytes), text length (4 bytes), size of
+//         integer stream (4 bytes), size of fraction stream (4 bytes), text,
+//         flags (n bytes), integer stream, fraction stream, exponent stream
+public class DecimalTextCodec implements ByteFunction
+{
+   private static final int HEADER_SIZE = 16;
+   private static final int MIN_NUMBERS = 16;
+   private static final byte NUMBER = 0x01;
+   private static final byte ESCAPE = 0x02;
+   private static final int END_OF_PART = 0x0F;
+
+   // Flags
+   private static final int SIGN_MINUS = 1;
+   private static final int SIGN_PLUS = 2;
+   private static final int SIGN_MASK = 3;
+   private static final int DOT = 4;
+   private static final int EXPONENT = 8;
+   private static final int UPPER_CASE_E = 16;
+   private static final int EXP_SIGN_SHIFT = 5;
+
+   private byte[][] streams; // flags, integer, fraction, exponent
+
+
+   public DecimalTextCodec()
+   {
+      this.streams = new byte[4][0];
+   }
+
+
+   public DecimalTextCodec(Map<String, Object> ctx)
+   {
+      this();
+   }
+
+
+   private static boolean isDigit(byte b)
+   {
+      return (b >= '0') && (b <= '9');
+   }
+
+
+   // Return true if a number can start after this byte
+   private static boolean isSeparator(byte b)
+   {
+      return (isDigit(b) == false) && (b != '_') && (b != '.') &&
+         ((b|0x20) < 'a' || (b|0x20) > 'z');
+   }
+
+
+   private static int getSign(byte b)
+   {
+      return (b == '-') ? SIGN_MINUS : ((b == '+') ? SIGN_PLUS : 0);
+   }
+
+
+   private static void putNibble(byte[] buf, int pos, int val)
+   {
+      if ((pos & 1) == 0)
+         buf[pos>>1] = (byte) (val<<4);
+      else
+         buf[pos>>1] |= (byte) val;
+   }
+
+
+   // Append digits and the end of part marker, return the new nibble position
+   private static int putDigits(byte[] buf, int pos, byte[] src, int start, int end)
+   {
+      for (int i=start; i<end; i++)
+         putNibble(buf, pos++, src[i]-'0');
+
+      putNibble(buf, pos++, END_OF_PART);
+      return pos;
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int srcEnd = srcIdx + count;
+      final int dstStart = output.index;
+
+      // The encoded output must be smaller than the input
+      final int limit = dstStart + count - HEADER_SIZE;
+
+      for (int k=0; k<this.streams.length; k++)
+      {
+         if (this.streams[k].length < count)
+            this.streams[k] = new byte[count];
+      }
+
+      final byte[] flags = this.streams[0];
+      final int[] pos = new int[4]; // flags in bytes, others in nibbles
+      int dstIdx = dstStart + HEADER_SIZE;
+      int i = srcIdx;
+
+      while (i < srcEnd)
+      {
+         final byte cur = src[i];
+
+         if ((i == srcIdx) || (isSeparator(src[i-1]) == true))
+         {
+            // Parse the mantissa
+            int j = i;
+            final int sign = getSign(cur);
+
+            if (sign != 0)
+               j++;
+
+            final int intStart = j;
+
+            while ((j < srcEnd) && (isDigit(src[j]) == true))
+               j++;
+
+            final int intEnd = j;
+            int fracStart = j;
+            int fracEnd = j;
+            boolean dot = false;
+
+            if ((j < srcEnd) && (src[j] == '.') &&
+               ((intEnd > intStart) || ((j+1 < srcEnd) && (isDigit(src[j+1]) == true))))
+            {
+               dot = true;
+               j++;
+               fracStart = j;
+
+               while ((j < srcEnd) && (isDigit(src[j]) == true))
+                  j++;
+
+               fracEnd = j;
+            }
+
+            if ((intEnd > intStart) || (fracEnd > fracStart))
+            {
+               // Parse the exponent
+               int expStart = -1;
+               int expSign = 0;
+
+               if ((j+1 < srcEnd) && ((src[j] == 'e') || (src[j] == 'E')))
+               {
+                  int k = j + 1;
+                  expSign = getSign(src[k]);
+
+                  if (expSign != 0)
+                     k++;
+
+                  if ((k < srcEnd) && (isDigit(src[k]) == true))
+                  {
+                     expStart = k;
+
+                     while ((k < srcEnd) && (isDigit(src[k]) == true))
+                        k++;
+                  }
+
+                  if (expStart < 0)
+                     expSign = 0;
+                  else
+                     j = k;
+               }
+
+               if ((dot == true) || (expStart >= 0))
+               {
+                  if (dstIdx >= limit)
+                     return false;
+
+                  // Decimal number
+                  int flag = sign;
+
+                  if (dot == true)
+                     flag |= DOT;
+
+                  pos[1] = putDigits(this.streams[1], pos[1], src, intStart, intEnd);
+
+                  if (dot == true)
+                     pos[2] = putDigits(this.streams[2], pos[2], src, fracStart, fracEnd);
+
+                  if (expStart >= 0)
+                  {
+                     flag |= EXPONENT | (expSign<<EXP_SIGN_SHIFT);
+
+                     if (src[expStart-((expSign == 0) ? 1 : 2)] == 'E')
+                        flag |= UPPER_CASE_E;
+
+                     pos[3] = putDigits(this.streams[3], pos[3], src, expStart, j);
+                  }
+
+                  flags[pos[0]++] = (byte) flag;
+                  dst[dstIdx++] = NUMBER;
+                  i = j;
+                  continue;
+               }
+
+               // Integer: copy as is
+               if (dstIdx + (intEnd-i) >= limit)
+                  return false;
+
+               System.arraycopy(src, i, dst, dstIdx, intEnd-i);
+               dstIdx += (intEnd-i);
+               i = intEnd;
+               continue;
+            }
+         }
+
+         if ((cur == NUMBER) || (cur == ESCAPE))
+         {
+            if (dstIdx >= limit)
+               return false;
+
+            dst[dstIdx++] = ESCAPE;
+         }
+
+         if (dstIdx >= limit)
+            return false;
+
+         dst[dstIdx++] = cur;
+         i++;
+      }
+
+      final int n = pos[0];
+
+      if (n < MIN_NUMBERS)
+         return false;
+
+      final int textLength = dstIdx - dstStart - HEADER_SIZE;
+      final int intSize = (pos[1]+1) >> 1;
+      final int fracSize = (pos[2]+1) >> 1;
+      final int expSize = (pos[3]+1) >> 1;
+
+      if ((long) dstIdx + n + intSize + fracSize + expSize >= dstStart + count)
+         return false;
+
+      System.arraycopy(flags, 0, dst, dstIdx, n);
+      dstIdx += n;
+      System.arraycopy(this.streams[1], 0, dst, dstIdx, intSize);
+      dstIdx += intSize;
+      System.arraycopy(this.streams[2], 0, dst, dstIdx, fracSize);
+      dstIdx += fracSize;
+      System.arraycopy(this.streams[3], 0, dst, dstIdx, expSize);
+      dstIdx += expSize;
+      final int[] header = { n, textLength, intSize, fracSize };
+
+      for (int k=0; k<header.length; k++)
+      {
+         dst[dstStart+4*k]   = (byte) (header[k]>>24);
+         dst[dstStart+4*k+1] = (byte) (header[k]>>16);
+         dst[dstStart+4*k+2] = (byte) (header[k]>>8);
+         dst[dstStart+4*k+3] = (byte) header[k];
+      }
+
+      input.index += count;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < HEADER_SIZE)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcStart = input.index;
+      final int srcEnd = srcStart + input.length;
+      final int[] header = new int[4];
+
+      for (int k=0; k<header.length; k++)
+      {
+         final int idx = srcStart + 4*k;
+         header[k] = ((src[idx]&0xFF)<<24) | ((src[idx+1]&0xFF)<<16) |
+            ((src[idx+2]&0xFF)<<8) | (src[idx+3]&0xFF);
+
+         if (header[k] < 0)
+            return false;
+      }
+
+      final int n = header[0];
+      final int textStart = srcStart + HEADER_SIZE;
+      final long flagStart = (long) textStart + header[1];
+      final long intStart = flagStart + n;
+      final long fracStart = intStart + header[2];
+      final long expStart = fracStart + header[3];
+
+      if (expStart > srcEnd)
+         return false;
+
+      // Streams: start and end (in nibbles, except for flags)
+      final long[] starts = { flagStart, 2*intStart, 2*fracStart, 2*expStart };
+      final long[] ends = { intStart, 2*fracStart, 2*expStart, 2L*srcEnd };
+      final long[] pos = starts.clone();
+      final int textEnd = (int) flagStart;
+      int srcIdx = textStart;
+      int dstIdx = output.index;
+      final int dstEnd = dst.length;
+
+      while (srcIdx < textEnd)
+      {
+         byte cur = src[srcIdx++];
+
+         if (cur == ESCAPE)
+         {
+            if (srcIdx >= textEnd)
+               return false;
+
+            cur = src[srcIdx++];
+         }
+         else if (cur == NUMBER)
+         {
+            if (pos[0] >= ends[0])
+               return false;
+
+            final int flag = src[(int) pos[0]++];
+            final int sign = flag & SIGN_MASK;
+
+            if (sign != 0)
+            {
+               if ((sign == SIGN_MASK) || (dstIdx >= dstEnd))
+                  return false;
+
+               dst[dstIdx++] = (byte) ((sign == SIGN_MINUS) ? '-' : '+');
+            }
+
+            dstIdx = getDigits(src, pos, 1, ends[1], dst, dstIdx);
+
+            if (dstIdx < 0)
+               return false;
+
+            if ((flag & DOT) != 0)
+            {
+               if (dstIdx >= dstEnd)
+                  return false;
+
+               dst[dstIdx++] = '.';
+               dstIdx = getDigits(src, pos, 2, ends[2], dst, dstIdx);
+
+               if (dstIdx < 0)
+                  return false;
+            }
+
+            if ((flag & EXPONENT) != 0)
+            {
+               final int expSign = (flag>>EXP_SIGN_SHIFT) & SIGN_MASK;
+
+               if ((expSign == SIGN_MASK) || (dstIdx+2 > dstEnd))
+                  return false;
+
+               dst[dstIdx++] = (byte) (((flag & UPPER_CASE_E) != 0) ? 'E' : 'e');
+
+               if (expSign != 0)
+                  dst[dstIdx++] = (byte) ((expSign == SIGN_MINUS) ? '-' : '+');
+
+               dstIdx = getDigits(src, pos, 3, ends[3], dst, dstIdx);
+
+               if (dstIdx < 0)
+                  return false;
+            }
+
+            continue;
+         }
+
+         if (dstIdx >= dstEnd)
+            return false;
+
+         dst[dstIdx++] = cur;
+      }
+
+      if (pos[0] != ends[0])
+         return false;
+
+      input.index = srcEnd;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   // Copy the digits of a part of stream 'k' (nibble position) to the output.
+   // Return the new output index or -1 on error.
+   private static int getDigits(byte[] src, long[] pos, int k, long end, byte[] dst, int dstIdx)
+   {
+      while (true)
+      {
+         if (pos[k] >= end)
+            return -1;
+
+         final long p = pos[k]++;
+         final int b = src[(int) (p>>1)];
+         final int v = ((p & 1) == 0) ? (b>>4) & 0x0F : b & 0x0F;
+
+         if (v == END_OF_PART)
+            return dstIdx;
+
+         if ((v > 9) || (dstIdx >= dst.length))
+            return -1;
+
+         dst[dstIdx++] = (byte) ('0'+v);
+      }
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen;
+   }
+}
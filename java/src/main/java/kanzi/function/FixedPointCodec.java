@@ -0,0 +1,392 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.Arrays;
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Encoding of the fixed point decimals of a text (EG. prices with 2 decimal
+// places) as scaled integers. A decimal is an optional '-', the integer part
+// (no leading zero) and exactly 'scale' fractional digits. It must neither
+// follow nor precede a letter, a digit, '_' or '.'. Each decimal is replaced by
+// a placeholder in the text and its value goes to a stream of integers (delta
+// to the previous value, zigzag varint). The other numbers (EG. other number
+// of decimal places, leading zeros, "-0.00") remain in the text as exceptions,
+// so the exact formatting is preserved. Literal placeholder and escape bytes
+// in the text are escaped.
+// The transform fails if there are too few decimals, too many exceptions or if
+// the output is not smaller than the input.
+// Format: number of decimals n (4 bytes), text length (4 bytes), scale (1 byte),
+//         text, values
+public class FixedPointCodec implements ByteFunction
+{
+   private static final int HEADER_SIZE = 9;
+   private static final int MIN_NUMBERS = 16;
+   private static final int MAX_SCALE = 9;
+   private static final int MAX_DIGITS = 18;
+   private static final byte NUMBER = 0x01;
+   private static final byte ESCAPE = 0x02;
+   private static final long[] POWERS = new long[MAX_DIGITS+1];
+
+   static
+   {
+      POWERS[0] = 1;
+
+      for (int i=1; i<POWERS.length; i++)
+         POWERS[i] = 10 * POWERS[i-1];
+   }
+
+   private final int scale;
+   private byte[] values;
+
+
+   public FixedPointCodec()
+   {
+      this(2);
+   }
+
+
+   public FixedPointCodec(int scale)
+   {
+      if ((scale < 0) || (scale > MAX_SCALE))
+         throw new IllegalArgumentException("Fixed point codec: Invalid scale: "+scale+
+            " (must be in [0.."+MAX_SCALE+"])");
+
+      this.scale = scale;
+      this.values = new byte[0];
+   }
+
+
+   public FixedPointCodec(Map<String, Object> ctx)
+   {
+      this((Integer) ctx.getOrDefault("fixedPointScale", 2));
+   }
+
+
+   public int getScale()
+   {
+      return this.scale;
+   }
+
+
+   private static boolean isDigit(byte b)
+   {
+      return (b >= '0') && (b <= '9');
+   }
+
+
+   // Return true if a number can start after (or end before) this byte
+   private static boolean isSeparator(byte b)
+   {
+      return (isDigit(b) == false) && (b != '_') && (b != '.') &&
+         ((b|0x20) < 'a' || (b|0x20) > 'z');
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int srcEnd = srcIdx + count;
+      final int dstStart = output.index;
+
+      // The encoded output must be smaller than the input
+      final int limit = dstStart + count - HEADER_SIZE;
+
+      if (this.values.length < count)
+         this.values = new byte[count];
+
+      int dstIdx = dstStart + HEADER_SIZE;
+      int valIdx = 0;
+      int n = 0;
+      int exceptions = 0;
+      long prev = 0;
+      int i = srcIdx;
+
+      while (i < srcEnd)
+      {
+         final byte cur = src[i];
+
+         if (((isDigit(cur) == true) || (cur == '-')) && ((i == srcIdx) || (isSeparator(src[i-1]) == true)))
+         {
+            int j = (cur == '-') ? i+1 : i;
+            final int intStart = j;
+
+            while ((j < srcEnd) && (isDigit(src[j]) == true))
+               j++;
+
+            final int intEnd = j;
+            int fracEnd = j;
+
+            if ((j+1 < srcEnd) && (src[j] == '.') && (isDigit(src[j+1]) == true))
+            {
+               j++;
+
+               while ((j < srcEnd) && (isDigit(src[j]) == true))
+                  j++;
+
+               fracEnd = j;
+            }
+
+            if (intEnd > intStart)
+            {
+               final int fracLen = (fracEnd == intEnd) ? 0 : fracEnd-intEnd-1;
+               final int intLen = intEnd - intStart;
+               final boolean bounded = (fracEnd == srcEnd) || (isSeparator(src[fracEnd]) == true);
+               boolean valid = (bounded == true) && (fracLen == this.scale) &&
+                  (intLen+fracLen <= MAX_DIGITS) && ((intLen == 1) || (src[intStart] != '0'));
+               long val = 0;
+
+               if (valid == true)
+               {
+                  for (int k=intStart; k<intEnd; k++)
+                     val = 10*val + (src[k]-'0');
+
+                  for (int k=intEnd+1; k<fracEnd; k++)
+                     val = 10*val + (src[k]-'0');
+
+                  // "-0.00" has no integer representation
+                  if (cur == '-')
+                     val = -val;
+
+                  valid = (cur != '-') || (val != 0);
+               }
+
+               if (valid == true)
+               {
+                  if (dstIdx >= limit)
+                     return false;
+
+                  // Delta to the previous value, zigzag varint
+                  final long delta = val - prev;
+                  long zz = (delta<<1) ^ (delta>>63);
+                  prev = val;
+
+                  if (valIdx+10 > this.values.length)
+                     this.values = Arrays.copyOf(this.values, 2*this.values.length);
+
+                  while ((zz & ~0x7FL) != 0)
+                  {
+                     this.values[valIdx++] = (byte) (0x80|(zz&0x7F));
+                     zz >>>= 7;
+                  }
+
+                  this.values[valIdx++] = (byte) zz;
+                  dst[dstIdx++] = NUMBER;
+                  n++;
+                  i = fracEnd;
+                  continue;
+               }
+
+               // Exception: decimals with another scale (or integers if the
+               // scale is 0) are copied as is
+               if ((fracLen > 0) || (this.scale == 0))
+                  exceptions++;
+
+               if (dstIdx + (fracEnd-i) >= limit)
+                  return false;
+
+               System.arraycopy(src, i, dst, dstIdx, fracEnd-i);
+               dstIdx += (fracEnd-i);
+               i = fracEnd;
+               continue;
+            }
+         }
+
+         if ((cur == NUMBER) || (cur == ESCAPE))
+         {
+            if (dstIdx >= limit)
+               return false;
+
+            dst[dstIdx++] = ESCAPE;
+         }
+
+         if (dstIdx >= limit)
+            return false;
+
+         dst[dstIdx++] = cur;
+         i++;
+      }
+
+      // Too few numbers or too many exceptions (more than 1 in 4)
+      if ((n < MIN_NUMBERS) || (4*exceptions > n+exceptions))
+         return false;
+
+      final int textLength = dstIdx - dstStart - HEADER_SIZE;
+
+      if ((long) dstIdx + valIdx >= dstStart + count)
+         return false;
+
+      System.arraycopy(this.values, 0, dst, dstIdx, valIdx);
+      dstIdx += valIdx;
+      dst[dstStart]   = (byte) (n>>24);
+      dst[dstStart+1] = (byte) (n>>16);
+      dst[dstStart+2] = (byte) (n>>8);
+      dst[dstStart+3] = (byte) n;
+      dst[dstStart+4] = (byte) (textLength>>24);
+      dst[dstStart+5] = (byte) (textLength>>16);
+      dst[dstStart+6] = (byte) (textLength>>8);
+      dst[dstStart+7] = (byte) textLength;
+      dst[dstStart+8] = (byte) this.scale;
+      input.index += count;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < HEADER_SIZE)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcStart = input.index;
+      final int srcEnd = srcStart + input.length;
+      final int n = ((src[srcStart]&0xFF)<<24) | ((src[srcStart+1]&0xFF)<<16) |
+         ((src[srcStart+2]&0xFF)<<8) | (src[srcStart+3]&0xFF);
+      final int textLength = ((src[srcStart+4]&0xFF)<<24) | ((src[srcStart+5]&0xFF)<<16) |
+         ((src[srcStart+6]&0xFF)<<8) | (src[srcStart+7]&0xFF);
+      final int sc = src[srcStart+8] & 0xFF;
+
+      // The scale is read from the stream (the inverse needs no parameter)
+      if ((n < 0) || (textLength < 0) || (sc > MAX_SCALE) || (textLength > srcEnd-srcStart-HEADER_SIZE))
+         return false;
+
+      final int textEnd = srcStart + HEADER_SIZE + textLength;
+      final int dstEnd = dst.length;
+      final byte[] digits = new byte[MAX_DIGITS+1];
+      int textIdx = srcStart + HEADER_SIZE;
+      int valIdx = textEnd;
+      int dstIdx = output.index;
+      int nbValues = 0;
+      long prev = 0;
+
+      while (textIdx < textEnd)
+      {
+         byte cur = src[textIdx++];
+
+         if (cur == NUMBER)
+         {
+            // Read zigzag varint
+            long zz = 0;
+            int shift = 0;
+
+            while (true)
+            {
+               if ((valIdx >= srcEnd) || (shift > 63))
+                  return false;
+
+               final int b = src[valIdx++] & 0xFF;
+               zz |= ((long) (b&0x7F)) << shift;
+
+               if (b < 0x80)
+                  break;
+
+               shift += 7;
+            }
+
+            final long val = prev + ((zz>>>1) ^ -(zz&1));
+            prev = val;
+            nbValues++;
+
+            if ((val <= -POWERS[MAX_DIGITS]) || (val >= POWERS[MAX_DIGITS]))
+               return false;
+
+            // Digits of the absolute value, at least scale+1
+            long abs = (val < 0) ? -val : val;
+            int nbDigits = 0;
+
+            while ((abs != 0) || (nbDigits <= sc))
+            {
+               digits[nbDigits++] = (byte) ('0' + (abs%10));
+               abs /= 10;
+            }
+
+            final int len = nbDigits + ((val < 0) ? 1 : 0) + ((sc > 0) ? 1 : 0);
+
+            if (dstIdx+len > dstEnd)
+               return false;
+
+            if (val < 0)
+               dst[dstIdx++] = '-';
+
+            for (int k=nbDigits-1; k>=0; k--)
+            {
+               if ((k == sc-1) && (sc > 0))
+                  dst[dstIdx++] = '.';
+
+               dst[dstIdx++] = digits[k];
+            }
+
+            continue;
+         }
+
+         if (cur == ESCAPE)
+         {
+            if (textIdx >= textEnd)
+               return false;
+
+            cur = src[textIdx++];
+
+            if ((cur != NUMBER) && (cur != ESCAPE))
+               return false;
+         }
+
+         if (dstIdx >= dstEnd)
+            return false;
+
+         dst[dstIdx++] = cur;
+      }
+
+      if ((nbValues != n) || (valIdx != srcEnd))
+         return false;
+
+      input.index = srcEnd;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen;
+   }
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Split of an address field (EG. IPv4 or IPv6 address, 1 to 16 bytes) stored
+// at a fixed position in fixed size records. The address bytes are removed
+// from the records and grouped by position: one plane per byte of the
+// address (all first octets, then all second octets, ...). Optionally, each
+// plane is delta coded (difference modulo 256 with the same octet of the
+// previous record) which turns runs of close addresses (same subnet) into
+// runs of zeros.
+// Format: stride (2 bytes), offset (2 bytes), address length (1 byte),
+//         flags (1 byte), number of records n (4 bytes), address length
+//         planes of n bytes, records without the field, remaining bytes
+//         (block size % stride)
+public class IPFieldCodec implements ByteFunction
+{
+   private static final int MAX_STRIDE = 65535;
+   private static final int MAX_ADDRESS_LENGTH = 16;
+   private static final int MIN_RECORDS = 8;
+   private static final int HEADER_SIZE = 10;
+   private static final int DELTA_FLAG = 1;
+
+   private final int stride;
+   private final int offset;
+   private final int ipLen;
+   private final boolean delta;
+
+
+   public IPFieldCodec()
+   {
+      this(16, 0, 4, true);
+   }
+
+
+   public IPFieldCodec(int stride, int offset, int ipLen)
+   {
+      this(stride, offset, ipLen, true);
+   }
+
+
+   public IPFieldCodec(int stride, int offset, int ipLen, boolean delta)
+   {
+      if ((stride < 1) || (stride > MAX_STRIDE))
+         throw new IllegalArgumentException("IP field codec: Invalid stride: "+stride+" (must be in [1.."+MAX_STRIDE+"])");
+
+      if ((ipLen < 1) || (ipLen > MAX_ADDRESS_LENGTH))
+         throw new IllegalArgumentException("IP field codec: Invalid address length: "+ipLen+" (must be in [1.."+MAX_ADDRESS_LENGTH+"])");
+
+      if ((offset < 0) || (offset+ipLen > stride))
+         throw new IllegalArgumentException("IP field codec: The field must fit in the record");
+
+      this.stride = stride;
+      this.offset = offset;
+      this.ipLen = ipLen;
+      this.delta = delta;
+   }
+
+
+   public IPFieldCodec(Map<String, Object> ctx)
+   {
+      this((Integer) ctx.getOrDefault("ipStride", 16),
+         (Integer) ctx.getOrDefault("ipOffset", 0),
+         (Integer) ctx.getOrDefault("ipLength", 4),
+         (Boolean) ctx.getOrDefault("ipDelta", true));
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+      final int n = count / this.stride;
+
+      if (n < MIN_RECORDS)
+         return false;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int dstStart = output.index;
+      final int len = this.ipLen;
+      int dstIdx = dstStart;
+
+      // Write header
+      dst[dstIdx++] = (byte) (this.stride>>8);
+      dst[dstIdx++] = (byte) this.stride;
+      dst[dstIdx++] = (byte) (this.offset>>8);
+      dst[dstIdx++] = (byte) this.offset;
+      dst[dstIdx++] = (byte) len;
+      dst[dstIdx++] = (byte) ((this.delta == true) ? DELTA_FLAG : 0);
+      dst[dstIdx++] = (byte) (n>>24);
+      dst[dstIdx++] = (byte) (n>>16);
+      dst[dstIdx++] = (byte) (n>>8);
+      dst[dstIdx++] = (byte) n;
+
+      // One plane per address byte
+      for (int k=0; k<len; k++)
+      {
+         int idx = srcIdx + this.offset + k;
+         byte prev = 0;
+
+         for (int i=0; i<n; i++, idx+=this.stride)
+         {
+            final byte cur = src[idx];
+            dst[dstIdx++] = (this.delta == true) ? (byte) (cur-prev) : cur;
+            prev = cur;
+         }
+      }
+
+      // Records without the address field
+      final int after = this.stride - this.offset - len;
+
+      for (int i=0; i<n; i++)
+      {
+         final int idx = srcIdx + i*this.stride;
+         System.arraycopy(src, idx, dst, dstIdx, this.offset);
+         dstIdx += this.offset;
+         System.arraycopy(src, idx+this.offset+len, dst, dstIdx, after);
+         dstIdx += after;
+      }
+
+      // Remaining bytes
+      for (int i=n*this.stride; i<count; i++)
+         dst[dstIdx++] = src[srcIdx+i];
+
+      input.index += count;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < HEADER_SIZE)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcEnd = input.index + input.length;
+      int srcIdx = input.index;
+      final int stride = ((src[srcIdx]&0xFF)<<8) | (src[srcIdx+1]&0xFF);
+      final int offset = ((src[srcIdx+2]&0xFF)<<8) | (src[srcIdx+3]&0xFF);
+      final int len = src[srcIdx+4] & 0xFF;
+      final int flags = src[srcIdx+5] & 0xFF;
+      final int n = ((src[srcIdx+6]&0xFF)<<24) | ((src[srcIdx+7]&0xFF)<<16) |
+         ((src[srcIdx+8]&0xFF)<<8) | (src[srcIdx+9]&0xFF);
+      srcIdx += HEADER_SIZE;
+
+      if ((len < 1) || (len > MAX_ADDRESS_LENGTH) || (offset+len > stride) || ((flags & ~DELTA_FLAG) != 0))
+         return false;
+
+      if ((n < 0) || ((long) n*stride > srcEnd-srcIdx) || ((long) n*stride > dst.length-output.index))
+         return false;
+
+      final boolean isDelta = (flags & DELTA_FLAG) != 0;
+      final int dstStart = output.index;
+
+      // Address planes
+      for (int k=0; k<len; k++)
+      {
+         int idx = dstStart + offset + k;
+         byte prev = 0;
+
+         for (int i=0; i<n; i++, idx+=stride)
+         {
+            final byte cur = (isDelta == true) ? (byte) (src[srcIdx++]+prev) : src[srcIdx++];
+            dst[idx] = cur;
+            prev = cur;
+         }
+      }
+
+      // Records without the address field
+      final int after = stride - offset - len;
+
+      for (int i=0; i<n; i++)
+      {
+         final int idx = dstStart + i*stride;
+         System.arraycopy(src, srcIdx, dst, idx, offset);
+         srcIdx += offset;
+         System.arraycopy(src, srcIdx, dst, idx+offset+len, after);
+         srcIdx += after;
+      }
+
+      // Remaining bytes
+      int dstIdx = dstStart + n*stride;
+      final int remaining = srcEnd - srcIdx;
+
+      if ((remaining >= stride) || (dstIdx+remaining > dst.length))
+         return false;
+
+      System.arraycopy(src, srcIdx, dst, dstIdx, remaining);
+      dstIdx += remaining;
+      input.index = srcEnd;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen + HEADER_SIZE;
+   }
+}
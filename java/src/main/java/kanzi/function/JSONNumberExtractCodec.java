@@ -0,0 +1,372 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Extraction of the numeric literals of a text (typically JSON) into a
+// separate stream. Each number is replaced by a placeholder in the text and
+// stored as a descriptor (sign, digit counts) followed by the zigzag encoded
+// difference with the previous number (varints). The exact formatting is
+// preserved (leading zeros, trailing zeros of the fraction).
+// A number is an optional '-' followed by an integer part and an optional
+// fraction ('.' followed by digits), with at most 18 digits, and must not
+// follow a letter, a digit, '_' or '.'. Exponents remain in the text.
+// Literal placeholder and escape bytes in the text are escaped.
+// The transform fails if there are too few numbers or if the output is not
+// smaller than the input.
+// Format: number of numbers (4 bytes), text length (4 bytes), text,
+//         numbers (for each number: descriptor varint, delta varint)
+public class JSONNumberExtractCodec implements ByteFunction
+{
+   private static final int HEADER_SIZE = 8;
+   private static final int MIN_NUMBERS = 16;
+   private static final int MAX_DIGITS = 18;
+   private static final byte NUMBER = 0x01;
+   private static final byte ESCAPE = 0x02;
+
+   private byte[] buffer;
+
+
+   public JSONNumberExtractCodec()
+   {
+      this.buffer = new byte[0];
+   }
+
+
+   public JSONNumberExtractCodec(Map<String, Object> ctx)
+   {
+      this();
+   }
+
+
+   private static boolean isDigit(byte b)
+   {
+      return (b >= '0') && (b <= '9');
+   }
+
+
+   // Return true if a number can start after this byte
+   private static boolean isSeparator(byte b)
+   {
+      return (isDigit(b) == false) && (b != '_') && (b != '.') &&
+         ((b|0x20) < 'a' || (b|0x20) > 'z');
+   }
+
+
+   private static int putVarLong(byte[] buf, int idx, long v)
+   {
+      while ((v & ~0x7FL) != 0)
+      {
+         buf[idx++] = (byte) (0x80|(v&0x7F));
+         v >>>= 7;
+      }
+
+      buf[idx++] = (byte) v;
+      return idx;
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int srcEnd = srcIdx + count;
+      final int dstStart = output.index;
+
+      // The encoded output must be smaller than the input
+      final int limit = dstStart + count - HEADER_SIZE;
+
+      if (this.buffer.length < count)
+         this.buffer = new byte[count];
+
+      final byte[] nums = this.buffer;
+      int numIdx = 0;
+      int dstIdx = dstStart + HEADER_SIZE;
+      int n = 0;
+      long prev = 0;
+      int i = srcIdx;
+
+      while (i < srcEnd)
+      {
+         final byte cur = src[i];
+         final boolean start = (i == srcIdx) || (isSeparator(src[i-1]) == true);
+         int j = i;
+
+         if ((start == true) && (cur == '-') && (i+1 < srcEnd))
+            j++;
+
+         if ((start == true) && (isDigit(src[j]) == true))
+         {
+            // Parse the number
+            final int intStart = j;
+
+            while ((j < srcEnd) && (isDigit(src[j]) == true))
+               j++;
+
+            final int intDigits = j - intStart;
+            int fracDigits = 0;
+
+            if ((j+1 < srcEnd) && (src[j] == '.') && (isDigit(src[j+1]) == true))
+            {
+               j++;
+
+               while ((j < srcEnd) && (isDigit(src[j]) == true))
+               {
+                  j++;
+                  fracDigits++;
+               }
+            }
+
+            if ((intDigits + fracDigits <= MAX_DIGITS) && (numIdx+20 < count))
+            {
+               long val = 0;
+               int leadingZeros = 0;
+
+               for (int k=intStart; k<j; k++)
+               {
+                  if (src[k] == '.')
+                     continue;
+
+                  if ((val == 0) && (src[k] == '0'))
+                     leadingZeros++;
+
+                  val = 10*val + (src[k]-'0');
+               }
+
+               // The value 0 is printed with one digit
+               if (val == 0)
+                  leadingZeros--;
+
+               final int neg = (cur == '-') ? 1 : 0;
+               final long delta = val - prev;
+               numIdx = putVarLong(nums, numIdx, (((leadingZeros*(MAX_DIGITS+1))+fracDigits)<<1) | neg);
+               numIdx = putVarLong(nums, numIdx, (delta<<1) ^ (delta>>63));
+               prev = val;
+               n++;
+
+               if (dstIdx >= limit)
+                  return false;
+
+               dst[dstIdx++] = NUMBER;
+               i = j;
+               continue;
+            }
+
+            // Too many digits: copy as is
+            if (dstIdx + (j-i) >= limit)
+               return false;
+
+            System.arraycopy(src, i, dst, dstIdx, j-i);
+            dstIdx += (j-i);
+            i = j;
+            continue;
+         }
+
+         if ((cur == NUMBER) || (cur == ESCAPE))
+         {
+            if (dstIdx >= limit)
+               return false;
+
+            dst[dstIdx++] = ESCAPE;
+         }
+
+         if (dstIdx >= limit)
+            return false;
+
+         dst[dstIdx++] = cur;
+         i++;
+      }
+
+      final int textLength = dstIdx - dstStart - HEADER_SIZE;
+
+      if ((n < MIN_NUMBERS) || (dstIdx+numIdx >= dstStart+count))
+         return false;
+
+      System.arraycopy(nums, 0, dst, dstIdx, numIdx);
+      dstIdx += numIdx;
+      dst[dstStart]   = (byte) (n>>24);
+      dst[dstStart+1] = (byte) (n>>16);
+      dst[dstStart+2] = (byte) (n>>8);
+      dst[dstStart+3] = (byte) n;
+      dst[dstStart+4] = (byte) (textLength>>24);
+      dst[dstStart+5] = (byte) (textLength>>16);
+      dst[dstStart+6] = (byte) (textLength>>8);
+      dst[dstStart+7] = (byte) textLength;
+      input.index += count;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < HEADER_SIZE)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcEnd = input.index + input.length;
+      int srcIdx = input.index;
+      final int n = ((src[srcIdx]&0xFF)<<24) | ((src[srcIdx+1]&0xFF)<<16) |
+         ((src[srcIdx+2]&0xFF)<<8) | (src[srcIdx+3]&0xFF);
+      final int textLength = ((src[srcIdx+4]&0xFF)<<24) | ((src[srcIdx+5]&0xFF)<<16) |
+         ((src[srcIdx+6]&0xFF)<<8) | (src[srcIdx+7]&0xFF);
+      srcIdx += HEADER_SIZE;
+
+      if ((n < 0) || (textLength < 0) || (textLength > srcEnd-srcIdx))
+         return false;
+
+      final int textEnd = srcIdx + textLength;
+      int numIdx = textEnd;
+      int dstIdx = output.index;
+      final int dstEnd = dst.length;
+      final byte[] digits = new byte[MAX_DIGITS];
+      final long[] vals = new long[2];
+      long prev = 0;
+      int remaining = n;
+
+      while (srcIdx < textEnd)
+      {
+         byte cur = src[srcIdx++];
+
+         if (cur == ESCAPE)
+         {
+            if (srcIdx >= textEnd)
+               return false;
+
+            cur = src[srcIdx++];
+         }
+         else if (cur == NUMBER)
+         {
+            if (remaining == 0)
+               return false;
+
+            remaining--;
+
+            for (int k=0; k<2; k++)
+            {
+               long v = 0;
+               int shift = 0;
+
+               while (true)
+               {
+                  if ((numIdx >= srcEnd) || (shift > 63))
+                     return false;
+
+                  final int b = src[numIdx++] & 0xFF;
+                  v |= ((long) (b&0x7F)) << shift;
+
+                  if (b < 0x80)
+                     break;
+
+                  shift += 7;
+               }
+
+               vals[k] = v;
+            }
+
+            final long desc = vals[0];
+            final long val = prev + ((vals[1]>>>1) ^ -(vals[1]&1));
+            final int fracDigits = (int) ((desc>>1) % (MAX_DIGITS+1));
+            final long leadingZeros = (desc>>1) / (MAX_DIGITS+1);
+            prev = val;
+
+            if ((val < 0) || (leadingZeros > MAX_DIGITS))
+               return false;
+
+            // Digits of the value, least significant first
+            int nd = 0;
+            long v = val;
+
+            do
+            {
+               digits[nd++] = (byte) ('0' + (v%10));
+               v /= 10;
+            }
+            while ((v != 0) && (nd < MAX_DIGITS));
+
+            if ((v != 0) || (nd+leadingZeros > MAX_DIGITS))
+               return false;
+
+            for (int k=0; k<leadingZeros; k++)
+               digits[nd++] = '0';
+
+            // At least one digit in the integer part
+            if (fracDigits >= nd)
+               return false;
+
+            if (dstIdx + nd + 2 > dstEnd)
+               return false;
+
+            if ((desc & 1) != 0)
+               dst[dstIdx++] = '-';
+
+            for (int k=nd-1; k>=0; k--)
+            {
+               if (k == fracDigits-1)
+                  dst[dstIdx++] = '.';
+
+               dst[dstIdx++] = digits[k];
+            }
+
+            continue;
+         }
+
+         if (dstIdx >= dstEnd)
+            return false;
+
+         dst[dstIdx++] = cur;
+      }
+
+      if ((remaining != 0) || (numIdx != srcEnd))
+         return false;
+
+      input.index = srcEnd;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen;
+   }
+}
@@ -0,0 +1,317 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Joint coding of correlated columns in fixed size records (EG. key/value
+// pairs). The bytes of each record are split into a 'key' plane and a 'value'
+// plane according to a grouping (one K or V per byte of the record).
+// Optionally, the values are delta coded against the values of the previous
+// record with the same key (keys are hashed). Since the key plane is stored
+// first, the decoder can rebuild the same key history.
+// The grouping is stored in the header so that the inverse needs no parameter.
+// Format: flags (1 byte), stride (1 byte), key mask ((stride+7)/8 bytes),
+//         key plane, value plane, remaining bytes (block size % stride)
+public class JointColumnCodec implements ByteFunction
+{
+   private static final int MAX_STRIDE = 255;
+   private static final int DELTA_FLAG = 1;
+   private static final int HASH_LOG = 12;
+   private static final String DEFAULT_GROUPING = "KKKKVVVV";
+
+   private final boolean[] keys;
+   private final boolean delta;
+   private final int[] lastRecords;
+
+
+   public JointColumnCodec()
+   {
+      this(DEFAULT_GROUPING, true);
+   }
+
+
+   // The grouping is a string made of 'K' (key byte) and 'V' (value byte)
+   // characters. Its length is the record size (stride).
+   public JointColumnCodec(String grouping, boolean delta)
+   {
+      this(parseGrouping(grouping), delta);
+   }
+
+
+   public JointColumnCodec(boolean[] keys, boolean delta)
+   {
+      if (keys == null)
+         throw new NullPointerException("Invalid null grouping parameter");
+
+      if ((keys.length < 2) || (keys.length > MAX_STRIDE))
+         throw new IllegalArgumentException("The stride must be in [2.."+MAX_STRIDE+"]");
+
+      int nbKeys = 0;
+
+      for (boolean k : keys)
+         nbKeys += (k == true) ? 1 : 0;
+
+      if ((nbKeys == 0) || (nbKeys == keys.length))
+         throw new IllegalArgumentException("The grouping must contain both key and value bytes");
+
+      this.keys = keys.clone();
+      this.delta = delta;
+      this.lastRecords = new int[1<<HASH_LOG];
+   }
+
+
+   public JointColumnCodec(Map<String, Object> ctx)
+   {
+      this((String) ctx.getOrDefault("jointGrouping", DEFAULT_GROUPING),
+         (Boolean) ctx.getOrDefault("jointDelta", true));
+   }
+
+
+   private static boolean[] parseGrouping(String grouping)
+   {
+      if (grouping == null)
+         throw new NullPointerException("Invalid null grouping parameter");
+
+      boolean[] res = new boolean[grouping.length()];
+
+      for (int i=0; i<res.length; i++)
+      {
+         final char c = Character.toUpperCase(grouping.charAt(i));
+
+         if ((c != 'K') && (c != 'V'))
+            throw new IllegalArgumentException("Invalid grouping: '"+grouping+"' (only K and V allowed)");
+
+         res[i] = c == 'K';
+      }
+
+      return res;
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+      final boolean[] k = this.keys;
+      final int stride = k.length;
+
+      if (count < stride)
+         return false;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      int dstIdx = output.index;
+      int nbKeys = 0;
+
+      // Write header
+      dst[dstIdx++] = (byte) ((this.delta == true) ? DELTA_FLAG : 0);
+      dst[dstIdx++] = (byte) stride;
+
+      for (int i=0; i<stride; i+=8)
+      {
+         int mask = 0;
+
+         for (int j=i; (j<i+8) && (j<stride); j++)
+         {
+            if (k[j] == true)
+            {
+               mask |= (1<<(j-i));
+               nbKeys++;
+            }
+         }
+
+         dst[dstIdx++] = (byte) mask;
+      }
+
+      final int records = count / stride;
+      final int end = srcIdx + records*stride;
+      int keyIdx = dstIdx;
+      int valIdx = dstIdx + records*nbKeys;
+
+      if (this.delta == true)
+      {
+         for (int i=0; i<this.lastRecords.length; i++)
+            this.lastRecords[i] = -1;
+      }
+
+      for (int n=srcIdx; n<end; n+=stride)
+      {
+         int h = 0;
+
+         for (int j=0; j<stride; j++)
+         {
+            if (k[j] == true)
+            {
+               dst[keyIdx++] = src[n+j];
+               h = (h*0x1E35A7BD) + (src[n+j]&0xFF);
+            }
+         }
+
+         if (this.delta == false)
+         {
+            for (int j=0; j<stride; j++)
+            {
+               if (k[j] == false)
+                  dst[valIdx++] = src[n+j];
+            }
+
+            continue;
+         }
+
+         h = (h*0x9E3779B1) >>> (32-HASH_LOG);
+         final int prev = this.lastRecords[h];
+         this.lastRecords[h] = n;
+
+         for (int j=0; j<stride; j++)
+         {
+            if (k[j] == false)
+               dst[valIdx++] = (prev < 0) ? src[n+j] : (byte) (src[n+j]-src[prev+j]);
+         }
+      }
+
+      // Copy remaining bytes
+      dstIdx = valIdx;
+
+      for (int n=end; n<srcIdx+count; n++)
+         dst[dstIdx++] = src[n];
+
+      input.index += count;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcEnd = input.index + input.length;
+      int srcIdx = input.index;
+
+      if (input.length < 3)
+         return false;
+
+      final boolean isDelta = (src[srcIdx++] & DELTA_FLAG) != 0;
+      final int stride = src[srcIdx++] & 0xFF;
+
+      if ((stride < 2) || (srcIdx+(stride+7)/8 > srcEnd))
+         return false;
+
+      final boolean[] k = new boolean[stride];
+      int nbKeys = 0;
+
+      for (int i=0; i<stride; i+=8)
+      {
+         final int mask = src[srcIdx++] & 0xFF;
+
+         for (int j=i; (j<i+8) && (j<stride); j++)
+         {
+            k[j] = (mask & (1<<(j-i))) != 0;
+            nbKeys += (k[j] == true) ? 1 : 0;
+         }
+      }
+
+      final int count = srcEnd - srcIdx;
+      final int records = count / stride;
+
+      if (output.index+count > dst.length)
+         return false;
+
+      int keyIdx = srcIdx;
+      int valIdx = srcIdx + records*nbKeys;
+      final int dstStart = output.index;
+      final int end = dstStart + records*stride;
+
+      if (isDelta == true)
+      {
+         for (int i=0; i<this.lastRecords.length; i++)
+            this.lastRecords[i] = -1;
+      }
+
+      for (int n=dstStart; n<end; n+=stride)
+      {
+         int h = 0;
+
+         for (int j=0; j<stride; j++)
+         {
+            if (k[j] == true)
+            {
+               dst[n+j] = src[keyIdx++];
+               h = (h*0x1E35A7BD) + (dst[n+j]&0xFF);
+            }
+         }
+
+         if (isDelta == false)
+         {
+            for (int j=0; j<stride; j++)
+            {
+               if (k[j] == false)
+                  dst[n+j] = src[valIdx++];
+            }
+
+            continue;
+         }
+
+         h = (h*0x9E3779B1) >>> (32-HASH_LOG);
+         final int prev = this.lastRecords[h];
+         this.lastRecords[h] = n;
+
+         for (int j=0; j<stride; j++)
+         {
+            if (k[j] == false)
+               dst[n+j] = (prev < 0) ? src[valIdx++] : (byte) (src[valIdx++]+dst[prev+j]);
+         }
+      }
+
+      // Copy remaining bytes
+      int dstIdx = end;
+
+      while (valIdx < srcEnd)
+         dst[dstIdx++] = src[valIdx++];
+
+      input.index = srcEnd;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen + 2 + (MAX_STRIDE+7)/8;
+   }
+}
@@ -24,6 +24,10 @@ import kanzi.SliceByteArray;
 // Simple byte oriented LZ77 implementation.
 // It is a modified LZ4 with a bigger window, a bigger hash map, 3+n*8 bit 
 // literal lengths and 17 or 24 bit match lengths.
+// By default, the match finder emits the first acceptable match (greedy
+// matching). Optionally, it checks whether a longer match starts at the next
+// position before emitting a match (lazy evaluation: slower, better ratio).
+// The format is the same in both modes.
 public final class LZCodec implements ByteFunction
 {
    private final ByteFunction delegate;
@@ -43,6 +47,22 @@ public final class LZCodec implements ByteFunction
    }
    
    
+   // Check for a longer match at the next position before emitting a match
+   // (lazy evaluation, disabled by default). Only used by LZ (not by LZP which
+   // has no match finder)
+   public void setLazyMatching(boolean lazy)
+   {
+      if (this.delegate instanceof LZXCodec)
+         ((LZXCodec) this.delegate).lazy = lazy;
+   }
+
+
+   public boolean isLazyMatching()
+   {
+      return (this.delegate instanceof LZXCodec) ? ((LZXCodec) this.delegate).lazy : false;
+   }
+
+
    @Override
    public int getMaxEncodedLength(int srcLength)
    {
@@ -99,6 +119,7 @@ public final class LZCodec implements ByteFunction
       private static final int MIN_MATCH_MIN_DIST = 1 << 16;
 
       private int[] hashes;
+      private boolean lazy;
 
 
       public LZXCodec()
@@ -110,6 +131,7 @@ public final class LZCodec implements ByteFunction
       public LZXCodec(Map<String, Object> ctx)
       {
          this.hashes = new int[0];
+         this.lazy = (Boolean) ctx.getOrDefault("lzLazy", false);
       }
 
 
@@ -190,16 +212,8 @@ public final class LZCodec implements ByteFunction
             int bestLen = 0;
 
             // Find a match
-            if ((ref > minRef) && (differentInts(src, ref, srcIdx) == false)) {
-               final int maxMatch = srcEnd - srcIdx;
-               bestLen = 4;
-
-               while ((bestLen+4 < maxMatch) && (differentInts(src, ref+bestLen, srcIdx+bestLen) == false))
-                  bestLen += 4;
-
-               while ((bestLen < maxMatch) && (src[ref+bestLen] == src[srcIdx+bestLen]))
-                  bestLen++;
-            }
+            if (ref > minRef)
+               bestLen = findMatch(src, srcIdx, ref, srcEnd-srcIdx);
 
             // No good match ?
             if ((bestLen < MIN_MATCH) || ((bestLen == MIN_MATCH) && (srcIdx-ref >= MIN_MATCH_MIN_DIST)))
@@ -209,6 +223,21 @@ public final class LZCodec implements ByteFunction
                continue;
             }
 
+            // Lazy evaluation: if a longer match starts at the next position,
+            // emit the current byte as a literal
+            if ((this.lazy == true) && (srcIdx+1 < srcEnd))
+            {
+               final int ref2 = this.hashes[hash(src, srcIdx+1)];
+
+               if ((ref2 > Math.max(srcIdx+1-maxDist, srcIdx0)) &&
+                  (findMatch(src, srcIdx+1, ref2, srcEnd-srcIdx-1) > bestLen))
+               {
+                  this.hashes[h] = srcIdx;
+                  srcIdx++;
+                  continue;
+               }
+            }
+
             // Emit token
             // Token: 3 bits litLen + 1 bit flag + 4 bits mLen (LLLFMMMM)
             // flag = if maxDist = (1<<17)-1, then highest bit of distance
@@ -403,6 +432,24 @@ public final class LZCodec implements ByteFunction
       }
 
 
+      // Return the length of the match at 'ref' (0 if the first 4 bytes differ)
+      private static int findMatch(byte[] src, int srcIdx, int ref, int maxMatch)
+      {
+         if (differentInts(src, ref, srcIdx) == true)
+            return 0;
+
+         int bestLen = 4;
+
+         while ((bestLen+4 < maxMatch) && (differentInts(src, ref+bestLen, srcIdx+bestLen) == false))
+            bestLen += 4;
+
+         while ((bestLen < maxMatch) && (src[ref+bestLen] == src[srcIdx+bestLen]))
+            bestLen++;
+
+         return bestLen;
+      }
+
+
       private static int hash(byte[] block, int idx)
       {
          return (int) ((Memory.LittleEndian.readLong64(block, idx)*HASH_SEED) >> HASH_SHIFT) & HASH_MASK;
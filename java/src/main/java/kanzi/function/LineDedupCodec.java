@@ -0,0 +1,273 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.nio.charset.StandardCharsets;
+import java.util.HashMap;
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Deduplication of repeated lines (line granular LZ): each line ('\n'
+// terminated, the last line may be unterminated) that is identical to a
+// previous line of the block is replaced by a reference to the most recent
+// occurrence (distance in lines). The other lines are stored as is, so the
+// line terminators (EG. CR LF) are preserved.
+// The transform fails if there are too few lines, no repeated line, or if the
+// output is not smaller than the input.
+// Format: number of lines (4 bytes), size of references (4 bytes), references
+//         (one varint per line, 0 for a line stored as is), lines stored as is
+public class LineDedupCodec implements ByteFunction
+{
+   private static final int HEADER_SIZE = 8;
+   private static final int MIN_LINES = 16;
+
+
+   public LineDedupCodec()
+   {
+   }
+
+
+   public LineDedupCodec(Map<String, Object> ctx)
+   {
+      this();
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int srcEnd = srcIdx + count;
+
+      // Line starts (plus end of block)
+      int n = 0;
+
+      for (int i=srcIdx; i<srcEnd; i++)
+      {
+         if (src[i] == '\n')
+            n++;
+      }
+
+      if (src[srcEnd-1] != '\n')
+         n++;
+
+      if (n < MIN_LINES)
+         return false;
+
+      final int[] starts = new int[n+1];
+      starts[n] = srcEnd;
+
+      for (int i=srcIdx, l=0; l<n; i++)
+      {
+         starts[l++] = i;
+
+         while ((i < srcEnd-1) && (src[i] != '\n'))
+            i++;
+      }
+
+      // Distance to the most recent identical line (0 if none)
+      final int[] dists = new int[n];
+      final Map<String, Integer> lastSeen = new HashMap<>();
+      int repeats = 0;
+      long refSize = 0;
+      long litSize = 0;
+
+      for (int l=0; l<n; l++)
+      {
+         final String line = new String(src, starts[l], starts[l+1]-starts[l], StandardCharsets.ISO_8859_1);
+         final Integer prev = lastSeen.put(line, l);
+
+         if (prev != null)
+         {
+            dists[l] = l - prev;
+            repeats++;
+         }
+         else
+         {
+            litSize += line.length();
+         }
+
+         for (int d=dists[l]; d>=0x80; d>>>=7)
+            refSize++;
+
+         refSize++;
+      }
+
+      if ((repeats == 0) || (HEADER_SIZE+refSize+litSize >= count))
+         return false;
+
+      int dstIdx = output.index;
+      dst[dstIdx++] = (byte) (n>>24);
+      dst[dstIdx++] = (byte) (n>>16);
+      dst[dstIdx++] = (byte) (n>>8);
+      dst[dstIdx++] = (byte) n;
+      dst[dstIdx++] = (byte) (refSize>>24);
+      dst[dstIdx++] = (byte) (refSize>>16);
+      dst[dstIdx++] = (byte) (refSize>>8);
+      dst[dstIdx++] = (byte) refSize;
+
+      // References
+      for (int l=0; l<n; l++)
+      {
+         int val = dists[l];
+
+         while (val >= 0x80)
+         {
+            dst[dstIdx++] = (byte) (0x80|(val&0x7F));
+            val >>>= 7;
+         }
+
+         dst[dstIdx++] = (byte) val;
+      }
+
+      // Lines stored as is
+      for (int l=0; l<n; l++)
+      {
+         if (dists[l] != 0)
+            continue;
+
+         final int len = starts[l+1] - starts[l];
+         System.arraycopy(src, starts[l], dst, dstIdx, len);
+         dstIdx += len;
+      }
+
+      input.index += count;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < HEADER_SIZE)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcEnd = input.index + input.length;
+      int srcIdx = input.index;
+      final int n = ((src[srcIdx]&0xFF)<<24) | ((src[srcIdx+1]&0xFF)<<16) |
+         ((src[srcIdx+2]&0xFF)<<8) | (src[srcIdx+3]&0xFF);
+      final int refSize = ((src[srcIdx+4]&0xFF)<<24) | ((src[srcIdx+5]&0xFF)<<16) |
+         ((src[srcIdx+6]&0xFF)<<8) | (src[srcIdx+7]&0xFF);
+      srcIdx += HEADER_SIZE;
+
+      // At least one byte per reference
+      if ((n <= 0) || (refSize < n) || (refSize > srcEnd-srcIdx))
+         return false;
+
+      final int refEnd = srcIdx + refSize;
+      int litIdx = refEnd;
+      final int dstStart = output.index;
+      final int dstEnd = dst.length;
+      final int[] starts = new int[n+1];
+      int dstIdx = dstStart;
+
+      for (int l=0; l<n; l++)
+      {
+         // Read reference
+         int dist = 0;
+         int shift = 0;
+
+         while (true)
+         {
+            if ((srcIdx >= refEnd) || (shift > 28))
+               return false;
+
+            final int b = src[srcIdx++] & 0xFF;
+            dist |= ((b&0x7F) << shift);
+
+            if (b < 0x80)
+               break;
+
+            shift += 7;
+         }
+
+         if ((dist < 0) || (dist > l))
+            return false;
+
+         starts[l] = dstIdx;
+
+         if (dist == 0)
+         {
+            // Line stored as is (up to the next '\n' included)
+            final int start = litIdx;
+
+            while ((litIdx < srcEnd) && (src[litIdx] != '\n'))
+               litIdx++;
+
+            if (litIdx < srcEnd)
+               litIdx++;
+
+            final int len = litIdx - start;
+
+            if ((len == 0) || (dstIdx+len > dstEnd))
+               return false;
+
+            System.arraycopy(src, start, dst, dstIdx, len);
+            dstIdx += len;
+         }
+         else
+         {
+            // Copy of a previous line
+            final int ref = l - dist;
+            final int len = starts[ref+1] - starts[ref];
+
+            if (dstIdx+len > dstEnd)
+               return false;
+
+            System.arraycopy(dst, starts[ref], dst, dstIdx, len);
+            dstIdx += len;
+         }
+      }
+
+      if ((srcIdx != refEnd) || (litIdx != srcEnd))
+         return false;
+
+      input.index = srcEnd;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen;
+   }
+}
@@ -0,0 +1,335 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Lossless logarithmic mapping of integers (little endian, signed, 2, 4 or 8
+// bytes). Each value is zigzag encoded (u = (v<<1) ^ (v>>63)) then split into a
+// bucket code (1 byte) and an offset within the bucket, so that the codes
+// capture the (heavy tailed) distribution of magnitudes and compress well.
+// Fixed mapping table:
+//   u < 16              -> code u, no offset
+//   u has e bits (e in [5..63])
+//                       -> code 16 + 4*(e-5) + (2 bits after the leading 1)
+//                          offset = e-3 low bits of u
+//   u has 64 bits       -> code 255 (exception), raw value in exception list
+// Format: element size (1 byte), number of elements n (4 bytes), n codes,
+//         offsets (bit packed, byte aligned), exceptions (raw little endian
+//         elements), remaining bytes (count % element size)
+public class LogMapCodec implements ByteFunction
+{
+   private static final int DIRECT_CODES = 16;
+   private static final int EXCEPTION = 255;
+
+   private final int elemSize;
+
+
+   public LogMapCodec()
+   {
+      this(4);
+   }
+
+
+   public LogMapCodec(int elemSize)
+   {
+      if ((elemSize != 2) && (elemSize != 4) && (elemSize != 8))
+         throw new IllegalArgumentException("LogMap codec: Invalid element size: "+elemSize+" (must be 2, 4 or 8)");
+
+      this.elemSize = elemSize;
+   }
+
+
+   public LogMapCodec(Map<String, Object> ctx)
+   {
+      this((Integer) ctx.getOrDefault("logMapElemSize", 4));
+   }
+
+
+   // Return the bucket code of a zigzag encoded value
+   static int getCode(long u)
+   {
+      if ((u >= 0) && (u < DIRECT_CODES))
+         return (int) u;
+
+      final int e = 64 - Long.numberOfLeadingZeros(u);
+
+      if (e == 64)
+         return EXCEPTION;
+
+      return DIRECT_CODES + 4*(e-5) + (int) ((u>>>(e-3)) & 3);
+   }
+
+
+   // Return the number of offset bits for a code
+   static int getOffsetBits(int code)
+   {
+      return (code < DIRECT_CODES) ? 0 : ((code-DIRECT_CODES)>>2) + 2;
+   }
+
+
+   // Return the smallest value in the bucket of a code
+   static long getBase(int code)
+   {
+      if (code < DIRECT_CODES)
+         return code;
+
+      final int e = ((code-DIRECT_CODES)>>2) + 5;
+      return (4L | ((code-DIRECT_CODES)&3)) << (e-3);
+   }
+
+
+   private static long readElement(byte[] buf, int idx, int size)
+   {
+      long v = 0;
+
+      for (int i=size-1; i>=0; i--)
+         v = (v<<8) | (buf[idx+i]&0xFF);
+
+      // Sign extension
+      final int shift = 64 - 8*size;
+      return (v<<shift) >> shift;
+   }
+
+
+   private static void writeElement(byte[] buf, int idx, int size, long v)
+   {
+      for (int i=0; i<size; i++)
+      {
+         buf[idx+i] = (byte) v;
+         v >>= 8;
+      }
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+      final int sz = this.elemSize;
+
+      if (count < 8*sz)
+         return false;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int n = count / sz;
+      final int dstStart = output.index;
+      int dstIdx = dstStart;
+
+      // Write header
+      dst[dstIdx++] = (byte) sz;
+      dst[dstIdx++] = (byte) (n>>24);
+      dst[dstIdx++] = (byte) (n>>16);
+      dst[dstIdx++] = (byte) (n>>8);
+      dst[dstIdx++] = (byte) n;
+
+      // Codes
+      int nbExceptions = 0;
+      final int codeIdx = dstIdx;
+
+      for (int i=0; i<n; i++)
+      {
+         final long v = readElement(src, srcIdx+i*sz, sz);
+         final int code = getCode((v<<1) ^ (v>>63));
+         dst[dstIdx++] = (byte) code;
+         nbExceptions += (code == EXCEPTION) ? 1 : 0;
+      }
+
+      // Offsets, MSB first
+      long acc = 0;
+      int nbBits = 0;
+
+      for (int i=0; i<n; i++)
+      {
+         final int code = dst[codeIdx+i] & 0xFF;
+
+         if (code == EXCEPTION)
+            continue;
+
+         int bits = getOffsetBits(code);
+
+         if (bits == 0)
+            continue;
+
+         final long v = readElement(src, srcIdx+i*sz, sz);
+         final long offset = ((v<<1) ^ (v>>63)) - getBase(code);
+
+         while (bits > 0)
+         {
+            final int k = (bits > 32) ? 32 : bits;
+            bits -= k;
+            acc = (acc<<k) | ((offset>>>bits) & ((1L<<k)-1));
+            nbBits += k;
+
+            while (nbBits >= 8)
+            {
+               nbBits -= 8;
+               dst[dstIdx++] = (byte) (acc>>>nbBits);
+            }
+         }
+      }
+
+      if (nbBits > 0)
+         dst[dstIdx++] = (byte) (acc<<(8-nbBits));
+
+      // Exceptions
+      if (nbExceptions > 0)
+      {
+         for (int i=0; i<n; i++)
+         {
+            if ((dst[codeIdx+i] & 0xFF) == EXCEPTION)
+            {
+               System.arraycopy(src, srcIdx+i*sz, dst, dstIdx, sz);
+               dstIdx += sz;
+            }
+         }
+      }
+
+      // Remaining bytes
+      for (int i=n*sz; i<count; i++)
+         dst[dstIdx++] = src[srcIdx+i];
+
+      if (dstIdx-dstStart >= count)
+         return false;
+
+      input.index += count;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < 5)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcEnd = input.index + input.length;
+      int srcIdx = input.index;
+      final int sz = src[srcIdx++];
+
+      if ((sz != 2) && (sz != 4) && (sz != 8))
+         return false;
+
+      final int n = ((src[srcIdx]&0xFF)<<24) | ((src[srcIdx+1]&0xFF)<<16) |
+         ((src[srcIdx+2]&0xFF)<<8) | (src[srcIdx+3]&0xFF);
+      srcIdx += 4;
+
+      if ((n < 0) || (n > srcEnd-srcIdx) || ((long) n*sz > dst.length-output.index))
+         return false;
+
+      final int codeIdx = srcIdx;
+      srcIdx += n;
+      long totalBits = 0;
+      int nbExceptions = 0;
+
+      for (int i=0; i<n; i++)
+      {
+         final int code = src[codeIdx+i] & 0xFF;
+
+         if (code == EXCEPTION)
+            nbExceptions++;
+         else if (code < EXCEPTION-3)
+            totalBits += getOffsetBits(code);
+         else
+            return false;
+      }
+
+      int excIdx = srcIdx + (int) ((totalBits+7)>>3);
+
+      if (excIdx+nbExceptions*sz > srcEnd)
+         return false;
+
+      final int dstStart = output.index;
+      long acc = 0;
+      int nbBits = 0;
+
+      for (int i=0; i<n; i++)
+      {
+         final int code = src[codeIdx+i] & 0xFF;
+
+         if (code == EXCEPTION)
+         {
+            System.arraycopy(src, excIdx, dst, dstStart+i*sz, sz);
+            excIdx += sz;
+            continue;
+         }
+
+         int bits = getOffsetBits(code);
+         long offset = 0;
+
+         while (bits > 0)
+         {
+            final int k = (bits > 32) ? 32 : bits;
+            bits -= k;
+
+            while (nbBits < k)
+            {
+               acc = (acc<<8) | (src[srcIdx++]&0xFF);
+               nbBits += 8;
+            }
+
+            nbBits -= k;
+            offset = (offset<<k) | ((acc>>>nbBits) & ((1L<<k)-1));
+         }
+
+         final long u = getBase(code) + offset;
+         writeElement(dst, dstStart+i*sz, sz, (u>>>1) ^ -(u&1));
+      }
+
+      // Remaining bytes
+      int dstIdx = dstStart + n*sz;
+      final int remaining = srcEnd - excIdx;
+
+      if ((remaining >= sz) || (dstIdx+remaining > dst.length))
+         return false;
+
+      System.arraycopy(src, excIdx, dst, dstIdx, remaining);
+      dstIdx += remaining;
+      input.index = srcEnd;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen + (srcLen>>1) + 16;
+   }
+}
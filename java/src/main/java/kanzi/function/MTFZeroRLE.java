@@ -0,0 +1,234 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Move To Front and Zero Run Length encoding fused in one pass.
+// The output is the same as MTFT followed by ZRLT but runs of identical
+// symbols (rank 0 after MTF) are detected on the input directly and skip
+// the MTF update. See ZRLT for the encoding of the ranks and run lengths.
+public final class MTFZeroRLE implements ByteFunction
+{
+   private final int[] ranks;   // rank to symbol
+   private final int[] symbols; // symbol to rank
+
+
+   public MTFZeroRLE()
+   {
+      this.ranks = new int[256];
+      this.symbols = new int[256];
+   }
+
+
+   public MTFZeroRLE(Map<String, Object> ctx)
+   {
+      this();
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (output.length - output.index < getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int[] r2s = this.ranks;
+      final int[] s2r = this.symbols;
+      int srcIdx = input.index;
+      int dstIdx = output.index;
+      final int srcEnd = srcIdx + count;
+      final int dstEnd = output.length;
+
+      for (int i=0; i<256; i++)
+      {
+         r2s[i] = i;
+         s2r[i] = i;
+      }
+
+      while (srcIdx < srcEnd)
+      {
+         final int c = src[srcIdx] & 0xFF;
+
+         if (r2s[0] == c)
+         {
+            // Run of rank 0: the MTF list does not change
+            int runLength = 1;
+
+            while ((srcIdx+runLength < srcEnd) && (src[srcIdx+runLength] == src[srcIdx]))
+               runLength++;
+
+            srcIdx += runLength;
+
+            // Encode length
+            runLength++;
+            int log2 = 31 - Integer.numberOfLeadingZeros(runLength);
+
+            if (dstIdx >= dstEnd-log2)
+               return false;
+
+            // Write every bit as a byte except the most significant one
+            while (log2 > 0)
+            {
+               log2--;
+               dst[dstIdx++] = (byte) ((runLength >> log2) & 1);
+            }
+
+            continue;
+         }
+
+         // Move symbol to front
+         final int r = s2r[c];
+
+         for (int k=r; k>0; k--)
+         {
+            final int s = r2s[k-1];
+            r2s[k] = s;
+            s2r[s] = k;
+         }
+
+         r2s[0] = c;
+         s2r[c] = 0;
+
+         if (r >= 0xFE)
+         {
+            if (dstIdx >= dstEnd-1)
+               return false;
+
+            dst[dstIdx] = (byte) 0xFF;
+            dst[dstIdx+1] = (byte) (r-0xFE);
+            dstIdx += 2;
+         }
+         else
+         {
+            if (dstIdx >= dstEnd)
+               return false;
+
+            dst[dstIdx++] = (byte) (r+1);
+         }
+
+         srcIdx++;
+      }
+
+      input.index = srcIdx;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int[] r2s = this.ranks;
+      int srcIdx = input.index;
+      int dstIdx = output.index;
+      final int srcEnd = srcIdx + input.length;
+      final int dstEnd = output.length;
+
+      for (int i=0; i<256; i++)
+         r2s[i] = i;
+
+      while (srcIdx < srcEnd)
+      {
+         int val = src[srcIdx] & 0xFF;
+
+         if (val <= 1)
+         {
+            // Generate the run length bit by bit (but force MSB)
+            long runLength = 1;
+
+            do
+            {
+               runLength = (runLength << 1) | val;
+               srcIdx++;
+
+               if (runLength > dstEnd-dstIdx+1)
+                  return false;
+            }
+            while ((srcIdx < srcEnd) && ((val = src[srcIdx] & 0xFF) <= 1));
+
+            final int end = dstIdx + (int) runLength - 1;
+            final byte c = (byte) r2s[0];
+
+            while (dstIdx < end)
+               dst[dstIdx++] = c;
+
+            continue;
+         }
+
+         int r = val - 1;
+
+         if (val == 0xFF)
+         {
+            srcIdx++;
+
+            if (srcIdx >= srcEnd)
+               return false;
+
+            r = 0xFE + (src[srcIdx] & 0xFF);
+
+            if (r > 0xFF)
+               return false;
+         }
+
+         if (dstIdx >= dstEnd)
+            return false;
+
+         // Move symbol to front
+         final int c = r2s[r];
+
+         for (int k=r; k>0; k--)
+            r2s[k] = r2s[k-1];
+
+         r2s[0] = c;
+         dst[dstIdx++] = (byte) c;
+         srcIdx++;
+      }
+
+      input.index = srcIdx;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   // Required encoding output buffer size unknown => guess
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen;
+   }
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.ArrayList;
+import java.util.Arrays;
+import java.util.HashMap;
+import java.util.List;
+import java.util.Map;
+import kanzi.EntropyEncoder;
+import kanzi.OutputBitStream;
+import kanzi.SliceByteArray;
+import kanzi.bitstream.DefaultOutputBitStream;
+import kanzi.entropy.EntropyCodecFactory;
+import kanzi.entropy.EntropyUtils;
+import kanzi.io.NullOutputStream;
+
+
+// Run the forward and inverse of each built-in transform on a sample and
+// report the compression ratio (transformed data encoded with a default
+// entropy codec into a null bit stream), the timings and whether the
+// transform round trips.
+public final class Profiler
+{
+   private static final int DEFAULT_ENTROPY = EntropyCodecFactory.ANS0_TYPE;
+
+
+   public static class ProfileResult
+   {
+      public final String name;
+      public final int inputSize;
+      public final int transformedSize;
+      public final long compressedSize;   // bytes, transformed data + entropy
+      public final int entropy;           // order 0 entropy of transformed data (*1024)
+      public final boolean applied;       // false if the transform was skipped
+      public final long forwardTime;      // nanoseconds
+      public final long inverseTime;      // nanoseconds
+      public final boolean roundTrip;
+
+
+      ProfileResult(String name, int inputSize, int transformedSize, long compressedSize,
+         int entropy, boolean applied, long forwardTime, long inverseTime, boolean roundTrip)
+      {
+         this.name = name;
+         this.inputSize = inputSize;
+         this.transformedSize = transformedSize;
+         this.compressedSize = compressedSize;
+         this.entropy = entropy;
+         this.applied = applied;
+         this.forwardTime = forwardTime;
+         this.inverseTime = inverseTime;
+         this.roundTrip = roundTrip;
+      }
+
+
+      public double getRatio()
+      {
+         return (this.inputSize == 0) ? 1.0 : (double) this.compressedSize / this.inputSize;
+      }
+
+
+      @Override
+      public String toString()
+      {
+         return String.format("%-10s %10d => %10d (%5.1f%%)  fwd: %8.2f ms  inv: %8.2f ms  %s",
+            this.name, this.inputSize, this.compressedSize, 100.0*this.getRatio(),
+            this.forwardTime/1000000.0, this.inverseTime/1000000.0,
+            (this.roundTrip == true) ? ((this.applied == true) ? "OK" : "skipped") : "FAILED");
+      }
+   }
+
+
+   private Profiler()
+   {
+   }
+
+
+   private static Map<String, Object> createContext(int blockSize)
+   {
+      Map<String, Object> ctx = new HashMap<>();
+      ctx.put("jobs", 1);
+      ctx.put("codec", EntropyCodecFactory.getName(DEFAULT_ENTROPY));
+      ctx.put("blockSize", blockSize);
+      return ctx;
+   }
+
+
+   // Run each built-in transform on the sample
+   public static List<ProfileResult> profile(byte[] sample)
+   {
+      List<ProfileResult> res = new ArrayList<>();
+
+      for (String name : ByteFunctionFactory.getTransformNames())
+         res.add(profile(name, sample));
+
+      return res;
+   }
+
+
+   // Run one transform (or sequence of transforms) on the sample
+   public static ProfileResult profile(String transform, byte[] sample)
+   {
+      ByteFunctionFactory bff = new ByteFunctionFactory();
+      final long type = bff.getType(transform);
+      final String name = bff.getName(type);
+      final int count = sample.length;
+      ByteTransformSequence seq = bff.newFunction(createContext(count), type);
+      byte[] input = Arrays.copyOf(sample, count); // the input may be modified
+      byte[] output = new byte[Math.max(seq.getMaxEncodedLength(count), count)];
+      SliceByteArray sa1 = new SliceByteArray(input, count, 0);
+      SliceByteArray sa2 = new SliceByteArray(output, 0);
+      long before = System.nanoTime();
+      final boolean applied = seq.forward(sa1, sa2);
+      final long forwardTime = System.nanoTime() - before;
+      final int transformedSize = sa2.index;
+      final long inverseTime = inverse(bff, type, seq.getSkipFlags(), output, transformedSize, sample);
+      final int entropy = (transformedSize == 0) ? 0 :
+         EntropyUtils.computeFirstOrderEntropy1024(output, 0, transformedSize, new int[256]);
+      final long compressedSize = getCompressedSize(output, transformedSize);
+      return new ProfileResult(name, count, transformedSize, compressedSize, entropy,
+         applied, forwardTime, Math.max(inverseTime, 0), inverseTime >= 0);
+   }
+
+
+   // Invert the transformed data with a new instance of the transform and
+   // compare with the original data. Return the inverse time (nanoseconds)
+   // or -1 if the data does not round trip.
+   private static long inverse(ByteFunctionFactory bff, long type, byte skipFlags,
+      byte[] data, int length, byte[] original)
+   {
+      try
+      {
+         ByteTransformSequence seq = bff.newFunction(createContext(original.length), type);
+         seq.setSkipFlags(skipFlags);
+         byte[] reverse = new byte[Math.max(seq.getMaxEncodedLength(original.length), original.length)];
+         SliceByteArray sa1 = new SliceByteArray(Arrays.copyOf(data, length), length, 0);
+         SliceByteArray sa2 = new SliceByteArray(reverse, 0);
+         final long before = System.nanoTime();
+         final boolean res = seq.inverse(sa1, sa2);
+         final long elapsed = System.nanoTime() - before;
+
+         if ((res == false) || (sa2.index != original.length) ||
+            (Arrays.equals(original, Arrays.copyOf(reverse, original.length)) == false))
+            return -1;
+
+         return elapsed;
+      }
+      catch (RuntimeException e)
+      {
+         return -1;
+      }
+   }
+
+
+   // Return the size (in bytes) of the data encoded with the default entropy
+   // codec into a null bit stream
+   private static long getCompressedSize(byte[] data, int length)
+   {
+      OutputBitStream obs = new DefaultOutputBitStream(new NullOutputStream(), 65536);
+      EntropyEncoder ee = new EntropyCodecFactory().newEncoder(obs, createContext(length), DEFAULT_ENTROPY);
+      ee.encode(data, 0, length);
+      ee.dispose();
+      obs.close();
+      return (obs.written()+7) >> 3;
+   }
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Separation of the signs and the magnitudes of signed integers (little
+// endian, two's complement, 1, 2, 4 or 8 bytes). The sign bits are packed in
+// one plane and the magnitudes (absolute values) are stored in another plane.
+// The magnitude is an unsigned value on elemSize bytes so the minimum value
+// (EG. -128 for bytes) is stored as sign 1 and magnitude 0x80...0 and is
+// restored exactly (two's complement negation wraps around).
+// Format: element size (1 byte), number of elements n (4 bytes), signs
+//         ((n+7)/8 bytes, MSB first), magnitudes (n elements, little endian),
+//         remaining bytes (count % element size)
+public class SignMagnitudeSplitCodec implements ByteFunction
+{
+   private static final int HEADER_SIZE = 5;
+
+   private final int elemSize;
+
+
+   public SignMagnitudeSplitCodec()
+   {
+      this(2);
+   }
+
+
+   public SignMagnitudeSplitCodec(int elemSize)
+   {
+      if ((elemSize != 1) && (elemSize != 2) && (elemSize != 4) && (elemSize != 8))
+         throw new IllegalArgumentException("SignMagnitude codec: Invalid element size: "+elemSize+" (must be 1, 2, 4 or 8)");
+
+      this.elemSize = elemSize;
+   }
+
+
+   public SignMagnitudeSplitCodec(Map<String, Object> ctx)
+   {
+      this((Integer) ctx.getOrDefault("signElemSize", 2));
+   }
+
+
+   private static long readElement(byte[] buf, int idx, int size)
+   {
+      long v = 0;
+
+      for (int i=size-1; i>=0; i--)
+         v = (v<<8) | (buf[idx+i]&0xFF);
+
+      // Sign extension
+      final int shift = 64 - 8*size;
+      return (v<<shift) >> shift;
+   }
+
+
+   private static void writeElement(byte[] buf, int idx, int size, long v)
+   {
+      for (int i=0; i<size; i++)
+      {
+         buf[idx+i] = (byte) v;
+         v >>= 8;
+      }
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+      final int sz = this.elemSize;
+
+      if (count < 8*sz)
+         return false;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int n = count / sz;
+      final int dstStart = output.index;
+      int dstIdx = dstStart;
+
+      // Write header
+      dst[dstIdx++] = (byte) sz;
+      dst[dstIdx++] = (byte) (n>>24);
+      dst[dstIdx++] = (byte) (n>>16);
+      dst[dstIdx++] = (byte) (n>>8);
+      dst[dstIdx++] = (byte) n;
+
+      final int signIdx = dstIdx;
+      int magIdx = signIdx + ((n+7)>>3);
+      int bits = 0;
+
+      for (int i=0; i<n; i++)
+      {
+         final long v = readElement(src, srcIdx+i*sz, sz);
+         final int sign = (int) (v>>>63);
+         bits = (bits<<1) | sign;
+
+         if ((i&7) == 7)
+         {
+            dst[dstIdx++] = (byte) bits;
+            bits = 0;
+         }
+
+         // For the minimum value, -v == v: the magnitude is 0x80...0 (unsigned)
+         writeElement(dst, magIdx, sz, (sign == 0) ? v : -v);
+         magIdx += sz;
+      }
+
+      if ((n&7) != 0)
+         dst[dstIdx] = (byte) (bits<<(8-(n&7)));
+
+      // Remaining bytes
+      for (int i=n*sz; i<count; i++)
+         dst[magIdx++] = src[srcIdx+i];
+
+      input.index += count;
+      output.index = magIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < HEADER_SIZE)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcEnd = input.index + input.length;
+      int srcIdx = input.index;
+      final int sz = src[srcIdx++];
+
+      if ((sz != 1) && (sz != 2) && (sz != 4) && (sz != 8))
+         return false;
+
+      final int n = ((src[srcIdx]&0xFF)<<24) | ((src[srcIdx+1]&0xFF)<<16) |
+         ((src[srcIdx+2]&0xFF)<<8) | (src[srcIdx+3]&0xFF);
+      srcIdx += 4;
+
+      if ((n < 0) || ((long) n*sz + ((n+7)>>3) > srcEnd-srcIdx) ||
+         ((long) n*sz > dst.length-output.index))
+         return false;
+
+      final int signIdx = srcIdx;
+      int magIdx = signIdx + ((n+7)>>3);
+      final int dstStart = output.index;
+
+      for (int i=0; i<n; i++)
+      {
+         final int sign = (src[signIdx+(i>>3)] >> (7-(i&7))) & 1;
+         long v = 0;
+
+         // Unsigned magnitude
+         for (int j=sz-1; j>=0; j--)
+            v = (v<<8) | (src[magIdx+j]&0xFF);
+
+         magIdx += sz;
+         writeElement(dst, dstStart+i*sz, sz, (sign == 0) ? v : -v);
+      }
+
+      // Remaining bytes
+      int dstIdx = dstStart + n*sz;
+      final int remaining = srcEnd - magIdx;
+
+      if ((remaining >= sz) || (dstIdx+remaining > dst.length))
+         return false;
+
+      System.arraycopy(src, magIdx, dst, dstIdx, remaining);
+      dstIdx += remaining;
+      input.index = srcEnd;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen + (srcLen>>3) + HEADER_SIZE + 8;
+   }
+}
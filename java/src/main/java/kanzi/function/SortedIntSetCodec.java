@@ -0,0 +1,349 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Encoding of a sorted set of integers (EG. list of ids). The block is a
+// strictly increasing sequence of 32 bit unsigned integers (big endian). Like
+// in a Roaring bitmap, the values are split in groups sharing the same 16 most
+// significant bits and each group is encoded either as a bitmap of the 16 least
+// significant bits (dense group) or as deltas (sparse group), whichever is
+// smaller.
+// The transform fails if the block is not a sorted set or if the output is
+// not smaller than the input.
+// Format: number of groups (4 bytes), groups:
+//         key delta to the previous group (varint), (cardinality-1)<<1 | type
+//         (varint), then for a BITMAP group: bitmap length (varint), bitmap
+//         (least significant bit first), for a DELTA group: first value
+//         (varint), deltas-1 (varints)
+public class SortedIntSetCodec implements ByteFunction
+{
+   public static final int DELTA = 0;
+   public static final int BITMAP = 1;
+
+   private static final int HEADER_SIZE = 4;
+   private static final int GROUP_SIZE = 1 << 16;
+
+
+   public SortedIntSetCodec()
+   {
+   }
+
+
+   public SortedIntSetCodec(Map<String, Object> ctx)
+   {
+      this();
+   }
+
+
+   private static int readInt32(byte[] buf, int idx)
+   {
+      return ((buf[idx]&0xFF)<<24) | ((buf[idx+1]&0xFF)<<16) |
+         ((buf[idx+2]&0xFF)<<8) | (buf[idx+3]&0xFF);
+   }
+
+
+   private static int getVarIntSize(int val)
+   {
+      int res = 1;
+
+      while ((val & ~0x7F) != 0)
+      {
+         val >>>= 7;
+         res++;
+      }
+
+      return res;
+   }
+
+
+   private static int writeVarInt(byte[] buf, int idx, int val)
+   {
+      while ((val & ~0x7F) != 0)
+      {
+         buf[idx++] = (byte) (0x80|(val&0x7F));
+         val >>>= 7;
+      }
+
+      buf[idx++] = (byte) val;
+      return idx;
+   }
+
+
+   // Return the value in res[0] and the next index (or -1 on error)
+   private static int readVarInt(byte[] buf, int idx, int end, int[] res)
+   {
+      int val = 0;
+      int shift = 0;
+
+      while (true)
+      {
+         if ((idx >= end) || (shift > 28))
+            return -1;
+
+         final int b = buf[idx++] & 0xFF;
+         val |= (b&0x7F) << shift;
+
+         if (b < 0x80)
+            break;
+
+         shift += 7;
+      }
+
+      res[0] = val;
+      return idx;
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if ((count & 3) != 0)
+         return false;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int srcEnd = srcIdx + count;
+      final int dstStart = output.index;
+
+      // The encoded output must be smaller than the input
+      final int limit = dstStart + count;
+      int dstIdx = dstStart + HEADER_SIZE;
+      int nbGroups = 0;
+      int prevKey = -1;
+      long prev = -1;
+
+      for (int i=srcIdx; i<srcEnd; )
+      {
+         // Find the end of the group and check that the values are sorted
+         final long first = readInt32(src, i) & 0xFFFFFFFFL;
+
+         if (first <= prev)
+            return false;
+
+         final int key = (int) (first >>> 16);
+         int j = i + 4;
+         prev = first;
+
+         while (j < srcEnd)
+         {
+            final long val = readInt32(src, j) & 0xFFFFFFFFL;
+
+            if ((int) (val >>> 16) != key)
+               break;
+
+            if (val <= prev)
+               return false;
+
+            prev = val;
+            j += 4;
+         }
+
+         final int card = (j-i) >> 2;
+         final int lastLow = (int) (prev & 0xFFFF);
+
+         // Pick the smallest encoding
+         final int bitmapLength = (lastLow>>3) + 1;
+         int deltaSize = getVarIntSize((int) (first & 0xFFFF));
+
+         for (int k=i+4; k<j; k+=4)
+            deltaSize += getVarIntSize(readInt32(src, k) - readInt32(src, k-4) - 1);
+
+         final int type = (getVarIntSize(bitmapLength) + bitmapLength < deltaSize) ? BITMAP : DELTA;
+         final int payload = (type == BITMAP) ? getVarIntSize(bitmapLength) + bitmapLength : deltaSize;
+
+         if (dstIdx + 10 + payload >= limit)
+            return false;
+
+         dstIdx = writeVarInt(dst, dstIdx, key-prevKey-1);
+         dstIdx = writeVarInt(dst, dstIdx, ((card-1)<<1) | type);
+
+         if (type == BITMAP)
+         {
+            dstIdx = writeVarInt(dst, dstIdx, bitmapLength);
+
+            for (int k=0; k<bitmapLength; k++)
+               dst[dstIdx+k] = 0;
+
+            for (int k=i; k<j; k+=4)
+            {
+               final int low = readInt32(src, k) & 0xFFFF;
+               dst[dstIdx+(low>>3)] |= (byte) (1<<(low&7));
+            }
+
+            dstIdx += bitmapLength;
+         }
+         else
+         {
+            dstIdx = writeVarInt(dst, dstIdx, (int) (first & 0xFFFF));
+
+            for (int k=i+4; k<j; k+=4)
+               dstIdx = writeVarInt(dst, dstIdx, readInt32(src, k) - readInt32(src, k-4) - 1);
+         }
+
+         prevKey = key;
+         nbGroups++;
+         i = j;
+      }
+
+      dst[dstStart]   = (byte) (nbGroups>>24);
+      dst[dstStart+1] = (byte) (nbGroups>>16);
+      dst[dstStart+2] = (byte) (nbGroups>>8);
+      dst[dstStart+3] = (byte) nbGroups;
+      input.index += count;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < HEADER_SIZE)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcEnd = input.index + input.length;
+      final int nbGroups = readInt32(src, input.index);
+
+      if ((nbGroups < 0) || (nbGroups > GROUP_SIZE))
+         return false;
+
+      final int dstEnd = dst.length;
+      final int[] res = new int[1];
+      int srcIdx = input.index + HEADER_SIZE;
+      int dstIdx = output.index;
+      int key = -1;
+
+      for (int g=0; g<nbGroups; g++)
+      {
+         if ((srcIdx = readVarInt(src, srcIdx, srcEnd, res)) < 0)
+            return false;
+
+         key += res[0] + 1;
+
+         if ((res[0] < 0) || (key >= GROUP_SIZE))
+            return false;
+
+         if ((srcIdx = readVarInt(src, srcIdx, srcEnd, res)) < 0)
+            return false;
+
+         final int card = (res[0]>>>1) + 1;
+         final int type = res[0] & 1;
+
+         if ((card > GROUP_SIZE) || (dstIdx+4L*card > dstEnd))
+            return false;
+
+         final int high = key << 16;
+         int n = 0;
+
+         if (type == BITMAP)
+         {
+            if ((srcIdx = readVarInt(src, srcIdx, srcEnd, res)) < 0)
+               return false;
+
+            final int bitmapLength = res[0];
+
+            if ((bitmapLength <= 0) || (bitmapLength > GROUP_SIZE/8) || (bitmapLength > srcEnd-srcIdx))
+               return false;
+
+            for (int k=0; k<bitmapLength; k++)
+            {
+               int bits = src[srcIdx+k] & 0xFF;
+
+               while (bits != 0)
+               {
+                  if (n >= card)
+                     return false;
+
+                  final int val = high | (k<<3) | Integer.numberOfTrailingZeros(bits);
+                  bits &= (bits-1);
+                  dst[dstIdx]   = (byte) (val>>24);
+                  dst[dstIdx+1] = (byte) (val>>16);
+                  dst[dstIdx+2] = (byte) (val>>8);
+                  dst[dstIdx+3] = (byte) val;
+                  dstIdx += 4;
+                  n++;
+               }
+            }
+
+            srcIdx += bitmapLength;
+         }
+         else
+         {
+            int low = -1;
+
+            for (; n<card; n++)
+            {
+               if ((srcIdx = readVarInt(src, srcIdx, srcEnd, res)) < 0)
+                  return false;
+
+               low += res[0] + 1;
+
+               if ((res[0] < 0) || (low >= GROUP_SIZE))
+                  return false;
+
+               final int val = high | low;
+               dst[dstIdx]   = (byte) (val>>24);
+               dst[dstIdx+1] = (byte) (val>>16);
+               dst[dstIdx+2] = (byte) (val>>8);
+               dst[dstIdx+3] = (byte) val;
+               dstIdx += 4;
+            }
+         }
+
+         if (n != card)
+            return false;
+      }
+
+      if (srcIdx != srcEnd)
+         return false;
+
+      input.index = srcEnd;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen;
+   }
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Split of sparse data (mostly zeros with a few non zero values) into a
+// presence bitmap (one bit per byte, 1 for a non zero byte, most significant
+// bit first) and the packed non zero bytes.
+// The transform fails if the density of non zero bytes is too high (the
+// bitmap would not pay for itself) or if the output is not smaller than the
+// input.
+// Format: block size n (4 bytes), bitmap ((n+7)/8 bytes), non zero bytes
+public class SparseMaskCodec implements ByteFunction
+{
+   private static final int HEADER_SIZE = 4;
+   private static final int MAX_DENSITY = 50; // in percent
+
+
+   public SparseMaskCodec()
+   {
+   }
+
+
+   public SparseMaskCodec(Map<String, Object> ctx)
+   {
+      this();
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int srcEnd = srcIdx + count;
+      int nz = 0;
+
+      for (int i=srcIdx; i<srcEnd; i++)
+      {
+         if (src[i] != 0)
+            nz++;
+      }
+
+      if ((long) nz*100 > (long) count*MAX_DENSITY)
+         return false;
+
+      final int mapSize = (count+7) >>> 3;
+
+      if ((long) HEADER_SIZE + mapSize + nz >= count)
+         return false;
+
+      int dstIdx = output.index;
+      dst[dstIdx++] = (byte) (count>>24);
+      dst[dstIdx++] = (byte) (count>>16);
+      dst[dstIdx++] = (byte) (count>>8);
+      dst[dstIdx++] = (byte) count;
+      int mapIdx = dstIdx;
+      int valIdx = dstIdx + mapSize;
+
+      // Bitmap and non zero bytes
+      for (int i=srcIdx; i<srcEnd; i+=8)
+      {
+         final int end = Math.min(i+8, srcEnd);
+         int bits = 0;
+
+         for (int j=i; j<end; j++)
+         {
+            bits <<= 1;
+
+            if (src[j] != 0)
+            {
+               bits |= 1;
+               dst[valIdx++] = src[j];
+            }
+         }
+
+         dst[mapIdx++] = (byte) (bits << (8-(end-i)));
+      }
+
+      input.index += count;
+      output.index = valIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < HEADER_SIZE)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcEnd = input.index + input.length;
+      int srcIdx = input.index;
+      final int count = ((src[srcIdx]&0xFF)<<24) | ((src[srcIdx+1]&0xFF)<<16) |
+         ((src[srcIdx+2]&0xFF)<<8) | (src[srcIdx+3]&0xFF);
+      srcIdx += HEADER_SIZE;
+
+      if ((count <= 0) || (count > dst.length-output.index))
+         return false;
+
+      final int mapSize = (count+7) >>> 3;
+
+      if (mapSize > srcEnd-srcIdx)
+         return false;
+
+      int mapIdx = srcIdx;
+      int valIdx = srcIdx + mapSize;
+      int dstIdx = output.index;
+      final int dstEnd = dstIdx + count;
+
+      for (int i=dstIdx; i<dstEnd; i+=8)
+      {
+         final int end = Math.min(i+8, dstEnd);
+         final int bits = src[mapIdx++] & 0xFF;
+
+         for (int j=i, shift=7; j<end; j++, shift--)
+         {
+            if (((bits >>> shift) & 1) == 0)
+            {
+               dst[j] = 0;
+               continue;
+            }
+
+            // Values must be non zero
+            if ((valIdx >= srcEnd) || (src[valIdx] == 0))
+               return false;
+
+            dst[j] = src[valIdx++];
+         }
+      }
+
+      // All the values must have been consumed
+      if (valIdx != srcEnd)
+         return false;
+
+      input.index = srcEnd;
+      output.index = dstEnd;
+      return true;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen;
+   }
+}
@@ -0,0 +1,512 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.nio.charset.StandardCharsets;
+import java.util.ArrayList;
+import java.util.Collections;
+import java.util.Comparator;
+import java.util.HashMap;
+import java.util.List;
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Template coding of log lines: lines are split into tokens (separated by
+// single spaces) and the tokens that occur often at the same position of lines
+// with the same number of tokens (and contain no digit) are considered
+// constant. The other tokens are variable fields. Frequent line templates
+// (constant tokens + variable slots) are stored once and each line is encoded
+// as a template id followed by its variable fields. Lines without a frequent
+// template are stored as is.
+// The number of templates is bounded and the transform fails if the block
+// contains binary data (bytes 0 or 1), if there are too few lines or no
+// repeated template, or if the output is not smaller than the input.
+// Format: number of lines (4 bytes), number of templates (2 bytes), flags
+//         (1 byte), size of templates (4 bytes), size of ids (4 bytes),
+//         templates ('\n' terminated, variable slots as byte 1), template
+//         ids (varints, 0 for a line stored as is), fields (variable fields
+//         terminated by 0, lines stored as is terminated by '\n')
+public class TemplateCodec implements ByteFunction
+{
+   private static final int HEADER_SIZE = 15;
+   private static final int MIN_LINES = 16;
+   private static final int MIN_REPEATS = 4;
+   private static final int MAX_TEMPLATES = 4096;
+   private static final int MAX_TOKENS = 255;
+   private static final byte VAR = 0x01;
+   private static final byte END_FIELD = 0x00;
+   private static final int LAST_LINE_TERMINATED = 1;
+
+
+   public TemplateCodec()
+   {
+   }
+
+
+   public TemplateCodec(Map<String, Object> ctx)
+   {
+      this();
+   }
+
+
+   private static boolean hasDigit(byte[] buf, int start, int end)
+   {
+      for (int i=start; i<end; i++)
+      {
+         if ((buf[i] >= '0') && (buf[i] <= '9'))
+            return true;
+      }
+
+      return false;
+   }
+
+
+   // Return the start of the tokens of the line (plus end+1) or null if there
+   // are too many tokens
+   private static int[] tokenize(byte[] buf, int start, int end)
+   {
+      int nbTokens = 1;
+
+      for (int i=start; i<end; i++)
+      {
+         if (buf[i] == ' ')
+            nbTokens++;
+      }
+
+      if (nbTokens > MAX_TOKENS)
+         return null;
+
+      final int[] res = new int[nbTokens+1];
+      int n = 0;
+      res[n++] = start;
+
+      for (int i=start; i<end; i++)
+      {
+         if (buf[i] == ' ')
+            res[n++] = i+1;
+      }
+
+      res[n] = end+1;
+      return res;
+   }
+
+
+   private static String getKey(byte[] buf, int nbTokens, int pos, int start, int end)
+   {
+      return nbTokens + ":" + pos + ":" + new String(buf, start, end-start, StandardCharsets.ISO_8859_1);
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int srcEnd = srcIdx + count;
+
+      // Split lines (no binary data)
+      List<int[]> lines = new ArrayList<>();
+      List<int[]> bounds = new ArrayList<>();
+      int start = srcIdx;
+
+      for (int i=srcIdx; i<srcEnd; i++)
+      {
+         if ((src[i] == END_FIELD) || (src[i] == VAR))
+            return false;
+
+         if (src[i] == '\n')
+         {
+            lines.add(tokenize(src, start, i));
+            bounds.add(new int[] { start, i });
+            start = i + 1;
+         }
+      }
+
+      final boolean terminated = start == srcEnd;
+
+      if (terminated == false)
+      {
+         lines.add(tokenize(src, start, srcEnd));
+         bounds.add(new int[] { start, srcEnd });
+      }
+
+      final int n = lines.size();
+
+      if (n < MIN_LINES)
+         return false;
+
+      // Frequencies of tokens by position
+      Map<String, Integer> freqs = new HashMap<>();
+
+      for (int[] tokens : lines)
+      {
+         if (tokens == null)
+            continue;
+
+         final int nbTokens = tokens.length - 1;
+
+         for (int j=0; j<nbTokens; j++)
+         {
+            if (hasDigit(src, tokens[j], tokens[j+1]-1) == true)
+               continue;
+
+            final String key = getKey(src, nbTokens, j, tokens[j], tokens[j+1]-1);
+            freqs.put(key, freqs.getOrDefault(key, 0) + 1);
+         }
+      }
+
+      // Template of each line (frequent tokens, other tokens replaced by VAR)
+      final String[] lineTemplates = new String[n];
+      final Map<String, Integer> counts = new HashMap<>();
+      final byte[] tmp = new byte[count+MAX_TOKENS+1];
+
+      for (int i=0; i<n; i++)
+      {
+         final int[] tokens = lines.get(i);
+
+         if (tokens == null)
+            continue;
+
+         final int nbTokens = tokens.length - 1;
+         int len = 0;
+         boolean hasConstant = false;
+
+         for (int j=0; j<nbTokens; j++)
+         {
+            final int tStart = tokens[j];
+            final int tEnd = tokens[j+1] - 1;
+
+            if (j > 0)
+               tmp[len++] = ' ';
+
+            if ((hasDigit(src, tStart, tEnd) == false) &&
+               (freqs.getOrDefault(getKey(src, nbTokens, j, tStart, tEnd), 0) >= MIN_REPEATS))
+            {
+               System.arraycopy(src, tStart, tmp, len, tEnd-tStart);
+               len += (tEnd - tStart);
+               hasConstant = true;
+            }
+            else
+            {
+               tmp[len++] = VAR;
+            }
+         }
+
+         if (hasConstant == false)
+            continue;
+
+         final String key = new String(tmp, 0, len, StandardCharsets.ISO_8859_1);
+         lineTemplates[i] = key;
+         counts.put(key, counts.getOrDefault(key, 0) + 1);
+      }
+
+      // Select the most frequent templates (smaller ids)
+      List<String> templates = new ArrayList<>();
+
+      for (Map.Entry<String, Integer> e : counts.entrySet())
+      {
+         if (e.getValue() >= MIN_REPEATS)
+            templates.add(e.getKey());
+      }
+
+      if (templates.isEmpty() == true)
+         return false;
+
+      Collections.sort(templates, new Comparator<String>()
+      {
+         @Override
+         public int compare(String t1, String t2)
+         {
+            final int res = counts.get(t2) - counts.get(t1);
+            return (res != 0) ? res : t1.compareTo(t2);
+         }
+      });
+
+      if (templates.size() > MAX_TEMPLATES)
+         templates = templates.subList(0, MAX_TEMPLATES);
+
+      final Map<String, Integer> ids = new HashMap<>();
+      final int dstStart = output.index;
+      final int limit = dstStart + count;
+      int dstIdx = dstStart + HEADER_SIZE;
+
+      for (String t : templates)
+      {
+         ids.put(t, ids.size()+1);
+         final byte[] b = t.getBytes(StandardCharsets.ISO_8859_1);
+
+         if (dstIdx+b.length+1 >= limit)
+            return false;
+
+         System.arraycopy(b, 0, dst, dstIdx, b.length);
+         dstIdx += b.length;
+         dst[dstIdx++] = '\n';
+      }
+
+      final int templatesSize = dstIdx - dstStart - HEADER_SIZE;
+
+      // Template ids go to the output, fields to a temporary buffer
+      int fieldsIdx = 0;
+
+      for (int i=0; i<n; i++)
+      {
+         final int[] tokens = lines.get(i);
+         final Integer id = (lineTemplates[i] == null) ? null : ids.get(lineTemplates[i]);
+         int val = (id == null) ? 0 : id;
+
+         if (dstIdx+3 >= limit)
+            return false;
+
+         while (val >= 0x80)
+         {
+            dst[dstIdx++] = (byte) (0x80|(val&0x7F));
+            val >>>= 7;
+         }
+
+         dst[dstIdx++] = (byte) val;
+
+         if (id == null)
+         {
+            // Line stored as is
+            final int lStart = bounds.get(i)[0];
+            final int lEnd = bounds.get(i)[1];
+            System.arraycopy(src, lStart, tmp, fieldsIdx, lEnd-lStart);
+            fieldsIdx += (lEnd - lStart);
+            tmp[fieldsIdx++] = '\n';
+            continue;
+         }
+
+         // Variable fields
+         final String t = lineTemplates[i];
+         final int nbTokens = tokens.length - 1;
+         int tIdx = 0;
+
+         for (int j=0; j<nbTokens; j++)
+         {
+            if ((tIdx < t.length()) && (t.charAt(tIdx) == VAR))
+            {
+               final int tStart = tokens[j];
+               final int tEnd = tokens[j+1] - 1;
+               System.arraycopy(src, tStart, tmp, fieldsIdx, tEnd-tStart);
+               fieldsIdx += (tEnd - tStart);
+               tmp[fieldsIdx++] = END_FIELD;
+               tIdx++;
+            }
+            else
+            {
+               tIdx += (tokens[j+1] - 1 - tokens[j]);
+            }
+
+            // Skip space
+            tIdx++;
+         }
+      }
+
+      final int idsSize = dstIdx - dstStart - HEADER_SIZE - templatesSize;
+
+      if (dstIdx+fieldsIdx >= limit)
+         return false;
+
+      System.arraycopy(tmp, 0, dst, dstIdx, fieldsIdx);
+      dstIdx += fieldsIdx;
+      final int nbTemplates = templates.size();
+      dst[dstStart]    = (byte) (n>>24);
+      dst[dstStart+1]  = (byte) (n>>16);
+      dst[dstStart+2]  = (byte) (n>>8);
+      dst[dstStart+3]  = (byte) n;
+      dst[dstStart+4]  = (byte) (nbTemplates>>8);
+      dst[dstStart+5]  = (byte) nbTemplates;
+      dst[dstStart+6]  = (byte) ((terminated == true) ? LAST_LINE_TERMINATED : 0);
+      dst[dstStart+7]  = (byte) (templatesSize>>24);
+      dst[dstStart+8]  = (byte) (templatesSize>>16);
+      dst[dstStart+9]  = (byte) (templatesSize>>8);
+      dst[dstStart+10] = (byte) templatesSize;
+      dst[dstStart+11] = (byte) (idsSize>>24);
+      dst[dstStart+12] = (byte) (idsSize>>16);
+      dst[dstStart+13] = (byte) (idsSize>>8);
+      dst[dstStart+14] = (byte) idsSize;
+      input.index += count;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < HEADER_SIZE)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcEnd = input.index + input.length;
+      int srcIdx = input.index;
+      final int n = ((src[srcIdx]&0xFF)<<24) | ((src[srcIdx+1]&0xFF)<<16) |
+         ((src[srcIdx+2]&0xFF)<<8) | (src[srcIdx+3]&0xFF);
+      final int nbTemplates = ((src[srcIdx+4]&0xFF)<<8) | (src[srcIdx+5]&0xFF);
+      final boolean terminated = (src[srcIdx+6] & LAST_LINE_TERMINATED) != 0;
+      final int templatesSize = ((src[srcIdx+7]&0xFF)<<24) | ((src[srcIdx+8]&0xFF)<<16) |
+         ((src[srcIdx+9]&0xFF)<<8) | (src[srcIdx+10]&0xFF);
+      final int idsSize = ((src[srcIdx+11]&0xFF)<<24) | ((src[srcIdx+12]&0xFF)<<16) |
+         ((src[srcIdx+13]&0xFF)<<8) | (src[srcIdx+14]&0xFF);
+      srcIdx += HEADER_SIZE;
+
+      if ((n < 0) || (nbTemplates > MAX_TEMPLATES) || (templatesSize < 0) || (idsSize < 0) ||
+         ((long) templatesSize + (long) idsSize > srcEnd-srcIdx))
+         return false;
+
+      // Start and end of each template
+      final int[] tStarts = new int[nbTemplates+1];
+      final int templatesEnd = srcIdx + templatesSize;
+      int tIdx = srcIdx;
+
+      for (int t=0; t<nbTemplates; t++)
+      {
+         tStarts[t] = tIdx;
+
+         while ((tIdx < templatesEnd) && (src[tIdx] != '\n'))
+            tIdx++;
+
+         if (tIdx >= templatesEnd)
+            return false;
+
+         tIdx++;
+      }
+
+      if (tIdx != templatesEnd)
+         return false;
+
+      tStarts[nbTemplates] = templatesEnd;
+      final int idsEnd = templatesEnd + idsSize;
+      int idIdx = templatesEnd;
+      int fIdx = idsEnd;
+      int dstIdx = output.index;
+      final int dstEnd = dst.length;
+
+      for (int i=0; i<n; i++)
+      {
+         // Read template id
+         int id = 0;
+         int shift = 0;
+
+         while (true)
+         {
+            if ((idIdx >= idsEnd) || (shift > 28))
+               return false;
+
+            final int b = src[idIdx++] & 0xFF;
+            id |= ((b&0x7F) << shift);
+
+            if (b < 0x80)
+               break;
+
+            shift += 7;
+         }
+
+         if ((id < 0) || (id > nbTemplates))
+            return false;
+
+         if (id == 0)
+         {
+            // Line stored as is
+            while ((fIdx < srcEnd) && (src[fIdx] != '\n'))
+            {
+               if (dstIdx >= dstEnd)
+                  return false;
+
+               dst[dstIdx++] = src[fIdx++];
+            }
+
+            if (fIdx >= srcEnd)
+               return false;
+
+            fIdx++;
+         }
+         else
+         {
+            // Template with variable fields
+            final int end = tStarts[id] - 1;
+
+            for (int k=tStarts[id-1]; k<end; k++)
+            {
+               if (src[k] != VAR)
+               {
+                  if (dstIdx >= dstEnd)
+                     return false;
+
+                  dst[dstIdx++] = src[k];
+                  continue;
+               }
+
+               while ((fIdx < srcEnd) && (src[fIdx] != END_FIELD))
+               {
+                  if (dstIdx >= dstEnd)
+                     return false;
+
+                  dst[dstIdx++] = src[fIdx++];
+               }
+
+               if (fIdx >= srcEnd)
+                  return false;
+
+               fIdx++;
+            }
+         }
+
+         if ((i < n-1) || (terminated == true))
+         {
+            if (dstIdx >= dstEnd)
+               return false;
+
+            dst[dstIdx++] = '\n';
+         }
+      }
+
+      if ((idIdx != idsEnd) || (fIdx != srcEnd))
+         return false;
+
+      input.index = srcEnd;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen;
+   }
+}
@@ -0,0 +1,598 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.Arrays;
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Decoding of the base64 and hexadecimal blobs embedded in text. Each region
+// (contiguous hexadecimal digits with a uniform case, or base64 characters
+// with optional '=' padding and line wrapping at a fixed width with LF or
+// CR LF line ends) is replaced by the decoded binary data, moved to a side
+// stream. A region is only selected if encoding the decoded data again yields
+// the exact original text, so the inverse is always exact.
+// The transform fails if there is no region or if the output is not smaller
+// than the input.
+// Format: number of regions (4 bytes), size of descriptors (4 bytes), size of
+//         binary data (4 bytes), descriptors, text outside of the regions,
+//         binary data
+//         Descriptor: gap (varint, text bytes since the previous region),
+//         flags (1 byte), decoded length (varint), wrap width (varint, only
+//         for wrapped base64)
+public class TextBlobDecodeCodec implements ByteFunction
+{
+   private static final int HEADER_SIZE = 12;
+   private static final int MIN_HEX_LENGTH = 32;
+   private static final int MIN_BASE64_LENGTH = 32;
+   private static final int MIN_WRAP = 16;
+   private static final int HEX_LOWER = 0;
+   private static final int HEX_UPPER = 1;
+   private static final int BASE64 = 2;
+   private static final int KIND_MASK = 3;
+   private static final int PADDED_FLAG = 4;
+   private static final int WRAPPED_FLAG = 8;
+   private static final int CRLF_FLAG = 16;
+   private static final byte[] HEX_LOWER_DIGITS = "0123456789abcdef".getBytes();
+   private static final byte[] HEX_UPPER_DIGITS = "0123456789ABCDEF".getBytes();
+   private static final byte[] BASE64_DIGITS =
+      "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/".getBytes();
+   private static final int[] BASE64_VALUES = new int[256];
+   private static final int[] HEX_VALUES = new int[256];
+
+   static
+   {
+      Arrays.fill(BASE64_VALUES, -1);
+      Arrays.fill(HEX_VALUES, -1);
+
+      for (int i=0; i<64; i++)
+         BASE64_VALUES[BASE64_DIGITS[i]] = i;
+
+      for (int i=0; i<16; i++)
+      {
+         HEX_VALUES[HEX_LOWER_DIGITS[i]] = i;
+         HEX_VALUES[HEX_UPPER_DIGITS[i]] = i;
+      }
+   }
+
+
+   public TextBlobDecodeCodec()
+   {
+   }
+
+
+   public TextBlobDecodeCodec(Map<String, Object> ctx)
+   {
+      this();
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final int srcIdx = input.index;
+      final int srcEnd = srcIdx + count;
+      final byte[] bin = new byte[count];
+      byte[] scratch = new byte[0];
+      int binIdx = 0;
+
+      // Regions: start, end, flags, wrap width, decoded length
+      int[] regions = new int[5*16];
+      int nbRegions = 0;
+      int i = srcIdx;
+
+      while (i < srcEnd)
+      {
+         if (BASE64_VALUES[src[i]&0xFF] < 0)
+         {
+            i++;
+            continue;
+         }
+
+         int j = i;
+
+         while ((j < srcEnd) && (BASE64_VALUES[src[j]&0xFF] >= 0))
+            j++;
+
+         int end = -1;
+         int flags = 0;
+         int wrap = 0;
+         int decoded = 0;
+
+         final int hexKind = getHexKind(src, i, j);
+
+         if ((hexKind >= 0) && (j-i >= MIN_HEX_LENGTH) && (((j-i) & 1) == 0))
+         {
+            end = j;
+            flags = hexKind;
+            decoded = decodeHex(src, i, j, bin, binIdx);
+         }
+         else
+         {
+            // Base64: first line, then lines of the same width (wrapping)
+            int pos = skipPadding(src, j, srcEnd);
+            final int lineLen = pos - i;
+            flags = BASE64;
+
+            if ((pos == j) && ((lineLen & 3) == 0) && (lineLen >= MIN_WRAP))
+            {
+               final int eolLen = getEOLLength(src, pos, srcEnd);
+
+               while ((eolLen > 0) && (getEOLLength(src, pos, srcEnd) == eolLen))
+               {
+                  final int k = pos + eolLen;
+                  int e = k;
+
+                  while ((e < srcEnd) && (BASE64_VALUES[src[e]&0xFF] >= 0))
+                     e++;
+
+                  final int p = skipPadding(src, e, srcEnd);
+
+                  if ((e == k) || (p-k > lineLen))
+                     break;
+
+                  wrap = lineLen;
+                  flags |= (WRAPPED_FLAG | ((eolLen == 2) ? CRLF_FLAG : 0));
+                  pos = p;
+
+                  if ((p != e) || (p-k != lineLen))
+                     break; // last line
+               }
+            }
+
+            if (src[pos-1] == '=')
+               flags |= PADDED_FLAG;
+
+            if (isBase64Candidate(src, i, pos) == true)
+            {
+               end = pos;
+               decoded = decodeBase64(src, i, pos, bin, binIdx);
+            }
+         }
+
+         if ((end > 0) && (decoded > 0))
+         {
+            // Check that encoding the binary data again yields the original text
+            if (scratch.length < end-i)
+               scratch = new byte[end-i];
+
+            final int n = encode(bin, binIdx, decoded, flags, wrap, scratch, 0, end-i);
+            boolean valid = n == end-i;
+
+            for (int k=0; (valid == true) && (k<n); k++)
+               valid = scratch[k] == src[i+k];
+
+            if (valid == true)
+            {
+               if (5*nbRegions+5 > regions.length)
+                  regions = Arrays.copyOf(regions, 2*regions.length);
+
+               regions[5*nbRegions] = i;
+               regions[5*nbRegions+1] = end;
+               regions[5*nbRegions+2] = flags;
+               regions[5*nbRegions+3] = wrap;
+               regions[5*nbRegions+4] = decoded;
+               nbRegions++;
+               binIdx += decoded;
+               i = end;
+               continue;
+            }
+         }
+
+         i = j;
+      }
+
+      if (nbRegions == 0)
+         return false;
+
+      // Descriptors
+      final byte[] desc = new byte[16*nbRegions];
+      int descIdx = 0;
+      int textSize = count;
+      int prev = srcIdx;
+
+      for (int r=0; r<nbRegions; r++)
+      {
+         final int start = regions[5*r];
+         final int flags = regions[5*r+2];
+         descIdx = writeVarInt(desc, descIdx, start-prev);
+         desc[descIdx++] = (byte) flags;
+         descIdx = writeVarInt(desc, descIdx, regions[5*r+4]);
+
+         if ((flags & WRAPPED_FLAG) != 0)
+            descIdx = writeVarInt(desc, descIdx, regions[5*r+3]);
+
+         textSize -= (regions[5*r+1] - start);
+         prev = regions[5*r+1];
+      }
+
+      if ((long) HEADER_SIZE + descIdx + textSize + binIdx >= count)
+         return false;
+
+      final byte[] dst = output.array;
+      int dstIdx = output.index;
+      dstIdx = writeInt(dst, dstIdx, nbRegions);
+      dstIdx = writeInt(dst, dstIdx, descIdx);
+      dstIdx = writeInt(dst, dstIdx, binIdx);
+      System.arraycopy(desc, 0, dst, dstIdx, descIdx);
+      dstIdx += descIdx;
+      prev = srcIdx;
+
+      // Text outside of the regions
+      for (int r=0; r<nbRegions; r++)
+      {
+         System.arraycopy(src, prev, dst, dstIdx, regions[5*r]-prev);
+         dstIdx += (regions[5*r] - prev);
+         prev = regions[5*r+1];
+      }
+
+      System.arraycopy(src, prev, dst, dstIdx, srcEnd-prev);
+      dstIdx += (srcEnd - prev);
+
+      // Binary data
+      System.arraycopy(bin, 0, dst, dstIdx, binIdx);
+      dstIdx += binIdx;
+      input.index += count;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < HEADER_SIZE)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcEnd = input.index + input.length;
+      int srcIdx = input.index;
+      final int nbRegions = readInt(src, srcIdx);
+      final int descSize = readInt(src, srcIdx+4);
+      final int binSize = readInt(src, srcIdx+8);
+      srcIdx += HEADER_SIZE;
+
+      // At least 3 bytes per descriptor
+      if ((nbRegions <= 0) || (descSize < 3L*nbRegions) || (binSize < 0) ||
+         ((long) descSize + binSize > srcEnd-srcIdx))
+         return false;
+
+      final int descEnd = srcIdx + descSize;
+      final int binEnd = srcEnd;
+      int binIdx = srcEnd - binSize;
+      final int textEnd = binIdx;
+      int textIdx = descEnd;
+      final int dstEnd = dst.length;
+      int dstIdx = output.index;
+      final int[] val = new int[1];
+
+      for (int r=0; r<nbRegions; r++)
+      {
+         srcIdx = readVarInt(src, srcIdx, descEnd, val);
+
+         if ((srcIdx < 0) || (srcIdx >= descEnd))
+            return false;
+
+         final int gap = val[0];
+         final int flags = src[srcIdx++] & 0xFF;
+         srcIdx = readVarInt(src, srcIdx, descEnd, val);
+
+         if (srcIdx < 0)
+            return false;
+
+         final int len = val[0];
+         int wrap = 0;
+
+         if ((flags & WRAPPED_FLAG) != 0)
+         {
+            srcIdx = readVarInt(src, srcIdx, descEnd, val);
+
+            if ((srcIdx < 0) || (val[0] == 0))
+               return false;
+
+            wrap = val[0];
+         }
+
+         if ((flags > (BASE64|PADDED_FLAG|WRAPPED_FLAG|CRLF_FLAG)) || ((flags & KIND_MASK) > BASE64))
+            return false;
+
+         if ((len == 0) || (gap > textEnd-textIdx) || (gap > dstEnd-dstIdx) || (len > binEnd-binIdx))
+            return false;
+
+         System.arraycopy(src, textIdx, dst, dstIdx, gap);
+         textIdx += gap;
+         dstIdx += gap;
+         dstIdx = encode(src, binIdx, len, flags, wrap, dst, dstIdx, dstEnd);
+
+         if (dstIdx < 0)
+            return false;
+
+         binIdx += len;
+      }
+
+      if ((srcIdx != descEnd) || (binIdx != binEnd) || (textEnd-textIdx > dstEnd-dstIdx))
+         return false;
+
+      System.arraycopy(src, textIdx, dst, dstIdx, textEnd-textIdx);
+      dstIdx += (textEnd - textIdx);
+      input.index = srcEnd;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   // Return the case of a run of hexadecimal digits (-1 if not hexadecimal
+   // or mixed case)
+   private static int getHexKind(byte[] src, int start, int end)
+   {
+      boolean lower = false;
+      boolean upper = false;
+
+      for (int i=start; i<end; i++)
+      {
+         final int c = src[i];
+
+         if (HEX_VALUES[c&0xFF] < 0)
+            return -1;
+
+         lower |= (c >= 'a');
+         upper |= ((c >= 'A') && (c <= 'F'));
+      }
+
+      if ((lower == true) && (upper == true))
+         return -1;
+
+      return (upper == true) ? HEX_UPPER : HEX_LOWER;
+   }
+
+
+   // Base64 blobs mix upper case, lower case and digits (unlike words or paths)
+   private static boolean isBase64Candidate(byte[] src, int start, int end)
+   {
+      boolean lower = false;
+      boolean upper = false;
+      boolean digit = false;
+      int n = 0;
+
+      for (int i=start; i<end; i++)
+      {
+         final int c = src[i];
+
+         if (BASE64_VALUES[c&0xFF] < 0)
+            continue;
+
+         lower |= ((c >= 'a') && (c <= 'z'));
+         upper |= ((c >= 'A') && (c <= 'Z'));
+         digit |= ((c >= '0') && (c <= '9'));
+         n++;
+      }
+
+      return (n >= MIN_BASE64_LENGTH) && (lower == true) && (upper == true) && (digit == true);
+   }
+
+
+   private static int skipPadding(byte[] src, int idx, int end)
+   {
+      for (int n=0; (n<2) && (idx<end) && (src[idx] == '='); n++)
+         idx++;
+
+      return idx;
+   }
+
+
+   private static int getEOLLength(byte[] src, int idx, int end)
+   {
+      if ((idx < end) && (src[idx] == '\n'))
+         return 1;
+
+      if ((idx+1 < end) && (src[idx] == '\r') && (src[idx+1] == '\n'))
+         return 2;
+
+      return 0;
+   }
+
+
+   // Return the number of decoded bytes
+   private static int decodeHex(byte[] src, int start, int end, byte[] dst, int dstIdx)
+   {
+      final int dstStart = dstIdx;
+
+      for (int i=start; i<end; i+=2)
+         dst[dstIdx++] = (byte) ((HEX_VALUES[src[i]&0xFF]<<4) | HEX_VALUES[src[i+1]&0xFF]);
+
+      return dstIdx - dstStart;
+   }
+
+
+   // Return the number of decoded bytes (line ends and padding are skipped,
+   // the trailing bits are dropped)
+   private static int decodeBase64(byte[] src, int start, int end, byte[] dst, int dstIdx)
+   {
+      final int dstStart = dstIdx;
+      int acc = 0;
+      int bits = 0;
+
+      for (int i=start; i<end; i++)
+      {
+         final int v = BASE64_VALUES[src[i]&0xFF];
+
+         if (v < 0)
+            continue;
+
+         acc = (acc<<6) | v;
+         bits += 6;
+
+         if (bits >= 8)
+         {
+            bits -= 8;
+            dst[dstIdx++] = (byte) (acc>>bits);
+         }
+      }
+
+      return dstIdx - dstStart;
+   }
+
+
+   // Encode 'len' bytes of binary data as text. Return the index in 'dst'
+   // after the encoded text or -1 if 'dst' is too small.
+   private static int encode(byte[] src, int srcIdx, int len, int flags, int wrap,
+      byte[] dst, int dstIdx, int dstEnd)
+   {
+      final int kind = flags & KIND_MASK;
+
+      if (kind != BASE64)
+      {
+         if (2L*len > dstEnd-dstIdx)
+            return -1;
+
+         final byte[] digits = (kind == HEX_UPPER) ? HEX_UPPER_DIGITS : HEX_LOWER_DIGITS;
+
+         for (int i=0; i<len; i++)
+         {
+            final int b = src[srcIdx+i] & 0xFF;
+            dst[dstIdx++] = digits[b>>4];
+            dst[dstIdx++] = digits[b&15];
+         }
+
+         return dstIdx;
+      }
+
+      final long nbDigits = (4L*len+2) / 3;
+      final long nbChars = ((flags & PADDED_FLAG) != 0) ? 4L*((len+2L)/3) : nbDigits;
+      final int eolLen = ((flags & CRLF_FLAG) != 0) ? 2 : 1;
+      final long nbLines = (wrap == 0) ? 1 : (nbChars+wrap-1) / wrap;
+
+      if (nbChars + (nbLines-1)*eolLen > dstEnd-dstIdx)
+         return -1;
+
+      int col = 0;
+
+      for (long k=0; k<nbChars; k++)
+      {
+         if (col == wrap)
+         {
+            if (eolLen == 2)
+               dst[dstIdx++] = '\r';
+
+            dst[dstIdx++] = '\n';
+            col = 0;
+         }
+
+         if (k < nbDigits)
+         {
+            // 6 bits starting at bit 6*k
+            final int bitPos = (int) (6*k);
+            final int b = srcIdx + (bitPos>>3);
+            final int v = ((src[b]&0xFF)<<8) | ((b+1 < srcIdx+len) ? src[b+1]&0xFF : 0);
+            dst[dstIdx++] = BASE64_DIGITS[(v>>(10-(bitPos&7))) & 0x3F];
+         }
+         else
+         {
+            dst[dstIdx++] = '=';
+         }
+
+         col++;
+      }
+
+      return dstIdx;
+   }
+
+
+   private static int writeInt(byte[] dst, int idx, int val)
+   {
+      dst[idx] = (byte) (val>>24);
+      dst[idx+1] = (byte) (val>>16);
+      dst[idx+2] = (byte) (val>>8);
+      dst[idx+3] = (byte) val;
+      return idx + 4;
+   }
+
+
+   private static int readInt(byte[] src, int idx)
+   {
+      return ((src[idx]&0xFF)<<24) | ((src[idx+1]&0xFF)<<16) |
+         ((src[idx+2]&0xFF)<<8) | (src[idx+3]&0xFF);
+   }
+
+
+   private static int writeVarInt(byte[] dst, int idx, int val)
+   {
+      while (val >= 0x80)
+      {
+         dst[idx++] = (byte) (0x80|(val&0x7F));
+         val >>>= 7;
+      }
+
+      dst[idx++] = (byte) val;
+      return idx;
+   }
+
+
+   // Return the index after the varint (value in 'val[0]') or -1 if invalid
+   private static int readVarInt(byte[] src, int idx, int end, int[] val)
+   {
+      int res = 0;
+      int shift = 0;
+
+      while (true)
+      {
+         if ((idx >= end) || (shift > 28))
+            return -1;
+
+         final int b = src[idx++] & 0xFF;
+         res |= ((b&0x7F) << shift);
+
+         if (b < 0x80)
+            break;
+
+         shift += 7;
+      }
+
+      if (res < 0)
+         return -1;
+
+      val[0] = res;
+      return idx;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen;
+   }
+}
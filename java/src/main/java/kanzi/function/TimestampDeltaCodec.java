@@ -0,0 +1,308 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Delta coding of a timestamp field (unsigned, big endian, 1 to 8 bytes)
+// stored at a fixed position in fixed size records. The field is removed
+// from the records and replaced by the difference with the field of the
+// previous record (modulo 2^(8*width)), zigzag encoded to support out of
+// order timestamps (negative deltas), then stored as a varint.
+// The transform fails if the output is not smaller than the input.
+// Format: stride (2 bytes), offset (2 bytes), width (1 byte), number of
+//         records n (4 bytes), n deltas (varints), records without the
+//         field, remaining bytes (block size % stride)
+public class TimestampDeltaCodec implements ByteFunction
+{
+   private static final int MAX_STRIDE = 65535;
+   private static final int MIN_RECORDS = 8;
+   private static final int HEADER_SIZE = 9;
+
+   private final int stride;
+   private final int offset;
+   private final int width;
+
+
+   public TimestampDeltaCodec()
+   {
+      this(16, 0, 8);
+   }
+
+
+   public TimestampDeltaCodec(int stride, int offset, int width)
+   {
+      if ((stride < 1) || (stride > MAX_STRIDE))
+         throw new IllegalArgumentException("Timestamp delta codec: Invalid stride: "+stride+" (must be in [1.."+MAX_STRIDE+"])");
+
+      if ((width < 1) || (width > 8))
+         throw new IllegalArgumentException("Timestamp delta codec: Invalid field width: "+width+" (must be in [1..8])");
+
+      if ((offset < 0) || (offset+width > stride))
+         throw new IllegalArgumentException("Timestamp delta codec: The field must fit in the record");
+
+      this.stride = stride;
+      this.offset = offset;
+      this.width = width;
+   }
+
+
+   public TimestampDeltaCodec(Map<String, Object> ctx)
+   {
+      this((Integer) ctx.getOrDefault("timestampStride", 16),
+         (Integer) ctx.getOrDefault("timestampOffset", 0),
+         (Integer) ctx.getOrDefault("timestampWidth", 8));
+   }
+
+
+   private static long readField(byte[] buf, int idx, int width)
+   {
+      long v = 0;
+
+      for (int i=0; i<width; i++)
+         v = (v<<8) | (buf[idx+i]&0xFF);
+
+      return v;
+   }
+
+
+   private static void writeField(byte[] buf, int idx, int width, long v)
+   {
+      for (int i=width-1; i>=0; i--)
+      {
+         buf[idx+i] = (byte) v;
+         v >>>= 8;
+      }
+   }
+
+
+   // Return the zigzag encoded difference (sign extended to the field width)
+   private static long encodeDelta(long v, long prev, int width)
+   {
+      final int shift = 64 - 8*width;
+      final long delta = ((v-prev) << shift) >> shift;
+      return (delta<<1) ^ (delta>>63);
+   }
+
+
+   private static int getVarLongSize(long v)
+   {
+      int res = 1;
+
+      while ((v & ~0x7FL) != 0)
+      {
+         v >>>= 7;
+         res++;
+      }
+
+      return res;
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+      final int n = count / this.stride;
+
+      if (n < MIN_RECORDS)
+         return false;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int w = this.width;
+
+      // First pass: compute the encoded size
+      long size = HEADER_SIZE + ((long) n)*(this.stride-w) + (count-n*this.stride);
+      long prev = 0;
+
+      for (int i=0; i<n; i++)
+      {
+         final long v = readField(src, srcIdx+i*this.stride+this.offset, w);
+         size += getVarLongSize(encodeDelta(v, prev, w));
+         prev = v;
+      }
+
+      if (size >= count)
+         return false;
+
+      final int dstStart = output.index;
+      int dstIdx = dstStart;
+
+      // Write header
+      dst[dstIdx++] = (byte) (this.stride>>8);
+      dst[dstIdx++] = (byte) this.stride;
+      dst[dstIdx++] = (byte) (this.offset>>8);
+      dst[dstIdx++] = (byte) this.offset;
+      dst[dstIdx++] = (byte) w;
+      dst[dstIdx++] = (byte) (n>>24);
+      dst[dstIdx++] = (byte) (n>>16);
+      dst[dstIdx++] = (byte) (n>>8);
+      dst[dstIdx++] = (byte) n;
+
+      // Deltas
+      prev = 0;
+
+      for (int i=0; i<n; i++)
+      {
+         final long v = readField(src, srcIdx+i*this.stride+this.offset, w);
+         long delta = encodeDelta(v, prev, w);
+
+         while ((delta & ~0x7FL) != 0)
+         {
+            dst[dstIdx++] = (byte) (0x80|(delta&0x7F));
+            delta >>>= 7;
+         }
+
+         dst[dstIdx++] = (byte) delta;
+         prev = v;
+      }
+
+      // Records without the timestamp field
+      final int after = this.stride - this.offset - w;
+
+      for (int i=0; i<n; i++)
+      {
+         final int idx = srcIdx + i*this.stride;
+         System.arraycopy(src, idx, dst, dstIdx, this.offset);
+         dstIdx += this.offset;
+         System.arraycopy(src, idx+this.offset+w, dst, dstIdx, after);
+         dstIdx += after;
+      }
+
+      // Remaining bytes
+      for (int i=n*this.stride; i<count; i++)
+         dst[dstIdx++] = src[srcIdx+i];
+
+      input.index += count;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < HEADER_SIZE)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcEnd = input.index + input.length;
+      int srcIdx = input.index;
+      final int stride = ((src[srcIdx]&0xFF)<<8) | (src[srcIdx+1]&0xFF);
+      final int offset = ((src[srcIdx+2]&0xFF)<<8) | (src[srcIdx+3]&0xFF);
+      final int w = src[srcIdx+4] & 0xFF;
+      srcIdx += 5;
+
+      if ((w < 1) || (w > 8) || (offset+w > stride))
+         return false;
+
+      final int n = ((src[srcIdx]&0xFF)<<24) | ((src[srcIdx+1]&0xFF)<<16) |
+         ((src[srcIdx+2]&0xFF)<<8) | (src[srcIdx+3]&0xFF);
+      srcIdx += 4;
+
+      if ((n < 0) || (n > srcEnd-srcIdx) || ((long) n*stride > dst.length-output.index))
+         return false;
+
+      // Skip deltas to find the records
+      int recIdx = srcIdx;
+
+      for (int i=0; i<n; i++)
+      {
+         while ((recIdx < srcEnd) && ((src[recIdx]&0x80) != 0))
+            recIdx++;
+
+         recIdx++;
+      }
+
+      if ((long) recIdx + (long) n*(stride-w) > srcEnd)
+         return false;
+
+      final int dstStart = output.index;
+      final int after = stride - offset - w;
+      long prev = 0;
+
+      for (int i=0; i<n; i++)
+      {
+         // Read delta
+         long delta = 0;
+         int shift = 0;
+
+         while (true)
+         {
+            final int b = src[srcIdx++] & 0xFF;
+            delta |= ((long) (b&0x7F) << shift);
+
+            if (b < 0x80)
+               break;
+
+            shift += 7;
+
+            if (shift > 63)
+               return false;
+         }
+
+         final long v = prev + ((delta>>>1) ^ -(delta&1));
+         final int idx = dstStart + i*stride;
+         System.arraycopy(src, recIdx, dst, idx, offset);
+         recIdx += offset;
+         writeField(dst, idx+offset, w, v);
+         System.arraycopy(src, recIdx, dst, idx+offset+w, after);
+         recIdx += after;
+         prev = v;
+      }
+
+      // Remaining bytes
+      int dstIdx = dstStart + n*stride;
+      final int remaining = srcEnd - recIdx;
+
+      if ((remaining >= stride) || (dstIdx+remaining > dst.length))
+         return false;
+
+      System.arraycopy(src, recIdx, dst, dstIdx, remaining);
+      dstIdx += remaining;
+      input.index = srcEnd;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen + HEADER_SIZE;
+   }
+}
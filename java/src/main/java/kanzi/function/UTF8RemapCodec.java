@@ -0,0 +1,429 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.util.Arrays;
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Remapping of UTF-8 text to a denser code: the block is decoded to code
+// points and the most frequent non ASCII code points are replaced by 1 byte
+// (64 most frequent) or 2 byte aliases. ASCII bytes are unchanged. Rare code
+// points are escaped and the bytes that are not part of a valid (shortest
+// form) UTF-8 sequence are passed through, so the inverse rebuilds the exact
+// original bytes.
+// The transform fails if there is no multi byte character or if the output is
+// not smaller than the input.
+// Format: number of aliases n (2 bytes), aliased code points (3 bytes each,
+//         most frequent first), symbols:
+//         0x00-0x7F: ASCII byte
+//         0x80-0xBF: alias 0 to 63
+//         0xC0-0xFD + 1 byte: alias 64 and above
+//         0xFE + 3 bytes: other code point
+//         0xFF + 1 byte: byte outside of a valid UTF-8 sequence
+public class UTF8RemapCodec implements ByteFunction
+{
+   private static final int ESCAPE_CODE_POINT = 0xFE;
+   private static final int ESCAPE_BYTE = 0xFF;
+   private static final int SHORT_ALIASES = 64;
+   private static final int MAX_ALIASES = SHORT_ALIASES + ((ESCAPE_CODE_POINT-0xC0) << 8);
+   private static final int MIN_FREQ = 2;
+   private static final int MAX_CODE_POINT = 0x10FFFF;
+   private static final long CODE_POINT_MASK = (1L<<21) - 1;
+
+
+   public UTF8RemapCodec()
+   {
+   }
+
+
+   public UTF8RemapCodec(Map<String, Object> ctx)
+   {
+      this();
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final int srcIdx = input.index;
+      final int srcEnd = srcIdx + count;
+
+      // Symbols: ASCII byte, code point (>= 0x80) or -byte (invalid sequence)
+      final int[] syms = new int[count];
+      int[] mb = new int[count/2];
+      int nbSyms = 0;
+      int nbMb = 0;
+
+      for (int i=srcIdx; i<srcEnd; )
+      {
+         final int cp = decodeUTF8(src, i, srcEnd);
+
+         if (cp < 0)
+         {
+            syms[nbSyms++] = -(src[i]&0xFF);
+            i++;
+         }
+         else if (cp < 0x80)
+         {
+            syms[nbSyms++] = cp;
+            i++;
+         }
+         else
+         {
+            syms[nbSyms++] = cp;
+            mb[nbMb++] = cp;
+            i += getUTF8Length(cp);
+         }
+      }
+
+      if (nbMb == 0)
+         return false;
+
+      // Rank the code points by decreasing frequency (then increasing value)
+      mb = Arrays.copyOf(mb, nbMb);
+      Arrays.sort(mb);
+      long[] keys = new long[nbMb];
+      int nbKeys = 0;
+
+      for (int i=0; i<nbMb; )
+      {
+         int j = i + 1;
+
+         while ((j < nbMb) && (mb[j] == mb[i]))
+            j++;
+
+         keys[nbKeys++] = ((long) (Integer.MAX_VALUE-(j-i)) << 21) | mb[i];
+         i = j;
+      }
+
+      keys = Arrays.copyOf(keys, nbKeys);
+      Arrays.sort(keys);
+      int n = 0;
+
+      while ((n < nbKeys) && (n < MAX_ALIASES) && (Integer.MAX_VALUE-(keys[n]>>>21) >= MIN_FREQ))
+         n++;
+
+      // Code point => rank lookup (sorted by code point)
+      final long[] lookup = new long[n];
+
+      for (int r=0; r<n; r++)
+         lookup[r] = ((keys[r] & CODE_POINT_MASK) << 14) | r;
+
+      Arrays.sort(lookup);
+      final int[] lookupCps = new int[n];
+      final int[] ranks = new int[n];
+
+      for (int k=0; k<n; k++)
+      {
+         lookupCps[k] = (int) (lookup[k] >>> 14);
+         ranks[k] = (int) (lookup[k] & 0x3FFF);
+      }
+
+      // Compute the size of the output
+      long size = 2 + 3L*n;
+
+      for (int i=0; i<nbSyms; i++)
+      {
+         final int s = syms[i];
+
+         if (s < 0)
+            size += 2;
+         else if (s < 0x80)
+            size++;
+         else
+         {
+            final int k = Arrays.binarySearch(lookupCps, s);
+            size += (k < 0) ? 4 : ((ranks[k] < SHORT_ALIASES) ? 1 : 2);
+         }
+      }
+
+      if (size >= count)
+         return false;
+
+      final byte[] dst = output.array;
+      int dstIdx = output.index;
+      dst[dstIdx++] = (byte) (n>>8);
+      dst[dstIdx++] = (byte) n;
+
+      for (int r=0; r<n; r++)
+      {
+         final int cp = (int) (keys[r] & CODE_POINT_MASK);
+         dst[dstIdx++] = (byte) (cp>>16);
+         dst[dstIdx++] = (byte) (cp>>8);
+         dst[dstIdx++] = (byte) cp;
+      }
+
+      for (int i=0; i<nbSyms; i++)
+      {
+         final int s = syms[i];
+
+         if (s < 0)
+         {
+            dst[dstIdx++] = (byte) ESCAPE_BYTE;
+            dst[dstIdx++] = (byte) -s;
+         }
+         else if (s < 0x80)
+         {
+            dst[dstIdx++] = (byte) s;
+         }
+         else
+         {
+            final int k = Arrays.binarySearch(lookupCps, s);
+
+            if (k < 0)
+            {
+               dst[dstIdx++] = (byte) ESCAPE_CODE_POINT;
+               dst[dstIdx++] = (byte) (s>>16);
+               dst[dstIdx++] = (byte) (s>>8);
+               dst[dstIdx++] = (byte) s;
+            }
+            else if (ranks[k] < SHORT_ALIASES)
+            {
+               dst[dstIdx++] = (byte) (0x80+ranks[k]);
+            }
+            else
+            {
+               final int r = ranks[k] - SHORT_ALIASES;
+               dst[dstIdx++] = (byte) (0xC0+(r>>8));
+               dst[dstIdx++] = (byte) r;
+            }
+         }
+      }
+
+      input.index += count;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      if (input.length < 2)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcEnd = input.index + input.length;
+      int srcIdx = input.index;
+      final int n = ((src[srcIdx]&0xFF)<<8) | (src[srcIdx+1]&0xFF);
+      srcIdx += 2;
+
+      if ((n > MAX_ALIASES) || (3*n > srcEnd-srcIdx))
+         return false;
+
+      final int[] aliases = new int[n];
+
+      for (int r=0; r<n; r++)
+      {
+         final int cp = ((src[srcIdx]&0xFF)<<16) | ((src[srcIdx+1]&0xFF)<<8) | (src[srcIdx+2]&0xFF);
+         srcIdx += 3;
+
+         if (isValidCodePoint(cp) == false)
+            return false;
+
+         aliases[r] = cp;
+      }
+
+      final int dstEnd = dst.length;
+      int dstIdx = output.index;
+
+      while (srcIdx < srcEnd)
+      {
+         final int b = src[srcIdx++] & 0xFF;
+
+         if (b < 0x80)
+         {
+            if (dstIdx >= dstEnd)
+               return false;
+
+            dst[dstIdx++] = (byte) b;
+            continue;
+         }
+
+         int cp;
+
+         if (b < 0xC0)
+         {
+            if (b-0x80 >= n)
+               return false;
+
+            cp = aliases[b-0x80];
+         }
+         else if (b < ESCAPE_CODE_POINT)
+         {
+            if (srcIdx >= srcEnd)
+               return false;
+
+            final int r = SHORT_ALIASES + (((b-0xC0)<<8) | (src[srcIdx++]&0xFF));
+
+            if (r >= n)
+               return false;
+
+            cp = aliases[r];
+         }
+         else if (b == ESCAPE_CODE_POINT)
+         {
+            if (srcIdx+3 > srcEnd)
+               return false;
+
+            cp = ((src[srcIdx]&0xFF)<<16) | ((src[srcIdx+1]&0xFF)<<8) | (src[srcIdx+2]&0xFF);
+            srcIdx += 3;
+
+            if (isValidCodePoint(cp) == false)
+               return false;
+         }
+         else
+         {
+            // Byte outside of a valid sequence
+            if ((srcIdx >= srcEnd) || (dstIdx >= dstEnd) || ((src[srcIdx]&0xFF) < 0x80))
+               return false;
+
+            dst[dstIdx++] = src[srcIdx++];
+            continue;
+         }
+
+         if (dstIdx+getUTF8Length(cp) > dstEnd)
+            return false;
+
+         dstIdx = encodeUTF8(cp, dst, dstIdx);
+      }
+
+      input.index = srcEnd;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   // Non ASCII code point that can be encoded in UTF-8
+   private static boolean isValidCodePoint(int cp)
+   {
+      return (cp >= 0x80) && (cp <= MAX_CODE_POINT) && ((cp < 0xD800) || (cp > 0xDFFF));
+   }
+
+
+   // Return the code point of the shortest form UTF-8 sequence at 'idx' or -1
+   private static int decodeUTF8(byte[] src, int idx, int end)
+   {
+      final int b = src[idx] & 0xFF;
+
+      if (b < 0x80)
+         return b;
+
+      int len;
+      int cp;
+
+      if ((b >= 0xC2) && (b <= 0xDF))
+      {
+         len = 2;
+         cp = b & 0x1F;
+      }
+      else if ((b >= 0xE0) && (b <= 0xEF))
+      {
+         len = 3;
+         cp = b & 0x0F;
+      }
+      else if ((b >= 0xF0) && (b <= 0xF4))
+      {
+         len = 4;
+         cp = b & 0x07;
+      }
+      else
+         return -1;
+
+      if (idx+len > end)
+         return -1;
+
+      for (int i=1; i<len; i++)
+      {
+         final int c = src[idx+i] & 0xFF;
+
+         if ((c & 0xC0) != 0x80)
+            return -1;
+
+         cp = (cp<<6) | (c&0x3F);
+      }
+
+      // Reject overlong forms and surrogates
+      if ((isValidCodePoint(cp) == false) || (getUTF8Length(cp) != len))
+         return -1;
+
+      return cp;
+   }
+
+
+   private static int getUTF8Length(int cp)
+   {
+      if (cp < 0x80)
+         return 1;
+
+      if (cp < 0x800)
+         return 2;
+
+      return (cp < 0x10000) ? 3 : 4;
+   }
+
+
+   private static int encodeUTF8(int cp, byte[] dst, int idx)
+   {
+      if (cp < 0x800)
+      {
+         dst[idx++] = (byte) (0xC0|(cp>>6));
+      }
+      else if (cp < 0x10000)
+      {
+         dst[idx++] = (byte) (0xE0|(cp>>12));
+         dst[idx++] = (byte) (0x80|((cp>>6)&0x3F));
+      }
+      else
+      {
+         dst[idx++] = (byte) (0xF0|(cp>>18));
+         dst[idx++] = (byte) (0x80|((cp>>12)&0x3F));
+         dst[idx++] = (byte) (0x80|((cp>>6)&0x3F));
+      }
+
+      dst[idx++] = (byte) (0x80|(cp&0x3F));
+      return idx;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen;
+   }
+}
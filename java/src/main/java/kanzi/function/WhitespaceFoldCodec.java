@@ -0,0 +1,296 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.function;
+
+import java.nio.charset.StandardCharsets;
+import java.util.ArrayList;
+import java.util.Collections;
+import java.util.Comparator;
+import java.util.HashMap;
+import java.util.List;
+import java.util.Map;
+import kanzi.ByteFunction;
+import kanzi.SliceByteArray;
+
+
+// Folding of whitespace runs (EG. end of line + indentation in source code).
+// The maximal runs of spaces, tabs and end of line characters are counted and
+// the most profitable ones are replaced by a single byte token. The tokens are
+// bytes absent from the block, so no escaping is needed. The exact bytes of
+// each run (mixed tabs and spaces, CR LF) are stored in the table.
+// The transform fails if whitespace is sparse, if there are not enough free
+// byte values or if the output is not smaller than the input.
+// Format: number of tokens m (1 byte), m entries (token, run length, run
+//         bytes), text
+public class WhitespaceFoldCodec implements ByteFunction
+{
+   private static final int MIN_RUN_LENGTH = 2;
+   private static final int MAX_RUN_LENGTH = 255;
+   private static final int MAX_TOKENS = 255;
+   private static final int MIN_WHITESPACE_RATIO = 8; // in percent
+
+
+   public WhitespaceFoldCodec()
+   {
+   }
+
+
+   public WhitespaceFoldCodec(Map<String, Object> ctx)
+   {
+      this();
+   }
+
+
+   private static boolean isWhitespace(byte b)
+   {
+      return (b == ' ') || (b == '\t') || (b == '\n') || (b == '\r');
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (output.length - output.index < this.getMaxEncodedLength(count))
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int srcEnd = srcIdx + count;
+      final boolean[] used = new boolean[256];
+      int whitespace = 0;
+
+      // Count the runs
+      final Map<String, Integer> freqs = new HashMap<>();
+      int i = srcIdx;
+
+      while (i < srcEnd)
+      {
+         used[src[i]&0xFF] = true;
+
+         if (isWhitespace(src[i]) == false)
+         {
+            i++;
+            continue;
+         }
+
+         final int start = i;
+
+         while ((i < srcEnd) && (isWhitespace(src[i]) == true))
+            i++;
+
+         final int len = i - start;
+         whitespace += len;
+
+         if ((len >= MIN_RUN_LENGTH) && (len <= MAX_RUN_LENGTH))
+         {
+            final String run = new String(src, start, len, StandardCharsets.ISO_8859_1);
+            freqs.put(run, freqs.getOrDefault(run, 0) + 1);
+         }
+      }
+
+      if ((long) whitespace*100 < (long) count*MIN_WHITESPACE_RATIO)
+         return false;
+
+      // Free byte values
+      final List<Integer> tokens = new ArrayList<>();
+
+      for (int b=0; b<256; b++)
+      {
+         if (used[b] == false)
+            tokens.add(b);
+      }
+
+      // Keep the most profitable runs (bytes saved minus table entry size)
+      List<String> runs = new ArrayList<>();
+
+      for (Map.Entry<String, Integer> e : freqs.entrySet())
+      {
+         if (getGain(e.getKey(), e.getValue()) > 0)
+            runs.add(e.getKey());
+      }
+
+      Collections.sort(runs, new Comparator<String>()
+      {
+         @Override
+         public int compare(String r1, String r2)
+         {
+            final int res = Long.compare(getGain(r2, freqs.get(r2)), getGain(r1, freqs.get(r1)));
+            return (res != 0) ? res : r1.compareTo(r2);
+         }
+      });
+
+      final int m = Math.min(Math.min(runs.size(), tokens.size()), MAX_TOKENS);
+
+      if (m == 0)
+         return false;
+
+      final int dstStart = output.index;
+      final int limit = dstStart + count;
+      final Map<String, Integer> table = new HashMap<>();
+      int dstIdx = dstStart;
+      dst[dstIdx++] = (byte) m;
+
+      for (int n=0; n<m; n++)
+      {
+         final String run = runs.get(n);
+         final int token = tokens.get(n);
+         table.put(run, token);
+
+         if (dstIdx+2+run.length() >= limit)
+            return false;
+
+         dst[dstIdx++] = (byte) token;
+         dst[dstIdx++] = (byte) run.length();
+
+         for (int k=0; k<run.length(); k++)
+            dst[dstIdx++] = (byte) run.charAt(k);
+      }
+
+      // Replace the runs in the table by their token
+      i = srcIdx;
+
+      while (i < srcEnd)
+      {
+         if (isWhitespace(src[i]) == false)
+         {
+            if (dstIdx >= limit)
+               return false;
+
+            dst[dstIdx++] = src[i++];
+            continue;
+         }
+
+         final int start = i;
+
+         while ((i < srcEnd) && (isWhitespace(src[i]) == true))
+            i++;
+
+         final int len = i - start;
+         Integer token = null;
+
+         if ((len >= MIN_RUN_LENGTH) && (len <= MAX_RUN_LENGTH))
+            token = table.get(new String(src, start, len, StandardCharsets.ISO_8859_1));
+
+         if (token != null)
+         {
+            if (dstIdx >= limit)
+               return false;
+
+            dst[dstIdx++] = (byte) token.intValue();
+            continue;
+         }
+
+         if (dstIdx+len >= limit)
+            return false;
+
+         System.arraycopy(src, start, dst, dstIdx, len);
+         dstIdx += len;
+      }
+
+      input.index += count;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   private static long getGain(String run, int freq)
+   {
+      return (long) freq*(run.length()-1) - (run.length()+2);
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcEnd = input.index + input.length;
+      int srcIdx = input.index;
+      final int m = src[srcIdx++] & 0xFF;
+
+      if (m == 0)
+         return false;
+
+      // Read the table
+      final byte[][] runs = new byte[256][];
+
+      for (int n=0; n<m; n++)
+      {
+         if (srcIdx+2 > srcEnd)
+            return false;
+
+         final int token = src[srcIdx++] & 0xFF;
+         final int len = src[srcIdx++] & 0xFF;
+
+         if ((len < MIN_RUN_LENGTH) || (srcIdx+len > srcEnd) || (runs[token] != null))
+            return false;
+
+         runs[token] = new byte[len];
+         System.arraycopy(src, srcIdx, runs[token], 0, len);
+         srcIdx += len;
+      }
+
+      int dstIdx = output.index;
+      final int dstEnd = dst.length;
+
+      while (srcIdx < srcEnd)
+      {
+         final byte[] run = runs[src[srcIdx]&0xFF];
+
+         if (run == null)
+         {
+            if (dstIdx >= dstEnd)
+               return false;
+
+            dst[dstIdx++] = src[srcIdx++];
+            continue;
+         }
+
+         if (dstIdx+run.length > dstEnd)
+            return false;
+
+         System.arraycopy(run, 0, dst, dstIdx, run.length);
+         dstIdx += run.length;
+         srcIdx++;
+      }
+
+      input.index = srcEnd;
+      output.index = dstIdx;
+      return true;
+   }
+
+
+   @Override
+   public int getMaxEncodedLength(int srcLen)
+   {
+      return srcLen;
+   }
+}
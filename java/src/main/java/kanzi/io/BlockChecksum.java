@@ -0,0 +1,76 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.io;
+
+import kanzi.util.hash.CRC32C;
+import kanzi.util.hash.XXHash32;
+import kanzi.util.hash.XXHash64;
+
+
+// Block checksum of a compressed stream. Thread safe (shared by the block
+// tasks).
+final class BlockChecksum
+{
+   private final ChecksumKind kind;
+   private final CRC32C crc32c;
+   private final XXHash32 xxhash32;
+   private final XXHash64 xxhash64;
+
+
+   private BlockChecksum(ChecksumKind kind, int seed)
+   {
+      this.kind = kind;
+      this.crc32c = (kind == ChecksumKind.CRC32C) ? new CRC32C() : null;
+      this.xxhash32 = (kind == ChecksumKind.XXHASH32) ? new XXHash32(seed) : null;
+      this.xxhash64 = (kind == ChecksumKind.XXHASH64) ? new XXHash64(seed) : null;
+   }
+
+
+   // Return null if there is no checksum
+   static BlockChecksum create(ChecksumKind kind, int seed)
+   {
+      return (kind == ChecksumKind.NONE) ? null : new BlockChecksum(kind, seed);
+   }
+
+
+   ChecksumKind getKind()
+   {
+      return this.kind;
+   }
+
+
+   // Number of bits written in the block header
+   int getSize()
+   {
+      return this.kind.getSize();
+   }
+
+
+   long hash(byte[] data, int offset, int length)
+   {
+      switch (this.kind)
+      {
+         case CRC32C:
+            return this.crc32c.hash(data, offset, length) & 0xFFFFFFFFL;
+
+         case XXHASH32:
+            return this.xxhash32.hash(data, offset, length) & 0xFFFFFFFFL;
+
+         default:
+            return this.xxhash64.hash(data, offset, length);
+      }
+   }
+}
@@ -0,0 +1,39 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.io;
+
+
+// Error in a given block of a compressed stream
+public class BlockException extends IOException
+{
+   private static final long serialVersionUID = 4702165519234516722L;
+
+   private final int blockId;
+
+
+   // Block ids start at 1 (same as in block events)
+   public BlockException(String msg, int code, int blockId)
+   {
+      super("Block "+blockId+": "+msg, code);
+      this.blockId = blockId;
+   }
+
+
+   public int getBlockId()
+   {
+      return this.blockId;
+   }
+}
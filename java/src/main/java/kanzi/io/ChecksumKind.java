@@ -0,0 +1,55 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.io;
+
+
+// Algorithm of the block checksums (computed on the original data of each
+// block). The ordinal is stored in the stream header.
+public enum ChecksumKind
+{
+   NONE(0),
+   CRC32C(32),
+   XXHASH32(32),
+   XXHASH64(64);
+
+   private final int size;
+
+
+   ChecksumKind(int size)
+   {
+      this.size = size;
+   }
+
+
+   // Size in bits of the checksum stored in each block header
+   public int getSize()
+   {
+      return this.size;
+   }
+
+
+   // Case insensitive lookup by name
+   public static ChecksumKind getKind(String name)
+   {
+      for (ChecksumKind kind : values())
+      {
+         if (kind.name().equalsIgnoreCase(name))
+            return kind;
+      }
+
+      throw new IllegalArgumentException("Unsupported checksum type: " + name);
+   }
+}
@@ -15,6 +15,7 @@ limitations under the License.
 
 package kanzi.io;
 
+import java.io.BufferedInputStream;
 import java.io.ByteArrayInputStream;
 import kanzi.function.ByteFunctionFactory;
 import kanzi.Error;
@@ -37,9 +38,10 @@ import kanzi.SliceByteArray;
 import kanzi.InputBitStream;
 import kanzi.bitstream.DefaultInputBitStream;
 import kanzi.entropy.EntropyCodecFactory;
+import kanzi.entropy.ParallelEntropyDecoder;
 import kanzi.function.ByteTransformSequence;
-import kanzi.util.hash.XXHash32;
 import kanzi.Listener;
+import kanzi.MetricsSink;
 
 
 // Implementation of a java.io.InputStream that can decode a stream
@@ -47,12 +49,11 @@ import kanzi.Listener;
 public class CompressedInputStream extends InputStream
 {
    private static final int BITSTREAM_TYPE           = 0x4B414E5A; // "KANZ"
-   private static final int BITSTREAM_FORMAT_VERSION = 9;
    private static final int DEFAULT_BUFFER_SIZE      = 256*1024;
+   private static final int MIN_BUFFER_SIZE          = 1024;
    private static final int EXTRA_BUFFER_SIZE        = 256;
    private static final int COPY_BLOCK_MASK          = 0x80;
    private static final int TRANSFORMS_MASK          = 0x10;
-   private static final int MIN_BITSTREAM_BLOCK_SIZE = 1024;
    private static final int MAX_BITSTREAM_BLOCK_SIZE = 1024*1024*1024;
    private static final byte[] EMPTY_BYTE_ARRAY      = new byte[0];
    private static final int CANCEL_TASKS_ID          = -1;
@@ -61,7 +62,7 @@ public class CompressedInputStream extends InputStream
    
    private int blockSize;
    private int nbInputBlocks;
-   private XXHash32 hasher;
+   private BlockChecksum hasher;
    private final SliceByteArray sa; // for all blocks
    private final SliceByteArray[] buffers; // input & output per block
    private int entropyType;
@@ -75,6 +76,15 @@ public class CompressedInputStream extends InputStream
    private final ExecutorService pool;
    private final List<Listener> listeners;
    private final Map<String, Object> ctx;
+   private StreamInfo info;
+   private long maxDecompressedSize; // 0 means no limit
+   private int alignment;
+   private long decompressed;
+   private boolean limitReached;
+   private MetricsSink metrics;
+   private long startTime;
+   private long nbBlocks;
+   private long checksumFailures;
 
    
    public CompressedInputStream(InputStream is, Map<String, Object> ctx)
@@ -95,7 +105,12 @@ public class CompressedInputStream extends InputStream
       if ((tasks > 1) && (threadPool == null))
          throw new IllegalArgumentException("The thread pool cannot be null when the number of jobs is "+tasks);
 
-      this.ibs = new DefaultInputBitStream(is, DEFAULT_BUFFER_SIZE);
+      // Avoid double buffering: if the input stream is already buffered (or
+      // 'bufferInput' is false), the bit stream uses a minimal buffer.
+      Boolean bBuffer = (Boolean) ctx.get("bufferInput");
+      final boolean buffered = (bBuffer != null) ? bBuffer == false :
+         (is instanceof BufferedInputStream) || (is instanceof ByteArrayInputStream);
+      this.ibs = new DefaultInputBitStream(is, (buffered == true) ? MIN_BUFFER_SIZE : DEFAULT_BUFFER_SIZE);
       this.sa = new SliceByteArray();
       this.jobs = tasks;
       this.pool = threadPool;
@@ -117,57 +132,58 @@ public class CompressedInputStream extends InputStream
 
    protected void readHeader() throws IOException
    {
-      // Read stream type
-      final int type = (int) this.ibs.readBits(32);
+      CompressedStreams.Header header = CompressedStreams.Header.read(this.ibs);
 
-      // Sanity check
-      if (type != BITSTREAM_TYPE)
-         throw new kanzi.io.IOException("Invalid stream type", Error.ERR_INVALID_FILE);
-
-      // Read stream version
-      final int version = (int) this.ibs.readBits(5);
-
-      // Sanity check
-      if (version != BITSTREAM_FORMAT_VERSION)
-         throw new kanzi.io.IOException("Invalid bitstream, cannot read this version of the stream: " + version,
-                 Error.ERR_STREAM_VERSION);
-
-      // Read block checksum
-      if (this.ibs.readBit() == 1)
-         this.hasher = new XXHash32(BITSTREAM_TYPE);
+      // Read block checksum type
+      this.hasher = BlockChecksum.create(header.checksumKind, BITSTREAM_TYPE);
 
       // Read entropy codec
-      this.entropyType = (int) this.ibs.readBits(5);
+      this.entropyType = header.entropyType;
       this.ctx.put("codec", EntropyCodecFactory.getName(this.entropyType));      
       this.ctx.put("extra", this.entropyType == EntropyCodecFactory.TPAQX_TYPE);      
 
       // Read transforms: 8*6 bits
-      this.transformType = this.ibs.readBits(48);
+      this.transformType = header.transformType;
       this.ctx.put("transform", new ByteFunctionFactory().getName(this.transformType));      
 
       // Read block size
-      this.blockSize = (int) this.ibs.readBits(28) << 4;
+      this.blockSize = header.blockSize;
       this.ctx.put("blockSize", this.blockSize);
 
-      if ((this.blockSize < MIN_BITSTREAM_BLOCK_SIZE) || (this.blockSize > MAX_BITSTREAM_BLOCK_SIZE))
-         throw new kanzi.io.IOException("Invalid bitstream, incorrect block size: " + this.blockSize,
-                 Error.ERR_BLOCK_SIZE);
+      // Entropy coded data split into independent sub-streams
+      this.ctx.put("entropySplit", header.splitEntropy);
+
+      // Parameters of the inverse transforms: the values in the stream header
+      // override the context (decoding requires no configuration)
+      this.ctx.putAll(header.transformParams);
 
       if (((long) this.blockSize) * ((long) this.jobs) >= (long) Integer.MAX_VALUE)
          this.jobs = Integer.MAX_VALUE / this.blockSize;
 
       // Read number of blocks in input. 0 means 'unknown' and 63 means 63 or more.
-      this.nbInputBlocks = (int) this.ibs.readBits(6);
-      
-      // Read reserved bits
-      this.ibs.readBits(3);   
+      this.nbInputBlocks = header.nbInputBlocks;
+      final int alignment = header.alignment;
+      this.alignment = alignment;
+      this.info = header.toStreamInfo();
 
       if (this.listeners.size() > 0)
       {
          StringBuilder sb = new StringBuilder(200);
-         sb.append("Checksum set to ").append(this.hasher != null).append("\n");
+         sb.append("Checksum set to ").append(header.checksumKind).append("\n");
          sb.append("Block size set to ").append(this.blockSize).append(" bytes").append("\n");
 
+         if (alignment > 1)
+            sb.append("Block alignment set to ").append(alignment).append(" bytes").append("\n");
+
+         if (header.splitEntropy == true)
+            sb.append("Entropy coding split into sub-streams").append("\n");
+
+         if (header.level >= 0)
+            sb.append("Compression level set to ").append(header.level).append("\n");
+
+         for (Map.Entry<String, String> entry : this.info.getExtraParams().entrySet())
+            sb.append("Parameter ").append(entry.getKey()).append(" set to ").append(entry.getValue()).append("\n");
+
          try
          {
             String w1 = EntropyCodecFactory.getName(this.entropyType);
@@ -206,6 +222,46 @@ public class CompressedInputStream extends InputStream
    }
 
 
+   // Return the description of the stream (read from the stream header)
+   public StreamInfo getStreamInfo() throws IOException
+   {
+      if (this.initialized.getAndSet(true) == false)
+      {
+         try
+         {
+            this.readHeader();
+         }
+         catch (BitStreamException e)
+         {
+            throw new kanzi.io.IOException(e.getMessage(), Error.ERR_READ_FILE);
+         }
+      }
+
+      return this.info;
+   }
+
+
+   // Limit the size of the decompressed data (protection against decompression
+   // bombs). 0 means no limit. Once the limit is reached, reads fail with an
+   // IOException (error code Error.ERR_OUTPUT_LIMIT). The block buffers are
+   // sized after the limit, not after the block size declared in the stream
+   // header, so a block that decodes to more than the remaining limit fails
+   // (the data of the previous blocks is delivered).
+   public void setMaxDecompressedSize(long maxSize)
+   {
+      if (maxSize < 0)
+         throw new IllegalArgumentException("Invalid negative decompressed size limit: "+maxSize);
+
+      this.maxDecompressedSize = maxSize;
+   }
+
+
+   public long getMaxDecompressedSize()
+   {
+      return this.maxDecompressedSize;
+   }
+
+
    public boolean addListener(Listener bl)
    {
       return (bl != null) ? this.listeners.add(bl) : false;
@@ -218,6 +274,20 @@ public class CompressedInputStream extends InputStream
    }
 
 
+   // Report the stream metrics (see MetricsSink) to the sink when the stream
+   // is closed. A null sink disables the reporting.
+   public void setMetricsSink(MetricsSink sink)
+   {
+      this.metrics = sink;
+   }
+
+
+   public MetricsSink getMetricsSink()
+   {
+      return this.metrics;
+   }
+
+
    /**
     * Reads the next byte of data from the input stream. The value byte is
     * returned as an <code>int</code> in the range <code>0</code> to
@@ -327,6 +397,10 @@ public class CompressedInputStream extends InputStream
                break;
          }
 
+         // Return the data decoded up to the limit, the next call fails
+         if ((this.limitReached == true) && (remaining < len))
+            break;
+
          // Buffer empty, time to decode
          int c2 = this.read();
 
@@ -347,11 +421,25 @@ public class CompressedInputStream extends InputStream
       if (this.initialized.getAndSet(true)== false)
          this.readHeader();
 
+      if (this.startTime == 0)
+         this.startTime = System.nanoTime();
+
+      // The limit was reached by a previous block
+      if (this.limitReached == true)
+         throw new kanzi.io.IOException("Decompressed data larger than the limit ("+
+            this.maxDecompressedSize+" bytes)", Error.ERR_OUTPUT_LIMIT);
+
+      final long maxOutput = (this.maxDecompressedSize == 0) ? Long.MAX_VALUE :
+         this.maxDecompressedSize - this.decompressed;
+
       try
       {
          // Add a padding area to manage any block with header or temporarily expanded
          final int blkSize = Math.max(this.blockSize+EXTRA_BUFFER_SIZE, this.blockSize+(this.blockSize>>4));
 
+         // Do not trust the block size in the header to allocate more than the limit
+         final int bufSize = getBufferSize(blkSize, maxOutput);
+
          // Protect against future concurrent modification of the list of block listeners
          Listener[] blockListeners = this.listeners.toArray(new Listener[this.listeners.size()]);
          int decoded = 0;
@@ -389,13 +477,13 @@ public class CompressedInputStream extends InputStream
                this.buffers[2*jobId].index = 0;
                this.buffers[2*jobId+1].index = 0;
 
-               if (this.buffers[2*jobId].array.length < blkSize+1024)
+               if (this.buffers[2*jobId].array.length < bufSize+1024)
                {
                   // Lazy instantiation of input buffers this.buffers[2*jobId]
                   // Output buffers this.buffers[2*jobId+1] are lazily instantiated
                   // by the decoding tasks.
-                  this.buffers[2*jobId].array = new byte[blkSize+1024];    
-                  this.buffers[2*jobId].length = blkSize+1024;
+                  this.buffers[2*jobId].array = new byte[bufSize+1024];    
+                  this.buffers[2*jobId].length = bufSize+1024;
                }
 
                Map<String, Object> map = new HashMap<>(this.ctx);
@@ -404,7 +492,7 @@ public class CompressedInputStream extends InputStream
                        this.buffers[2*jobId+1], blkSize, this.transformType,
                        this.entropyType, firstBlockId+jobId+1,
                        this.ibs, this.hasher, this.blockId,
-                       blockListeners, map);
+                       blockListeners, maxOutput, this.alignment, map);
                tasks.add(task);            
             }
 
@@ -421,6 +509,7 @@ public class CompressedInputStream extends InputStream
                   skipped++;
 
                decoded += status.decoded;
+               this.updateMetrics(status);
 
                if (status.error != 0)
                   throw new kanzi.io.IOException(status.msg, status.error);
@@ -437,6 +526,7 @@ public class CompressedInputStream extends InputStream
                      skipped++;
 
                   decoded += status.decoded;
+                  this.updateMetrics(status);
 
                   if (status.error != 0)
                      throw new kanzi.io.IOException(status.msg, status.error);
@@ -448,6 +538,18 @@ public class CompressedInputStream extends InputStream
             if (size > nbJobs*this.blockSize)
                throw new kanzi.io.IOException("Invalid data", Error.ERR_PROCESS_BLOCK);
 
+            // Deliver the data up to the limit, the next read fails
+            if (decoded > maxOutput)
+            {
+               if (maxOutput == 0)
+                  throw new kanzi.io.IOException("Decompressed data larger than the limit ("+
+                     this.maxDecompressedSize+" bytes)", Error.ERR_OUTPUT_LIMIT);
+
+               truncate(results, (int) maxOutput);
+               decoded = (int) maxOutput;
+               this.limitReached = true;
+            }
+
             this.sa.length = size;
 
             if (this.sa.array.length < this.sa.length)
@@ -462,7 +564,7 @@ public class CompressedInputStream extends InputStream
                {
                   // Notify after transform ... in block order !
                   Event evt = new Event(Event.Type.AFTER_TRANSFORM, res.blockId,
-                          res.decoded, res.checksum, this.hasher != null, res.completionTime);
+                          res.decoded, (int) res.checksum, this.hasher != null, res.completionTime);
 
                   notifyListeners(blockListeners, evt);
                }
@@ -474,6 +576,7 @@ public class CompressedInputStream extends InputStream
          }
          
          this.sa.index = 0;
+         this.decompressed += decoded;
          return decoded;
       }
       catch (kanzi.io.IOException e)
@@ -489,6 +592,43 @@ public class CompressedInputStream extends InputStream
    }
 
 
+   private void updateMetrics(Status status)
+   {
+      if (status.error == Error.ERR_CRC_CHECK)
+         this.checksumFailures++;
+
+      if ((status.decoded > 0) && (status.skipped == false))
+         this.nbBlocks++;
+   }
+
+
+   // Return the size of the buffers of a block when at most 'maxOutput' bytes
+   // may be decoded (the block size when the limit is larger)
+   private static int getBufferSize(int blkSize, long maxOutput)
+   {
+      if (maxOutput >= blkSize)
+         return blkSize;
+
+      return (int) Math.min(blkSize, maxOutput+Math.max(EXTRA_BUFFER_SIZE, maxOutput>>4));
+   }
+
+
+   // Drop the decoded data beyond 'maxSize' bytes
+   private static void truncate(List<Status> results, int maxSize)
+   {
+      for (int i=0; i<results.size(); i++)
+      {
+         Status res = results.get(i);
+         final int decoded = Math.min(res.decoded, maxSize);
+         maxSize -= decoded;
+
+         if (decoded != res.decoded)
+            results.set(i, new Status(new SliceByteArray(res.data, 0), res.blockId,
+               decoded, res.checksum, 0, null, res.skipped));
+      }
+   }
+
+
    /**
     * Closes this input stream and releases any system resources associated
     * with the stream.
@@ -501,6 +641,22 @@ public class CompressedInputStream extends InputStream
       if (this.closed.getAndSet(true)== true)
          return;
 
+      if (this.metrics != null)
+      {
+         final long elapsed = (this.nbBlocks == 0) ? 0 : System.nanoTime() - this.startTime;
+         CompressedStreams.reportMetrics(this.metrics, false, this.nbBlocks, this.getRead(),
+            this.decompressed, elapsed, this.entropyType, this.transformType);
+
+         try
+         {
+            this.metrics.observe(MetricsSink.DECOMPRESS_CHECKSUM_FAILURES, this.checksumFailures);
+         }
+         catch (Exception e)
+         {
+            // Ignore exceptions in the metrics sink
+         }
+      }
+
       try
       {
          this.ibs.close();
@@ -557,17 +713,19 @@ public class CompressedInputStream extends InputStream
       private final int entropyType;
       private final int blockId;
       private final InputBitStream ibs;
-      private final XXHash32 hasher;
+      private final BlockChecksum hasher;
       private final AtomicInteger processedBlockId;
       private final Listener[] listeners;
+      private final long maxOutput;
+      private final int alignment;
       private final Map<String, Object> ctx;
 
 
       DecodingTask(SliceByteArray iBuffer, SliceByteArray oBuffer, int blockSize,
               long transformType, int entropyType, int blockId,
-              InputBitStream ibs, XXHash32 hasher,
+              InputBitStream ibs, BlockChecksum hasher,
               AtomicInteger processedBlockId, Listener[] listeners,
-              Map<String, Object> ctx)
+              long maxOutput, int alignment, Map<String, Object> ctx)
       {
          this.data = iBuffer;
          this.buffer = oBuffer;
@@ -579,6 +737,8 @@ public class CompressedInputStream extends InputStream
          this.hasher = hasher;
          this.processedBlockId = processedBlockId;
          this.listeners = listeners;
+         this.maxOutput = maxOutput;
+         this.alignment = alignment;
          this.ctx = ctx;
       }
 
@@ -638,9 +798,23 @@ public class CompressedInputStream extends InputStream
          }
 
          final int r = (int) ((read + 7) >> 3);
+         final int maxSize = getBufferSize(this.blockSize, this.maxOutput);
+
+         // With a limit, a compressed block larger than the limit (plus
+         // padding) cannot decode to less than the limit. The block may also
+         // contain alignment padding (less than 'alignment' bytes).
+         if ((maxSize < this.blockSize) && (r > (long) maxSize + this.alignment))
+         {
+            this.processedBlockId.set(CANCEL_TASKS_ID);
+            return new Status(data, currentBlockId, 0, 0, Error.ERR_OUTPUT_LIMIT,
+                 "Decompressed data larger than the limit");
+         }
 
-         if (data.array.length < Math.max(this.blockSize, r))
-            data.array = new byte[Math.max(this.blockSize, r)];
+         if (data.array.length < Math.max(maxSize, r))
+         {
+            data.array = new byte[Math.max(maxSize, r)];
+            data.length = data.array.length;
+         }
 
          for (int n=0; read>0; )
          {            
@@ -663,7 +837,7 @@ public class CompressedInputStream extends InputStream
          
          ByteArrayInputStream bais = new ByteArrayInputStream(data.array, 0, r);
          DefaultInputBitStream is = new DefaultInputBitStream(bais, 16384);
-         int checksum1 = 0;
+         long checksum1 = 0;
          EntropyDecoder ed = null;
 
          try
@@ -705,21 +879,31 @@ public class CompressedInputStream extends InputStream
                     "Invalid compressed block length: " + preTransformLength);
             }
 
+            // Check the output limit before allocating the buffers. The inverse
+            // transforms do not shrink the data (except for a few bytes of
+            // transform header).
+            if (preTransformLength > this.maxOutput + EXTRA_BUFFER_SIZE)
+            {
+               this.processedBlockId.set(CANCEL_TASKS_ID);
+               return new Status(data, currentBlockId, 0, checksum1, Error.ERR_OUTPUT_LIMIT,
+                    "Decompressed data larger than the limit");
+            }
+
             // Extract checksum from bit stream (if any)
             if (this.hasher != null)
-               checksum1 = (int) is.readBits(32);
+               checksum1 = is.readBits(this.hasher.getSize());
 
             if (this.listeners.length > 0)
             {
                // Notify before entropy (block size in bitstream is unknown)
                Event evt = new Event(Event.Type.BEFORE_ENTROPY, currentBlockId,
-                       -1, checksum1, this.hasher != null);
+                       -1, (int) checksum1, this.hasher != null);
 
                notifyListeners(this.listeners, evt);
             }
 
-            final int bufferSize = (this.blockSize >= preTransformLength + EXTRA_BUFFER_SIZE) ?
-               this.blockSize : preTransformLength + EXTRA_BUFFER_SIZE;
+            final int bufferSize = (maxSize >= preTransformLength + EXTRA_BUFFER_SIZE) ?
+               maxSize : preTransformLength + EXTRA_BUFFER_SIZE;
 
             if (buffer.length < bufferSize)
             {
@@ -734,7 +918,12 @@ public class CompressedInputStream extends InputStream
 
             // Each block is decoded separately
             // Rebuild the entropy decoder to reset block statistics
-            ed = new EntropyCodecFactory().newDecoder(is, this.ctx, blockEntropyType);
+            final boolean split = (Boolean) this.ctx.getOrDefault("entropySplit", false);
+
+            if ((split == true) && ((mode & COPY_BLOCK_MASK) == 0))
+               ed = new ParallelEntropyDecoder(is, this.ctx, blockEntropyType);
+            else
+               ed = new EntropyCodecFactory().newDecoder(is, this.ctx, blockEntropyType);
 
             // Block entropy decode
             if (ed.decode(buffer.array, 0, preTransformLength) != preTransformLength)
@@ -749,7 +938,7 @@ public class CompressedInputStream extends InputStream
             {
                // Notify after entropy (block size set to size in bitstream)
                Event evt = new Event(Event.Type.AFTER_ENTROPY, currentBlockId,
-                       (int) (is.read()>>3), checksum1, this.hasher != null);
+                       (int) (is.read()>>3), (int) checksum1, this.hasher != null);
 
                notifyListeners(this.listeners, evt);
             }
@@ -758,7 +947,7 @@ public class CompressedInputStream extends InputStream
             {
                // Notify before transform (block size after entropy decoding)
                Event evt = new Event(Event.Type.BEFORE_TRANSFORM, currentBlockId,
-                       preTransformLength, checksum1, this.hasher != null);
+                       preTransformLength, (int) checksum1, this.hasher != null);
 
                notifyListeners(this.listeners, evt);
             }
@@ -772,20 +961,27 @@ public class CompressedInputStream extends InputStream
             buffer.length = preTransformLength;
 
             if (transform.inverse(buffer, data) == false)
+            {
+               // The output buffer is sized after the limit
+               if (maxSize < this.blockSize)
+                  return new Status(data, currentBlockId, 0, checksum1, Error.ERR_OUTPUT_LIMIT,
+                     "Decompressed data larger than the limit");
+
                return new Status(data, currentBlockId, 0, checksum1, Error.ERR_PROCESS_BLOCK,
                   "Transform inverse failed");
+            }
 
             final int decoded = data.index - savedIdx;
 
             // Verify checksum
             if (this.hasher != null)
             {
-               final int checksum2 = this.hasher.hash(data.array, savedIdx, decoded);
+               final long checksum2 = this.hasher.hash(data.array, savedIdx, decoded);
 
                if (checksum2 != checksum1)
                   return new Status(data, currentBlockId, decoded, checksum1, Error.ERR_CRC_CHECK,
-                          "Corrupted bitstream: expected checksum " + Integer.toHexString(checksum1) +
-                          ", found " + Integer.toHexString(checksum2));
+                          "Corrupted bitstream: expected checksum " + Long.toHexString(checksum1) +
+                          ", found " + Long.toHexString(checksum2));
             }
 
             return new Status(data, currentBlockId, decoded, checksum1, 0, null);
@@ -817,15 +1013,15 @@ public class CompressedInputStream extends InputStream
       final boolean skipped;
       final int error; // 0 = OK
       final String msg;
-      final int checksum;
+      final long checksum;
       final long completionTime;
 
-      Status(SliceByteArray data, int blockId, int decoded, int checksum, int error, String msg)
+      Status(SliceByteArray data, int blockId, int decoded, long checksum, int error, String msg)
       {
          this(data, blockId, decoded, checksum, error, msg, false);
       }
       
-      Status(SliceByteArray data, int blockId, int decoded, int checksum, int error, String msg, boolean skipped)
+      Status(SliceByteArray data, int blockId, int decoded, long checksum, int error, String msg, boolean skipped)
       {
          this.data = data.array;
          this.blockId = blockId;
@@ -19,10 +19,13 @@ import java.io.ByteArrayOutputStream;
 import kanzi.function.ByteFunctionFactory;
 import kanzi.Error;
 import kanzi.Event;
+import kanzi.Global;
 import java.io.IOException;
 import java.io.OutputStream;
 import java.util.ArrayList;
+import java.util.Arrays;
 import java.util.HashMap;
+import java.util.LinkedHashMap;
 import java.util.List;
 import java.util.Map;
 import java.util.concurrent.Callable;
@@ -31,15 +34,19 @@ import java.util.concurrent.Future;
 import java.util.concurrent.atomic.AtomicBoolean;
 import java.util.concurrent.atomic.AtomicInteger;
 import kanzi.BitStreamException;
+import kanzi.BoundaryDetector;
 import kanzi.EntropyEncoder;
 import kanzi.SliceByteArray;
 import kanzi.OutputBitStream;
+import kanzi.ShardFactory;
 import kanzi.bitstream.DefaultOutputBitStream;
 import kanzi.entropy.EntropyCodecFactory;
 import kanzi.function.ByteTransformSequence;
-import kanzi.util.hash.XXHash32;
 import kanzi.Listener;
+import kanzi.MetricsSink;
 import kanzi.entropy.EntropyUtils;
+import kanzi.entropy.ParallelEntropyEncoder;
+import kanzi.util.hash.XXHash32;
 
 
 
@@ -50,25 +57,41 @@ import kanzi.entropy.EntropyUtils;
 public class CompressedOutputStream extends OutputStream
 {
    private static final int BITSTREAM_TYPE           = 0x4B414E5A; // "KANZ"
-   private static final int BITSTREAM_FORMAT_VERSION = 9;
+   private static final int BITSTREAM_FORMAT_VERSION = 11;
+   private static final int MIN_BITSTREAM_FORMAT_VERSION = 9;
    private static final int COPY_BLOCK_MASK          = 0x80;
    private static final int TRANSFORMS_MASK          = 0x10;
    private static final int MIN_BITSTREAM_BLOCK_SIZE = 1024;
    private static final int MAX_BITSTREAM_BLOCK_SIZE = 1024*1024*1024;
+   private static final int MAX_BLOCK_ALIGNMENT      = 1024*1024;
+   private static final int MAX_LEVEL                = 14;
+   private static final int MAX_PARAMS_SIZE          = 65535;
    private static final int DEFAULT_BUFFER_SIZE      = 256*1024;
    private static final int SMALL_BLOCK_SIZE         = 15;
    private static final byte[] EMPTY_BYTE_ARRAY      = new byte[0];
    private static final int MAX_CONCURRENCY          = 64;
    private static final int CANCEL_TASKS_ID          = -1;
 
+   // Highest transform and entropy codec types registered when each format
+   // version was introduced (from MIN_BITSTREAM_FORMAT_VERSION): version 10
+   // only added BRLT. The types registered later are not known by all the
+   // decoders of an older version. No limit for the current version.
+   private static final int[] MAX_TRANSFORM_TYPES = { 14, 15, 63 };
+   private static final int[] MAX_ENTROPY_TYPES = { 9, 9, 31 };
+
    private final int blockSize;
+   private final int alignment;
+   private final int level;
+   private final byte[] params;
+   private final boolean hasUserParams;
+   private final int entropyChunkSize;
    private final int nbInputBlocks;
-   private final XXHash32 hasher;
+   private BlockChecksum hasher;
    private final SliceByteArray sa; // for all blocks
    private final SliceByteArray[] buffers; // input & output per block
    private final int entropyType;
    private final long transformType;
-   private final OutputBitStream obs;
+   private OutputBitStream obs;
    private final AtomicBoolean initialized;
    private final AtomicBoolean closed;
    private final AtomicInteger blockId;
@@ -76,6 +99,25 @@ public class CompressedOutputStream extends OutputStream
    private final ExecutorService pool;
    private final List<Listener> listeners;
    private final Map<String, Object> ctx;
+   private final Long anytimeDeadline; // null if no time budget
+   private int maxInflightBlocks;
+   private int formatVersion;
+   private MetricsSink metrics;
+   private BoundaryDetector detector;
+   private int detectorWindow;
+   private int minBlockSize;
+   private ShardFactory shardFactory;
+   private long rotatedBits; // written to the previous output streams
+   private long startTime;
+   private long nbBlocks;
+   private long nbBytes;
+
+
+   // Single job stream created from a profile (parameters already validated)
+   public CompressedOutputStream(OutputStream os, Profile profile)
+   {
+      this(os, profile.toContext());
+   }
 
 
    public CompressedOutputStream(OutputStream os, Map<String, Object> ctx)
@@ -114,16 +156,83 @@ public class CompressedOutputStream extends OutputStream
 
       if (((long) bSize) * ((long) tasks) >= (long) Integer.MAX_VALUE)
          tasks = Integer.MAX_VALUE / bSize;
+
+      // Optional alignment (in bytes) of the compressed blocks in the output stream
+      final int align = (ctx.containsKey("alignment")) ? (Integer) ctx.get("alignment") : 1;
+
+      if ((align < 1) || (align > MAX_BLOCK_ALIGNMENT) || ((align & (align-1)) != 0))
+         throw new IllegalArgumentException("The block alignment must be a power of 2 in [1.."+MAX_BLOCK_ALIGNMENT+"]");
       
+      // Optional compression level and extended parameters (recorded in the header)
+      final int lvl = (ctx.containsKey("level")) ? (Integer) ctx.get("level") : -1;
+
+      if ((lvl < -1) || (lvl > MAX_LEVEL))
+         throw new IllegalArgumentException("The compression level must be in [0.."+MAX_LEVEL+"]");
+
+      @SuppressWarnings("unchecked")
+      Map<String, String> userParams = (Map<String, String>) ctx.get("params");
+      Map<String, String> extraParams = new LinkedHashMap<>();
+      byte[] blob = null;
+
+      if (userParams != null)
+         extraParams.putAll(userParams);
+
+      // Record the parameters of the inverse transforms (EG. image dimensions)
+      // so that the decoder requires no configuration
+      if ((Boolean) ctx.getOrDefault("storeOnly", false) == false)
+      {
+         for (String key : ByteFunctionFactory.getInverseParameters(new ByteFunctionFactory().getType(transform)))
+         {
+            if (ctx.containsKey(key))
+               extraParams.put(key, String.valueOf(ctx.get(key)));
+         }
+      }
+
+      if (extraParams.isEmpty() == false)
+      {
+         blob = StreamInfo.encodeParams(extraParams);
+
+         if (blob.length > MAX_PARAMS_SIZE)
+            throw new IllegalArgumentException("The extended parameters must be at most "+MAX_PARAMS_SIZE+" bytes");
+      }
+
+      // Optional size of the entropy sub-streams (0 means one entropy stream per block)
+      final int chunkSize = (ctx.containsKey("entropyChunkSize")) ? (Integer) ctx.get("entropyChunkSize") : 0;
+
+      if ((chunkSize != 0) && ((chunkSize < ParallelEntropyEncoder.MIN_CHUNK_SIZE) || (chunkSize > bSize)))
+         throw new IllegalArgumentException("The entropy chunk size must be 0 or in ["+
+            ParallelEntropyEncoder.MIN_CHUNK_SIZE+".."+bSize+"]");
+
+      // Every block decodes independently (no seekability option to check):
+      // the entropy codec and the transforms are created for each block (see
+      // EncodingTask) and no option carries state across blocks.
+
       ExecutorService threadPool = (ExecutorService) ctx.get("pool");
 
       if ((tasks > 1) && (threadPool == null))
          throw new IllegalArgumentException("The thread pool cannot be null when the number of jobs is "+tasks);
 
+      this.level = lvl;
+      this.params = blob;
+      this.hasUserParams = (userParams != null) && (userParams.isEmpty() == false);
+      this.entropyChunkSize = chunkSize;
       this.obs = new DefaultOutputBitStream(os, DEFAULT_BUFFER_SIZE);
-      this.entropyType = EntropyCodecFactory.getType(entropyCodec);
-      this.transformType = new ByteFunctionFactory().getType(transform);
+
+      // Store only: all blocks are copied, the header records no transform
+      // and no entropy codec
+      if ((Boolean) ctx.getOrDefault("storeOnly", false) == true)
+      {
+         this.entropyType = EntropyCodecFactory.NONE_TYPE;
+         this.transformType = ByteFunctionFactory.NONE_TYPE;
+      }
+      else
+      {
+         this.entropyType = EntropyCodecFactory.getType(entropyCodec);
+         this.transformType = new ByteFunctionFactory().getType(transform);
+      }
+
       this.blockSize = bSize;
+      this.alignment = align;
       
       // If input size has been provided, calculate the number of blocks
       // in the input data else use 0. A value of 63 means '63 or more blocks'.
@@ -134,9 +243,18 @@ public class CompressedOutputStream extends OutputStream
       int nbBlocks = (int) (fileSize+(bSize-1)) / bSize;
       this.nbInputBlocks = (nbBlocks > 63) ? 63 : nbBlocks;
       
-      boolean checksum = (Boolean) ctx.get("checksum");
-      this.hasher = (checksum == true) ? new XXHash32(BITSTREAM_TYPE) : null;
+      // Block checksum: XXHash32 unless another algorithm is selected
+      ChecksumKind checksumKind = ((Boolean) ctx.getOrDefault("checksum", false) == true) ? ChecksumKind.XXHASH32 :
+         ChecksumKind.NONE;
+      final Object kind = ctx.get("checksumKind");
+
+      if (kind != null)
+         checksumKind = (kind instanceof ChecksumKind) ? (ChecksumKind) kind : ChecksumKind.getKind(kind.toString());
+
+      this.hasher = BlockChecksum.create(checksumKind, BITSTREAM_TYPE);
       this.jobs = tasks;
+      this.maxInflightBlocks = tasks;
+      this.formatVersion = BITSTREAM_FORMAT_VERSION;
       this.pool = threadPool;
       this.sa = new SliceByteArray(new byte[0], 0); 
       this.buffers = new SliceByteArray[2*this.jobs];
@@ -148,20 +266,136 @@ public class CompressedOutputStream extends OutputStream
 
       this.blockId = new AtomicInteger(0);
       this.listeners = new ArrayList<>(10);
+
+      // Anytime compression: the time budget (in ms) starts now and is shared
+      // by all the blocks (see AnytimeCodec). The deadline is added to the
+      // context of each block, the context provided by the caller is unchanged.
+      if ((ctx.containsKey("anytimeBudget") == true) && (ctx.containsKey("anytimeDeadline") == false))
+         this.anytimeDeadline = System.nanoTime() + 1000000L*(Integer) ctx.get("anytimeBudget");
+      else
+         this.anytimeDeadline = null;
+
       this.ctx = ctx;
    }
 
 
+   // Limit the number of blocks encoded concurrently and buffered while waiting
+   // for their turn to be written (default is the number of jobs). A block is
+   // only submitted once the block n positions before has been written, which
+   // bounds the memory used by the output buffers. The output does not depend
+   // on this value.
+   public void setMaxInflightBlocks(int n)
+   {
+      if (n < 1)
+         throw new IllegalArgumentException("The maximum number of in-flight blocks must be at least 1");
+
+      this.maxInflightBlocks = n;
+   }
+
+
+   public int getMaxInflightBlocks()
+   {
+      return this.maxInflightBlocks;
+   }
+
+
+   // Select the algorithm of the block checksums (recorded in the header).
+   // Must be called before the first write.
+   public void setChecksum(ChecksumKind kind)
+   {
+      if (kind == null)
+         throw new NullPointerException("Invalid null checksum type parameter");
+
+      if (this.initialized.get() == true)
+         throw new IllegalStateException("The checksum type cannot be changed after the header is written");
+
+      this.checkFormatVersion(this.formatVersion, kind);
+      this.hasher = BlockChecksum.create(kind, BITSTREAM_TYPE);
+   }
+
+
+   public ChecksumKind getChecksum()
+   {
+      return (this.hasher == null) ? ChecksumKind.NONE : this.hasher.getKind();
+   }
+
+
+   // Write a stream readable by the decoders of an older format version (from
+   // version 9). Must be called before the first write. The features missing
+   // in this version are rejected: checksum types other than XXHash32 (before
+   // version 11), block alignment, extended parameters and entropy sub-streams
+   // (before version 10), and the more recent transforms and entropy codecs.
+   // A version 9 header does not record the compression level nor the
+   // parameters of the inverse transforms (the decoder must provide them).
+   public void setFormatVersion(int version)
+   {
+      if ((version < MIN_BITSTREAM_FORMAT_VERSION) || (version > BITSTREAM_FORMAT_VERSION))
+         throw new IllegalArgumentException("The format version must be in ["+MIN_BITSTREAM_FORMAT_VERSION+
+            ".."+BITSTREAM_FORMAT_VERSION+"]");
+
+      if (this.initialized.get() == true)
+         throw new IllegalStateException("The format version cannot be changed after the header is written");
+
+      this.checkFormatVersion(version, this.getChecksum());
+      this.formatVersion = version;
+   }
+
+
+   public int getFormatVersion()
+   {
+      return this.formatVersion;
+   }
+
+
+   // Throw an IllegalArgumentException if the stream uses a feature missing
+   // in the given format version
+   private void checkFormatVersion(int version, ChecksumKind kind)
+   {
+      if ((version < 11) && (kind != ChecksumKind.NONE) && (kind != ChecksumKind.XXHASH32))
+         throw new IllegalArgumentException("The checksum type "+kind+" requires format version 11");
+
+      final int idx = version - MIN_BITSTREAM_FORMAT_VERSION;
+
+      if (this.entropyType > MAX_ENTROPY_TYPES[idx])
+         throw new IllegalArgumentException("The entropy codec "+EntropyCodecFactory.getName(this.entropyType)+
+            " is not available in format version "+version);
+
+      for (int shift=42; shift>=0; shift-=6)
+      {
+         final long t = (this.transformType >>> shift) & 0x3F;
+
+         if (t > MAX_TRANSFORM_TYPES[idx])
+            throw new IllegalArgumentException("The transform "+new ByteFunctionFactory().getName(t<<42)+
+               " is not available in format version "+version);
+      }
+
+      if (version >= 10)
+         return;
+
+      if (this.alignment > 1)
+         throw new IllegalArgumentException("The block alignment requires format version 10");
+
+      if (this.hasUserParams == true)
+         throw new IllegalArgumentException("The extended parameters require format version 10");
+
+      if (this.entropyChunkSize > 0)
+         throw new IllegalArgumentException("The entropy sub-streams require format version 10");
+   }
+
+
    protected void writeHeader() throws IOException
    {
       if (this.obs.writeBits(BITSTREAM_TYPE, 32) != 32)
          throw new kanzi.io.IOException("Cannot write bitstream type to header", Error.ERR_WRITE_FILE);
 
-      if (this.obs.writeBits(BITSTREAM_FORMAT_VERSION, 5) != 5)
+      if (this.obs.writeBits(this.formatVersion, 5) != 5)
          throw new kanzi.io.IOException("Cannot write bitstream version to header", Error.ERR_WRITE_FILE);
 
-      if (this.obs.writeBits((this.hasher != null) ? 1 : 0, 1) != 1)
-         throw new kanzi.io.IOException("Cannot write checksum to header", Error.ERR_WRITE_FILE);
+      // Checksum type since version 11 (XXHash32 implied before)
+      if (this.formatVersion >= 11)
+         CompressedStreams.Header.writeChecksumKind(this.obs, this.getChecksum());
+      else
+         this.obs.writeBits((this.getChecksum() != ChecksumKind.NONE) ? 1 : 0, 1);
       
       if (this.obs.writeBits(this.entropyType, 5) != 5)
          throw new kanzi.io.IOException("Cannot write entropy type to header", Error.ERR_WRITE_FILE);
@@ -175,8 +409,24 @@ public class CompressedOutputStream extends OutputStream
       if (this.obs.writeBits(this.nbInputBlocks, 6) != 6)
          throw new kanzi.io.IOException("Cannot write number of blocks to header", Error.ERR_WRITE_FILE);
 
-      if (this.obs.writeBits(0L, 3) != 3)
-         throw new kanzi.io.IOException("Cannot write reserved bits to header", Error.ERR_WRITE_FILE);
+      if (this.formatVersion < 10)
+      {
+         // Reserved bits, no alignment, level or extended parameters
+         if (this.obs.writeBits(0, 3) != 3)
+            throw new kanzi.io.IOException("Cannot write reserved bits to header", Error.ERR_WRITE_FILE);
+
+         return;
+      }
+
+      if (this.obs.writeBits(Global.log2(this.alignment), 5) != 5)
+         throw new kanzi.io.IOException("Cannot write block alignment to header", Error.ERR_WRITE_FILE);
+
+      // Write compression level, extended parameters flag, entropy sub-streams
+      // flag and optional extended parameters
+      CompressedStreams.Header.writeParams(this.obs, this.level, this.params, this.entropyChunkSize > 0);
+
+      // Align first block
+      writePadding(this.obs, this.obs.written(), this.alignment);
    }
 
 
@@ -190,6 +440,104 @@ public class CompressedOutputStream extends OutputStream
     {
        return (bl != null) ? this.listeners.remove(bl) : false;
     }
+
+
+   // Report the stream metrics (see MetricsSink) to the sink when the stream
+   // is closed. A null sink disables the reporting.
+   public void setMetricsSink(MetricsSink sink)
+   {
+      this.metrics = sink;
+   }
+
+
+   public MetricsSink getMetricsSink()
+   {
+      return this.metrics;
+   }
+
+
+   // Cut the blocks at the boundaries decided by the detector (content defined
+   // block sizes) instead of every blockSize bytes. The detector is called with
+   // a rolling window of at most 'window' bytes once a block holds at least
+   // 'minBlockSize' bytes. Blocks are still limited to the block size. A null
+   // detector restores the fixed block size.
+   public void setBoundaryDetector(BoundaryDetector detector, int window, int minBlockSize)
+   {
+      if (window < 1)
+         throw new IllegalArgumentException("Invalid boundary detector window size: "+window);
+
+      if ((minBlockSize < 1) || (minBlockSize > this.blockSize))
+         throw new IllegalArgumentException("The minimum block size must be in [1.."+this.blockSize+"]");
+
+      this.detector = detector;
+      this.detectorWindow = window;
+      this.minBlockSize = minBlockSize;
+   }
+
+
+   public BoundaryDetector getBoundaryDetector()
+   {
+      return this.detector;
+   }
+
+
+   // Sharded output: each compressed block is written to its own shard created
+   // by the factory and the stream only receives a manifest: the header, then
+   // one entry per block, in order (shard size in bits, block length on 32
+   // bits, XXHash32 of the shard on 32 bits) and the end of stream marker.
+   // Must be called before the first write. CompressedStreams.unshard()
+   // rebuilds the regular stream from the manifest and the shards.
+   public void setShardFactory(ShardFactory factory)
+   {
+      if (this.initialized.get() == true)
+         throw new IllegalStateException("The shard factory cannot be changed after the header is written");
+
+      this.shardFactory = factory;
+   }
+
+
+   public ShardFactory getShardFactory()
+   {
+      return this.shardFactory;
+   }
+
+
+   // Continue the compressed stream on another output stream (EG. rotation of
+   // output files by size). The buffered data is compressed (the current block
+   // ends here) and all the bytes written so far are flushed to the current
+   // output stream, which is not closed. The concatenation of the successive
+   // output streams is the regular compressed stream. The split happens between
+   // two blocks, so the blocks must be byte aligned: the block alignment must
+   // be at least 2 bytes.
+   public void rotate(OutputStream os) throws IOException
+   {
+      if (os == null)
+         throw new NullPointerException("Invalid null output stream parameter");
+
+      if (this.closed.get() == true)
+         throw new kanzi.io.IOException("Stream closed", Error.ERR_WRITE_FILE);
+
+      if (this.alignment < 2)
+         throw new IllegalStateException("The output stream can only be rotated if the blocks are byte aligned (block alignment of at least 2 bytes)");
+
+      while (this.sa.index > 0)
+         this.processBlock(true);
+
+      if ((this.obs.written() & 7) != 0)
+         throw new IllegalStateException("The output stream can only be rotated at a byte boundary");
+
+      try
+      {
+         this.obs.close();
+      }
+      catch (BitStreamException e)
+      {
+         throw new kanzi.io.IOException(e.getMessage(), e.getErrorCode());
+      }
+
+      this.rotatedBits += this.obs.written();
+      this.obs = new DefaultOutputBitStream(os, DEFAULT_BUFFER_SIZE);
+   }
     
 
     /**
@@ -341,7 +689,7 @@ public class CompressedOutputStream extends OutputStream
       if (this.closed.getAndSet(true) == true)
          return;
 
-      if (this.sa.index > 0)
+      while (this.sa.index > 0)
          this.processBlock(true);
 
       try
@@ -356,6 +704,13 @@ public class CompressedOutputStream extends OutputStream
          throw new kanzi.io.IOException(e.getMessage(), e.getErrorCode());
       }
 
+      if (this.metrics != null)
+      {
+         final long elapsed = (this.nbBlocks == 0) ? 0 : System.nanoTime() - this.startTime;
+         CompressedStreams.reportMetrics(this.metrics, true, this.nbBlocks, this.nbBytes,
+            this.getWritten(), elapsed, this.entropyType, this.transformType);
+      }
+
       this.listeners.clear();
 
       // Release resources
@@ -392,66 +747,105 @@ public class CompressedOutputStream extends OutputStream
       if (this.initialized.getAndSet(true) == false)
          this.writeHeader();
 
+      if (this.nbBlocks == 0)
+         this.startTime = System.nanoTime();
+
       try
       {
          // Protect against future concurrent modification of the list of block listeners         
          Listener[] blockListeners = this.listeners.toArray(new Listener[this.listeners.size()]);
          final int dataLength = this.sa.index;
+         final int[] sizes = this.getBlockSizes(dataLength, force);
+
+         if (sizes.length == 0)
+            return;
+
          this.sa.index = 0;
-         List<Callable<Status>> tasks = new ArrayList<>(this.jobs);
          int firstBlockId = this.blockId.get();
 
+         // Spread the jobs over the tasks (used by the entropy sub-streams
+         // when there are fewer blocks than jobs)
+         final int nbTasks = sizes.length;
+         final int[] jobsPerTask = Global.computeJobsPerTask(new int[nbTasks], this.jobs, nbTasks);
+         final int inflight = Math.min(this.maxInflightBlocks, nbTasks);
+         List<Future<Status>> results = new ArrayList<>(nbTasks);
+         final OutputBitStream[] shardStreams = new OutputBitStream[nbTasks];
+         final ByteArrayOutputStream[] shards = new ByteArrayOutputStream[nbTasks];
+
          // Create as many tasks as required
-         for (int jobId=0; jobId<this.jobs; jobId++)
+         for (int jobId=0; jobId<nbTasks; jobId++)
          {
-            final int sz = (this.sa.index + this.blockSize > dataLength) ?
-                    dataLength - this.sa.index : this.blockSize;
-            
-            if (sz == 0)
-               break;
-            
-            this.buffers[2*jobId].index = 0;
-            this.buffers[2*jobId+1].index = 0;
+            final int sz = sizes[jobId];
+
+            // Blocks are written in order: once the block 'inflight' positions
+            // before is done, its buffers can be reused
+            if ((jobId >= inflight) && (results.get(jobId-inflight) != null))
+            {
+               checkStatus(results.get(jobId-inflight).get());
+               this.writeShard(firstBlockId+jobId-inflight+1, sizes[jobId-inflight],
+                  shardStreams[jobId-inflight], shards[jobId-inflight]);
+            }
+
+            final int bufId = jobId % inflight;
+            this.buffers[2*bufId].index = 0;
+            this.buffers[2*bufId+1].index = 0;
             
-            if (this.buffers[2*jobId].array.length < sz)
+            if (this.buffers[2*bufId].array.length < sz)
             {
-               this.buffers[2*jobId].array = new byte[sz];
-               this.buffers[2*jobId].length = sz;
+               this.buffers[2*bufId].array = new byte[sz];
+               this.buffers[2*bufId].length = sz;
             }
             
-            System.arraycopy(this.sa.array, this.sa.index, this.buffers[2*jobId].array, 0, sz);
+            System.arraycopy(this.sa.array, this.sa.index, this.buffers[2*bufId].array, 0, sz);
+            Map<String, Object> map = new HashMap<>(this.ctx);
+            map.put("jobs", jobsPerTask[jobId]);
+
+            if (this.anytimeDeadline != null)
+               map.put("anytimeDeadline", this.anytimeDeadline);
+
+            // Sharded output: the block goes to its own bitstream
+            if (this.shardFactory != null)
+            {
+               shards[jobId] = new ByteArrayOutputStream(sz/2+1024);
+               shardStreams[jobId] = new DefaultOutputBitStream(shards[jobId], 16384);
+            }
             
-            Callable<Status> task = new EncodingTask(this.buffers[2*jobId],
-                    this.buffers[2*jobId+1], sz, this.transformType,
-                    this.entropyType, firstBlockId+jobId+1,
-                    this.obs, this.hasher, this.blockId,
-                    blockListeners, new HashMap<>(this.ctx));
-            tasks.add(task);
+            Callable<Status> task = new EncodingTask(this.buffers[2*bufId],
+                    this.buffers[2*bufId+1], sz, this.transformType,
+                    this.entropyType, firstBlockId+jobId+1, this.alignment,
+                    (this.shardFactory != null) ? shardStreams[jobId] : this.obs,
+                    this.hasher, this.blockId, blockListeners, map);
             this.sa.index += sz;
-         }
+            this.nbBlocks++;
+            this.nbBytes += sz;
 
-         if (tasks.size() == 1)
-         {           
-            // Synchronous call
-            Status status = tasks.get(0).call();
-            
-            if (status.error != 0)
-               throw new kanzi.io.IOException(status.msg, status.error);
+            if (inflight == 1)
+            {
+               // Synchronous call
+               checkStatus(task.call());
+               this.writeShard(firstBlockId+jobId+1, sz, shardStreams[jobId], shards[jobId]);
+               results.add(null);
+            }
+            else
+            {
+               results.add(this.pool.submit(task));
+            }
          }
-         else
+
+         // Wait for completion of the remaining tasks and validate the results
+         for (int i=Math.max(results.size()-inflight, 0); i<results.size(); i++)
          {
-            // Invoke the tasks concurrently and validate the results
-            for (Future<Status> result : this.pool.invokeAll(tasks))
+            if (results.get(i) != null)
             {
-               // Wait for completion of next task and validate result
-               Status status = result.get();
-
-               if (status.error != 0)
-                  throw new kanzi.io.IOException(status.msg, status.error);
+               checkStatus(results.get(i).get());
+               this.writeShard(firstBlockId+i+1, sizes[i], shardStreams[i], shards[i]);
             }
          }
 
-         this.sa.index = 0;
+         // Keep the bytes of an incomplete block (content defined boundaries)
+         final int remaining = dataLength - this.sa.index;
+         System.arraycopy(this.sa.array, this.sa.index, this.sa.array, 0, remaining);
+         this.sa.index = remaining;
       }
       catch (kanzi.io.IOException e)
       {
@@ -466,10 +860,107 @@ public class CompressedOutputStream extends OutputStream
    }
 
 
+   // Return the sizes of the next blocks (at most one per job) in the first
+   // 'length' bytes of the buffer. With a boundary detector, a block ends at
+   // the first cut (after at least minBlockSize bytes) or after blockSize bytes.
+   // Unless 'force' is set, the trailing bytes of an incomplete block are left
+   // in the buffer.
+   private int[] getBlockSizes(int length, boolean force)
+   {
+      final int[] sizes = new int[this.jobs];
+      int n = 0;
+      int start = 0;
+
+      while ((n < this.jobs) && (start < length))
+      {
+         final int max = Math.min(this.blockSize, length-start);
+         int sz = max;
+
+         if (this.detector != null)
+         {
+            sz = -1;
+
+            for (int end=start+this.minBlockSize; end<=start+max; end++)
+            {
+               final int idx = Math.max(start, end-this.detectorWindow);
+
+               if (this.detector.cut(this.sa.array, idx, end-idx) == true)
+               {
+                  sz = end - start;
+                  break;
+               }
+            }
+
+            if (sz < 0)
+            {
+               if ((max < this.blockSize) && (force == false))
+                  break;
+
+               sz = max;
+            }
+         }
+
+         sizes[n++] = sz;
+         start += sz;
+      }
+
+      return Arrays.copyOf(sizes, n);
+   }
+
+
+   // Sharded output: write the block to its shard (in block order) and the
+   // corresponding entry to the manifest. Nothing to do without shard factory.
+   private void writeShard(int id, int length, OutputBitStream bs, ByteArrayOutputStream baos)
+      throws IOException
+   {
+      if (bs == null)
+         return;
+
+      bs.close();
+      final byte[] data = baos.toByteArray();
+      OutputStream os = this.shardFactory.createShard(id);
+
+      try
+      {
+         os.write(data);
+      }
+      finally
+      {
+         os.close();
+      }
+
+      final int lw = (this.blockSize >= 1<<28) ? 40 : 32;
+      this.obs.writeBits(bs.written(), lw);
+      this.obs.writeBits(length, 32);
+      this.obs.writeBits(new XXHash32(BITSTREAM_TYPE).hash(data, 0, data.length), 32);
+   }
+
+
+   private static void checkStatus(Status status) throws kanzi.io.IOException
+   {
+      if (status.error != 0)
+         throw new kanzi.io.IOException(status.msg, status.error);
+   }
+
+
+   // Write 0 bits to round the offset (in bits) up to a multiple of 'alignment' bytes
+   static void writePadding(OutputBitStream bs, long offset, int alignment)
+   {
+      long padding = (-offset) & ((((long) alignment)<<3) - 1);
+
+      while (padding > 0)
+      {
+         final int n = (padding > 64) ? 64 : (int) padding;
+         bs.writeBits(0L, n);
+         padding -= n;
+      }
+   }
+
+
    // Return the number of bytes written so far
    public long getWritten()
    {
-      return (this.obs.written() + 7) >> 3;
+      return (this.rotatedBits + this.obs.written() + 7) >> 3;
    }
 
    
@@ -500,16 +991,17 @@ public class CompressedOutputStream extends OutputStream
       private final long transformType;
       private final int entropyType;
       private final int blockId;
+      private final int alignment;
       private final OutputBitStream obs;
-      private final XXHash32 hasher;
+      private final BlockChecksum hasher;
       private final AtomicInteger processedBlockId;
       private final Listener[] listeners;
       private final Map<String, Object> ctx;
 
 
       EncodingTask(SliceByteArray iBuffer, SliceByteArray oBuffer, int length,
-              long transformType, int entropyType, int blockId,
-              OutputBitStream obs, XXHash32 hasher,
+              long transformType, int entropyType, int blockId, int alignment,
+              OutputBitStream obs, BlockChecksum hasher,
               AtomicInteger processedBlockId, Listener[] listeners,
               Map<String, Object> ctx)
       {
@@ -519,6 +1011,7 @@ public class CompressedOutputStream extends OutputStream
          this.transformType = transformType;
          this.entropyType = entropyType;
          this.blockId = blockId;
+         this.alignment = alignment;
          this.obs = obs;
          this.hasher = hasher;
          this.processedBlockId = processedBlockId;
@@ -560,7 +1053,7 @@ public class CompressedOutputStream extends OutputStream
 
             byte mode = 0;
             int postTransformLength;
-            int checksum = 0;
+            long checksum = 0;
 
             // Compute block checksum
             if (this.hasher != null)
@@ -570,11 +1063,14 @@ public class CompressedOutputStream extends OutputStream
             {
                // Notify before transform               
                Event evt = new Event(Event.Type.BEFORE_TRANSFORM, currentBlockId,
-                       blockLength, checksum, this.hasher != null);
+                       blockLength, (int) checksum, this.hasher != null);
                
                notifyListeners(this.listeners, evt);
             }
 
+            if ((Boolean) this.ctx.getOrDefault("storeOnly", false) == true)
+               return this.storeBlock(data, blockLength, checksum, currentBlockId);
+
             if (blockLength <= SMALL_BLOCK_SIZE)
             {
                blockTransformType = ByteFunctionFactory.NONE_TYPE;
@@ -644,7 +1140,7 @@ public class CompressedOutputStream extends OutputStream
             {
                // Notify after transform
                Event evt = new Event(Event.Type.AFTER_TRANSFORM, currentBlockId,
-                       postTransformLength, checksum, this.hasher != null);
+                       postTransformLength, (int) checksum, this.hasher != null);
                
                notifyListeners(this.listeners, evt);
             }           
@@ -669,20 +1165,25 @@ public class CompressedOutputStream extends OutputStream
 
             // Write checksum
             if (this.hasher != null)
-               os.writeBits(checksum, 32);
+               os.writeBits(checksum, this.hasher.getSize());
 
             if (this.listeners.length > 0)
             {
                // Notify before entropy
                Event evt = new Event(Event.Type.BEFORE_ENTROPY, currentBlockId,
-                       postTransformLength, checksum, this.hasher != null);
+                       postTransformLength, (int) checksum, this.hasher != null);
                
                notifyListeners(this.listeners, evt);
             }
    
             // Each block is encoded separately
             // Rebuild the entropy encoder to reset block statistics
-            ee = new EntropyCodecFactory().newEncoder(os, this.ctx, blockEntropyType);
+            final int chunkSize = (Integer) this.ctx.getOrDefault("entropyChunkSize", 0);
+
+            if ((chunkSize > 0) && ((mode & COPY_BLOCK_MASK) == 0))
+               ee = new ParallelEntropyEncoder(os, this.ctx, blockEntropyType, chunkSize);
+            else
+               ee = new EntropyCodecFactory().newEncoder(os, this.ctx, blockEntropyType);
 
             // Entropy encode block
             if (ee.encode(buffer.array, 0, postTransformLength) != postTransformLength)
@@ -697,6 +1198,14 @@ public class CompressedOutputStream extends OutputStream
             // Force ee to null to avoid double dispose (in the finally section)
             ee = null;
 
+            // Block size in bits (max size pre-entropy is 1 GB = 1 << 30 bytes)
+            final int lw = (blockLength >= 1<<28) ? 40 : 32;
+
+            // Pad the block so that the next block starts on an alignment boundary.
+            // The padding is included in the block size and ignored by the decoder.
+            if (this.alignment > 1)
+               writePadding(os, lw+os.written(), this.alignment);
+
             os.close();
             long written = os.written();
             
@@ -722,20 +1231,22 @@ public class CompressedOutputStream extends OutputStream
             {
                // Notify after entropy
                Event evt = new Event(Event.Type.AFTER_ENTROPY, 
-                       currentBlockId, (written+7) >> 3, checksum, this.hasher != null);
+                       currentBlockId, (written+7) >> 3, (int) checksum, this.hasher != null);
                
                notifyListeners(this.listeners, evt);
             }
             
-            // Emit block size in bits (max size pre-entropy is 1 GB = 1 << 30 bytes)
-            final int lw = (blockLength >= 1<<28) ? 40 : 32;
+            // Emit block size in bits
             this.obs.writeBits(written, lw);
+            
+            // The output buffer may have been reallocated if the block expanded
+            final byte[] blockData = baos.getBuffer();
 
             // Emit data to shared bitstream
             for (int n=0; written>0; )
             {
                final int chkSize = (written < (long) (1<<30)) ? (int) written : 1<<30;
-               this.obs.writeBits(this.data.array, n, chkSize);
+               this.obs.writeBits(blockData, n, chkSize);
                n += ((chkSize+7) >> 3);
                written -= chkSize;
             }
@@ -762,6 +1273,81 @@ public class CompressedOutputStream extends OutputStream
               ee.dispose();
          }
       }     
+
+
+      // Write a copy block directly to the shared bitstream (no transform, no
+      // entropy coding, no intermediate buffer). The size of the block is
+      // known in advance: block header and raw data.
+      private Status storeBlock(SliceByteArray data, int blockLength, long checksum,
+         int currentBlockId)
+      {
+         int dataSize = 0;
+
+         for (long n=0xFF; n<blockLength; n<<=8)
+            dataSize++;
+
+         // All transforms skipped
+         final int mode = COPY_BLOCK_MASK | ((dataSize & 0x03) << 5) | 0x0F;
+         dataSize++;
+         final int lw = (blockLength >= 1<<28) ? 40 : 32;
+         final long bits = 8 + 8*dataSize + ((this.hasher != null) ? this.hasher.getSize() : 0) + 8L*blockLength;
+         final long padding = (this.alignment > 1) ? (-(lw+bits)) & ((((long) this.alignment)<<3) - 1) : 0;
+
+         if (this.listeners.length > 0)
+         {
+            // Notify after transform
+            Event evt = new Event(Event.Type.AFTER_TRANSFORM, currentBlockId,
+                    blockLength, (int) checksum, this.hasher != null);
+
+            notifyListeners(this.listeners, evt);
+         }
+
+         // Lock free synchronization
+         while (true)
+         {
+            final int taskId = this.processedBlockId.get();
+
+            if (taskId == CANCEL_TASKS_ID)
+               return new Status(currentBlockId, 0, "Canceled");
+
+            if (taskId == currentBlockId-1)
+               break;
+
+            Thread.yield();
+         }
+
+         this.obs.writeBits(bits+padding, lw);
+         this.obs.writeBits(mode, 8);
+         this.obs.writeBits(blockLength, 8*dataSize);
+
+         if (this.hasher != null)
+            this.obs.writeBits(checksum, this.hasher.getSize());
+
+         long remaining = 8L*blockLength;
+
+         for (int n=data.index; remaining>0; )
+         {
+            final int chkSize = (remaining < (long) (1<<30)) ? (int) remaining : 1<<30;
+            this.obs.writeBits(data.array, n, chkSize);
+            n += (chkSize >> 3);
+            remaining -= chkSize;
+         }
+
+         writePadding(this.obs, lw+bits, this.alignment);
+
+         if (this.listeners.length > 0)
+         {
+            // Notify after entropy
+            Event evt = new Event(Event.Type.AFTER_ENTROPY,
+                    currentBlockId, (bits+padding) >> 3, (int) checksum, this.hasher != null);
+
+            notifyListeners(this.listeners, evt);
+         }
+
+         // Unblock the task processing the next block (if any)
+         this.processedBlockId.incrementAndGet();
+         return new Status(currentBlockId, 0, "Success");
+      }
    }
 
    
@@ -793,5 +1379,11 @@ public class CompressedOutputStream extends OutputStream
          
          this.buf = buffer;
       }
+
+
+      public byte[] getBuffer()
+      {
+         return this.buf;
+      }
    }   
 }
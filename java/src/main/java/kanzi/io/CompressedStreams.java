@@ -0,0 +1,914 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.io;
+
+import java.io.ByteArrayInputStream;
+import java.io.IOException;
+import java.io.InputStream;
+import java.io.OutputStream;
+import java.util.HashMap;
+import java.util.Map;
+import kanzi.BitStreamException;
+import kanzi.EntropyDecoder;
+import kanzi.EntropyEncoder;
+import kanzi.Error;
+import kanzi.Global;
+import kanzi.InputBitStream;
+import kanzi.MetricsSink;
+import kanzi.OutputBitStream;
+import kanzi.ShardFactory;
+import kanzi.SliceByteArray;
+import kanzi.bitstream.DefaultInputBitStream;
+import kanzi.bitstream.DefaultOutputBitStream;
+import kanzi.entropy.EntropyCodecFactory;
+import kanzi.entropy.ParallelEntropyDecoder;
+import kanzi.entropy.ParallelEntropyEncoder;
+import kanzi.function.ByteFunctionFactory;
+import kanzi.function.ByteTransformSequence;
+import kanzi.util.hash.XXHash32;
+
+
+// Utilities operating on whole streams produced by CompressedOutputStream
+public final class CompressedStreams
+{
+   private static final int BITSTREAM_TYPE           = 0x4B414E5A; // "KANZ"
+   private static final int BITSTREAM_FORMAT_VERSION = 11;
+   private static final int MIN_BITSTREAM_FORMAT_VERSION = 9;
+   private static final int COPY_BLOCK_MASK          = 0x80;
+   private static final int TRANSFORMS_MASK          = 0x10;
+   private static final int MIN_BITSTREAM_BLOCK_SIZE = 1024;
+   private static final int MAX_BITSTREAM_BLOCK_SIZE = 1024*1024*1024;
+   private static final int MAX_BLOCK_ALIGNMENT_LOG  = 20;
+   private static final int DEFAULT_BUFFER_SIZE      = 256*1024;
+   private static final int EXTRA_BUFFER_SIZE        = 256;
+
+
+   private CompressedStreams()
+   {
+   }
+
+
+   // Stream header fields
+   static class Header
+   {
+      int version;
+      ChecksumKind checksumKind = ChecksumKind.NONE;
+      int entropyType;
+      long transformType;
+      int blockSize;
+      int nbInputBlocks;
+      int alignment;
+      int level = -1;
+      byte[] params;
+      boolean splitEntropy;
+      final Map<String, Integer> transformParams = new HashMap<>();
+
+
+      static Header read(InputBitStream ibs) throws IOException
+      {
+         Header h = new Header();
+
+         if ((int) ibs.readBits(32) != BITSTREAM_TYPE)
+            throw new kanzi.io.IOException("Invalid stream type", Error.ERR_INVALID_FILE);
+
+         h.version = (int) ibs.readBits(5);
+
+         if ((h.version < MIN_BITSTREAM_FORMAT_VERSION) || (h.version > BITSTREAM_FORMAT_VERSION))
+            throw new kanzi.io.IOException("Invalid bitstream, cannot read this version of the stream: " + h.version,
+                    Error.ERR_STREAM_VERSION);
+
+         if (ibs.readBit() == 1)
+         {
+            // Checksum type (2 bits) since version 11, always XXHash32 before
+            h.checksumKind = (h.version >= 11) ? ChecksumKind.values()[(int) ibs.readBits(2)] :
+               ChecksumKind.XXHASH32;
+
+            if (h.checksumKind == ChecksumKind.NONE)
+               throw new kanzi.io.IOException("Invalid bitstream, incorrect checksum type",
+                       Error.ERR_INVALID_FILE);
+         }
+
+         h.entropyType = (int) ibs.readBits(5);
+         h.transformType = ibs.readBits(48);
+         h.blockSize = (int) ibs.readBits(28) << 4;
+
+         if ((h.blockSize < MIN_BITSTREAM_BLOCK_SIZE) || (h.blockSize > MAX_BITSTREAM_BLOCK_SIZE))
+            throw new kanzi.io.IOException("Invalid bitstream, incorrect block size: " + h.blockSize,
+                    Error.ERR_BLOCK_SIZE);
+
+         h.nbInputBlocks = (int) ibs.readBits(6);
+         h.alignment = 1;
+
+         if (h.version >= 10)
+         {
+            final int logAlign = (int) ibs.readBits(5);
+
+            if (logAlign > MAX_BLOCK_ALIGNMENT_LOG)
+               throw new kanzi.io.IOException("Invalid bitstream, incorrect block alignment: 2^" + logAlign,
+                       Error.ERR_INVALID_FILE);
+
+            h.alignment = 1 << logAlign;
+
+            // Read compression level (4 bits), extended parameters flag (1 bit)
+            // and entropy sub-streams flag (1 bit)
+            h.level = (int) ibs.readBits(4) - 1;
+            final boolean hasParams = ibs.readBit() == 1;
+            h.splitEntropy = ibs.readBit() == 1;
+
+            if (hasParams == true)
+            {
+               h.params = new byte[(int) ibs.readBits(16)];
+               ibs.readBits(h.params, 0, 8*h.params.length);
+               Map<String, String> params = StreamInfo.decodeParams(h.params);
+
+               // Parameters of the inverse transforms (recorded by the encoder)
+               for (String key : ByteFunctionFactory.getInverseParameters(h.transformType))
+               {
+                  final String value = params.get(key);
+
+                  if (value == null)
+                     continue;
+
+                  try
+                  {
+                     h.transformParams.put(key, Integer.parseInt(value));
+                  }
+                  catch (NumberFormatException e)
+                  {
+                     throw new kanzi.io.IOException("Invalid bitstream, incorrect value for parameter "+key+": "+value,
+                        Error.ERR_INVALID_PARAM);
+                  }
+               }
+            }
+
+            long padding = (-ibs.read()) & ((((long) h.alignment)<<3) - 1);
+
+            while (padding > 0)
+            {
+               final int n = (padding > 64) ? 64 : (int) padding;
+               ibs.readBits(n);
+               padding -= n;
+            }
+         }
+         else
+         {
+            ibs.readBits(3);
+         }
+
+         return h;
+      }
+
+
+      // Always written with the current format version
+      void write(OutputBitStream obs)
+      {
+         obs.writeBits(BITSTREAM_TYPE, 32);
+         obs.writeBits(BITSTREAM_FORMAT_VERSION, 5);
+         writeChecksumKind(obs, this.checksumKind);
+         obs.writeBits(this.entropyType, 5);
+         obs.writeBits(this.transformType, 48);
+         obs.writeBits(this.blockSize >>> 4, 28);
+         obs.writeBits(this.nbInputBlocks, 6);
+         obs.writeBits(Global.log2(this.alignment), 5);
+         writeParams(obs, this.level, this.params, this.splitEntropy);
+         CompressedOutputStream.writePadding(obs, obs.written(), this.alignment);
+      }
+
+
+      // Write checksum flag and checksum type (2 bits, if any)
+      static void writeChecksumKind(OutputBitStream obs, ChecksumKind kind)
+      {
+         obs.writeBits((kind != ChecksumKind.NONE) ? 1 : 0, 1);
+
+         if (kind != ChecksumKind.NONE)
+            obs.writeBits(kind.ordinal(), 2);
+      }
+
+
+      // Write compression level, extended parameters flag, entropy sub-streams
+      // flag and optional extended parameters (16 bit length + data)
+      static void writeParams(OutputBitStream obs, int level, byte[] params, boolean splitEntropy)
+      {
+         obs.writeBits(level+1, 4);
+         obs.writeBits((params != null) ? 1 : 0, 1);
+         obs.writeBits((splitEntropy == true) ? 1 : 0, 1);
+
+         if (params != null)
+         {
+            obs.writeBits(params.length, 16);
+            obs.writeBits(params, 0, 8*params.length);
+         }
+      }
+
+
+      StreamInfo toStreamInfo()
+      {
+         return new StreamInfo(this.version, this.checksumKind,
+            EntropyCodecFactory.getName(this.entropyType),
+            new ByteFunctionFactory().getName(this.transformType),
+            this.blockSize, this.nbInputBlocks, this.alignment, this.level,
+            (this.params == null) ? new HashMap<String, String>() : StreamInfo.decodeParams(this.params));
+      }
+
+
+      Map<String, Object> createContext()
+      {
+         Map<String, Object> ctx = new HashMap<>();
+         ctx.put("codec", EntropyCodecFactory.getName(this.entropyType));
+         ctx.put("transform", new ByteFunctionFactory().getName(this.transformType));
+         ctx.put("extra", this.entropyType == EntropyCodecFactory.TPAQX_TYPE);
+         ctx.put("blockSize", this.blockSize);
+         ctx.put("entropySplit", this.splitEntropy);
+         ctx.put("jobs", 1);
+         ctx.putAll(this.transformParams);
+         return ctx;
+      }
+   }
+
+
+   // Read the next block from the bitstream. Return null at the end of stream.
+   // The block data size in bits is returned in 'bits[0]'.
+   static byte[] readBlock(InputBitStream ibs, int blockSize, long[] bits) throws IOException
+   {
+      final int lr = (blockSize >= 1<<28) ? 40 : 32;
+      long read = ibs.readBits(lr);
+
+      if (read == 0)
+         return null;
+
+      if (read > 1L<<34)
+         throw new kanzi.io.IOException("Invalid block size", Error.ERR_BLOCK_SIZE);
+
+      bits[0] = read;
+      byte[] data = new byte[(int) ((read+7) >> 3)];
+
+      for (int n=0; read>0; )
+      {
+         final int chkSize = (read < (long) (1<<30)) ? (int) read : 1<<30;
+         ibs.readBits(data, n, chkSize);
+         n += ((chkSize+7) >> 3);
+         read -= chkSize;
+      }
+
+      return data;
+   }
+
+
+   static void writeBlock(OutputBitStream obs, int blockSize, byte[] data, long written)
+   {
+      final int lw = (blockSize >= 1<<28) ? 40 : 32;
+      obs.writeBits(written, lw);
+
+      for (int n=0; written>0; )
+      {
+         final int chkSize = (written < (long) (1<<30)) ? (int) written : 1<<30;
+         obs.writeBits(data, n, chkSize);
+         n += ((chkSize+7) >> 3);
+         written -= chkSize;
+      }
+   }
+
+
+   static boolean usesTransform(long transformType, int functionType)
+   {
+      for (int i=0; i<8; i++)
+      {
+         if (((transformType >>> (42-6*i)) & 0x3F) == functionType)
+            return true;
+      }
+
+      return false;
+   }
+
+
+   // Report the standard metrics of a compressed (or decompressed) stream.
+   // Exceptions thrown by the sink are ignored (like those of the listeners).
+   static void reportMetrics(MetricsSink sink, boolean compress, long blocks,
+      long inputBytes, long outputBytes, long elapsedNanos, int entropyType,
+      long transformType)
+   {
+      try
+      {
+         final long uncompressed = (compress == true) ? inputBytes : outputBytes;
+         final long compressed = (compress == true) ? outputBytes : inputBytes;
+         sink.observe((compress == true) ? MetricsSink.COMPRESS_BLOCKS : MetricsSink.DECOMPRESS_BLOCKS, blocks);
+         sink.observe((compress == true) ? MetricsSink.COMPRESS_INPUT_BYTES : MetricsSink.DECOMPRESS_INPUT_BYTES, inputBytes);
+         sink.observe((compress == true) ? MetricsSink.COMPRESS_OUTPUT_BYTES : MetricsSink.DECOMPRESS_OUTPUT_BYTES, outputBytes);
+
+         if (uncompressed > 0)
+            sink.observe((compress == true) ? MetricsSink.COMPRESS_RATIO : MetricsSink.DECOMPRESS_RATIO,
+               (double) compressed / uncompressed);
+
+         if (elapsedNanos > 0)
+            sink.observe((compress == true) ? MetricsSink.COMPRESS_BLOCKS_PER_SECOND : MetricsSink.DECOMPRESS_BLOCKS_PER_SECOND,
+               blocks * 1.0e9 / elapsedNanos);
+
+         // All the blocks of a stream share the entropy codec and the transforms
+         final String entropy = EntropyCodecFactory.getName(entropyType);
+         sink.observe(((compress == true) ? MetricsSink.COMPRESS_ENTROPY_BLOCKS : MetricsSink.DECOMPRESS_ENTROPY_BLOCKS) +
+            "{codec=\""+entropy+"\"}", blocks);
+
+         for (String t : new ByteFunctionFactory().getName(transformType).split("\\+"))
+         {
+            sink.observe(((compress == true) ? MetricsSink.COMPRESS_TRANSFORM_BLOCKS : MetricsSink.DECOMPRESS_TRANSFORM_BLOCKS) +
+               "{codec=\""+t+"\"}", blocks);
+         }
+      }
+      catch (Exception e)
+      {
+         // Ignore exceptions in the metrics sink
+      }
+   }
+
+
+   // Rewrite a compressed stream with a different entropy codec. The entropy
+   // layer of each block is decoded and the transform output (whose length is
+   // stored in the block header) is entropy coded again with the new codec.
+   // The transforms are not run again: skip flags, block lengths and checksums
+   // (computed on the original data) are copied as is.
+   public static void reentropize(InputStream is, OutputStream os, String codec) throws IOException
+   {
+      if (is == null)
+         throw new NullPointerException("Invalid null input stream parameter");
+
+      if (os == null)
+         throw new NullPointerException("Invalid null output stream parameter");
+
+      final int newEntropyType = EntropyCodecFactory.getType(codec);
+
+      try
+      {
+         InputBitStream ibs = new DefaultInputBitStream(is, DEFAULT_BUFFER_SIZE);
+         OutputBitStream obs = new DefaultOutputBitStream(os, DEFAULT_BUFFER_SIZE);
+         Header header = Header.read(ibs);
+         final int oldEntropyType = header.entropyType;
+
+         // The variant of the text transform depends on the entropy codec
+         if ((usesTransform(header.transformType, ByteFunctionFactory.DICT_TYPE) == true) &&
+            (ByteFunctionFactory.getTextCodecType(EntropyCodecFactory.getName(oldEntropyType)) !=
+             ByteFunctionFactory.getTextCodecType(codec)))
+         {
+            throw new kanzi.io.IOException("Cannot switch from "+EntropyCodecFactory.getName(oldEntropyType)+
+               " to "+codec+": incompatible text transform", Error.ERR_INVALID_CODEC);
+         }
+
+         Map<String, Object> dCtx = header.createContext();
+         header.entropyType = newEntropyType;
+         Map<String, Object> eCtx = header.createContext();
+         header.write(obs);
+         final int lw = (header.blockSize >= 1<<28) ? 40 : 32;
+         final long[] bits = new long[1];
+         byte[] buffer = new byte[0];
+
+         while (true)
+         {
+            final byte[] block = readBlock(ibs, header.blockSize, bits);
+
+            if (block == null)
+               break;
+
+            InputBitStream bis = new DefaultInputBitStream(new ByteArrayInputStream(block), 16384);
+            CompressedOutputStream.CustomByteArrayOutputStream baos =
+               new CompressedOutputStream.CustomByteArrayOutputStream(new byte[block.length+1024], block.length+1024);
+            OutputBitStream bos = new DefaultOutputBitStream(baos, 16384);
+
+            // Copy block header
+            final int mode = (int) bis.readBits(8);
+            bos.writeBits(mode, 8);
+
+            if (((mode & COPY_BLOCK_MASK) == 0) && ((mode & TRANSFORMS_MASK) != 0))
+               bos.writeBits(bis.readBits(8), 8);
+
+            final int dataSize = 1 + ((mode>>5) & 0x03);
+            final int length = (int) bis.readBits(8*dataSize);
+            bos.writeBits(length, 8*dataSize);
+
+            final int checksumSize = header.checksumKind.getSize();
+
+            if (checksumSize > 0)
+               bos.writeBits(bis.readBits(checksumSize), checksumSize);
+
+            if ((length < 0) || (length > MAX_BITSTREAM_BLOCK_SIZE))
+               throw new kanzi.io.IOException("Invalid compressed block length: " + length, Error.ERR_READ_FILE);
+
+            if (buffer.length < length)
+               buffer = new byte[length];
+
+            // Copy blocks are never entropy coded
+            final boolean copy = (mode & COPY_BLOCK_MASK) != 0;
+            dCtx.put("size", length);
+            eCtx.put("size", length);
+            final boolean split = (copy == false) && (header.splitEntropy == true);
+            EntropyDecoder ed = (split == true) ? new ParallelEntropyDecoder(bis, dCtx, oldEntropyType) :
+               new EntropyCodecFactory().newDecoder(bis, dCtx,
+                  (copy == true) ? EntropyCodecFactory.NONE_TYPE : oldEntropyType);
+
+            if (ed.decode(buffer, 0, length) != length)
+               throw new kanzi.io.IOException("Entropy decoding failed", Error.ERR_PROCESS_BLOCK);
+
+            ed.dispose();
+
+            // Keep the sub-stream layout of split blocks
+            EntropyEncoder ee = (split == true) ?
+               new ParallelEntropyEncoder(bos, eCtx, newEntropyType, ((ParallelEntropyDecoder) ed).getChunkSize()) :
+               new EntropyCodecFactory().newEncoder(bos, eCtx,
+                  (copy == true) ? EntropyCodecFactory.NONE_TYPE : newEntropyType);
+
+            if (ee.encode(buffer, 0, length) != length)
+               throw new kanzi.io.IOException("Entropy coding failed", Error.ERR_PROCESS_BLOCK);
+
+            ee.dispose();
+
+            if (header.alignment > 1)
+               CompressedOutputStream.writePadding(bos, lw+bos.written(), header.alignment);
+
+            bos.close();
+            writeBlock(obs, header.blockSize, baos.getBuffer(), bos.written());
+         }
+
+         // Write end block of size 0
+         obs.writeBits(0, lw);
+         obs.close();
+      }
+      catch (BitStreamException e)
+      {
+         throw new kanzi.io.IOException(e.getMessage(), e.getErrorCode());
+      }
+      catch (IllegalArgumentException e)
+      {
+         throw new kanzi.io.IOException(e.getMessage(), Error.ERR_INVALID_CODEC);
+      }
+   }
+
+
+   // Rebuild a regular compressed stream from the manifest of a sharded stream
+   // (see CompressedOutputStream.setShardFactory) and the shards provided by
+   // the factory. The size and checksum of each shard are verified against
+   // the manifest. Return the number of blocks.
+   public static int unshard(InputStream manifest, ShardFactory factory, OutputStream os) throws IOException
+   {
+      if (manifest == null)
+         throw new NullPointerException("Invalid null manifest parameter");
+
+      if (factory == null)
+         throw new NullPointerException("Invalid null shard factory parameter");
+
+      if (os == null)
+         throw new NullPointerException("Invalid null output stream parameter");
+
+      try
+      {
+         InputBitStream ibs = new DefaultInputBitStream(manifest, DEFAULT_BUFFER_SIZE);
+         OutputBitStream obs = new DefaultOutputBitStream(os, DEFAULT_BUFFER_SIZE);
+         Header header = Header.read(ibs);
+         header.write(obs);
+         final int lw = (header.blockSize >= 1<<28) ? 40 : 32;
+         final long[] bits = new long[1];
+         final XXHash32 hasher = new XXHash32(BITSTREAM_TYPE);
+         int blockId = 0;
+
+         while (true)
+         {
+            // Manifest entry: shard size in bits, block length, shard checksum
+            final long shardBits = ibs.readBits(lw);
+
+            if (shardBits == 0)
+               break;
+
+            ibs.readBits(32);
+            final int checksum = (int) ibs.readBits(32);
+            blockId++;
+
+            if (shardBits > 1L<<34)
+               throw new BlockException("Invalid shard size in manifest", Error.ERR_BLOCK_SIZE, blockId);
+
+            byte[] data = new byte[(int) ((shardBits+7) >> 3)];
+            InputStream is = factory.openShard(blockId);
+
+            try
+            {
+               int n = 0;
+
+               while (n < data.length)
+               {
+                  final int r = is.read(data, n, data.length-n);
+
+                  if (r < 0)
+                     break;
+
+                  n += r;
+               }
+
+               if ((n != data.length) || (is.read() >= 0))
+                  throw new BlockException("Shard size does not match the manifest", Error.ERR_READ_FILE, blockId);
+            }
+            finally
+            {
+               is.close();
+            }
+
+            if (hasher.hash(data, 0, data.length) != checksum)
+               throw new BlockException("Shard checksum does not match the manifest", Error.ERR_CRC_CHECK, blockId);
+
+            // The shard holds the block (size and data) as written in a regular stream
+            InputBitStream bis = new DefaultInputBitStream(new ByteArrayInputStream(data), 16384);
+            final byte[] block = readBlock(bis, header.blockSize, bits);
+
+            if ((block == null) || (lw+bits[0] != shardBits))
+               throw new BlockException("Invalid shard content", Error.ERR_INVALID_FILE, blockId);
+
+            writeBlock(obs, header.blockSize, block, bits[0]);
+         }
+
+         // Write end block of size 0
+         obs.writeBits(0, lw);
+         obs.close();
+         return blockId;
+      }
+      catch (BitStreamException e)
+      {
+         throw new kanzi.io.IOException(e.getMessage(), e.getErrorCode());
+      }
+   }
+
+
+   // Compress an input stream of unknown length until the end of stream is
+   // reached and write a complete compressed stream (including the last short
+   // block and the end of stream marker) to the output stream. Missing context
+   // entries are set to their default values (BWT+RANK+ZRLT, ANS0, 1 MB blocks,
+   // 1 job). Neither stream is closed. Return the number of bytes read.
+   public static long compress(InputStream is, OutputStream os, Map<String, Object> ctx) throws IOException
+   {
+      if (is == null)
+         throw new NullPointerException("Invalid null input stream parameter");
+
+      if (os == null)
+         throw new NullPointerException("Invalid null output stream parameter");
+
+      Map<String, Object> cCtx = (ctx == null) ? new HashMap<String, Object>() : new HashMap<>(ctx);
+      cCtx.putIfAbsent("transform", "BWT+RANK+ZRLT");
+      cCtx.putIfAbsent("codec", "ANS0");
+      cCtx.putIfAbsent("blockSize", 1024*1024);
+      cCtx.putIfAbsent("jobs", 1);
+      CompressedOutputStream cos = new CompressedOutputStream(os, cCtx);
+      final byte[] buf = new byte[DEFAULT_BUFFER_SIZE];
+      long read = 0;
+      int n;
+
+      // Short reads are buffered by the compressed stream until a full block
+      // is available
+      while ((n = is.read(buf, 0, buf.length)) != -1)
+      {
+         cos.write(buf, 0, n);
+         read += n;
+      }
+
+      // Emit the last block and the end of stream marker. Not done if reading
+      // failed: a truncated input must not yield a valid compressed stream.
+      cos.close();
+      return read;
+   }
+
+
+   // Decompress a whole stream into the provided buffer. The blocks are decoded
+   // directly into the buffer (the output is not allocated). Return the number
+   // of bytes written. If the buffer is too small, the remaining blocks are
+   // decoded into a block buffer (output discarded) and a ShortBufferException
+   // reporting the decompressed size is thrown.
+   public static int decompressInto(byte[] dst, byte[] src) throws IOException
+   {
+      if (dst == null)
+         throw new NullPointerException("Invalid null destination buffer parameter");
+
+      if (src == null)
+         throw new NullPointerException("Invalid null source buffer parameter");
+
+      InputBitStream ibs = new DefaultInputBitStream(new ByteArrayInputStream(src), 16384);
+
+      try
+      {
+         Header header = Header.read(ibs);
+         Map<String, Object> ctx = header.createContext();
+         BlockChecksum hasher = BlockChecksum.create(header.checksumKind, BITSTREAM_TYPE);
+         final int blkSize = Math.max(header.blockSize+EXTRA_BUFFER_SIZE, header.blockSize+(header.blockSize>>4));
+         final long[] bits = new long[1];
+         byte[] buffer = new byte[0];
+         byte[] saved = new byte[0];
+         byte[] output = null; // only for a block that does not fit
+         long required = -1; // decompressed size once the buffer is too small
+         int written = 0;
+         int blockId = 0;
+
+         while (true)
+         {
+            final byte[] block = readBlock(ibs, header.blockSize, bits);
+            blockId++;
+
+            if (block == null)
+               break;
+
+            InputBitStream bis = new DefaultInputBitStream(new ByteArrayInputStream(block), 16384);
+            final int mode = (int) bis.readBits(8);
+            final boolean copy = (mode & COPY_BLOCK_MASK) != 0;
+            byte skipFlags = 0;
+
+            if (copy == false)
+            {
+               if ((mode & TRANSFORMS_MASK) != 0)
+                  skipFlags = (byte) bis.readBits(8);
+               else
+                  skipFlags = (byte) ((mode<<4) | 0x0F);
+            }
+
+            final int dataSize = 1 + ((mode>>5) & 0x03);
+            final int length = (int) bis.readBits(8*dataSize);
+
+            // Last block is empty
+            if (length == 0)
+               break;
+
+            if ((length < 0) || (length > MAX_BITSTREAM_BLOCK_SIZE))
+               throw new BlockException("Invalid compressed block length: "+length, Error.ERR_READ_FILE, blockId);
+
+            final long checksum1 = (hasher == null) ? 0 : bis.readBits(hasher.getSize());
+
+            if (buffer.length < length)
+               buffer = new byte[length];
+
+            ctx.put("size", length);
+            EntropyDecoder ed;
+
+            if (copy == true)
+               ed = new EntropyCodecFactory().newDecoder(bis, ctx, EntropyCodecFactory.NONE_TYPE);
+            else if (header.splitEntropy == true)
+               ed = new ParallelEntropyDecoder(bis, ctx, header.entropyType);
+            else
+               ed = new EntropyCodecFactory().newDecoder(bis, ctx, header.entropyType);
+
+            try
+            {
+               if (ed.decode(buffer, 0, length) != length)
+                  throw new BlockException("Entropy decoding failed", Error.ERR_PROCESS_BLOCK, blockId);
+            }
+            finally
+            {
+               ed.dispose();
+            }
+
+            final long transformType = (copy == true) ? ByteFunctionFactory.NONE_TYPE : header.transformType;
+            ByteTransformSequence transform = new ByteFunctionFactory().newFunction(ctx, transformType);
+            transform.setSkipFlags(skipFlags);
+            byte[] out = dst;
+            int outIdx = written;
+            int decoded;
+
+            if (required >= 0)
+            {
+               // The buffer is already too small: only compute the decompressed size
+               if (output == null)
+                  output = new byte[blkSize];
+
+               SliceByteArray sa1 = new SliceByteArray(buffer, length, 0);
+               SliceByteArray sa2 = new SliceByteArray(output, 0);
+
+               if (transform.inverse(sa1, sa2) == false)
+                  throw new BlockException("Transform inverse failed", Error.ERR_PROCESS_BLOCK, blockId);
+
+               out = output;
+               outIdx = 0;
+               decoded = sa2.index;
+            }
+            else
+            {
+               final boolean mayNotFit = dst.length - written < blkSize;
+
+               // The inverse transforms may use their input as work buffer: keep a
+               // copy in case the block must be decoded again
+               if (mayNotFit == true)
+               {
+                  if (saved.length < length)
+                     saved = new byte[length];
+
+                  System.arraycopy(buffer, 0, saved, 0, length);
+               }
+
+               // Inverse transform directly into the destination buffer
+               SliceByteArray sa1 = new SliceByteArray(buffer, length, 0);
+               SliceByteArray sa2 = new SliceByteArray(dst, dst.length, written);
+               boolean res;
+
+               try
+               {
+                  res = transform.inverse(sa1, sa2);
+               }
+               catch (ArrayIndexOutOfBoundsException e)
+               {
+                  res = false;
+               }
+
+               decoded = sa2.index - written;
+
+               if (res == false)
+               {
+                  // With enough room, the block is invalid
+                  if (mayNotFit == false)
+                     throw new BlockException("Transform inverse failed", Error.ERR_PROCESS_BLOCK, blockId);
+
+                  // Decode the block into a block buffer to find out whether it fits
+                  if (output == null)
+                     output = new byte[blkSize];
+
+                  transform = new ByteFunctionFactory().newFunction(ctx, transformType);
+                  transform.setSkipFlags(skipFlags);
+                  sa1 = new SliceByteArray(saved, length, 0);
+                  sa2 = new SliceByteArray(output, 0);
+
+                  if (transform.inverse(sa1, sa2) == false)
+                     throw new BlockException("Transform inverse failed", Error.ERR_PROCESS_BLOCK, blockId);
+
+                  out = output;
+                  outIdx = 0;
+                  decoded = sa2.index;
+               }
+            }
+
+            if (hasher != null)
+            {
+               final long checksum2 = hasher.hash(out, outIdx, decoded);
+
+               if (checksum2 != checksum1)
+                  throw new BlockException("Corrupted bitstream: expected checksum " + Long.toHexString(checksum1) +
+                     ", found " + Long.toHexString(checksum2), Error.ERR_CRC_CHECK, blockId);
+            }
+
+            if (required >= 0)
+            {
+               required += decoded;
+            }
+            else if (decoded > dst.length - written)
+            {
+               // First block that does not fit: keep decoding to find the total size
+               required = (long) written + decoded;
+            }
+            else
+            {
+               if (out != dst)
+                  System.arraycopy(output, 0, dst, written, decoded);
+
+               written += decoded;
+            }
+         }
+
+         if (required >= 0)
+            throw new ShortBufferException(dst.length, required);
+
+         return written;
+      }
+      catch (BitStreamException e)
+      {
+         throw new kanzi.io.IOException(e.getMessage(), e.getErrorCode());
+      }
+   }
+
+
+   // Check the integrity of a compressed stream: header, block headers and
+   // block lengths. If the stream has block checksums (computed on the original
+   // data), each block is also decompressed (output discarded) and the checksum
+   // verified. Throw a BlockException identifying the first failing block.
+   public static void verify(InputStream is) throws IOException
+   {
+      if (is == null)
+         throw new NullPointerException("Invalid null input stream parameter");
+
+      Header header;
+      InputBitStream ibs = new DefaultInputBitStream(is, DEFAULT_BUFFER_SIZE);
+
+      try
+      {
+         header = Header.read(ibs);
+      }
+      catch (BitStreamException e)
+      {
+         throw new kanzi.io.IOException("Invalid stream header: "+e.getMessage(), Error.ERR_READ_FILE);
+      }
+
+      Map<String, Object> ctx = header.createContext();
+      BlockChecksum hasher = BlockChecksum.create(header.checksumKind, BITSTREAM_TYPE);
+      final long[] bits = new long[1];
+      byte[] buffer = new byte[0];
+      byte[] output = new byte[0];
+      int blockId = 0;
+
+      while (true)
+      {
+         blockId++;
+         byte[] block;
+
+         try
+         {
+            block = readBlock(ibs, header.blockSize, bits);
+         }
+         catch (BitStreamException e)
+         {
+            throw new BlockException("Truncated stream: "+e.getMessage(), Error.ERR_READ_FILE, blockId);
+         }
+         catch (kanzi.io.IOException e)
+         {
+            throw new BlockException(e.getMessage(), e.getErrorCode(), blockId);
+         }
+
+         if (block == null)
+            break;
+
+         EntropyDecoder ed = null;
+
+         try
+         {
+            InputBitStream bis = new DefaultInputBitStream(new ByteArrayInputStream(block), 16384);
+            final int mode = (int) bis.readBits(8);
+            final boolean copy = (mode & COPY_BLOCK_MASK) != 0;
+            byte skipFlags = 0;
+
+            if (copy == false)
+            {
+               if ((mode & TRANSFORMS_MASK) != 0)
+                  skipFlags = (byte) bis.readBits(8);
+               else
+                  skipFlags = (byte) ((mode<<4) | 0x0F);
+            }
+
+            final int dataSize = 1 + ((mode>>5) & 0x03);
+            final int length = (int) bis.readBits(8*dataSize);
+
+            if ((length < 0) || (length > MAX_BITSTREAM_BLOCK_SIZE))
+               throw new BlockException("Invalid compressed block length: "+length, Error.ERR_READ_FILE, blockId);
+
+            if ((hasher == null) || (length == 0))
+               continue;
+
+            // The checksum is computed on the original data: decompress the block
+            final long checksum1 = bis.readBits(hasher.getSize());
+
+            if (buffer.length < length)
+               buffer = new byte[length];
+
+            if (output.length < Math.max(header.blockSize, block.length))
+               output = new byte[Math.max(header.blockSize, block.length)];
+
+            ctx.put("size", length);
+
+            if (copy == true)
+               ed = new EntropyCodecFactory().newDecoder(bis, ctx, EntropyCodecFactory.NONE_TYPE);
+            else if (header.splitEntropy == true)
+               ed = new ParallelEntropyDecoder(bis, ctx, header.entropyType);
+            else
+               ed = new EntropyCodecFactory().newDecoder(bis, ctx, header.entropyType);
+
+            if (ed.decode(buffer, 0, length) != length)
+               throw new BlockException("Entropy decoding failed", Error.ERR_PROCESS_BLOCK, blockId);
+
+            ByteTransformSequence transform = new ByteFunctionFactory().newFunction(ctx,
+               (copy == true) ? ByteFunctionFactory.NONE_TYPE : header.transformType);
+            transform.setSkipFlags(skipFlags);
+            SliceByteArray sa1 = new SliceByteArray(buffer, length, 0);
+            SliceByteArray sa2 = new SliceByteArray(output, 0);
+
+            if (transform.inverse(sa1, sa2) == false)
+               throw new BlockException("Transform inverse failed", Error.ERR_PROCESS_BLOCK, blockId);
+
+            final long checksum2 = hasher.hash(output, 0, sa2.index);
+
+            if (checksum2 != checksum1)
+               throw new BlockException("Corrupted bitstream: expected checksum " + Long.toHexString(checksum1) +
+                  ", found " + Long.toHexString(checksum2), Error.ERR_CRC_CHECK, blockId);
+         }
+         catch (BlockException e)
+         {
+            throw e;
+         }
+         catch (Exception e)
+         {
+            // Decoding of corrupted data may fail in many ways
+            throw new BlockException(e.getMessage(), Error.ERR_PROCESS_BLOCK, blockId);
+         }
+         finally
+         {
+            if (ed != null)
+               ed.dispose();
+         }
+      }
+   }
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.io;
+
+import java.io.IOException;
+import java.io.InputStream;
+import java.util.Map;
+import kanzi.Error;
+import kanzi.Listener;
+
+
+// Decompress a stream of length delimited frames produced by
+// LengthDelimitedOutputStream. The frame payloads are concatenated and
+// decoded by a CompressedInputStream.
+public class LengthDelimitedInputStream extends InputStream
+{
+   private final CompressedInputStream cis;
+
+
+   public LengthDelimitedInputStream(InputStream is, Map<String, Object> ctx)
+   {
+      if (is == null)
+         throw new NullPointerException("Invalid null input stream parameter");
+
+      this.cis = new CompressedInputStream(new FrameReader(is), ctx);
+   }
+
+
+   public boolean addListener(Listener bl)
+   {
+      return this.cis.addListener(bl);
+   }
+
+
+   public boolean removeListener(Listener bl)
+   {
+      return this.cis.removeListener(bl);
+   }
+
+
+   @Override
+   public int read() throws IOException
+   {
+      return this.cis.read();
+   }
+
+
+   @Override
+   public int read(byte[] data, int off, int len) throws IOException
+   {
+      return this.cis.read(data, off, len);
+   }
+
+
+   @Override
+   public void close() throws IOException
+   {
+      this.cis.close();
+   }
+
+
+   // Read a varint (7 bits per byte, least significant group first).
+   // Return -1 at the end of stream.
+   static long readVarInt(InputStream is) throws IOException
+   {
+      long res = 0;
+
+      for (int shift=0; shift<64; shift+=7)
+      {
+         final int b = is.read();
+
+         if (b < 0)
+         {
+            if (shift == 0)
+               return -1;
+
+            throw new kanzi.io.IOException("Truncated frame length", Error.ERR_READ_FILE);
+         }
+
+         res |= ((long) (b&0x7F)) << shift;
+
+         if (b < 0x80)
+            return res;
+      }
+
+      throw new kanzi.io.IOException("Invalid frame length", Error.ERR_READ_FILE);
+   }
+
+
+   // Concatenate the frame payloads
+   private static class FrameReader extends InputStream
+   {
+      private final InputStream is;
+      private long remaining; // bytes left in the current frame
+
+
+      FrameReader(InputStream is)
+      {
+         this.is = is;
+      }
+
+
+      // Return false at the end of stream
+      private boolean nextFrame() throws IOException
+      {
+         while (this.remaining == 0)
+         {
+            final long len = readVarInt(this.is);
+
+            if (len < 0)
+               return false;
+
+            this.remaining = len;
+         }
+
+         return true;
+      }
+
+
+      @Override
+      public int read() throws IOException
+      {
+         if (this.nextFrame() == false)
+            return -1;
+
+         final int b = this.is.read();
+
+         if (b < 0)
+            throw new kanzi.io.IOException("Truncated frame", Error.ERR_READ_FILE);
+
+         this.remaining--;
+         return b;
+      }
+
+
+      @Override
+      public int read(byte[] data, int off, int len) throws IOException
+      {
+         if (len == 0)
+            return 0;
+
+         if (this.nextFrame() == false)
+            return -1;
+
+         final int r = this.is.read(data, off, (int) Math.min(len, this.remaining));
+
+         if (r < 0)
+            throw new kanzi.io.IOException("Truncated frame", Error.ERR_READ_FILE);
+
+         this.remaining -= r;
+         return r;
+      }
+
+
+      @Override
+      public void close() throws IOException
+      {
+         this.is.close();
+      }
+   }
+}
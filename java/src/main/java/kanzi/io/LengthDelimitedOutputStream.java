@@ -0,0 +1,266 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.io;
+
+import java.io.ByteArrayInputStream;
+import java.io.IOException;
+import java.io.OutputStream;
+import java.util.HashMap;
+import java.util.Map;
+import kanzi.Error;
+import kanzi.Listener;
+import kanzi.bitstream.DefaultInputBitStream;
+
+
+// Compress data like CompressedOutputStream and write the compressed stream
+// as a sequence of length delimited frames (varint length, protobuf style,
+// followed by the frame bytes): the stream header, each compressed block and
+// the end of stream marker. The concatenation of the frames is the regular
+// compressed stream.
+// The blocks must be byte aligned, so the block alignment is at least 2 bytes.
+public class LengthDelimitedOutputStream extends OutputStream
+{
+   private static final int MIN_ALIGNMENT = 2;
+
+   private final CompressedOutputStream cos;
+   private final FrameWriter frames;
+
+
+   public LengthDelimitedOutputStream(OutputStream os, Map<String, Object> ctx)
+   {
+      if (os == null)
+         throw new NullPointerException("Invalid null output stream parameter");
+
+      if (ctx == null)
+         throw new NullPointerException("Invalid null context parameter");
+
+      Map<String, Object> map = new HashMap<>(ctx);
+      final int align = (ctx.containsKey("alignment")) ? (Integer) ctx.get("alignment") : 1;
+      map.put("alignment", Math.max(align, MIN_ALIGNMENT));
+      this.frames = new FrameWriter(os);
+      this.cos = new CompressedOutputStream(this.frames, map);
+   }
+
+
+   public boolean addListener(Listener bl)
+   {
+      return this.cos.addListener(bl);
+   }
+
+
+   public boolean removeListener(Listener bl)
+   {
+      return this.cos.removeListener(bl);
+   }
+
+
+   @Override
+   public void write(int b) throws IOException
+   {
+      this.cos.write(b);
+   }
+
+
+   @Override
+   public void write(byte[] data, int off, int len) throws IOException
+   {
+      this.cos.write(data, off, len);
+   }
+
+
+   // Return the number of frames written so far
+   public int getFrameCount()
+   {
+      return this.frames.count;
+   }
+
+
+   @Override
+   public void close() throws IOException
+   {
+      this.cos.close();
+      this.frames.close();
+   }
+
+
+   // Write a varint (7 bits per byte, least significant group first)
+   static void writeVarInt(OutputStream os, long value) throws IOException
+   {
+      while (value >= 0x80)
+      {
+         os.write((int) (0x80|(value&0x7F)));
+         value >>>= 7;
+      }
+
+      os.write((int) value);
+   }
+
+
+   // Split the compressed stream into frames
+   private static class FrameWriter extends OutputStream
+   {
+      private static final int HEADER_SIZE = 17; // without the optional parameters
+      private static final int MAX_FRAME_SIZE = Integer.MAX_VALUE - 16;
+
+      private final OutputStream os;
+      private byte[] buffer;
+      private int size;
+      private int lengthBytes; // 0 until the header has been processed
+      private int count;
+      private boolean closed;
+
+
+      FrameWriter(OutputStream os)
+      {
+         this.os = os;
+         this.buffer = new byte[65536];
+      }
+
+
+      @Override
+      public void write(int b) throws IOException
+      {
+         this.write(new byte[] { (byte) b }, 0, 1);
+      }
+
+
+      @Override
+      public void write(byte[] data, int off, int len) throws IOException
+      {
+         if (this.closed == true)
+            throw new kanzi.io.IOException("Stream closed", Error.ERR_WRITE_FILE);
+
+         if (this.size+len > this.buffer.length)
+         {
+            byte[] buf = new byte[Math.max(this.size+len, 2*this.buffer.length)];
+            System.arraycopy(this.buffer, 0, buf, 0, this.size);
+            this.buffer = buf;
+         }
+
+         System.arraycopy(data, off, this.buffer, this.size, len);
+         this.size += len;
+         this.process();
+      }
+
+
+      private static int getBits(byte[] buf, int offset, int count)
+      {
+         int res = 0;
+
+         for (int i=offset; i<offset+count; i++)
+            res = (res<<1) | ((buf[i>>3]>>(7-(i&7))) & 1);
+
+         return res;
+      }
+
+
+      // Emit all the complete frames in the buffer
+      private void process() throws IOException
+      {
+         while (true)
+         {
+            if (this.lengthBytes == 0)
+            {
+               // Stream header: fixed fields, optional parameters, padding
+               if (this.size < HEADER_SIZE)
+                  return;
+
+               int hs = HEADER_SIZE;
+
+               if (getBits(this.buffer, 134, 1) == 1)
+               {
+                  if (this.size < HEADER_SIZE+2)
+                     return;
+
+                  hs += 2 + getBits(this.buffer, 8*HEADER_SIZE, 16);
+               }
+
+               final int alignment = 1 << getBits(this.buffer, 125, 5);
+
+               if (alignment < MIN_ALIGNMENT)
+                  throw new kanzi.io.IOException("The compressed blocks are not byte aligned", Error.ERR_INVALID_FILE);
+
+               hs = (hs+alignment-1) & -alignment;
+
+               if (this.size < hs)
+                  return;
+
+               // Validate the header
+               CompressedStreams.Header h = CompressedStreams.Header.read(
+                  new DefaultInputBitStream(new ByteArrayInputStream(this.buffer, 0, hs), 1024));
+               this.lengthBytes = (h.blockSize >= 1<<28) ? 5 : 4;
+               this.emit(hs);
+               continue;
+            }
+
+            // Block: size in bits (padding included), data
+            if (this.size < this.lengthBytes)
+               return;
+
+            long bits = 0;
+
+            for (int i=0; i<this.lengthBytes; i++)
+               bits = (bits<<8) | (this.buffer[i]&0xFF);
+
+            if ((bits & 7) != 0)
+               throw new kanzi.io.IOException("The compressed blocks are not byte aligned", Error.ERR_INVALID_FILE);
+
+            final long frameSize = this.lengthBytes + (bits>>3);
+
+            if (frameSize > MAX_FRAME_SIZE)
+               throw new kanzi.io.IOException("Invalid block size", Error.ERR_BLOCK_SIZE);
+
+            if (this.size < frameSize)
+               return;
+
+            this.emit((int) frameSize);
+         }
+      }
+
+
+      private void emit(int frameSize) throws IOException
+      {
+         writeVarInt(this.os, frameSize);
+         this.os.write(this.buffer, 0, frameSize);
+         this.size -= frameSize;
+         System.arraycopy(this.buffer, frameSize, this.buffer, 0, this.size);
+         this.count++;
+      }
+
+
+      @Override
+      public void flush() throws IOException
+      {
+         this.os.flush();
+      }
+
+
+      @Override
+      public void close() throws IOException
+      {
+         if (this.closed == true)
+            return;
+
+         this.closed = true;
+
+         // Incomplete frame (truncated stream): emit as is
+         if (this.size > 0)
+            this.emit(this.size);
+
+         this.os.flush();
+      }
+   }
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.io;
+
+import java.util.HashMap;
+import java.util.LinkedHashMap;
+import java.util.Map;
+import kanzi.entropy.EntropyCodecFactory;
+import kanzi.function.ByteFunctionFactory;
+
+
+// Immutable set of compression parameters (transform sequence, entropy codec,
+// block size, checksum type and level) validated once and reused to create
+// compressed streams. The transform and entropy names are normalized so that
+// two profiles describing the same stream are equal.
+// A profile is serialized with the same keys and format as the parameters
+// stored in the stream header (see StreamInfo).
+public final class Profile
+{
+   private static final int MIN_BLOCK_SIZE = 1024;
+   private static final int MAX_BLOCK_SIZE = 1024*1024*1024;
+   private static final int MAX_LEVEL = 14;
+
+   private final String transform;
+   private final String entropy;
+   private final int blockSize;
+   private final ChecksumKind checksumKind;
+   private final int level;
+
+
+   // A checksum means an XXHash32 checksum
+   public Profile(String transform, String entropy, int blockSize, boolean checksum)
+   {
+      this(transform, entropy, blockSize, checksum, -1);
+   }
+
+
+   public Profile(String transform, String entropy, int blockSize, boolean checksum, int level)
+   {
+      this(transform, entropy, blockSize, (checksum == true) ? ChecksumKind.XXHASH32 : ChecksumKind.NONE, level);
+   }
+
+
+   // A level of -1 means that no level is recorded in the stream header
+   public Profile(String transform, String entropy, int blockSize, ChecksumKind checksumKind, int level)
+   {
+      if (transform == null)
+         throw new NullPointerException("Invalid null transform type parameter");
+
+      if (entropy == null)
+         throw new NullPointerException("Invalid null entropy encoder type parameter");
+
+      if (checksumKind == null)
+         throw new NullPointerException("Invalid null checksum type parameter");
+
+      if ((blockSize < MIN_BLOCK_SIZE) || (blockSize > MAX_BLOCK_SIZE))
+         throw new IllegalArgumentException("The block size must be in ["+MIN_BLOCK_SIZE+".."+MAX_BLOCK_SIZE+"]");
+
+      if ((blockSize & -16) != blockSize)
+         throw new IllegalArgumentException("The block size must be a multiple of 16");
+
+      if ((level < -1) || (level > MAX_LEVEL))
+         throw new IllegalArgumentException("The compression level must be in [0.."+MAX_LEVEL+"]");
+
+      ByteFunctionFactory bff = new ByteFunctionFactory();
+      this.transform = bff.getName(bff.getType(transform));
+      this.entropy = EntropyCodecFactory.getName(EntropyCodecFactory.getType(entropy));
+      this.blockSize = blockSize;
+      this.checksumKind = checksumKind;
+      this.level = level;
+   }
+
+
+   // Same rules as CompressedOutputStream: XXHash32 if 'checksum' is set,
+   // unless another algorithm is selected with 'checksumKind'
+   private static ChecksumKind toChecksumKind(boolean checksum, Object kind)
+   {
+      if (kind != null)
+         return (kind instanceof ChecksumKind) ? (ChecksumKind) kind : ChecksumKind.getKind(kind.toString());
+
+      return (checksum == true) ? ChecksumKind.XXHASH32 : ChecksumKind.NONE;
+   }
+
+
+   // Create a profile from a context or from the parameters returned by
+   // StreamInfo.getParams()
+   public static Profile fromParams(Map<String, Object> params)
+   {
+      final Integer bSize = (Integer) params.get("blockSize");
+
+      if (bSize == null)
+         throw new IllegalArgumentException("Missing block size parameter");
+
+      return new Profile((String) params.get("transform"), (String) params.get("codec"),
+         bSize, toChecksumKind((Boolean) params.getOrDefault("checksum", false), params.get("checksumKind")),
+         (Integer) params.getOrDefault("level", -1));
+   }
+
+
+   public static Profile fromStreamInfo(StreamInfo info)
+   {
+      return new Profile(info.getTransform(), info.getEntropy(), info.getBlockSize(),
+         info.getChecksumKind(), info.getLevel());
+   }
+
+
+   // Deserialize a profile written by toBytes()
+   public static Profile fromBytes(byte[] blob)
+   {
+      Map<String, String> map = StreamInfo.decodeParams(blob);
+      final String[] keys = { "transform", "codec", "blockSize", "checksum" };
+
+      for (String key : keys)
+      {
+         if (map.containsKey(key) == false)
+            throw new IllegalArgumentException("Missing profile parameter: '"+key+"'");
+      }
+
+      try
+      {
+         final int lvl = (map.containsKey("level")) ? Integer.parseInt(map.get("level")) : -1;
+         return new Profile(map.get("transform"), map.get("codec"), Integer.parseInt(map.get("blockSize")),
+            toChecksumKind(Boolean.parseBoolean(map.get("checksum")), map.get("checksumKind")), lvl);
+      }
+      catch (NumberFormatException e)
+      {
+         throw new IllegalArgumentException("Invalid profile parameter: "+e.getMessage());
+      }
+   }
+
+
+   public String getTransform()
+   {
+      return this.transform;
+   }
+
+
+   public String getEntropy()
+   {
+      return this.entropy;
+   }
+
+
+   public int getBlockSize()
+   {
+      return this.blockSize;
+   }
+
+
+   public boolean hasChecksum()
+   {
+      return this.checksumKind != ChecksumKind.NONE;
+   }
+
+
+   public ChecksumKind getChecksumKind()
+   {
+      return this.checksumKind;
+   }
+
+
+   // Return -1 if no level is recorded
+   public int getLevel()
+   {
+      return this.level;
+   }
+
+
+   // Return the parameters with the same keys as StreamInfo.getParams()
+   public Map<String, Object> getParams()
+   {
+      Map<String, Object> params = new HashMap<>();
+      params.put("transform", this.transform);
+      params.put("codec", this.entropy);
+      params.put("blockSize", this.blockSize);
+      params.put("checksum", this.hasChecksum());
+
+      // XXHash32 is the default checksum
+      if ((this.checksumKind == ChecksumKind.CRC32C) || (this.checksumKind == ChecksumKind.XXHASH64))
+         params.put("checksumKind", this.checksumKind.name());
+
+      if (this.level >= 0)
+         params.put("level", this.level);
+
+      return params;
+   }
+
+
+   // Return a context for CompressedOutputStream (single job)
+   public Map<String, Object> toContext()
+   {
+      Map<String, Object> ctx = this.getParams();
+      ctx.put("jobs", 1);
+      return ctx;
+   }
+
+
+   // Serialize the profile ('key = "value"' lines, see StreamInfo)
+   public byte[] toBytes()
+   {
+      Map<String, String> map = new LinkedHashMap<>();
+      map.put("transform", this.transform);
+      map.put("codec", this.entropy);
+      map.put("blockSize", String.valueOf(this.blockSize));
+      map.put("checksum", String.valueOf(this.hasChecksum()));
+
+      if ((this.checksumKind == ChecksumKind.CRC32C) || (this.checksumKind == ChecksumKind.XXHASH64))
+         map.put("checksumKind", this.checksumKind.name());
+
+      if (this.level >= 0)
+         map.put("level", String.valueOf(this.level));
+
+      return StreamInfo.encodeParams(map);
+   }
+
+
+   @Override
+   public boolean equals(Object o)
+   {
+      if (o == this)
+         return true;
+
+      if ((o instanceof Profile) == false)
+         return false;
+
+      Profile p = (Profile) o;
+      return (this.transform.equals(p.transform)) && (this.entropy.equals(p.entropy)) &&
+         (this.blockSize == p.blockSize) && (this.checksumKind == p.checksumKind) &&
+         (this.level == p.level);
+   }
+
+
+   @Override
+   public int hashCode()
+   {
+      int h = this.transform.hashCode();
+      h = 31*h + this.entropy.hashCode();
+      h = 31*h + this.blockSize;
+      h = 31*h + this.checksumKind.ordinal();
+      return 31*h + this.level;
+   }
+
+
+   @Override
+   public String toString()
+   {
+      return this.getParams().toString();
+   }
+}
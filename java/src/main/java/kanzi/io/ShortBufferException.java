@@ -0,0 +1,42 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.io;
+
+import kanzi.Error;
+
+
+// Destination buffer too small for the decompressed data
+public class ShortBufferException extends IOException
+{
+   private static final long serialVersionUID = -3318620519463727115L;
+
+   private final long requiredSize;
+
+
+   public ShortBufferException(int size, long requiredSize)
+   {
+      super("Destination buffer too small: "+size+" bytes available, "+
+         requiredSize+" bytes required", Error.ERR_SHORT_BUFFER);
+      this.requiredSize = requiredSize;
+   }
+
+
+   // Size of the decompressed data
+   public long getRequiredSize()
+   {
+      return this.requiredSize;
+   }
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.io;
+
+import java.nio.charset.StandardCharsets;
+import java.util.Collections;
+import java.util.HashMap;
+import java.util.LinkedHashMap;
+import java.util.Map;
+import kanzi.function.ByteFunctionFactory;
+
+
+// Description of a compressed stream, as stored in the stream header.
+// The extended parameters are stored in the header as a small TOML like
+// text (one 'key = "value"' line per parameter).
+public final class StreamInfo
+{
+   private final int version;
+   private final ChecksumKind checksumKind;
+   private final String entropy;
+   private final String transform;
+   private final int blockSize;
+   private final int nbInputBlocks;
+   private final int alignment;
+   private final int level;
+   private final Map<String, String> extraParams;
+
+
+   StreamInfo(int version, ChecksumKind checksumKind, String entropy, String transform,
+      int blockSize, int nbInputBlocks, int alignment, int level,
+      Map<String, String> extraParams)
+   {
+      this.version = version;
+      this.checksumKind = checksumKind;
+      this.entropy = entropy;
+      this.transform = transform;
+      this.blockSize = blockSize;
+      this.nbInputBlocks = nbInputBlocks;
+      this.alignment = alignment;
+      this.level = level;
+      this.extraParams = Collections.unmodifiableMap(new LinkedHashMap<>(extraParams));
+   }
+
+
+   public int getVersion()
+   {
+      return this.version;
+   }
+
+
+   public boolean hasChecksum()
+   {
+      return this.checksumKind != ChecksumKind.NONE;
+   }
+
+
+   public ChecksumKind getChecksumKind()
+   {
+      return this.checksumKind;
+   }
+
+
+   public String getEntropy()
+   {
+      return this.entropy;
+   }
+
+
+   public String getTransform()
+   {
+      return this.transform;
+   }
+
+
+   public int getBlockSize()
+   {
+      return this.blockSize;
+   }
+
+
+   // 0 means unknown, 63 means 63 or more
+   public int getNbInputBlocks()
+   {
+      return this.nbInputBlocks;
+   }
+
+
+   public int getAlignment()
+   {
+      return this.alignment;
+   }
+
+
+   // Return -1 if the compression level was not recorded
+   public int getLevel()
+   {
+      return this.level;
+   }
+
+
+   public Map<String, String> getExtraParams()
+   {
+      return this.extraParams;
+   }
+
+
+   // Return the parameters used to create the stream, with the same keys
+   // as the context of CompressedOutputStream (only 'jobs' is missing).
+   public Map<String, Object> getParams()
+   {
+      Map<String, Object> params = new HashMap<>();
+      params.put("transform", this.transform);
+      params.put("codec", this.entropy);
+      params.put("blockSize", this.blockSize);
+      params.put("checksum", this.hasChecksum());
+
+      // XXHash32 is the default checksum
+      if ((this.checksumKind == ChecksumKind.CRC32C) || (this.checksumKind == ChecksumKind.XXHASH64))
+         params.put("checksumKind", this.checksumKind.name());
+
+      if (this.level >= 0)
+         params.put("level", this.level);
+
+      if (this.alignment > 1)
+         params.put("alignment", this.alignment);
+
+      if (this.extraParams.isEmpty() == false)
+         params.put("params", this.extraParams);
+
+      // Parameters of the inverse transforms (validated when the header is read)
+      for (String key : ByteFunctionFactory.getInverseParameters(new ByteFunctionFactory().getType(this.transform)))
+      {
+         if (this.extraParams.containsKey(key))
+            params.put(key, Integer.valueOf(this.extraParams.get(key)));
+      }
+
+      return params;
+   }
+
+
+   static byte[] encodeParams(Map<String, String> params)
+   {
+      StringBuilder sb = new StringBuilder(100);
+
+      for (Map.Entry<String, String> entry : params.entrySet())
+      {
+         final String key = entry.getKey();
+         final String value = entry.getValue();
+
+         if ((key.isEmpty() == true) || (key.matches("[A-Za-z0-9_.]+") == false))
+            throw new IllegalArgumentException("Invalid parameter name: '"+key+"'");
+
+         if ((value.indexOf('"') >= 0) || (value.indexOf('\n') >= 0))
+            throw new IllegalArgumentException("Invalid value for parameter '"+key+"'");
+
+         sb.append(key).append(" = \"").append(value).append("\"\n");
+      }
+
+      return sb.toString().getBytes(StandardCharsets.UTF_8);
+   }
+
+
+   static Map<String, String> decodeParams(byte[] blob)
+   {
+      Map<String, String> params = new LinkedHashMap<>();
+      String[] lines = new String(blob, StandardCharsets.UTF_8).split("\n");
+
+      for (String line : lines)
+      {
+         final int idx = line.indexOf('=');
+
+         if (idx <= 0)
+            continue;
+
+         final String key = line.substring(0, idx).trim();
+         String value = line.substring(idx+1).trim();
+
+         if ((value.length() >= 2) && (value.charAt(0) == '"') && (value.charAt(value.length()-1) == '"'))
+            value = value.substring(1, value.length()-1);
+
+         params.put(key, value);
+      }
+
+      return params;
+   }
+}
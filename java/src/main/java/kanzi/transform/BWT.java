@@ -62,6 +62,9 @@ import kanzi.SliceByteArray;
 // This implementation extends the canonical algorithm to use up to MAX_CHUNKS primary
 // indexes (based on input block size). Each primary index corresponds to a data chunk.
 // Chunks may be inverted concurrently.
+// The forward transform (suffix sorting) is sequential and the chunks only depend
+// on the block size, so the output (data and primary indexes) is the same for any
+// number of jobs and from run to run: there is no random or timing dependent choice.
 
 public class BWT implements ByteTransform
 {
@@ -79,6 +82,8 @@ public class BWT implements ByteTransform
    private DivSufSort saAlgo;
    private final ExecutorService pool;
    private final int jobs;
+   private final boolean lowMemory;
+   private final Object lock; // null if the low memory instances are not serialized
 
 
    // Static allocation of memory
@@ -91,6 +96,8 @@ public class BWT implements ByteTransform
       this.primaryIndexes = new int[8];
       this.pool = null;
       this.jobs = 1;
+      this.lowMemory = false;
+      this.lock = null;
    }
 
 
@@ -114,6 +121,36 @@ public class BWT implements ByteTransform
       this.primaryIndexes = new int[8];
       this.pool = (tasks == 1) ? null : threadPool;
       this.jobs = tasks;
+      // In low memory mode, the work buffers are released after each block.
+      // The instances sharing the lock provided in the context (EG. all the
+      // blocks of a stream) also run the suffix sorting and the inversion one
+      // at a time.
+      this.lowMemory = (Boolean) ctx.getOrDefault("bwtLowMemory", false);
+      this.lock = (this.lowMemory == true) ? ctx.get("bwtLock") : null;
+   }
+
+
+   // Return the estimated peak working memory (in bytes) of one forward
+   // transform: suffix array (4 bytes per symbol) and copy of the input used
+   // by the suffix sorting (2 bytes per symbol).
+   public static long getWorkingSetSize(int blockSize)
+   {
+      return 6L * blockSize + 4L * (256+65536);
+   }
+
+
+   public boolean isLowMemory()
+   {
+      return this.lowMemory;
+   }
+
+
+   private void releaseBuffers()
+   {
+      this.buffer1 = new int[0];
+      this.buffer2 = new short[0];
+      this.buckets = new int[256];
+      this.saAlgo = null;
    }
 
 
@@ -137,6 +174,34 @@ public class BWT implements ByteTransform
    // Not thread safe
    @Override
    public boolean forward(SliceByteArray src, SliceByteArray dst)
+   {
+      if (this.lowMemory == false)
+         return this.forwardBlock(src, dst);
+
+      if (this.lock == null)
+         return this.processLowMemory(src, dst, true);
+
+      synchronized (this.lock)
+      {
+         return this.processLowMemory(src, dst, true);
+      }
+   }
+
+
+   private boolean processLowMemory(SliceByteArray src, SliceByteArray dst, boolean forward)
+   {
+      try
+      {
+         return (forward == true) ? this.forwardBlock(src, dst) : this.inverseBlock(src, dst);
+      }
+      finally
+      {
+         this.releaseBuffers();
+      }
+   }
+
+
+   private boolean forwardBlock(SliceByteArray src, SliceByteArray dst)
    {
       if (src.length == 0)
          return true;
@@ -238,6 +303,21 @@ public class BWT implements ByteTransform
    // Not thread safe
    @Override
    public boolean inverse(SliceByteArray src, SliceByteArray dst)
+   {
+      if (this.lowMemory == false)
+         return this.inverseBlock(src, dst);
+
+      if (this.lock == null)
+         return this.processLowMemory(src, dst, false);
+
+      synchronized (this.lock)
+      {
+         return this.processLowMemory(src, dst, false);
+      }
+   }
+
+
+   private boolean inverseBlock(SliceByteArray src, SliceByteArray dst)
    {
       if (src.length == 0)
          return true;
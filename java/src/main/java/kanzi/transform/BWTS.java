@@ -53,6 +53,14 @@ public class BWTS implements ByteTransform
    }
 
 
+   // Return the estimated peak working memory (in bytes) of one forward
+   // transform: suffix array and inverse suffix array (4 bytes per symbol each).
+   public static long getWorkingSetSize(int blockSize)
+   {
+      return 8L * blockSize + 4L * (256+65536);
+   }
+
+
    // Not thread safe
    @Override
    public boolean forward(SliceByteArray src, SliceByteArray dst)
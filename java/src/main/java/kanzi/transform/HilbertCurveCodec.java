@@ -0,0 +1,180 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.transform;
+
+import java.util.Map;
+import kanzi.ByteTransform;
+import kanzi.SliceByteArray;
+
+
+// Reordering of 2D data (width x height bytes, row major) along a Hilbert
+// curve. Consecutive bytes in the output are spatial neighbors which helps
+// the next stages (delta, entropy).
+// If the dimensions are not powers of two, the curve covers the smallest
+// enclosing power of two square and the points outside of the image are
+// skipped (implicit padding, no data is added).
+// The block is processed as a sequence of images. The remaining bytes
+// (ragged tail, block size % (width*height)) are copied as is. The inverse
+// must be provided the same dimensions.
+public class HilbertCurveCodec implements ByteTransform
+{
+   private static final int MAX_SIDE = 1 << 15;
+
+   private final int width;
+   private final int height;
+   private int[] order; // output position => input position
+
+
+   public HilbertCurveCodec(int width, int height)
+   {
+      if ((width < 1) || (height < 1) || (width > MAX_SIDE) || (height > MAX_SIDE))
+         throw new IllegalArgumentException("Hilbert curve codec: Invalid dimensions: "+width+"x"+height+
+            " (must be in [1.."+MAX_SIDE+"])");
+
+      this.width = width;
+      this.height = height;
+      this.order = new int[0];
+   }
+
+
+   public HilbertCurveCodec(Map<String, Object> ctx)
+   {
+      this((Integer) ctx.getOrDefault("hilbertWidth", 256),
+         (Integer) ctx.getOrDefault("hilbertHeight", 256));
+   }
+
+
+   // Compute the position in the image of each point of the curve
+   private int[] getOrder()
+   {
+      final int size = this.width * this.height;
+
+      if (this.order.length == size)
+         return this.order;
+
+      int n = 1;
+
+      while ((n < this.width) || (n < this.height))
+         n <<= 1;
+
+      final int[] res = new int[size];
+      final long nn = (long) n * n;
+      int k = 0;
+
+      for (long d=0; d<nn; d++)
+      {
+         // Convert distance along the curve to coordinates
+         long t = d;
+         int x = 0;
+         int y = 0;
+
+         for (int s=1; s<n; s<<=1)
+         {
+            final int rx = (int) ((t>>1) & 1);
+            final int ry = (int) ((t^rx) & 1);
+
+            // Rotate quadrant
+            if (ry == 0)
+            {
+               if (rx == 1)
+               {
+                  x = s - 1 - x;
+                  y = s - 1 - y;
+               }
+
+               final int tmp = x;
+               x = y;
+               y = tmp;
+            }
+
+            x += s * rx;
+            y += s * ry;
+            t >>= 2;
+         }
+
+         if ((x < this.width) && (y < this.height))
+            res[k++] = y*this.width + x;
+      }
+
+      this.order = res;
+      return res;
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      return this.process(input, output, true);
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      return this.process(input, output, false);
+   }
+
+
+   private boolean process(SliceByteArray input, SliceByteArray output, boolean forward)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+      final long size = (long) this.width * this.height;
+
+      // At least one full image is required
+      if (count < size)
+         return false;
+
+      if (output.length - output.index < count)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int dstIdx = output.index;
+      final int sz = (int) size;
+      final int end = (count/sz) * sz;
+      final int[] ord = this.getOrder();
+
+      for (int n=0; n<end; n+=sz)
+      {
+         final int s = srcIdx + n;
+         final int d = dstIdx + n;
+
+         if (forward == true)
+         {
+            for (int i=0; i<sz; i++)
+               dst[d+i] = src[s+ord[i]];
+         }
+         else
+         {
+            for (int i=0; i<sz; i++)
+               dst[d+ord[i]] = src[s+i];
+         }
+      }
+
+      // Ragged tail
+      System.arraycopy(src, srcIdx+end, dst, dstIdx+end, count-end);
+      input.index += count;
+      output.index += count;
+      return true;
+   }
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.transform;
+
+import java.util.Map;
+import kanzi.ByteTransform;
+import kanzi.SliceByteArray;
+
+
+// Grouping of the bytes of a block by lane (position modulo the number of
+// lanes), EG. for data laid out for N-lane SIMD processing: all the bytes of
+// lane 0 come first, then the bytes of lane 1 and so on. Unlike a transposition
+// of fixed size matrices, the whole block is grouped. With a ragged tail
+// (block size % lanes != 0), the first lanes get one more byte than the others,
+// so the lane sizes are implied by the block size.
+// The output size is the input size. The inverse must be provided the same
+// number of lanes.
+public class LaneGroupCodec implements ByteTransform
+{
+   private static final int MAX_LANES = 1 << 16;
+
+   private final int lanes;
+
+
+   public LaneGroupCodec(int lanes)
+   {
+      if ((lanes < 1) || (lanes > MAX_LANES))
+         throw new IllegalArgumentException("Lane group codec: Invalid number of lanes: "+lanes+
+            " (must be in [1.."+MAX_LANES+"])");
+
+      this.lanes = lanes;
+   }
+
+
+   public LaneGroupCodec(Map<String, Object> ctx)
+   {
+      this((Integer) ctx.getOrDefault("laneCount", 4));
+   }
+
+
+   public int getLanes()
+   {
+      return this.lanes;
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      return this.process(input, output, true);
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      return this.process(input, output, false);
+   }
+
+
+   private boolean process(SliceByteArray input, SliceByteArray output, boolean forward)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (output.length - output.index < count)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int dstIdx = output.index;
+      final int n = Math.min(this.lanes, count);
+      final int q = count / n;
+      final int r = count % n;
+      int laneStart = 0;
+
+      for (int k=0; k<n; k++)
+      {
+         // The first r lanes have one more byte
+         final int laneSize = (k < r) ? q+1 : q;
+
+         if (forward == true)
+         {
+            for (int i=0, j=k; i<laneSize; i++, j+=n)
+               dst[dstIdx+laneStart+i] = src[srcIdx+j];
+         }
+         else
+         {
+            for (int i=0, j=k; i<laneSize; i++, j+=n)
+               dst[dstIdx+j] = src[srcIdx+laneStart+i];
+         }
+
+         laneStart += laneSize;
+      }
+
+      input.index += count;
+      output.index += count;
+      return true;
+   }
+}
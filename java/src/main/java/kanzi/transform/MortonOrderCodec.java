@@ -0,0 +1,169 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.transform;
+
+import java.util.Map;
+import kanzi.ByteTransform;
+import kanzi.SliceByteArray;
+
+
+// Reordering of 2D data (width x height bytes, row major) along a Morton
+// (Z-order) curve: the position on the curve is obtained by interleaving the
+// bits of the coordinates. The locality is not as good as with a Hilbert
+// curve (see HilbertCurveCodec) but the order is much cheaper to compute.
+// If the dimensions are not powers of two, the curve covers the smallest
+// enclosing power of two square and the points outside of the image are
+// skipped (implicit padding, no data is added, so the size of the data is
+// unchanged and nothing needs to be recorded).
+// The block is processed as a sequence of images. The remaining bytes
+// (ragged tail, block size % (width*height)) are copied as is. The inverse
+// must be provided the same dimensions.
+public class MortonOrderCodec implements ByteTransform
+{
+   private static final int MAX_SIDE = 1 << 15;
+
+   private final int width;
+   private final int height;
+   private int[] order; // output position => input position
+
+
+   public MortonOrderCodec(int width, int height)
+   {
+      if ((width < 1) || (height < 1) || (width > MAX_SIDE) || (height > MAX_SIDE))
+         throw new IllegalArgumentException("Morton order codec: Invalid dimensions: "+width+"x"+height+
+            " (must be in [1.."+MAX_SIDE+"])");
+
+      this.width = width;
+      this.height = height;
+      this.order = new int[0];
+   }
+
+
+   public MortonOrderCodec(Map<String, Object> ctx)
+   {
+      this((Integer) ctx.getOrDefault("mortonWidth", 256),
+         (Integer) ctx.getOrDefault("mortonHeight", 256));
+   }
+
+
+   // Keep the even bits of x and pack them in the lower half
+   private static int compact(long x)
+   {
+      x &= 0x5555555555555555L;
+      x = (x | (x >>> 1)) & 0x3333333333333333L;
+      x = (x | (x >>> 2)) & 0x0F0F0F0F0F0F0F0FL;
+      x = (x | (x >>> 4)) & 0x00FF00FF00FF00FFL;
+      x = (x | (x >>> 8)) & 0x0000FFFF0000FFFFL;
+      return (int) x;
+   }
+
+
+   // Compute the position in the image of each point of the curve
+   private int[] getOrder()
+   {
+      final int size = this.width * this.height;
+
+      if (this.order.length == size)
+         return this.order;
+
+      int n = 1;
+
+      while ((n < this.width) || (n < this.height))
+         n <<= 1;
+
+      final int[] res = new int[size];
+      final long nn = (long) n * n;
+      int k = 0;
+
+      for (long d=0; d<nn; d++)
+      {
+         // Even bits => x, odd bits => y
+         final int x = compact(d);
+         final int y = compact(d >>> 1);
+
+         if ((x < this.width) && (y < this.height))
+            res[k++] = y*this.width + x;
+      }
+
+      this.order = res;
+      return res;
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      return this.process(input, output, true);
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      return this.process(input, output, false);
+   }
+
+
+   private boolean process(SliceByteArray input, SliceByteArray output, boolean forward)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+      final long size = (long) this.width * this.height;
+
+      // At least one full image is required
+      if (count < size)
+         return false;
+
+      if (output.length - output.index < count)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int dstIdx = output.index;
+      final int sz = (int) size;
+      final int end = (count/sz) * sz;
+      final int[] ord = this.getOrder();
+
+      for (int n=0; n<end; n+=sz)
+      {
+         final int s = srcIdx + n;
+         final int d = dstIdx + n;
+
+         if (forward == true)
+         {
+            for (int i=0; i<sz; i++)
+               dst[d+i] = src[s+ord[i]];
+         }
+         else
+         {
+            for (int i=0; i<sz; i++)
+               dst[d+ord[i]] = src[s+i];
+         }
+      }
+
+      // Ragged tail
+      System.arraycopy(src, srcIdx+end, dst, dstIdx+end, count-end);
+      input.index += count;
+      output.index += count;
+      return true;
+   }
+}
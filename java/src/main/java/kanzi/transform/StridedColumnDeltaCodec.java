@@ -0,0 +1,112 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.transform;
+
+import java.util.Map;
+import kanzi.ByteTransform;
+import kanzi.SliceByteArray;
+
+
+// Column wise delta of fixed size records (EG. rows of a table of numeric
+// values): each byte is replaced by the difference (modulo 256) with the byte
+// at the same position in the previous record. The first record is copied
+// as is and a ragged final record is processed like the others (its bytes
+// have a byte above in the previous record).
+// The output size is the input size. The inverse must be provided the same
+// stride.
+public class StridedColumnDeltaCodec implements ByteTransform
+{
+   private final int stride;
+
+
+   public StridedColumnDeltaCodec(int stride)
+   {
+      if (stride < 1)
+         throw new IllegalArgumentException("Strided column delta codec: Invalid stride: "+stride);
+
+      this.stride = stride;
+   }
+
+
+   public StridedColumnDeltaCodec(Map<String, Object> ctx)
+   {
+      this((Integer) ctx.getOrDefault("columnStride", 4));
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (output.length - output.index < count)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int dstIdx = output.index;
+      final int st = Math.min(this.stride, count);
+
+      // First record
+      System.arraycopy(src, srcIdx, dst, dstIdx, st);
+
+      for (int i=st; i<count; i++)
+         dst[dstIdx+i] = (byte) (src[srcIdx+i] - src[srcIdx+i-st]);
+
+      input.index += count;
+      output.index += count;
+      return true;
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+
+      if (output.length - output.index < count)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int dstIdx = output.index;
+      final int st = Math.min(this.stride, count);
+
+      // First record
+      System.arraycopy(src, srcIdx, dst, dstIdx, st);
+
+      for (int i=st; i<count; i++)
+         dst[dstIdx+i] = (byte) (src[srcIdx+i] + dst[dstIdx+i-st]);
+
+      input.index += count;
+      output.index += count;
+      return true;
+   }
+}
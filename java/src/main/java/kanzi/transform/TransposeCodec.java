@@ -0,0 +1,119 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.transform;
+
+import java.util.Map;
+import kanzi.ByteTransform;
+import kanzi.SliceByteArray;
+
+
+// Transposition of row major matrices of bytes (rows x cols) into column
+// major order. It groups the bytes of each column (EG. same byte of numeric
+// values in a table) to help the next stages (delta, entropy).
+// The block is processed as a sequence of matrices. The remaining bytes
+// (ragged tail, block size % (rows*cols)) are copied as is. The output size
+// is the input size so the tail size is implied by the block size.
+// The inverse must be provided the same dimensions.
+public class TransposeCodec implements ByteTransform
+{
+   private final int rows;
+   private final int cols;
+
+
+   public TransposeCodec(int rows, int cols)
+   {
+      if ((rows < 1) || (cols < 1))
+         throw new IllegalArgumentException("Transpose codec: Invalid dimensions: "+rows+"x"+cols);
+
+      if ((long) rows*cols > Integer.MAX_VALUE)
+         throw new IllegalArgumentException("Transpose codec: The matrix is too large: "+rows+"x"+cols);
+
+      this.rows = rows;
+      this.cols = cols;
+   }
+
+
+   public TransposeCodec(Map<String, Object> ctx)
+   {
+      this((Integer) ctx.getOrDefault("transposeRows", 256),
+         (Integer) ctx.getOrDefault("transposeCols", 4));
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      return this.process(input, output, true);
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      return this.process(input, output, false);
+   }
+
+
+   private boolean process(SliceByteArray input, SliceByteArray output, boolean forward)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+      final int size = this.rows * this.cols;
+
+      // At least one full matrix is required
+      if (count < size)
+         return false;
+
+      if (output.length - output.index < count)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int dstIdx = output.index;
+      final int end = (count/size) * size;
+
+      // Forward: row major (rows x cols) => column major
+      // Inverse: row major (cols x rows) => column major
+      final int r = (forward == true) ? this.rows : this.cols;
+      final int c = (forward == true) ? this.cols : this.rows;
+
+      for (int n=0; n<end; n+=size)
+      {
+         final int s = srcIdx + n;
+         final int d = dstIdx + n;
+
+         for (int i=0; i<r; i++)
+         {
+            final int s0 = s + i*c;
+
+            for (int j=0; j<c; j++)
+               dst[d+j*r+i] = src[s0+j];
+         }
+      }
+
+      // Ragged tail
+      System.arraycopy(src, srcIdx+end, dst, dstIdx+end, count-end);
+      input.index += count;
+      output.index += count;
+      return true;
+   }
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.transform;
+
+import java.util.Map;
+import kanzi.ByteTransform;
+import kanzi.SliceByteArray;
+
+
+// Reordering of serialized DCT coefficients (dim x dim coefficients per block,
+// row major, 'width' bytes per coefficient) in zig-zag scan order (the JPEG
+// ordering): the coefficients are visited along the anti-diagonals, from the
+// DC coefficient to the highest frequencies, so that the low frequency
+// coefficients are grouped at the beginning of the block and the (mostly
+// zero) high frequency coefficients at the end. The bytes of a coefficient
+// are kept together, in the original byte order.
+// The data is processed as a sequence of coefficient blocks. The remaining
+// bytes (ragged tail, data size % (dim*dim*width)) are copied as is. The
+// output size is the input size. The inverse must be provided the same
+// dimension and coefficient width.
+public class ZigZagScanCodec implements ByteTransform
+{
+   private static final int MAX_DIM = 256;
+
+   private final int dim;
+   private final int width;
+   private final int[] order; // scan position => raster position
+
+
+   public ZigZagScanCodec(int dim, int width)
+   {
+      if ((dim < 1) || (dim > MAX_DIM))
+         throw new IllegalArgumentException("Zig-zag scan codec: Invalid block dimension: "+dim+
+            " (must be in [1.."+MAX_DIM+"])");
+
+      if ((width != 1) && (width != 2) && (width != 4))
+         throw new IllegalArgumentException("Zig-zag scan codec: Invalid coefficient width: "+width+
+            " (must be 1, 2 or 4)");
+
+      this.dim = dim;
+      this.width = width;
+      this.order = computeOrder(dim);
+   }
+
+
+   public ZigZagScanCodec(Map<String, Object> ctx)
+   {
+      this((Integer) ctx.getOrDefault("zigzagDim", 8),
+         (Integer) ctx.getOrDefault("zigzagWidth", 2));
+   }
+
+
+   // Compute the raster position of each coefficient of the scan: even
+   // diagonals are scanned upwards (decreasing row), odd diagonals downwards
+   static int[] computeOrder(int dim)
+   {
+      final int[] res = new int[dim*dim];
+      int k = 0;
+
+      for (int s=0; s<2*dim-1; s++)
+      {
+         final int lo = Math.max(0, s-dim+1);
+         final int hi = Math.min(s, dim-1);
+
+         if ((s & 1) == 0)
+         {
+            for (int row=hi; row>=lo; row--)
+               res[k++] = row*dim + (s-row);
+         }
+         else
+         {
+            for (int row=lo; row<=hi; row++)
+               res[k++] = row*dim + (s-row);
+         }
+      }
+
+      return res;
+   }
+
+
+   @Override
+   public boolean forward(SliceByteArray input, SliceByteArray output)
+   {
+      return this.process(input, output, true);
+   }
+
+
+   @Override
+   public boolean inverse(SliceByteArray input, SliceByteArray output)
+   {
+      return this.process(input, output, false);
+   }
+
+
+   private boolean process(SliceByteArray input, SliceByteArray output, boolean forward)
+   {
+      if (input.length == 0)
+         return true;
+
+      if (input.array == output.array)
+         return false;
+
+      final int count = input.length;
+      final int size = this.dim * this.dim * this.width;
+
+      // At least one full block is required
+      if (count < size)
+         return false;
+
+      if (output.length - output.index < count)
+         return false;
+
+      final byte[] src = input.array;
+      final byte[] dst = output.array;
+      final int srcIdx = input.index;
+      final int dstIdx = output.index;
+      final int end = (count/size) * size;
+      final int[] ord = this.order;
+      final int w = this.width;
+
+      for (int n=0; n<end; n+=size)
+      {
+         final int s = srcIdx + n;
+         final int d = dstIdx + n;
+
+         for (int k=0; k<ord.length; k++)
+         {
+            // Forward: scan position k <= raster position ord[k]
+            final int from = (forward == true) ? s + ord[k]*w : s + k*w;
+            final int to = (forward == true) ? d + k*w : d + ord[k]*w;
+
+            for (int j=0; j<w; j++)
+               dst[to+j] = src[from+j];
+         }
+      }
+
+      // Ragged tail
+      System.arraycopy(src, srcIdx+end, dst, dstIdx+end, count-end);
+      input.index += count;
+      output.index += count;
+      return true;
+   }
+}
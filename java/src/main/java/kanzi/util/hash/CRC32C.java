@@ -0,0 +1,85 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.util.hash;
+
+
+// CRC-32C (Castagnoli polynomial, as used by iSCSI, ext4, SSE 4.2 crc32
+// instruction). Table driven implementation processing 8 bytes per step
+// (slicing by 8).
+
+public class CRC32C
+{
+  private static final int POLYNOMIAL = 0x82F63B78; // reversed 0x1EDC6F41
+  private static final int[][] TABLES = new int[8][256];
+
+  static
+  {
+     for (int i=0; i<256; i++)
+     {
+        int crc = i;
+
+        for (int j=0; j<8; j++)
+           crc = (crc >>> 1) ^ ((crc & 1) * POLYNOMIAL);
+
+        TABLES[0][i] = crc;
+     }
+
+     for (int i=0; i<256; i++)
+     {
+        for (int t=1; t<8; t++)
+           TABLES[t][i] = (TABLES[t-1][i] >>> 8) ^ TABLES[0][TABLES[t-1][i] & 0xFF];
+     }
+  }
+
+
+  public CRC32C()
+  {
+  }
+
+
+  public int hash(byte[] data)
+  {
+     return this.hash(data, 0, data.length);
+  }
+
+
+  public int hash(byte[] data, int offset, int length)
+  {
+     final int[] t0 = TABLES[0], t1 = TABLES[1], t2 = TABLES[2], t3 = TABLES[3];
+     final int[] t4 = TABLES[4], t5 = TABLES[5], t6 = TABLES[6], t7 = TABLES[7];
+     final int end = offset + length;
+     final int end8 = offset + (length & -8);
+     int crc = -1;
+     int idx = offset;
+
+     while (idx < end8)
+     {
+        final int lo = crc ^ ((data[idx]&0xFF) | ((data[idx+1]&0xFF)<<8) |
+           ((data[idx+2]&0xFF)<<16) | ((data[idx+3]&0xFF)<<24));
+        crc = t7[lo&0xFF] ^ t6[(lo>>>8)&0xFF] ^ t5[(lo>>>16)&0xFF] ^ t4[lo>>>24] ^
+           t3[data[idx+4]&0xFF] ^ t2[data[idx+5]&0xFF] ^ t1[data[idx+6]&0xFF] ^ t0[data[idx+7]&0xFF];
+        idx += 8;
+     }
+
+     while (idx < end)
+     {
+        crc = (crc >>> 8) ^ t0[(crc ^ data[idx]) & 0xFF];
+        idx++;
+     }
+
+     return ~crc;
+  }
+}
@@ -15,7 +15,12 @@ limitations under the License.
 
 package kanzi.test;
 
+import java.util.Arrays;
+import java.util.HashMap;
+import java.util.Map;
 import java.util.Random;
+import java.util.concurrent.ExecutorService;
+import java.util.concurrent.Executors;
 import kanzi.ByteTransform;
 import kanzi.SliceByteArray;
 import kanzi.transform.BWT;
@@ -31,6 +36,7 @@ public class TestBWT
    {
       Assert.assertTrue(testCorrectness(true, 200));
       Assert.assertTrue(testCorrectness(false, 200));
+      Assert.assertTrue(testDeterminism(10));
    }
    
    
@@ -61,6 +67,9 @@ public class TestBWT
       
       if (testCorrectness(false, 20) == false)
          System.exit(1);
+
+      if (testDeterminism(50) == false)
+         System.exit(1);
       
       testSpeed(true);
       testSpeed(false);
@@ -186,6 +195,90 @@ public class TestBWT
    }
     
     
+   // The output of the BWT (data and primary indexes) must not depend on the
+   // number of jobs nor change from run to run.
+   public static boolean testDeterminism(int iters)
+   {
+      System.out.println("\nBWT determinism test");
+      ExecutorService pool = Executors.newFixedThreadPool(8);
+
+      try
+      {
+         // Large enough for several chunks, repetitive to stress the sort
+         final int size = 1 << 20;
+         byte[] input = new byte[size];
+         Random rnd = new Random(251);
+
+         for (int i=0; i<size; )
+         {
+            final int len = Math.min(1+rnd.nextInt(64), size-i);
+
+            if ((i > 4096) && (rnd.nextInt(4) != 0))
+               System.arraycopy(input, rnd.nextInt(i-len), input, i, len);
+            else
+               for (int j=0; j<len; j++)
+                  input[i+j] = (byte) (65 + rnd.nextInt(4));
+
+            i += len;
+         }
+
+         // Reference: serial BWT
+         BWT bwt = new BWT();
+         byte[] ref = new byte[size];
+         bwt.forward(new SliceByteArray(input, 0), new SliceByteArray(ref, 0));
+         final int[] refIndexes = new int[8];
+
+         for (int i=0; i<refIndexes.length; i++)
+            refIndexes[i] = bwt.getPrimaryIndex(i);
+
+         for (int jobs : new int[] { 1, 2, 3, 4, 8 })
+         {
+            for (int ii=0; ii<iters; ii++)
+            {
+               Map<String, Object> ctx = new HashMap<>();
+               ctx.put("jobs", jobs);
+               ctx.put("pool", pool);
+               bwt = new BWT(ctx);
+               byte[] output = new byte[size];
+               byte[] reverse = new byte[size];
+               bwt.forward(new SliceByteArray(input, 0), new SliceByteArray(output, 0));
+
+               if (Arrays.equals(ref, output) == false)
+               {
+                  System.out.println("Jobs="+jobs+", run "+ii+": different output");
+                  return false;
+               }
+
+               for (int i=0; i<refIndexes.length; i++)
+               {
+                  if (bwt.getPrimaryIndex(i) != refIndexes[i])
+                  {
+                     System.out.println("Jobs="+jobs+", run "+ii+": different primary index "+i);
+                     return false;
+                  }
+               }
+
+               bwt.inverse(new SliceByteArray(output, 0), new SliceByteArray(reverse, 0));
+
+               if (Arrays.equals(input, reverse) == false)
+               {
+                  System.out.println("Jobs="+jobs+", run "+ii+": different output after inverse");
+                  return false;
+               }
+            }
+
+            System.out.println("Jobs="+jobs+": "+iters+" identical runs");
+         }
+
+         return true;
+      }
+      finally
+      {
+         pool.shutdown();
+      }
+   }
+
+
    public static void testSpeed(boolean isBWT)
    {
       System.out.println("\nBWT"+(!isBWT?"S":"")+" Speed test");
@@ -0,0 +1,2555 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi.test;
+
+import java.io.BufferedInputStream;
+import java.io.ByteArrayInputStream;
+import java.io.ByteArrayOutputStream;
+import java.io.File;
+import java.io.FilterInputStream;
+import java.io.IOException;
+import java.io.InputStream;
+import java.io.OutputStream;
+import java.lang.management.ManagementFactory;
+import java.lang.management.ThreadMXBean;
+import java.nio.file.Files;
+import java.util.Arrays;
+import java.util.ArrayList;
+import java.util.HashMap;
+import java.util.List;
+import java.util.Map;
+import java.util.Random;
+import java.util.TreeMap;
+import java.util.concurrent.ExecutorService;
+import java.util.concurrent.Executors;
+import kanzi.BoundaryDetector;
+import kanzi.Event;
+import kanzi.Listener;
+import kanzi.MetricsSink;
+import kanzi.ShardFactory;
+import kanzi.SliceByteArray;
+import kanzi.app.BlockCompressor;
+import kanzi.bitstream.DefaultInputBitStream;
+import kanzi.entropy.EntropyCodecFactory;
+import kanzi.function.AnytimeCodec;
+import kanzi.function.ByteFunctionFactory;
+import kanzi.io.BlockException;
+import kanzi.io.ChecksumKind;
+import kanzi.io.CompressedInputStream;
+import kanzi.io.CompressedOutputStream;
+import kanzi.io.CompressedStreams;
+import kanzi.io.LengthDelimitedInputStream;
+import kanzi.io.LengthDelimitedOutputStream;
+import kanzi.io.Profile;
+import kanzi.io.ShortBufferException;
+import kanzi.io.StreamInfo;
+import org.junit.Assert;
+import org.junit.Test;
+
+
+public class TestCompressedStream
+{
+   public static void main(String[] args)
+   {
+      System.out.println("TestCompressedStream");
+
+      if (testTransformOnly() == false)
+         System.exit(1);
+
+      if (testBlockAlignment() == false)
+         System.exit(1);
+
+      if (testReentropize() == false)
+         System.exit(1);
+
+      if (testStreamParams() == false)
+         System.exit(1);
+
+      if (testParallelEntropy() == false)
+         System.exit(1);
+
+      if (testVerify() == false)
+         System.exit(1);
+
+      if (testMemoryBudget() == false)
+         System.exit(1);
+
+      if (testBufferedInput() == false)
+         System.exit(1);
+
+      if (testSeekable() == false)
+         System.exit(1);
+
+      if (testLengthDelimited() == false)
+         System.exit(1);
+
+      if (testProfile() == false)
+         System.exit(1);
+
+      if (testDecompressionLimit() == false)
+         System.exit(1);
+
+      if (testStoreOnly() == false)
+         System.exit(1);
+
+      if (testInflightBlocks() == false)
+         System.exit(1);
+
+      if (testDecompressInto() == false)
+         System.exit(1);
+
+      if (testChecksumKinds() == false)
+         System.exit(1);
+
+      if (testMetricsSink() == false)
+         System.exit(1);
+
+      if (testConfigFreeDecode() == false)
+         System.exit(1);
+
+      if (testSecondBWT() == false)
+         System.exit(1);
+
+      if (testCompressStream() == false)
+         System.exit(1);
+
+      if (testBoundaryDetector() == false)
+         System.exit(1);
+
+      if (testFormatVersion() == false)
+         System.exit(1);
+
+      if (testAnytime() == false)
+         System.exit(1);
+
+      if (testSharding() == false)
+         System.exit(1);
+
+      if (testRotate() == false)
+         System.exit(1);
+   }
+
+
+   @Test
+   public void testCompressedStream()
+   {
+      System.out.println("\n\nTest transform only stream");
+      Assert.assertTrue(testTransformOnly());
+      System.out.println("\n\nTest block alignment");
+      Assert.assertTrue(testBlockAlignment());
+      System.out.println("\n\nTest reentropize");
+      Assert.assertTrue(testReentropize());
+      System.out.println("\n\nTest stream parameters");
+      Assert.assertTrue(testStreamParams());
+      System.out.println("\n\nTest parallel entropy sub-streams");
+      Assert.assertTrue(testParallelEntropy());
+      System.out.println("\n\nTest verify");
+      Assert.assertTrue(testVerify());
+      System.out.println("\n\nTest memory budget");
+      Assert.assertTrue(testMemoryBudget());
+      System.out.println("\n\nTest buffered input");
+      Assert.assertTrue(testBufferedInput());
+      System.out.println("\n\nTest seekable stream");
+      Assert.assertTrue(testSeekable());
+      System.out.println("\n\nTest length delimited frames");
+      Assert.assertTrue(testLengthDelimited());
+      System.out.println("\n\nTest profile");
+      Assert.assertTrue(testProfile());
+      System.out.println("\n\nTest decompression limit");
+      Assert.assertTrue(testDecompressionLimit());
+      System.out.println("\n\nTest store only");
+      Assert.assertTrue(testStoreOnly());
+      System.out.println("\n\nTest in-flight blocks");
+      Assert.assertTrue(testInflightBlocks());
+      System.out.println("\n\nTest decompress into buffer");
+      Assert.assertTrue(testDecompressInto());
+      System.out.println("\n\nTest checksum types");
+      Assert.assertTrue(testChecksumKinds());
+      System.out.println("\n\nTest MetricsSink");
+      Assert.assertTrue(testMetricsSink());
+      System.out.println("\n\nTest ConfigFreeDecode");
+      Assert.assertTrue(testConfigFreeDecode());
+      System.out.println("\n\nTest second BWT stage");
+      Assert.assertTrue(testSecondBWT());
+      System.out.println("\n\nTest compress stream of unknown length");
+      Assert.assertTrue(testCompressStream());
+      System.out.println("\n\nTest content defined block boundaries");
+      Assert.assertTrue(testBoundaryDetector());
+      System.out.println("\n\nTest older format versions");
+      Assert.assertTrue(testFormatVersion());
+      System.out.println("\n\nTest Anytime");
+      Assert.assertTrue(testAnytime());
+      System.out.println("\n\nTest Sharding");
+      Assert.assertTrue(testSharding());
+      System.out.println("\n\nTest Rotate");
+      Assert.assertTrue(testRotate());
+   }
+
+
+   static Map<String, Object> createContext(String transform, String codec,
+      int blockSize, boolean checksum)
+   {
+      Map<String, Object> ctx = new HashMap<>();
+      ctx.put("transform", transform);
+      ctx.put("codec", codec);
+      ctx.put("blockSize", blockSize);
+      ctx.put("checksum", checksum);
+      ctx.put("jobs", 1);
+      return ctx;
+   }
+
+
+   static byte[] compress(byte[] input, Map<String, Object> ctx, Listener listener)
+      throws IOException
+   {
+      ByteArrayOutputStream baos = new ByteArrayOutputStream(input.length);
+      CompressedOutputStream cos = new CompressedOutputStream(baos, ctx);
+
+      if (listener != null)
+         cos.addListener(listener);
+
+      cos.write(input, 0, input.length);
+      cos.close();
+      return baos.toByteArray();
+   }
+
+
+   static byte[] decompress(byte[] input, Map<String, Object> ctx) throws IOException
+   {
+      ByteArrayOutputStream baos = new ByteArrayOutputStream(input.length);
+      CompressedInputStream cis = new CompressedInputStream(new ByteArrayInputStream(input), ctx);
+      byte[] buf = new byte[65536];
+      int len;
+
+      while ((len = cis.read(buf, 0, buf.length)) > 0)
+         baos.write(buf, 0, len);
+
+      cis.close();
+      return baos.toByteArray();
+   }
+
+
+   // Generate text like data with repeated words
+   static byte[] generateText(int size, long seed)
+   {
+      final String[] words = { "the ", "quick ", "brown ", "fox ", "jumps ", "over ",
+         "lazy ", "dog ", "and ", "runs ", "away\n", "1234 ", "kanzi " };
+      Random rnd = new Random(seed);
+      byte[] res = new byte[size];
+      int n = 0;
+
+      while (n < size)
+      {
+         byte[] w = words[rnd.nextInt(words.length)].getBytes();
+
+         for (int i=0; (i<w.length) && (n<size); i++)
+            res[n++] = w[i];
+      }
+
+      return res;
+   }
+
+
+   // Return the number of bytes used to encode the size of a block in the block header
+   static int getBlockSizeBytes(long length)
+   {
+      int dataSize = 0;
+
+      for (long n=0xFF; n<length; n<<=8)
+         dataSize++;
+
+      return dataSize + 1;
+   }
+
+
+   public static boolean testTransformOnly()
+   {
+      try
+      {
+         byte[] input = generateText(200000, 12345);
+         final Map<Integer, Long> transformSizes = new HashMap<>();
+         final Map<Integer, Long> entropySizes = new HashMap<>();
+
+         Listener listener = new Listener()
+         {
+            @Override
+            public void processEvent(Event evt)
+            {
+               if (evt.getType() == Event.Type.AFTER_TRANSFORM)
+                  transformSizes.put(evt.getId(), evt.getSize());
+               else if (evt.getType() == Event.Type.AFTER_ENTROPY)
+                  entropySizes.put(evt.getId(), evt.getSize());
+            }
+         };
+
+         byte[] output = compress(input, createContext("BWT+MTFT+ZRLT", "NONE", 65536, false), listener);
+         System.out.println("Transform only: " + input.length + " => " + output.length + " bytes");
+
+         if (transformSizes.isEmpty() || (transformSizes.size() != entropySizes.size()))
+         {
+            System.out.println("Missing block events");
+            return false;
+         }
+
+         // Each block contains a block header followed by the verbatim transform output
+         for (Map.Entry<Integer, Long> entry : transformSizes.entrySet())
+         {
+            final long tSize = entry.getValue();
+            final long eSize = entropySizes.get(entry.getKey());
+
+            if (eSize != tSize + 1 + getBlockSizeBytes(tSize))
+            {
+               System.out.println("Block " + entry.getKey() + ": transform size " + tSize +
+                  " does not match block size " + eSize);
+               return false;
+            }
+         }
+
+         byte[] reverse = decompress(output, createContext("NONE", "NONE", 65536, false));
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after decompression");
+            return false;
+         }
+
+         System.out.println("Identical");
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   public static boolean testBlockAlignment()
+   {
+      try
+      {
+         byte[] input = generateText(300000, 6789);
+         final int[] alignments = { 16, 512, 4096 };
+
+         for (int alignment : alignments)
+         {
+            Map<String, Object> ctx = createContext("TEXT+BWT+RANK+ZRLT", "ANS0", 32768, true);
+            ctx.put("alignment", alignment);
+            byte[] output = compress(input, ctx, null);
+            System.out.println("Alignment " + alignment + ": " + input.length + " => " + output.length + " bytes");
+
+            // Header size: 136 bits rounded up to the alignment
+            int offset = (17 + alignment - 1) & -alignment;
+            int blocks = 0;
+
+            // Walk the blocks using the stored block sizes (32 bits, in bits)
+            while (true)
+            {
+               if (offset % alignment != 0)
+               {
+                  System.out.println("Block " + blocks + " at offset " + offset + " is not aligned");
+                  return false;
+               }
+
+               final long bits = ((output[offset] & 0xFFL) << 24) | ((output[offset+1] & 0xFFL) << 16) |
+                  ((output[offset+2] & 0xFFL) << 8) | (output[offset+3] & 0xFFL);
+
+               if (bits == 0)
+                  break;
+
+               if ((bits & 7) != 0)
+               {
+                  System.out.println("Block " + blocks + " is not padded");
+                  return false;
+               }
+
+               offset += 4 + (int) (bits >> 3);
+               blocks++;
+            }
+
+            if (blocks != (input.length + 32767) / 32768)
+            {
+               System.out.println("Found " + blocks + " blocks");
+               return false;
+            }
+
+            byte[] reverse = decompress(output, createContext("NONE", "NONE", 32768, false));
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after decompression");
+               return false;
+            }
+         }
+
+         System.out.println("Identical");
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   public static boolean testReentropize()
+   {
+      try
+      {
+         // The last block is small enough to be a copy block
+         byte[] input = generateText(3*65536+10, 4321);
+         Map<String, Object> ctx = createContext("TEXT+BWT+RANK+ZRLT", "HUFFMAN", 65536, true);
+         byte[] output1 = compress(input, ctx, null);
+         ByteArrayOutputStream baos = new ByteArrayOutputStream(output1.length);
+         CompressedStreams.reentropize(new ByteArrayInputStream(output1), baos, "ANS0");
+         byte[] output2 = baos.toByteArray();
+         System.out.println("Huffman: " + input.length + " => " + output1.length + " bytes");
+         System.out.println("ANS0   : " + input.length + " => " + output2.length + " bytes");
+         Map<String, Object> dCtx = createContext("NONE", "NONE", 65536, false);
+         byte[] reverse = decompress(output2, dCtx);
+
+         if ("ANS0".equals(dCtx.get("codec")) == false)
+         {
+            System.out.println("Unexpected entropy codec in header: " + dCtx.get("codec"));
+            return false;
+         }
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after decompression");
+            return false;
+         }
+
+         System.out.println("Identical");
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   public static boolean testStreamParams()
+   {
+      try
+      {
+         byte[] input = generateText(100000, 999);
+         Map<String, String> extra = new HashMap<>();
+         extra.put("source", "unit test");
+         extra.put("jointGrouping", "KKVV");
+         Map<String, Object> ctx = createContext("TEXT+BWT+RANK+ZRLT", "ANS0", 65536, true);
+         ctx.put("level", 4);
+         ctx.put("params", extra);
+         byte[] output = compress(input, ctx, null);
+
+         CompressedInputStream cis = new CompressedInputStream(new ByteArrayInputStream(output),
+            createContext("NONE", "NONE", 65536, false));
+         StreamInfo info = cis.getStreamInfo();
+         Map<String, Object> params = info.getParams();
+         System.out.println("Parameters: " + params);
+         final String[] keys = { "transform", "codec", "blockSize", "checksum", "level", "params" };
+
+         for (String key : keys)
+         {
+            if (ctx.get(key).equals(params.get(key)) == false)
+            {
+               System.out.println("Parameter " + key + ": expected " + ctx.get(key) + ", got " + params.get(key));
+               return false;
+            }
+         }
+
+         // The stream must still decode after reading the header
+         ByteArrayOutputStream baos = new ByteArrayOutputStream(input.length);
+         byte[] buf = new byte[65536];
+         int len;
+
+         while ((len = cis.read(buf, 0, buf.length)) > 0)
+            baos.write(buf, 0, len);
+
+         cis.close();
+
+         if (Arrays.equals(input, baos.toByteArray()) == false)
+         {
+            System.out.println("Different output after decompression");
+            return false;
+         }
+
+         // No level and no extended parameters
+         output = compress(input, createContext("LZ", "HUFFMAN", 65536, false), null);
+         cis = new CompressedInputStream(new ByteArrayInputStream(output), createContext("NONE", "NONE", 65536, false));
+         info = cis.getStreamInfo();
+         cis.close();
+
+         if ((info.getLevel() != -1) || (info.getExtraParams().isEmpty() == false) ||
+            (info.getParams().containsKey("level") == true))
+         {
+            System.out.println("Unexpected level or extended parameters");
+            return false;
+         }
+
+         System.out.println("Identical");
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   // The output must not depend on the number of jobs
+   public static boolean testParallelEntropy()
+   {
+      final int[] jobs = { 1, 2, 4 };
+      final int blockSize = 4*1024*1024;
+      ExecutorService pool = Executors.newFixedThreadPool(4);
+
+      try
+      {
+         byte[] input = generateText(blockSize, 2468);
+         Map<String, Object> ctx = createContext("NONE", "CM", blockSize, true);
+         byte[] ref = compress(input, ctx, null);
+         System.out.println("Single stream: " + input.length + " => " + ref.length + " bytes");
+         ref = null;
+
+         for (int i=0; i<jobs.length; i++)
+         {
+            ctx = createContext("NONE", "CM", blockSize, true);
+            ctx.put("entropyChunkSize", 256*1024);
+            ctx.put("jobs", jobs[i]);
+            ctx.put("pool", pool);
+            long before = System.nanoTime();
+            byte[] output = compress(input, ctx, null);
+            long after = System.nanoTime();
+            final long encTime = Math.max((after-before) / 1000000L, 1);
+
+            if (ref == null)
+               ref = output;
+            else if (Arrays.equals(ref, output) == false)
+            {
+               System.out.println("Different output with " + jobs[i] + " jobs");
+               return false;
+            }
+
+            Map<String, Object> dCtx = createContext("NONE", "NONE", blockSize, false);
+            dCtx.put("jobs", jobs[i]);
+            dCtx.put("pool", pool);
+            before = System.nanoTime();
+            byte[] reverse = decompress(output, dCtx);
+            after = System.nanoTime();
+            final long decTime = Math.max((after-before) / 1000000L, 1);
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after decompression with " + jobs[i] + " jobs");
+               return false;
+            }
+
+            System.out.println("Jobs=" + jobs[i] + ": " + input.length + " => " + output.length + " bytes" +
+               ", encoding: " + (input.length*1000L/encTime)/1024 + " KB/s" +
+               ", decoding: " + (input.length*1000L/decTime)/1024 + " KB/s");
+         }
+
+         // Sub-streams are kept when the entropy codec is changed
+         ByteArrayOutputStream baos = new ByteArrayOutputStream(ref.length);
+         CompressedStreams.reentropize(new ByteArrayInputStream(ref), baos, "HUFFMAN");
+         byte[] reverse = decompress(baos.toByteArray(), createContext("NONE", "NONE", blockSize, false));
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after decompression of reentropized stream");
+            return false;
+         }
+
+         System.out.println("Identical");
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+      finally
+      {
+         pool.shutdown();
+      }
+   }
+
+
+   public static boolean testVerify()
+   {
+      try
+      {
+         byte[] input = generateText(4*65536, 1357);
+         byte[] output = compress(input, createContext("TEXT+BWT+RANK+ZRLT", "ANS0", 65536, true), null);
+         CompressedStreams.verify(new ByteArrayInputStream(output));
+         System.out.println("Valid stream: OK");
+
+         // Corrupt the entropy coded data of the first block (the header is
+         // 17 bytes long) and of the last block
+         final int[] offsets = { 40, output.length-20 };
+         final int[] blockIds = { 1, 4 };
+
+         for (int i=0; i<offsets.length; i++)
+         {
+            byte[] corrupted = output.clone();
+            corrupted[offsets[i]] ^= 0x55;
+
+            try
+            {
+               CompressedStreams.verify(new ByteArrayInputStream(corrupted));
+               System.out.println("Corruption at offset " + offsets[i] + " not detected");
+               return false;
+            }
+            catch (BlockException e)
+            {
+               System.out.println("Corruption at offset " + offsets[i] + ": " + e.getMessage());
+
+               if (e.getBlockId() != blockIds[i])
+               {
+                  System.out.println("Expected failure in block " + blockIds[i]);
+                  return false;
+               }
+            }
+         }
+
+         // Truncated stream
+         try
+         {
+            CompressedStreams.verify(new ByteArrayInputStream(Arrays.copyOf(output, output.length/2)));
+            System.out.println("Truncation not detected");
+            return false;
+         }
+         catch (BlockException e)
+         {
+            System.out.println("Truncated stream: " + e.getMessage());
+         }
+
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   public static boolean testMemoryBudget()
+   {
+      File dir = null;
+
+      try
+      {
+         dir = Files.createTempDirectory("kanzi").toFile();
+         File inFile = new File(dir, "input.txt");
+         File outFile = new File(dir, "input.txt.knz");
+         byte[] input = generateText(1024*1024, 2468);
+         Files.write(inFile.toPath(), input);
+
+         Map<String, Object> map = new HashMap<>();
+         map.put("level", -1);
+         map.put("verbose", 0);
+         map.put("overwrite", true);
+         map.put("inputName", inFile.getPath());
+         map.put("outputName", outFile.getPath());
+         map.put("transform", "BWT+RANK+ZRLT");
+         map.put("entropy", "ANS0");
+         map.put("block", 1024*1024);
+         map.put("jobs", 2);
+         BlockCompressor bc = new BlockCompressor(map);
+
+         try
+         {
+            final long budget = 6*1024*1024;
+            System.out.println("Estimated memory (no budget): " +
+               BlockCompressor.estimateMemory(bc.getBlockSize(), 2, true, false) + " bytes");
+            bc.setMemoryBudget(budget);
+            final long estimate = BlockCompressor.estimateMemory(bc.getBlockSize(), 2, true, bc.isLowMemoryBWT());
+            System.out.println("Budget: " + budget + " bytes, block size: " + bc.getBlockSize() +
+               ", estimated memory: " + estimate + " bytes");
+
+            if (bc.isLowMemoryBWT() == false)
+            {
+               System.out.println("The low memory BWT was not selected");
+               return false;
+            }
+
+            if ((bc.getBlockSize() >= 1024*1024) || (estimate > budget))
+            {
+               System.out.println("The block size was not reduced to fit the budget");
+               return false;
+            }
+
+            if (bc.call() != 0)
+            {
+               System.out.println("Compression failed");
+               return false;
+            }
+         }
+         finally
+         {
+            bc.dispose();
+         }
+
+         byte[] reverse = decompress(Files.readAllBytes(outFile.toPath()), createContext("NONE", "NONE", 65536, false));
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different data after decompression");
+            return false;
+         }
+
+         System.out.println("Low memory path selected, decompression: OK");
+
+         // BWTS has no low memory mode: its working set is estimated and the
+         // block size is reduced
+         map.put("level", -1);
+         map.put("verbose", 0);
+         map.put("overwrite", true);
+         map.put("inputName", inFile.getPath());
+         map.put("outputName", outFile.getPath());
+         map.put("transform", "BWTS+RANK+ZRLT");
+         map.put("entropy", "ANS0");
+         map.put("block", 1024*1024);
+         map.put("jobs", 2);
+         bc = new BlockCompressor(map);
+
+         try
+         {
+            final long budget = 12*1024*1024;
+
+            if (BlockCompressor.estimateMemory(1024*1024, 2, 0, 1, false) <= BlockCompressor.estimateMemory(1024*1024, 2, 0, false))
+            {
+               System.out.println("The memory estimate should account for the BWTS stage");
+               return false;
+            }
+
+            bc.setMemoryBudget(budget);
+            final long estimate = BlockCompressor.estimateMemory(bc.getBlockSize(), 2, 0, 1, false);
+            System.out.println("BWTS budget: " + budget + " bytes, block size: " + bc.getBlockSize() +
+               ", estimated memory: " + estimate + " bytes");
+
+            if (bc.isLowMemoryBWT() == true)
+            {
+               System.out.println("The low memory BWT does not apply to BWTS");
+               return false;
+            }
+
+            if ((bc.getBlockSize() >= 1024*1024) || (estimate > budget))
+            {
+               System.out.println("The block size was not reduced to fit the budget");
+               return false;
+            }
+
+            if (bc.call() != 0)
+            {
+               System.out.println("Compression failed");
+               return false;
+            }
+         }
+         finally
+         {
+            bc.dispose();
+         }
+
+         reverse = decompress(Files.readAllBytes(outFile.toPath()), createContext("NONE", "NONE", 65536, false));
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("BWTS: different data after decompression");
+            return false;
+         }
+
+         // Even the minimum block size does not fit
+         try
+         {
+            map.put("level", -1);
+            map.put("verbose", 0);
+            map.put("jobs", 2);
+            map.put("transform", "BWT+RANK+ZRLT");
+            BlockCompressor bc2 = new BlockCompressor(map);
+
+            try
+            {
+               bc2.setMemoryBudget(4096);
+               System.out.println("Budget too small not detected");
+               return false;
+            }
+            finally
+            {
+               bc2.dispose();
+            }
+         }
+         catch (IllegalArgumentException e)
+         {
+            System.out.println("Budget too small: " + e.getMessage());
+         }
+
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+      finally
+      {
+         if (dir != null)
+         {
+            for (File f : dir.listFiles())
+               f.delete();
+
+            dir.delete();
+         }
+      }
+   }
+
+
+   // Return the number of bytes allocated so far by the current thread or -1
+   // if allocation counting is not supported by the JVM
+   static long getAllocatedBytes()
+   {
+      ThreadMXBean bean = ManagementFactory.getThreadMXBean();
+
+      if (bean instanceof com.sun.management.ThreadMXBean)
+      {
+         com.sun.management.ThreadMXBean bean2 = (com.sun.management.ThreadMXBean) bean;
+
+         if (bean2.isThreadAllocatedMemorySupported() == true)
+            return bean2.getThreadAllocatedBytes(Thread.currentThread().getId());
+      }
+
+      return -1;
+   }
+
+
+   public static boolean testBufferedInput()
+   {
+      try
+      {
+         byte[] input = generateText(1024*1024, 8642);
+         final byte[] output = compress(input, createContext("BWT+RANK+ZRLT", "ANS0", 256*1024, true), null);
+         final String[] names = { "Unbuffered input", "BufferedInputStream", "Internal buffering disabled" };
+
+         if (getAllocatedBytes() < 0)
+            System.out.println("Allocation counting not supported, only checking decoding");
+
+         for (int t=0; t<names.length; t++)
+         {
+            // Hide the type of the underlying stream
+            InputStream is = new FilterInputStream(new ByteArrayInputStream(output)) { };
+
+            if (t == 1)
+               is = new BufferedInputStream(is);
+
+            Map<String, Object> ctx = createContext("NONE", "NONE", 65536, false);
+
+            if (t == 2)
+               ctx.put("bufferInput", false);
+
+            final long before = getAllocatedBytes();
+            CompressedInputStream cis = new CompressedInputStream(is, ctx);
+            final long allocated = getAllocatedBytes() - before;
+            ByteArrayOutputStream baos = new ByteArrayOutputStream(input.length);
+            byte[] buf = new byte[65536];
+            int len;
+
+            while ((len = cis.read(buf, 0, buf.length)) > 0)
+               baos.write(buf, 0, len);
+
+            cis.close();
+
+            if (Arrays.equals(input, baos.toByteArray()) == false)
+            {
+               System.out.println(names[t] + ": different data after decompression");
+               return false;
+            }
+
+            if (before < 0)
+               continue;
+
+            System.out.println(names[t] + ": " + allocated + " bytes allocated by the constructor");
+
+            // 256 KB bit stream buffer expected only for the unbuffered input
+            if ((t == 0) != (allocated >= 256*1024))
+            {
+               System.out.println("Unexpected allocation size");
+               return false;
+            }
+         }
+
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   public static boolean testSeekable()
+   {
+      try
+      {
+         final int blockSize = 65536;
+         final int nbBlocks = 8;
+         byte[] input = generateText(nbBlocks*blockSize, 97531);
+
+         // No state is shared across blocks: each block decodes on its own
+         Map<String, Object> ctx = createContext("LZ", "HUFFMAN", blockSize, true);
+         byte[] output = compress(input, ctx, null);
+
+         for (int i=nbBlocks; i>=1; i--)
+         {
+            Map<String, Object> ctx2 = createContext("NONE", "NONE", blockSize, false);
+            ctx2.put("from", i);
+            ctx2.put("to", i+1);
+            byte[] block = decompress(output, ctx2);
+            byte[] expected = Arrays.copyOfRange(input, (i-1)*blockSize, i*blockSize);
+
+            if (Arrays.equals(expected, block) == false)
+            {
+               System.out.println("Block " + i + ": different data after decompression");
+               return false;
+            }
+         }
+
+         System.out.println("Seek to each block: OK");
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   // Generic reader of varint (protobuf style) length delimited records
+   static List<byte[]> readLengthDelimited(byte[] data)
+   {
+      List<byte[]> res = new ArrayList<>();
+      int idx = 0;
+
+      while (idx < data.length)
+      {
+         int len = 0;
+         int shift = 0;
+
+         while (true)
+         {
+            final int b = data[idx++] & 0xFF;
+            len |= (b&0x7F) << shift;
+
+            if (b < 0x80)
+               break;
+
+            shift += 7;
+         }
+
+         res.add(Arrays.copyOfRange(data, idx, idx+len));
+         idx += len;
+      }
+
+      return res;
+   }
+
+
+   public static boolean testLengthDelimited()
+   {
+      try
+      {
+         final int blockSize = 65536;
+         final int nbBlocks = 5;
+         byte[] input = generateText((nbBlocks-1)*blockSize+1000, 11223);
+         Map<String, Object> ctx = createContext("TEXT+BWT+RANK+ZRLT", "ANS0", blockSize, true);
+         ByteArrayOutputStream baos = new ByteArrayOutputStream();
+         LengthDelimitedOutputStream ldos = new LengthDelimitedOutputStream(baos, ctx);
+         ldos.write(input, 0, input.length);
+         ldos.close();
+         byte[] framed = baos.toByteArray();
+
+         // Header, blocks and end of stream marker
+         List<byte[]> frames = readLengthDelimited(framed);
+         System.out.println(frames.size() + " frames, " + framed.length + " bytes");
+
+         if ((frames.size() != nbBlocks+2) || (ldos.getFrameCount() != frames.size()))
+         {
+            System.out.println("Expected " + (nbBlocks+2) + " frames");
+            return false;
+         }
+
+         // The concatenated frames are the regular compressed stream
+         ByteArrayOutputStream baos2 = new ByteArrayOutputStream();
+
+         for (byte[] frame : frames)
+            baos2.write(frame, 0, frame.length);
+
+         Map<String, Object> ctx2 = createContext("TEXT+BWT+RANK+ZRLT", "ANS0", blockSize, true);
+         ctx2.put("alignment", 2);
+         byte[] expected = compress(input, ctx2, null);
+
+         if (Arrays.equals(expected, baos2.toByteArray()) == false)
+         {
+            System.out.println("The frames do not contain the compressed stream");
+            return false;
+         }
+
+         byte[] reverse = decompress(baos2.toByteArray(), createContext("NONE", "NONE", blockSize, false));
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different data after decompression of the concatenated frames");
+            return false;
+         }
+
+         // Round trip through the matching reader
+         LengthDelimitedInputStream ldis = new LengthDelimitedInputStream(new ByteArrayInputStream(framed),
+            createContext("NONE", "NONE", blockSize, false));
+         ByteArrayOutputStream baos3 = new ByteArrayOutputStream(input.length);
+         byte[] buf = new byte[65536];
+         int len;
+
+         while ((len = ldis.read(buf, 0, buf.length)) > 0)
+            baos3.write(buf, 0, len);
+
+         ldis.close();
+
+         if (Arrays.equals(input, baos3.toByteArray()) == false)
+         {
+            System.out.println("Different data after decompression of the frames");
+            return false;
+         }
+
+         System.out.println("Identical");
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   public static boolean testProfile()
+   {
+      try
+      {
+         // Names are normalized
+         Profile profile = new Profile("text+bwt+rank+zrlt", "ans0", 65536, true, 5);
+
+         if (profile.equals(new Profile("TEXT+BWT+RANK+ZRLT", "ANS0", 65536, true, 5)) == false)
+         {
+            System.out.println("Profiles with the same parameters must be equal");
+            return false;
+         }
+
+         // Serialization round trip
+         if (profile.equals(Profile.fromBytes(profile.toBytes())) == false)
+         {
+            System.out.println("Different profile after deserialization");
+            return false;
+         }
+
+         byte[] input = generateText(200000, 4321);
+         ByteArrayOutputStream baos = new ByteArrayOutputStream(input.length);
+         CompressedOutputStream cos = new CompressedOutputStream(baos, profile);
+         cos.write(input, 0, input.length);
+         cos.close();
+         byte[] output = baos.toByteArray();
+
+         CompressedInputStream cis = new CompressedInputStream(new ByteArrayInputStream(output),
+            createContext("NONE", "NONE", 65536, false));
+         StreamInfo info = cis.getStreamInfo();
+         System.out.println("Profile: " + profile);
+         System.out.println("Stream:  " + info.getParams());
+         cis.close();
+
+         if ((profile.equals(Profile.fromStreamInfo(info)) == false) ||
+            (profile.getParams().equals(info.getParams()) == false))
+         {
+            System.out.println("The stream parameters do not match the profile");
+            return false;
+         }
+
+         if (Arrays.equals(input, decompress(output, createContext("NONE", "NONE", 65536, false))) == false)
+         {
+            System.out.println("Different data after decompression");
+            return false;
+         }
+
+         // The checksum type is preserved
+         for (ChecksumKind kind : ChecksumKind.values())
+         {
+            Profile profile2 = new Profile("LZ", "HUFFMAN", 65536, kind, -1);
+
+            if ((profile2.getChecksumKind() != kind) || (profile2.equals(Profile.fromBytes(profile2.toBytes())) == false) ||
+               (profile2.equals(Profile.fromParams(profile2.getParams())) == false))
+            {
+               System.out.println("Checksum " + kind + ": different profile after deserialization");
+               return false;
+            }
+
+            baos = new ByteArrayOutputStream(input.length);
+            cos = new CompressedOutputStream(baos, profile2);
+            cos.write(input, 0, input.length);
+            cos.close();
+            cis = new CompressedInputStream(new ByteArrayInputStream(baos.toByteArray()),
+               createContext("NONE", "NONE", 65536, false));
+            info = cis.getStreamInfo();
+            cis.close();
+
+            if ((info.getChecksumKind() != kind) || (profile2.equals(Profile.fromStreamInfo(info)) == false))
+            {
+               System.out.println("Checksum " + kind + ": the stream does not match the profile");
+               return false;
+            }
+         }
+
+         // Invalid parameters are rejected when the profile is created
+         try
+         {
+            new Profile("BWT", "ANS0", 1000, false);
+            System.out.println("Invalid block size not detected");
+            return false;
+         }
+         catch (IllegalArgumentException e)
+         {
+            System.out.println("Expected error: " + e.getMessage());
+         }
+
+         System.out.println("Identical");
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   public static boolean testDecompressionLimit()
+   {
+      try
+      {
+         // 32 MB of zeros compress to a few hundred bytes
+         final int blockSize = 1024*1024;
+         byte[] input = new byte[32*blockSize];
+         byte[] output = compress(input, createContext("RLT", "HUFFMAN", blockSize, false), null);
+         System.out.println(input.length + " => " + output.length);
+         // The block crossing the limit fails, the previous blocks are delivered
+         final long limit = 5*blockSize/2;
+         CompressedInputStream cis = new CompressedInputStream(new ByteArrayInputStream(output),
+            createContext("NONE", "NONE", blockSize, false));
+         cis.setMaxDecompressedSize(limit);
+         byte[] buf = new byte[65536];
+         long total = 0;
+         final long before = getAllocatedBytes();
+
+         try
+         {
+            int len;
+
+            while ((len = cis.read(buf, 0, buf.length)) > 0)
+               total += len;
+
+            System.out.println("The limit was not detected");
+            return false;
+         }
+         catch (kanzi.io.IOException e)
+         {
+            System.out.println("Expected error: " + e.getMessage());
+
+            if (e.getErrorCode() != kanzi.Error.ERR_OUTPUT_LIMIT)
+            {
+               System.out.println("Unexpected error code: " + e.getErrorCode());
+               return false;
+            }
+         }
+
+         final long allocated = getAllocatedBytes() - before;
+         cis.close();
+         System.out.println(total + " bytes decompressed");
+
+         if (total != 2*blockSize)
+         {
+            System.out.println("Expected " + (2*blockSize) + " bytes before the error");
+            return false;
+         }
+
+         // At most a few blocks are allocated
+         if ((before >= 0) && (allocated >= 8*blockSize))
+         {
+            System.out.println("Too much memory allocated: " + allocated + " bytes");
+            return false;
+         }
+
+         // A limit equal to the decompressed size is not exceeded
+         cis = new CompressedInputStream(new ByteArrayInputStream(output),
+            createContext("NONE", "NONE", blockSize, false));
+         cis.setMaxDecompressedSize(input.length);
+         total = 0;
+         int len;
+
+         while ((len = cis.read(buf, 0, buf.length)) > 0)
+            total += len;
+
+         cis.close();
+
+         if (total != input.length)
+         {
+            System.out.println("Expected " + input.length + " bytes, got " + total);
+            return false;
+         }
+
+         // Small limit and large block size declared in the header: the
+         // buffers must be sized after the limit
+         final int bigBlockSize = 64*1024*1024;
+         final int smallLimit = 65536;
+         input = new byte[blockSize];
+         output = compress(input, createContext("RLT", "HUFFMAN", bigBlockSize, false), null);
+         cis = new CompressedInputStream(new ByteArrayInputStream(output),
+            createContext("NONE", "NONE", blockSize, false));
+         cis.setMaxDecompressedSize(smallLimit);
+         final long before2 = getAllocatedBytes();
+
+         try
+         {
+            while (cis.read(buf, 0, buf.length) > 0)
+            {
+            }
+
+            System.out.println("The limit was not detected");
+            return false;
+         }
+         catch (kanzi.io.IOException e)
+         {
+            System.out.println("Expected error: " + e.getMessage());
+
+            if (e.getErrorCode() != kanzi.Error.ERR_OUTPUT_LIMIT)
+            {
+               System.out.println("Unexpected error code: " + e.getErrorCode());
+               return false;
+            }
+         }
+
+         final long allocated2 = getAllocatedBytes() - before2;
+         cis.close();
+
+         if ((before2 >= 0) && (allocated2 >= 16*smallLimit))
+         {
+            System.out.println("Too much memory allocated with a declared block size of " +
+               bigBlockSize + " bytes: " + allocated2 + " bytes");
+            return false;
+         }
+
+         // A block smaller than the limit is decoded with small buffers
+         input = new byte[smallLimit/2];
+         Arrays.fill(input, (byte) 'a');
+         output = compress(input, createContext("RLT", "HUFFMAN", bigBlockSize, false), null);
+         cis = new CompressedInputStream(new ByteArrayInputStream(output),
+            createContext("NONE", "NONE", blockSize, false));
+         cis.setMaxDecompressedSize(smallLimit);
+         byte[] reverse = new byte[input.length];
+         total = 0;
+
+         while ((len = cis.read(reverse, (int) total, reverse.length-(int) total)) > 0)
+            total += len;
+
+         cis.close();
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after decompression");
+            return false;
+         }
+
+         // Aligned blocks: the padding of a small last block exceeds the
+         // remaining budget but the stream does not exceed the limit
+         input = generateText(2*65536+1000, 2261);
+         Map<String, Object> ctx = createContext("LZ", "HUFFMAN", 65536, false);
+         ctx.put("alignment", 4096);
+         output = compress(input, ctx, null);
+         cis = new CompressedInputStream(new ByteArrayInputStream(output),
+            createContext("NONE", "NONE", 65536, false));
+         cis.setMaxDecompressedSize(input.length);
+         reverse = new byte[input.length];
+         total = 0;
+
+         while ((len = cis.read(reverse, (int) total, reverse.length-(int) total)) > 0)
+            total += len;
+
+         cis.close();
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Aligned blocks: different output after decompression");
+            return false;
+         }
+
+         System.out.println("Identical");
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   public static boolean testStoreOnly()
+   {
+      try
+      {
+         // Incompressible data (EG. zip, jpeg)
+         final int blockSize = 1024*1024;
+         byte[] input = new byte[8*blockSize+12345];
+         new Random(777).nextBytes(input);
+
+         for (int t=0; t<3; t++)
+         {
+            // The transform and the entropy codec are ignored
+            Map<String, Object> ctx = createContext("TEXT+BWT+RANK+ZRLT", "ANS0", blockSize, t == 1);
+            ctx.put("storeOnly", true);
+
+            if (t == 2)
+               ctx.put("alignment", 4096);
+
+            byte[] output = compress(input, ctx, null);
+            CompressedInputStream cis = new CompressedInputStream(new ByteArrayInputStream(output),
+               createContext("NONE", "NONE", blockSize, false));
+            StreamInfo info = cis.getStreamInfo();
+            cis.close();
+            System.out.println(input.length + " => " + output.length + " (" + info.getTransform() +
+               "&" + info.getEntropy() + ((t == 1) ? ", checksum" : "") + ((t == 2) ? ", aligned" : "") + ")");
+
+            if (("NONE".equals(info.getTransform()) == false) || ("NONE".equals(info.getEntropy()) == false))
+            {
+               System.out.println("The header must record no transform and no entropy codec");
+               return false;
+            }
+
+            // Block headers only (plus alignment padding)
+            final int overhead = (t == 2) ? 10*4096 : 1024;
+
+            if (output.length > input.length+overhead)
+            {
+               System.out.println("Unexpected overhead: " + (output.length-input.length) + " bytes");
+               return false;
+            }
+
+            byte[] reverse = decompress(output, createContext("NONE", "NONE", blockSize, false));
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different data after decompression");
+               return false;
+            }
+         }
+
+         // Throughput: memory copy, store only and regular path without
+         // transform and entropy codec (best of several runs)
+         final String[] names = { "Memory copy", "Store only", "NONE&NONE" };
+         final int iter = 10;
+         byte[] copy = new byte[input.length];
+
+         for (int n=0; n<names.length; n++)
+         {
+            long best = Long.MAX_VALUE;
+
+            for (int i=0; i<iter; i++)
+            {
+               final long before = System.nanoTime();
+
+               if (n == 0)
+               {
+                  System.arraycopy(input, 0, copy, 0, input.length);
+               }
+               else
+               {
+                  Map<String, Object> ctx = createContext("NONE", "NONE", blockSize, false);
+
+                  if (n == 1)
+                     ctx.put("storeOnly", true);
+
+                  compress(input, ctx, null);
+               }
+
+               best = Math.min(best, System.nanoTime()-before);
+            }
+
+            System.out.println(String.format("%-12s %8.1f MB/s", names[n],
+               (double) input.length*1000 / (1024*1024) / Math.max(best/1000000.0, 0.001)));
+         }
+
+         System.out.println("Identical");
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   public static boolean testInflightBlocks()
+   {
+      final int[] inflight = { 1, 2, 3, 8 };
+      final int blockSize = 256*1024;
+      final int jobs = 8;
+      ExecutorService pool = Executors.newFixedThreadPool(jobs);
+
+      try
+      {
+         // Ragged last block
+         byte[] input = generateText(20*blockSize+4321, 1357);
+         byte[] ref = null;
+
+         for (int i=0; i<inflight.length; i++)
+         {
+            Map<String, Object> ctx = createContext("TEXT+BWT+RANK+ZRLT", "ANS0", blockSize, true);
+            ctx.put("jobs", jobs);
+            ctx.put("pool", pool);
+            ByteArrayOutputStream baos = new ByteArrayOutputStream(input.length);
+            CompressedOutputStream cos = new CompressedOutputStream(baos, ctx);
+            cos.setMaxInflightBlocks(inflight[i]);
+            final long before = System.nanoTime();
+            cos.write(input, 0, input.length);
+            cos.close();
+            final long after = System.nanoTime();
+            byte[] output = baos.toByteArray();
+            System.out.println("In-flight blocks=" + inflight[i] + ": " + input.length + " => " +
+               output.length + " bytes, " + (after-before)/1000000L + " ms");
+
+            if (ref == null)
+               ref = output;
+            else if (Arrays.equals(ref, output) == false)
+            {
+               System.out.println("Different output with " + inflight[i] + " in-flight blocks");
+               return false;
+            }
+         }
+
+         byte[] reverse = decompress(ref, createContext("NONE", "NONE", blockSize, false));
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different data after decompression");
+            return false;
+         }
+
+         try
+         {
+            new CompressedOutputStream(new ByteArrayOutputStream(),
+               createContext("NONE", "NONE", blockSize, false)).setMaxInflightBlocks(0);
+            System.out.println("Invalid number of in-flight blocks not detected");
+            return false;
+         }
+         catch (IllegalArgumentException e)
+         {
+            System.out.println("Expected error: " + e.getMessage());
+         }
+
+         System.out.println("Identical");
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+      finally
+      {
+         pool.shutdown();
+      }
+   }
+
+
+   public static boolean testDecompressInto()
+   {
+      try
+      {
+         byte[] input = generateText(3*65536+777, 97531);
+         byte[] compressed = compress(input, createContext("LZ", "HUFFMAN", 65536, true), null);
+         final int[] sizes = { input.length, input.length+1000, input.length-1, 1000, 0 };
+
+         for (int size : sizes)
+         {
+            byte[] dst = new byte[size];
+
+            try
+            {
+               final int n = CompressedStreams.decompressInto(dst, compressed);
+
+               if (size < input.length)
+               {
+                  System.out.println("Buffer of " + size + " bytes: expected short buffer error");
+                  return false;
+               }
+
+               if ((n != input.length) || (Arrays.equals(input, Arrays.copyOf(dst, n)) == false))
+               {
+                  System.out.println("Buffer of " + size + " bytes: different data after decompression");
+                  return false;
+               }
+
+               System.out.println("Buffer of " + size + " bytes: " + n + " bytes written");
+            }
+            catch (ShortBufferException e)
+            {
+               // The exact size is reported, a retry with this size succeeds
+               if ((size >= input.length) || (e.getRequiredSize() != input.length) ||
+                  (e.getErrorCode() != kanzi.Error.ERR_SHORT_BUFFER))
+               {
+                  System.out.println("Buffer of " + size + " bytes: unexpected error: " + e.getMessage());
+                  return false;
+               }
+
+               System.out.println("Buffer of " + size + " bytes: expected error: " + e.getMessage());
+
+               if (CompressedStreams.decompressInto(new byte[(int) e.getRequiredSize()], compressed) != input.length)
+               {
+                  System.out.println("Buffer of " + size + " bytes: retry with the required size failed");
+                  return false;
+               }
+            }
+         }
+
+         System.out.println("Identical");
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   public static boolean testChecksumKinds()
+   {
+      // Check value of the CRC32C implementation
+      if (new kanzi.util.hash.CRC32C().hash("123456789".getBytes()) != 0xE3069283)
+      {
+         System.out.println("Invalid CRC32C check value");
+         return false;
+      }
+
+      try
+      {
+         final int blockSize = 65536;
+         byte[] input = generateText(4*blockSize+999, 3141);
+
+         for (ChecksumKind kind : ChecksumKind.values())
+         {
+            // Store only: tampering with the data does not break the block structure
+            Map<String, Object> ctx = createContext("NONE", "NONE", blockSize, false);
+            ctx.put("storeOnly", true);
+            ByteArrayOutputStream baos = new ByteArrayOutputStream(input.length);
+            CompressedOutputStream cos = new CompressedOutputStream(baos, ctx);
+            cos.setChecksum(kind);
+            cos.write(input, 0, input.length);
+            cos.close();
+            byte[] output = baos.toByteArray();
+
+            CompressedInputStream cis = new CompressedInputStream(new ByteArrayInputStream(output),
+               createContext("NONE", "NONE", blockSize, false));
+            StreamInfo info = cis.getStreamInfo();
+            cis.close();
+            System.out.println(kind + ": " + input.length + " => " + output.length +
+               ", header checksum: " + info.getChecksumKind());
+
+            if ((info.getChecksumKind() != kind) || (info.hasChecksum() != (kind != ChecksumKind.NONE)))
+            {
+               System.out.println("Different checksum type in header");
+               return false;
+            }
+
+            byte[] reverse = decompress(output, createContext("NONE", "NONE", blockSize, false));
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different data after decompression");
+               return false;
+            }
+
+            // Tamper with the data of a block
+            output[output.length/2+1000] ^= 0x20;
+
+            try
+            {
+               reverse = decompress(output, createContext("NONE", "NONE", blockSize, false));
+
+               if (kind != ChecksumKind.NONE)
+               {
+                  System.out.println("Corruption not detected");
+                  return false;
+               }
+
+               System.out.println("No checksum: corruption not detected (" +
+                  ((Arrays.equals(input, reverse) == true) ? "same" : "different") + " data)");
+            }
+            catch (kanzi.io.IOException e)
+            {
+               if ((kind == ChecksumKind.NONE) || (e.getErrorCode() != kanzi.Error.ERR_CRC_CHECK))
+               {
+                  System.out.println("Unexpected error: " + e.getMessage());
+                  return false;
+               }
+
+               System.out.println("Expected error: " + e.getMessage());
+            }
+         }
+
+         // The checksum type is part of the stream parameters
+         Map<String, Object> ctx = createContext("LZ", "HUFFMAN", blockSize, false);
+         ctx.put("checksumKind", "XXHASH64");
+         byte[] output = compress(input, ctx, null);
+         CompressedInputStream cis = new CompressedInputStream(new ByteArrayInputStream(output),
+            createContext("NONE", "NONE", blockSize, false));
+         Map<String, Object> params = cis.getStreamInfo().getParams();
+         cis.close();
+         params.put("jobs", 1);
+
+         if (Arrays.equals(output, compress(input, params, null)) == false)
+         {
+            System.out.println("Different output with the parameters of the stream: " + params);
+            return false;
+         }
+
+         System.out.println("Identical");
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   // Sink recording the last value of each metric
+   static class TestMetricsSink implements MetricsSink
+   {
+      final Map<String, Double> values = new HashMap<>();
+
+      @Override
+      public void observe(String name, double value)
+      {
+         this.values.put(name, value);
+      }
+   }
+
+
+   static boolean checkMetric(TestMetricsSink sink, String name, double expected)
+   {
+      final Double value = sink.values.get(name);
+
+      if ((value == null) || (Math.abs(value-expected) > 1e-9))
+      {
+         System.out.println("Invalid metric " + name + ": " + value + " (expected " + expected + ")");
+         return false;
+      }
+
+      return true;
+   }
+
+
+   public static boolean testMetricsSink()
+   {
+      try
+      {
+         final int blockSize = 65536;
+         byte[] input = generateText(4*blockSize+500, 2718);
+
+         // Compression metrics
+         TestMetricsSink sink = new TestMetricsSink();
+         ByteArrayOutputStream baos = new ByteArrayOutputStream(input.length);
+         CompressedOutputStream cos = new CompressedOutputStream(baos,
+            createContext("TEXT+BWT", "ANS0", blockSize, true));
+         cos.setMetricsSink(sink);
+         cos.write(input, 0, input.length);
+         cos.close();
+         byte[] output = baos.toByteArray();
+         System.out.println("Compression metrics: " + sink.values);
+
+         if ((checkMetric(sink, MetricsSink.COMPRESS_BLOCKS, 5) == false)
+            || (checkMetric(sink, MetricsSink.COMPRESS_INPUT_BYTES, input.length) == false)
+            || (checkMetric(sink, MetricsSink.COMPRESS_OUTPUT_BYTES, output.length) == false)
+            || (checkMetric(sink, MetricsSink.COMPRESS_RATIO, (double) output.length/input.length) == false)
+            || (checkMetric(sink, MetricsSink.COMPRESS_ENTROPY_BLOCKS+"{codec=\"ANS0\"}", 5) == false)
+            || (checkMetric(sink, MetricsSink.COMPRESS_TRANSFORM_BLOCKS+"{codec=\"TEXT\"}", 5) == false)
+            || (checkMetric(sink, MetricsSink.COMPRESS_TRANSFORM_BLOCKS+"{codec=\"BWT\"}", 5) == false))
+            return false;
+
+         if (sink.values.getOrDefault(MetricsSink.COMPRESS_BLOCKS_PER_SECOND, 0.0) <= 0)
+         {
+            System.out.println("Missing compression speed");
+            return false;
+         }
+
+         // Decompression metrics
+         sink = new TestMetricsSink();
+         CompressedInputStream cis = new CompressedInputStream(new ByteArrayInputStream(output),
+            createContext("NONE", "NONE", blockSize, false));
+         cis.setMetricsSink(sink);
+         // Read up to the end of stream (including the end block)
+         byte[] reverse = new byte[input.length+1];
+         int n = 0, len;
+
+         while ((len = cis.read(reverse, n, reverse.length-n)) > 0)
+            n += len;
+
+         cis.close();
+         System.out.println("Decompression metrics: " + sink.values);
+
+         if ((n != input.length) || (Arrays.equals(input, Arrays.copyOf(reverse, n)) == false))
+         {
+            System.out.println("Different data after decompression");
+            return false;
+         }
+
+         if ((checkMetric(sink, MetricsSink.DECOMPRESS_BLOCKS, 5) == false)
+            || (checkMetric(sink, MetricsSink.DECOMPRESS_INPUT_BYTES, output.length) == false)
+            || (checkMetric(sink, MetricsSink.DECOMPRESS_OUTPUT_BYTES, input.length) == false)
+            || (checkMetric(sink, MetricsSink.DECOMPRESS_ENTROPY_BLOCKS+"{codec=\"ANS0\"}", 5) == false)
+            || (checkMetric(sink, MetricsSink.DECOMPRESS_CHECKSUM_FAILURES, 0) == false))
+            return false;
+
+         // Checksum failure (store only: tampering does not break the block structure)
+         Map<String, Object> ctx = createContext("NONE", "NONE", blockSize, true);
+         ctx.put("storeOnly", true);
+         output = compress(input, ctx, null);
+         output[output.length/2+1000] ^= 0x20;
+         sink = new TestMetricsSink();
+         cis = new CompressedInputStream(new ByteArrayInputStream(output),
+            createContext("NONE", "NONE", blockSize, false));
+         cis.setMetricsSink(sink);
+
+         try
+         {
+            while (cis.read(reverse, 0, reverse.length) > 0)
+            {
+            }
+
+            System.out.println("Corruption not detected");
+            return false;
+         }
+         catch (kanzi.io.IOException e)
+         {
+            System.out.println("Expected error: " + e.getMessage());
+         }
+
+         cis.close();
+
+         if (checkMetric(sink, MetricsSink.DECOMPRESS_CHECKSUM_FAILURES, 1) == false)
+            return false;
+
+         System.out.println("Identical");
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   public static boolean testConfigFreeDecode()
+   {
+      try
+      {
+         byte[] text = generateText(300000, 1618);
+
+         // Image like data (smooth rows of 3 byte pixels)
+         final int w = 300;
+         final int h = 200;
+         byte[] image = new byte[3*w*h];
+
+         for (int i=0; i<image.length; i++)
+            image[i] = (byte) (100 + 50*Math.sin((i/3)/37.0) + (i%3)*20);
+
+         final byte[][] inputs = { text, image, image };
+         final String[] transforms = { "TEXT+BWT+RANK+ZRLT", "MORTON+COLDELTA+ZRLT", "TRANSPOSE+HILBERT" };
+
+         for (int n=0; n<inputs.length; n++)
+         {
+            // Non default transform parameters
+            Map<String, Object> ctx = createContext(transforms[n], "ANS0", 1<<20, true);
+            ctx.put("mortonWidth", 3*w);
+            ctx.put("mortonHeight", h);
+            ctx.put("columnStride", 3);
+            ctx.put("transposeRows", w*h);
+            ctx.put("transposeCols", 3);
+            ctx.put("hilbertWidth", w);
+            ctx.put("hilbertHeight", h);
+            byte[] output = compress(inputs[n], ctx, null);
+
+            // No transform, codec or parameter hints at all
+            Map<String, Object> dCtx = new HashMap<>();
+            dCtx.put("jobs", 1);
+            byte[] reverse = decompress(output, dCtx);
+
+            if (Arrays.equals(inputs[n], reverse) == false)
+            {
+               System.out.println(transforms[n] + ": different output after decompression");
+               return false;
+            }
+
+            // Misleading hints: the stream header is authoritative
+            dCtx = createContext("LZ", "HUFFMAN", 65536, false);
+            dCtx.put("mortonWidth", 16);
+            dCtx.put("columnStride", 7);
+            dCtx.put("hilbertWidth", 5);
+            reverse = decompress(output, dCtx);
+
+            if (Arrays.equals(inputs[n], reverse) == false)
+            {
+               System.out.println(transforms[n] + ": different output with a misleading context");
+               return false;
+            }
+
+            CompressedStreams.verify(new ByteArrayInputStream(output));
+            System.out.println(transforms[n] + ": " + inputs[n].length + " => " + output.length + ", identical");
+         }
+
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   public static boolean testSecondBWT()
+   {
+      ExecutorService pool = Executors.newFixedThreadPool(2);
+
+      try
+      {
+         Random rnd = new Random(244);
+         byte[] noisy = new byte[200000];
+
+         for (int i=0; i<noisy.length; i++)
+            noisy[i] = (byte) ((i & 63) + rnd.nextInt(4));
+
+         final byte[][] inputs = { generateText(500000, 244), noisy, generateText(17, 1) };
+         final String[] names = { "text", "noisy", "tiny" };
+         final String[] transforms = { "BWT+MTFT+ZRLT", "BWT+MTFT+BWT+ZRLT" };
+
+         for (int n=0; n<inputs.length; n++)
+         {
+            int[] sizes = new int[transforms.length];
+
+            for (int t=0; t<transforms.length; t++)
+            {
+               // Several blocks and jobs: each block carries the primary
+               // indexes of both BWT stages
+               Map<String, Object> ctx = createContext(transforms[t], "ANS0", 65536, true);
+               ctx.put("jobs", 2);
+               ctx.put("pool", pool);
+               byte[] output = compress(inputs[n], ctx, null);
+               Map<String, Object> dCtx = new HashMap<>();
+               dCtx.put("jobs", 2);
+               dCtx.put("pool", pool);
+               byte[] reverse = decompress(output, dCtx);
+
+               if (Arrays.equals(inputs[n], reverse) == false)
+               {
+                  System.out.println(names[n] + " " + transforms[t] + ": different output after decompression");
+                  return false;
+               }
+
+               sizes[t] = output.length;
+            }
+
+            System.out.println(names[n] + ": " + inputs[n].length + " => " + sizes[0] + " (" + transforms[0] +
+               "), " + sizes[1] + " (" + transforms[1] + ")");
+         }
+
+         // The BWT stages of a block keep their buffers until the end of the block
+         if (BlockCompressor.estimateMemory(1<<20, 2, 2, false) <= BlockCompressor.estimateMemory(1<<20, 2, 1, false))
+         {
+            System.out.println("The memory estimate should account for both BWT stages");
+            return false;
+         }
+
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+      finally
+      {
+         pool.shutdown();
+      }
+   }
+
+
+   // Input stream returning at most 'chunk' bytes per read (like a network stream)
+   static InputStream chunkedStream(byte[] data, final int chunk)
+   {
+      return new FilterInputStream(new ByteArrayInputStream(data))
+      {
+         @Override
+         public int read(byte[] b, int off, int len) throws IOException
+         {
+            return super.read(b, off, Math.min(len, chunk));
+         }
+      };
+   }
+
+
+   public static boolean testCompressStream()
+   {
+      try
+      {
+         final byte[] text = generateText(200000, 245);
+         final byte[][] inputs = { text, new byte[0], Arrays.copyOf(text, 1) };
+         final int[] chunks = { 1, 7, 1023, 65537, 1<<20 };
+
+         for (byte[] input : inputs)
+         {
+            for (int chunk : chunks)
+            {
+               // Unknown length: no fileSize in the context, several blocks
+               // and a short last block
+               Map<String, Object> ctx = createContext("TEXT+BWT+RANK+ZRLT", "ANS0", 65536, true);
+               ByteArrayOutputStream baos = new ByteArrayOutputStream();
+               final long read = CompressedStreams.compress(chunkedStream(input, chunk), baos, ctx);
+
+               if (read != input.length)
+               {
+                  System.out.println("Chunk size " + chunk + ": read " + read + " bytes, expected " + input.length);
+                  return false;
+               }
+
+               byte[] output = baos.toByteArray();
+               CompressedStreams.verify(new ByteArrayInputStream(output));
+               byte[] reverse = decompress(output, createContext("NONE", "NONE", 65536, false));
+
+               if (Arrays.equals(input, reverse) == false)
+               {
+                  System.out.println("Chunk size " + chunk + ": different output after decompression");
+                  return false;
+               }
+            }
+
+            System.out.println(input.length + " bytes: identical for chunk sizes " + Arrays.toString(chunks));
+         }
+
+         // Default context
+         ByteArrayOutputStream baos = new ByteArrayOutputStream();
+         CompressedStreams.compress(chunkedStream(text, 333), baos, null);
+
+         if (Arrays.equals(text, decompress(baos.toByteArray(), createContext("NONE", "NONE", 65536, false))) == false)
+         {
+            System.out.println("Default context: different output after decompression");
+            return false;
+         }
+
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   public static boolean testBoundaryDetector()
+   {
+      ExecutorService pool = Executors.newFixedThreadPool(2);
+
+      try
+      {
+         final byte[] input = generateText(300000, 247);
+         final int blockSize = 16384;
+         final int minBlockSize = 4096;
+
+         // Cut after a newline
+         BoundaryDetector newline = new BoundaryDetector()
+         {
+            @Override
+            public boolean cut(byte[] array, int index, int length)
+            {
+               return array[index+length-1] == '\n';
+            }
+         };
+
+         for (int jobs=1; jobs<=2; jobs++)
+         {
+            Map<String, Object> ctx = createContext("TEXT+BWT+RANK+ZRLT", "ANS0", blockSize, true);
+            ctx.put("jobs", jobs);
+            ctx.put("pool", pool);
+            final TreeMap<Integer, Long> sizes = new TreeMap<>();
+            ByteArrayOutputStream baos = new ByteArrayOutputStream(input.length);
+            CompressedOutputStream cos = new CompressedOutputStream(baos, ctx);
+            cos.setBoundaryDetector(newline, 1, minBlockSize);
+            cos.addListener(new Listener()
+            {
+               @Override
+               public void processEvent(Event evt)
+               {
+                  if (evt.getType() == Event.Type.BEFORE_TRANSFORM)
+                  {
+                     synchronized (sizes)
+                     {
+                        sizes.put(evt.getId(), evt.getSize());
+                     }
+                  }
+               }
+            });
+
+            // Writes of odd sizes
+            for (int off=0; off<input.length; off+=1001)
+               cos.write(input, off, Math.min(1001, input.length-off));
+
+            cos.close();
+            byte[] output = baos.toByteArray();
+
+            // Every block but the last one ends with a newline
+            long offset = 0;
+            int n = 0;
+
+            for (long sz : sizes.values())
+            {
+               offset += sz;
+               n++;
+
+               if ((n < sizes.size()) && ((input[(int) offset-1] != '\n') || (sz < minBlockSize) || (sz > blockSize)))
+               {
+                  System.out.println("Jobs=" + jobs + ": invalid boundary for block " + n + " (size " + sz + ")");
+                  return false;
+               }
+            }
+
+            if (offset != input.length)
+            {
+               System.out.println("Jobs=" + jobs + ": the blocks do not cover the input");
+               return false;
+            }
+
+            // The decoder is not aware of the boundaries
+            byte[] reverse = decompress(output, createContext("NONE", "NONE", 65536, false));
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Jobs=" + jobs + ": different output after decompression");
+               return false;
+            }
+
+            System.out.println("Jobs=" + jobs + ": " + sizes.size() + " blocks, " + input.length + " => " +
+               output.length + ", identical");
+         }
+
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+      finally
+      {
+         pool.shutdown();
+      }
+   }
+
+
+   public static boolean testFormatVersion()
+   {
+      try
+      {
+         final byte[] input = generateText(200000, 249);
+
+         for (int version=9; version<=11; version++)
+         {
+            Map<String, Object> ctx = createContext("TEXT+BWT+RANK+ZRLT", "ANS0", 65536, true);
+            ctx.put("level", 3);
+            ByteArrayOutputStream baos = new ByteArrayOutputStream(input.length);
+            CompressedOutputStream cos = new CompressedOutputStream(baos, ctx);
+            cos.setFormatVersion(version);
+            cos.write(input, 0, input.length);
+            cos.close();
+            byte[] output = baos.toByteArray();
+
+            // Current decoder
+            CompressedInputStream cis = new CompressedInputStream(new ByteArrayInputStream(output),
+               createContext("NONE", "NONE", 65536, false));
+            StreamInfo info = cis.getStreamInfo();
+            cis.close();
+
+            if ((info.getVersion() != version) || (info.hasChecksum() == false))
+            {
+               System.out.println("Version " + version + ": incorrect header " + info.getVersion());
+               return false;
+            }
+
+            if ((version == 9) && ((info.getLevel() != -1) || (info.getExtraParams().isEmpty() == false)))
+            {
+               System.out.println("Version 9: no level or extended parameters expected");
+               return false;
+            }
+
+            if (Arrays.equals(input, decompress(output, createContext("NONE", "NONE", 65536, false))) == false)
+            {
+               System.out.println("Version " + version + ": different output after decompression");
+               return false;
+            }
+
+            System.out.println("Version " + version + ": " + input.length + " => " + output.length + ", identical");
+         }
+
+         // Version 9 header: fixed fields only (128 bits), no checksum type
+         Map<String, Object> ctx = createContext("BWT+MTFT", "HUFFMAN", 65536, true);
+         ByteArrayOutputStream baos = new ByteArrayOutputStream();
+         CompressedOutputStream cos = new CompressedOutputStream(baos, ctx);
+         cos.setFormatVersion(9);
+         cos.write(input, 0, 1000);
+         cos.close();
+         DefaultInputBitStream ibs = new DefaultInputBitStream(new ByteArrayInputStream(baos.toByteArray()), 16384);
+         final boolean valid = (ibs.readBits(32) == 0x4B414E5A) && (ibs.readBits(5) == 9) &&
+            (ibs.readBit() == 1) && (ibs.readBits(5) == EntropyCodecFactory.HUFFMAN_TYPE) &&
+            (ibs.readBits(48) == new ByteFunctionFactory().getType("BWT+MTFT")) &&
+            (ibs.readBits(28) == 65536>>4) && (ibs.readBits(6) == 0) && (ibs.readBits(3) == 0);
+         ibs.close();
+
+         if (valid == false)
+         {
+            System.out.println("Version 9: incorrect header layout");
+            return false;
+         }
+
+         // Features missing in older versions
+         final String[][] cases = { { "10", "checksumKind", "CRC32C" }, { "9", "alignment", "4096" },
+            { "9", "transform", "HILBERT" }, { "10", "transform", "UTF8REMAP" }, { "9", "codec", "MIXRANGE" },
+            { "9", "entropyChunkSize", "65536" }, { "8", "", "" }, { "9", "transform", "BRLT" },
+            { "10", "transform", "TEMPLATE" }, { "10", "codec", "MIXRANGE" } };
+
+         for (String[] c : cases)
+         {
+            ctx = createContext("BWT", "ANS0", 65536, false);
+
+            if ("alignment".equals(c[1]) || "entropyChunkSize".equals(c[1]))
+               ctx.put(c[1], Integer.parseInt(c[2]));
+            else if (c[1].isEmpty() == false)
+               ctx.put(c[1], c[2]);
+
+            try
+            {
+               new CompressedOutputStream(new ByteArrayOutputStream(), ctx).setFormatVersion(Integer.parseInt(c[0]));
+               System.out.println("Version " + c[0] + " with " + c[1] + "=" + c[2] + ": expected failure");
+               return false;
+            }
+            catch (IllegalArgumentException e)
+            {
+               System.out.println("Version " + c[0] + ": " + e.getMessage());
+            }
+         }
+
+         // BRLT was registered with version 10
+         cos = new CompressedOutputStream(new ByteArrayOutputStream(), createContext("BRLT", "ANS0", 65536, false));
+         cos.setFormatVersion(10);
+         cos.close();
+
+         // Not allowed once the header is written
+         cos = new CompressedOutputStream(new ByteArrayOutputStream(), createContext("BWT", "ANS0", 65536, false));
+         cos.write(input, 0, 100000);
+
+         try
+         {
+            cos.setFormatVersion(10);
+            System.out.println("Expected failure after the header is written");
+            return false;
+         }
+         catch (IllegalStateException e)
+         {
+            // Expected
+         }
+
+         cos.close();
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+   }
+
+
+   public static boolean testAnytime()
+   {
+      ExecutorService pool = Executors.newFixedThreadPool(2);
+
+      try
+      {
+         final byte[] input = generateText(600000, 253);
+
+         // Per block choice: with an expired deadline only the fastest level
+         // is used, without deadline the best level is selected
+         byte[] block = Arrays.copyOf(input, 65536);
+         long[] deadlines = { System.nanoTime(), -1 };
+
+         for (long deadline : deadlines)
+         {
+            Map<String, Object> ctx = new HashMap<>();
+            ctx.put("jobs", 1);
+
+            if (deadline != -1)
+               ctx.put("anytimeDeadline", deadline);
+
+            AnytimeCodec codec = new AnytimeCodec(ctx);
+            byte[] output = new byte[codec.getMaxEncodedLength(block.length)];
+            byte[] reverse = new byte[block.length];
+            SliceByteArray sa1 = new SliceByteArray(block, 0);
+            SliceByteArray sa2 = new SliceByteArray(output, 0);
+
+            if (codec.forward(sa1, sa2) == false)
+            {
+               System.out.println("Encoding error");
+               return false;
+            }
+
+            final int level = codec.getLastLevel();
+            System.out.println(((deadline == -1) ? "No deadline" : "Expired deadline") + ": level " +
+               level + ", " + block.length + " => " + sa2.index);
+
+            if ((deadline != -1) && (level != AnytimeCodec.LZ_LEVEL))
+            {
+               System.out.println("Only the fastest level should be used after the deadline");
+               return false;
+            }
+
+            sa2.length = sa2.index;
+            sa2.index = 0;
+
+            // The decoder only reads the level from the block
+            AnytimeCodec decoder = new AnytimeCodec();
+
+            if ((decoder.inverse(sa2, new SliceByteArray(reverse, 0)) == false) ||
+               (Arrays.equals(block, reverse) == false))
+            {
+               System.out.println("Different output after inverse");
+               return false;
+            }
+         }
+
+         // Generous and tight budgets (in ms): the stream is valid in both cases
+         for (int budget : new int[] { 60000, 1 })
+         {
+            for (int jobs=1; jobs<=2; jobs++)
+            {
+               Map<String, Object> ctx = createContext("ANYTIME", "ANS0", 65536, true);
+               ctx.put("jobs", jobs);
+               ctx.put("pool", pool);
+               ctx.put("anytimeBudget", budget);
+               final long before = System.nanoTime();
+               byte[] output = compress(input, ctx, null);
+               final long delay = (System.nanoTime() - before) / 1000000;
+               System.out.println("Budget " + budget + " ms, jobs=" + jobs + ": " + input.length +
+                  " => " + output.length + " in " + delay + " ms");
+
+               // The deadline must not leak into the context of the caller
+               if (ctx.containsKey("anytimeDeadline") == true)
+               {
+                  System.out.println("The context of the caller was modified");
+                  return false;
+               }
+
+               byte[] reverse = decompress(output, createContext("NONE", "NONE", 65536, false));
+
+               if (Arrays.equals(input, reverse) == false)
+               {
+                  System.out.println("Budget " + budget + " ms: different output after decompression");
+                  return false;
+               }
+            }
+         }
+
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+      finally
+      {
+         pool.shutdown();
+      }
+   }
+
+
+   public static boolean testSharding()
+   {
+      ExecutorService pool = Executors.newFixedThreadPool(2);
+
+      try
+      {
+         final byte[] input = generateText(500000, 255);
+         final Map<Integer, byte[]> shards = new HashMap<>();
+
+         // In memory shards
+         ShardFactory factory = new ShardFactory()
+         {
+            @Override
+            public OutputStream createShard(final int blockId)
+            {
+               return new ByteArrayOutputStream()
+               {
+                  @Override
+                  public void close()
+                  {
+                     shards.put(blockId, this.toByteArray());
+                  }
+               };
+            }
+
+            @Override
+            public InputStream openShard(int blockId) throws IOException
+            {
+               final byte[] data = shards.get(blockId);
+
+               if (data == null)
+                  throw new IOException("No shard for block " + blockId);
+
+               return new ByteArrayInputStream(data);
+            }
+         };
+
+         for (int jobs=1; jobs<=2; jobs++)
+         {
+            Map<String, Object> ctx = createContext("TEXT+BWT+RANK+ZRLT", "ANS0", 65536, true);
+            ctx.put("jobs", jobs);
+            ctx.put("pool", pool);
+            final byte[] regular = compress(input, ctx, null);
+            shards.clear();
+            ByteArrayOutputStream baos = new ByteArrayOutputStream();
+            CompressedOutputStream cos = new CompressedOutputStream(baos, ctx);
+            cos.setShardFactory(factory);
+            cos.write(input, 0, input.length);
+            cos.close();
+            final byte[] manifest = baos.toByteArray();
+            long total = 0;
+
+            for (byte[] shard : shards.values())
+               total += shard.length;
+
+            System.out.println("Jobs=" + jobs + ": manifest " + manifest.length + " bytes, " +
+               shards.size() + " shards, " + total + " bytes (regular stream: " + regular.length + ")");
+
+            if (shards.size() != (input.length+65535) / 65536)
+            {
+               System.out.println("Incorrect number of shards: " + shards.size());
+               return false;
+            }
+
+            // The rebuilt stream is the regular stream
+            baos = new ByteArrayOutputStream();
+            final int nbBlocks = CompressedStreams.unshard(new ByteArrayInputStream(manifest), factory, baos);
+
+            if ((nbBlocks != shards.size()) || (Arrays.equals(regular, baos.toByteArray()) == false))
+            {
+               System.out.println("The rebuilt stream differs from the regular stream");
+               return false;
+            }
+
+            byte[] reverse = decompress(baos.toByteArray(), createContext("NONE", "NONE", 65536, false));
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Jobs=" + jobs + ": different output after decompression");
+               return false;
+            }
+         }
+
+         // Corrupted shard
+         final byte[] manifest = compressSharded(input, factory);
+         shards.get(2)[10] ^= 1;
+
+         try
+         {
+            CompressedStreams.unshard(new ByteArrayInputStream(manifest), factory, new ByteArrayOutputStream());
+            System.out.println("Expected failure with a corrupted shard");
+            return false;
+         }
+         catch (BlockException e)
+         {
+            System.out.println("Corrupted shard: " + e.getMessage());
+
+            if (e.getBlockId() != 2)
+               return false;
+         }
+
+         // Missing shard
+         shards.get(2)[10] ^= 1;
+         shards.remove(3);
+
+         try
+         {
+            CompressedStreams.unshard(new ByteArrayInputStream(manifest), factory, new ByteArrayOutputStream());
+            System.out.println("Expected failure with a missing shard");
+            return false;
+         }
+         catch (IOException e)
+         {
+            System.out.println("Missing shard: " + e.getMessage());
+         }
+
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+      finally
+      {
+         pool.shutdown();
+      }
+   }
+
+
+   private static byte[] compressSharded(byte[] input, ShardFactory factory) throws IOException
+   {
+      ByteArrayOutputStream baos = new ByteArrayOutputStream();
+      CompressedOutputStream cos = new CompressedOutputStream(baos, createContext("TEXT+BWT+RANK+ZRLT", "ANS0", 65536, true));
+      cos.setShardFactory(factory);
+      cos.write(input, 0, input.length);
+      cos.close();
+      return baos.toByteArray();
+   }
+
+
+   public static boolean testRotate()
+   {
+      ExecutorService pool = Executors.newFixedThreadPool(2);
+
+      try
+      {
+         final byte[] input = generateText(400000, 257);
+         final int blockSize = 32768;
+         final int k = 5;
+
+         for (int jobs=1; jobs<=2; jobs++)
+         {
+            Map<String, Object> ctx = createContext("TEXT+BWT+RANK+ZRLT", "ANS0", blockSize, true);
+            ctx.put("jobs", jobs);
+            ctx.put("pool", pool);
+            ctx.put("alignment", 16);
+            final int[] blocks = new int[1];
+            ByteArrayOutputStream baos1 = new ByteArrayOutputStream();
+            ByteArrayOutputStream baos2 = new ByteArrayOutputStream();
+            CompressedOutputStream cos = new CompressedOutputStream(baos1, ctx);
+            cos.addListener(new Listener()
+            {
+               @Override
+               public void processEvent(Event evt)
+               {
+                  if (evt.getType() == Event.Type.AFTER_ENTROPY)
+                  {
+                     synchronized (blocks)
+                     {
+                        blocks[0]++;
+                     }
+                  }
+               }
+            });
+
+            // Rotate after k blocks
+            cos.write(input, 0, k*blockSize);
+            cos.rotate(baos2);
+
+            if ((blocks[0] != k) || (cos.getWritten() != baos1.size()))
+            {
+               System.out.println("Jobs=" + jobs + ": " + blocks[0] + " blocks and " + baos1.size() +
+                  " bytes in the first output");
+               return false;
+            }
+
+            cos.write(input, k*blockSize, input.length-k*blockSize);
+            cos.close();
+            System.out.println("Jobs=" + jobs + ": " + baos1.size() + " + " + baos2.size() + " bytes, " +
+               blocks[0] + " blocks");
+
+            // The concatenation of the outputs is the regular stream
+            ByteArrayOutputStream concat = new ByteArrayOutputStream();
+            concat.write(baos1.toByteArray());
+            concat.write(baos2.toByteArray());
+
+            if (Arrays.equals(compress(input, ctx, null), concat.toByteArray()) == false)
+            {
+               System.out.println("Jobs=" + jobs + ": the concatenation differs from the regular stream");
+               return false;
+            }
+
+            byte[] reverse = decompress(concat.toByteArray(), createContext("NONE", "NONE", blockSize, false));
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Jobs=" + jobs + ": different output after decompression");
+               return false;
+            }
+         }
+
+         // Blocks not byte aligned
+         CompressedOutputStream cos = new CompressedOutputStream(new ByteArrayOutputStream(),
+            createContext("BWT", "ANS0", blockSize, false));
+         cos.write(input, 0, blockSize);
+
+         try
+         {
+            cos.rotate(new ByteArrayOutputStream());
+            System.out.println("Expected failure without block alignment");
+            return false;
+         }
+         catch (IllegalStateException e)
+         {
+            System.out.println("No block alignment: " + e.getMessage());
+         }
+
+         cos.close();
+         return true;
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+      finally
+      {
+         pool.shutdown();
+      }
+   }
+}
@@ -43,6 +43,7 @@ public class TestDefaultBitStream
       testCorrectnessAligned2();
       testCorrectnessMisaligned1();
       testCorrectnessMisaligned2();
+      testWords();
       testSpeed1(args); // Writes big output.bin file to local dir (or specified file name) !!!
       testSpeed2(args); // Writes big output.bin file to local dir (or specified file name) !!!
    }
@@ -55,6 +56,7 @@ public class TestDefaultBitStream
       Assert.assertTrue(testCorrectnessAligned2());
       Assert.assertTrue(testCorrectnessMisaligned1());
       Assert.assertTrue(testCorrectnessMisaligned2());
+      Assert.assertTrue(testWords());
    }
     
     
@@ -455,6 +457,88 @@ public class TestDefaultBitStream
    }
 
    
+   public static boolean testWords()
+   {
+      System.out.println("Correctness Test - words");
+      Random rnd = new Random(24680);
+
+      try
+      {
+         for (int bytes=1; bytes<=8; bytes++)
+         {
+            for (int e=0; e<2; e++)
+            {
+               final boolean bigEndian = e == 0;
+
+               // Byte order of an aligned word: 0x01 0x02 ... (big endian)
+               ByteArrayOutputStream baos = new ByteArrayOutputStream(64);
+               OutputBitStream obs = new DefaultOutputBitStream(baos, 16384);
+               obs.writeWord(0x0807060504030201L, bytes, bigEndian);
+               obs.close();
+               byte[] block = baos.toByteArray();
+
+               for (int i=0; i<bytes; i++)
+               {
+                  final int expected = (bigEndian == true) ? bytes-i : i+1;
+
+                  if (block[i] != expected)
+                  {
+                     System.out.println("Invalid byte order for " + bytes + " byte word: byte " +
+                        i + " is " + block[i] + " (expected " + expected + ")");
+                     return false;
+                  }
+               }
+
+               // Round trip of random words (misaligned: after a few bits)
+               final long[] values = new long[100];
+               final long mask = (bytes == 8) ? -1L : (1L << (8*bytes)) - 1;
+               baos = new ByteArrayOutputStream(1024);
+               obs = new DefaultOutputBitStream(baos, 16384);
+               obs.writeBits(5, 3);
+
+               for (int i=0; i<values.length; i++)
+               {
+                  values[i] = rnd.nextLong();
+                  obs.writeWord(values[i], bytes, bigEndian);
+               }
+
+               obs.close();
+               InputBitStream ibs = new DefaultInputBitStream(new ByteArrayInputStream(baos.toByteArray()), 16384);
+
+               if (ibs.readBits(3) != 5)
+               {
+                  System.out.println("Invalid prefix");
+                  return false;
+               }
+
+               for (int i=0; i<values.length; i++)
+               {
+                  final long value = ibs.readWord(bytes, bigEndian);
+
+                  if (value != (values[i] & mask))
+                  {
+                     System.out.println("Invalid value for " + bytes + " byte word: " +
+                        Long.toHexString(value) + " (expected " + Long.toHexString(values[i] & mask) + ")");
+                     return false;
+                  }
+               }
+
+               ibs.close();
+               System.out.println(bytes + " byte words, " + ((bigEndian == true) ? "big" : "little") +
+                  " endian: Success");
+            }
+         }
+      }
+      catch (Exception e)
+      {
+         e.printStackTrace();
+         return false;
+      }
+
+      return true;
+   }
+
+
    public static boolean testSpeed1(String[] args)
    {    
       // Test speed
@@ -20,6 +20,8 @@ import kanzi.entropy.BinaryEntropyDecoder;
 import kanzi.entropy.BinaryEntropyEncoder;
 import java.io.ByteArrayInputStream;
 import java.io.ByteArrayOutputStream;
+import java.io.IOException;
+import java.util.Arrays;
 import java.util.Random;
 import kanzi.EntropyDecoder;
 import kanzi.EntropyEncoder;
@@ -35,6 +37,8 @@ import kanzi.entropy.ExpGolombDecoder;
 import kanzi.entropy.ExpGolombEncoder;
 import kanzi.entropy.HuffmanDecoder;
 import kanzi.entropy.HuffmanEncoder;
+import kanzi.entropy.MixedRangeDecoder;
+import kanzi.entropy.MixedRangeEncoder;
 import kanzi.Predictor;
 import kanzi.entropy.FPAQDecoder;
 import kanzi.entropy.FPAQEncoder;
@@ -89,6 +93,15 @@ public class TestEntropyCodec
                 System.exit(1);
              
               testSpeed("RANGE", 150);
+              System.out.println("\n\nTest Mixed Range Codec");
+              
+              if (testCorrectness("MIXRANGE")== false)
+                System.exit(1);
+             
+              if (testMixedOrderRatio()== false)
+                System.exit(1);
+             
+              testSpeed("MIXRANGE", 20);
               System.out.println("\n\nTest FPAQ Codec");
               
               if (testCorrectness("FPAQ") == false)
@@ -106,6 +119,9 @@ public class TestEntropyCodec
               if (testCorrectness("TPAQ") == false)
                 System.exit(1);
              
+              if (testMixerWeights() == false)
+                System.exit(1);
+
               testSpeed("TPAQ", 75);
               System.out.println("\n\nTestExpGolombCodec");
               
@@ -144,6 +160,10 @@ public class TestEntropyCodec
       System.out.println("\n\nTest Range Codec");
       Assert.assertTrue(testCorrectness("RANGE"));
       //testSpeed("RANGE");
+      System.out.println("\n\nTest Mixed Range Codec");
+      Assert.assertTrue(testCorrectness("MIXRANGE"));
+      Assert.assertTrue(testMixedOrderRatio());
+      //testSpeed("MIXRANGE");
       System.out.println("\n\nTest FPAQ Codec");
       Assert.assertTrue(testCorrectness("FPAQ"));
       //testSpeed("FPAQ");
@@ -152,6 +172,7 @@ public class TestEntropyCodec
       //testSpeed("CM");
       System.out.println("\n\nTest TPAQ Codec");
       Assert.assertTrue(testCorrectness("TPAQ"));
+      Assert.assertTrue(testMixerWeights());
       //testSpeed("TPAQ");
       System.out.println("\n\nTest ExpGolomb Codec");
       Assert.assertTrue(testCorrectness("EXPGOLOMB"));
@@ -197,6 +218,9 @@ public class TestEntropyCodec
          case "RANGE":
             return new RangeEncoder(obs);
 
+         case "MIXRANGE":
+            return new MixedRangeEncoder(obs);
+
          case "EXPGOLOMB":
             return new ExpGolombEncoder(obs, true);
 
@@ -241,6 +265,9 @@ public class TestEntropyCodec
          case "RANGE":
             return new RangeDecoder(ibs);
 
+         case "MIXRANGE":
+            return new MixedRangeDecoder(ibs);
+
          case "EXPGOLOMB":
             return new ExpGolombDecoder(ibs, true);
 
@@ -356,7 +383,141 @@ public class TestEntropyCodec
       return true;      
    }
 
+
+   // Compare the compression ratio of the order 0..2 mixing model with the
+   // order 0 and order 1 models on text
+   public static boolean testMixedOrderRatio()
+   {
+      System.out.println("\nRatio test for mixed range codec");
+      final String[] words = { "the ", "quick ", "brown ", "fox ", "jumps ", "over ",
+         "lazy ", "dog ", "and ", "runs ", "away. ", "The ", "cat ", "sleeps\n" };
+      Random random = new Random(12345);
+      byte[] input = new byte[200000];
+      int n = 0;
+
+      while (n < input.length)
+      {
+         byte[] w = words[random.nextInt(words.length)].getBytes();
+
+         for (int i=0; (i<w.length) && (n<input.length); i++)
+            input[n++] = w[i];
+      }
+
+      int[] sizes = new int[4];
+
+      for (int order=0; order<=3; order++)
+      {
+         ByteArrayOutputStream os = new ByteArrayOutputStream(input.length);
+         OutputBitStream obs = new DefaultOutputBitStream(os, 16384);
+         EntropyEncoder ec = (order == 3) ? new RangeEncoder(obs) : new MixedRangeEncoder(obs, order);
+         ec.encode(input, 0, input.length);
+         ec.dispose();
+         obs.close();
+         byte[] buf = os.toByteArray();
+         sizes[order] = buf.length;
+         InputBitStream ibs = new DefaultInputBitStream(new ByteArrayInputStream(buf), 16384);
+         EntropyDecoder ed = (order == 3) ? new RangeDecoder(ibs) : new MixedRangeDecoder(ibs, order);
+         byte[] output = new byte[input.length];
+         ed.decode(output, 0, output.length);
+         ed.dispose();
+         ibs.close();
+
+         if (Arrays.equals(input, output) == false)
+         {
+            System.out.println("Different output after decoding");
+            return false;
+         }
+      }
+
+      System.out.println("Range (static order 0) : "+input.length+" => "+sizes[3]);
+      System.out.println("Adaptive order 0       : "+input.length+" => "+sizes[0]);
+      System.out.println("Adaptive order 0-1     : "+input.length+" => "+sizes[1]);
+      System.out.println("Adaptive order 0-2     : "+input.length+" => "+sizes[2]);
+
+      if ((sizes[2] >= sizes[1]) || (sizes[1] >= sizes[0]))
+      {
+         System.out.println("Higher order models should compress text better");
+         return false;
+      }
+
+      return true;
+   }
+
     
+   public static boolean testMixerWeights()
+   {
+      System.out.println("\nTest mixer weights");
+      Random rnd = new Random(4455);
+      TPAQPredictor.Mixer mixer = new TPAQPredictor.Mixer();
+      final int[] p = new int[8];
+
+      try
+      {
+         // Warm up: the first inputs predict the bit better than the others
+         for (int i=0; i<100000; i++)
+         {
+            final int bit = rnd.nextInt(2);
+
+            for (int j=0; j<p.length; j++)
+               p[j] = ((bit == 1) ? 1 : -1) * rnd.nextInt((j < 3) ? 2047 : 64);
+
+            mixer.get(p[0], p[1], p[2], p[3], p[4], p[5], p[6], p[7]);
+            mixer.update(bit);
+         }
+
+         ByteArrayOutputStream baos = new ByteArrayOutputStream();
+         mixer.saveWeights(baos);
+         byte[] weights = baos.toByteArray();
+         System.out.println("Saved weights: " + weights.length + " bytes");
+         TPAQPredictor.Mixer mixer2 = new TPAQPredictor.Mixer();
+         mixer2.loadWeights(new ByteArrayInputStream(weights));
+
+         // Same inputs and bits => same predictions
+         for (int i=0; i<100000; i++)
+         {
+            final int bit = rnd.nextInt(2);
+
+            for (int j=0; j<p.length; j++)
+               p[j] = rnd.nextInt(4095) - 2047;
+
+            final int pr1 = mixer.get(p[0], p[1], p[2], p[3], p[4], p[5], p[6], p[7]);
+            final int pr2 = mixer2.get(p[0], p[1], p[2], p[3], p[4], p[5], p[6], p[7]);
+
+            if (pr1 != pr2)
+            {
+               System.out.println("Different predictions at step " + i + ": " + pr1 + " vs " + pr2);
+               return false;
+            }
+
+            mixer.update(bit);
+            mixer2.update(bit);
+         }
+
+         // Invalid version
+         weights[4] = 99;
+
+         try
+         {
+            new TPAQPredictor.Mixer().loadWeights(new ByteArrayInputStream(weights));
+            System.out.println("Invalid version not detected");
+            return false;
+         }
+         catch (IOException e)
+         {
+            System.out.println("Expected error: " + e.getMessage());
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      System.out.println("Identical predictions");
+      return true;
+   }
+
+
    public static void testSpeed(String name, int iter)
    {
       // Test speed
@@ -15,15 +15,46 @@ limitations under the License.
 
 package kanzi.test;
 
+import java.io.ByteArrayOutputStream;
+import java.io.IOException;
+import java.nio.charset.StandardCharsets;
 import java.util.Arrays;
+import java.util.Base64;
+import java.util.HashMap;
+import java.util.List;
+import java.util.Locale;
+import java.util.Map;
 import java.util.Random;
 import kanzi.ByteFunction;
 import kanzi.SliceByteArray;
+import kanzi.function.AdaptiveCodec;
+import kanzi.function.BPECodec;
+import kanzi.function.ByteFunctionFactory;
+import kanzi.function.CounterFieldCodec;
+import kanzi.function.DecimalTextCodec;
+import kanzi.function.FixedPointCodec;
+import kanzi.function.IPFieldCodec;
+import kanzi.function.JSONNumberExtractCodec;
+import kanzi.function.JointColumnCodec;
+import kanzi.function.LineDedupCodec;
+import kanzi.function.LogMapCodec;
 import kanzi.function.LZCodec;
+import kanzi.function.MTFZeroRLE;
+import kanzi.function.Profiler;
 import kanzi.function.RLT;
 import kanzi.function.ROLZCodec;
 import kanzi.function.SRT;
+import kanzi.function.SignMagnitudeSplitCodec;
+import kanzi.function.SortedIntSetCodec;
+import kanzi.function.SparseMaskCodec;
+import kanzi.function.TemplateCodec;
+import kanzi.function.TextBlobDecodeCodec;
+import kanzi.function.TimestampDeltaCodec;
+import kanzi.function.UTF8RemapCodec;
+import kanzi.function.WhitespaceFoldCodec;
 import kanzi.function.ZRLT;
+import kanzi.transform.BWT;
+import kanzi.transform.SBRT;
 import org.junit.Assert;
 import org.junit.Test;
 
@@ -48,7 +79,7 @@ public class TestFunctions
          {
             System.out.println("\n\nTestLZ");
 
-            if (testCorrectness("LZ") == false)
+            if ((testCorrectness("LZ") == false) || (testLZGreedy() == false))
                System.exit(1);
 
             testSpeed("LZ");
@@ -82,6 +113,168 @@ public class TestFunctions
                System.exit(1);
 
             testSpeed("SRT");                 
+            System.out.println("\n\nTestJOINT");
+
+            if (testCorrectness("JOINT") == false)
+               System.exit(1);
+
+            if (testJointColumn() == false)
+               System.exit(1);
+
+            testSpeed("JOINT");
+            System.out.println("\n\nTestBPE");
+
+            if (testCorrectness("BPE") == false)
+               System.exit(1);
+
+            if (testBPE() == false)
+               System.exit(1);
+
+            testSpeed("BPE");
+            System.out.println("\n\nTestLOGMAP");
+
+            if (testCorrectness("LOGMAP") == false)
+               System.exit(1);
+
+            if (testLogMap() == false)
+               System.exit(1);
+
+            testSpeed("LOGMAP");
+            System.out.println("\n\nTestCOUNTER");
+
+            if (testCorrectness("COUNTER") == false)
+               System.exit(1);
+
+            if (testCounterField() == false)
+               System.exit(1);
+
+            System.out.println("\n\nTestADAPT");
+
+            if (testCorrectness("ADAPT") == false)
+               System.exit(1);
+
+            if (testAdaptive() == false)
+               System.exit(1);
+
+            testSpeed("ADAPT");
+            System.out.println("\n\nTestMTFZRLE");
+
+            if (testCorrectness("MTFZRLE") == false)
+               System.exit(1);
+
+            if (testMTFZeroRLE() == false)
+               System.exit(1);
+
+            testSpeed("MTFZRLE");
+            System.out.println("\n\nTestSIGNMAG");
+
+            if (testCorrectness("SIGNMAG") == false)
+               System.exit(1);
+
+            if (testSignMagnitude() == false)
+               System.exit(1);
+
+            testSpeed("SIGNMAG");
+            System.out.println("\n\nTestTSDELTA");
+
+            if (testCorrectness("TSDELTA") == false)
+               System.exit(1);
+
+            if (testTimestampDelta() == false)
+               System.exit(1);
+
+            System.out.println("\n\nTestJSONNUM");
+
+            if (testCorrectness("JSONNUM") == false)
+               System.exit(1);
+
+            if (testJSONNumber() == false)
+               System.exit(1);
+
+            System.out.println("\n\nTestDECIMAL");
+
+            if (testCorrectness("DECIMAL") == false)
+               System.exit(1);
+
+            if (testDecimalText() == false)
+               System.exit(1);
+
+            System.out.println("\n\nTestIPFIELD");
+
+            if (testCorrectness("IPFIELD") == false)
+               System.exit(1);
+
+            if (testIPField() == false)
+               System.exit(1);
+
+            System.out.println("\n\nTestTEMPLATE");
+
+            if (testCorrectness("TEMPLATE") == false)
+               System.exit(1);
+
+            if (testTemplate() == false)
+               System.exit(1);
+
+            System.out.println("\n\nTestWSFOLD");
+
+            if (testCorrectness("WSFOLD") == false)
+               System.exit(1);
+
+            if (testWhitespaceFold() == false)
+               System.exit(1);
+
+            System.out.println("\n\nTestSPARSE");
+
+            if (testCorrectness("SPARSE") == false)
+               System.exit(1);
+
+            if (testSparseMask() == false)
+               System.exit(1);
+
+            System.out.println("\n\nTestLINEDEDUP");
+
+            if (testCorrectness("LINEDEDUP") == false)
+               System.exit(1);
+
+            if (testLineDedup() == false)
+               System.exit(1);
+
+            System.out.println("\n\nTestTEXTBLOB");
+
+            if (testCorrectness("TEXTBLOB") == false)
+               System.exit(1);
+
+            if (testTextBlob() == false)
+               System.exit(1);
+
+            System.out.println("\n\nTestUTF8REMAP");
+
+            if (testCorrectness("UTF8REMAP") == false)
+               System.exit(1);
+
+            if (testUTF8Remap() == false)
+               System.exit(1);
+
+            System.out.println("\n\nTestFIXEDPOINT");
+
+            if (testCorrectness("FIXEDPOINT") == false)
+               System.exit(1);
+
+            if (testFixedPoint() == false)
+               System.exit(1);
+
+            System.out.println("\n\nTestSORTEDSET");
+
+            if (testCorrectness("SORTEDSET") == false)
+               System.exit(1);
+
+            if (testSortedIntSet() == false)
+               System.exit(1);
+
+            System.out.println("\n\nTestProfile");
+
+            if (testProfile() == false)
+               System.exit(1);
          }
          else
          {
@@ -104,6 +297,7 @@ public class TestFunctions
       //testSpeed("SRT");
       System.out.println("\n\nTestLZ");
       Assert.assertTrue(testCorrectness("LZ"));
+      Assert.assertTrue(testLZGreedy());
       //testSpeed("LZ");
       System.out.println("\n\nTestROLZ");
       Assert.assertTrue(testCorrectness("ROLZ"));
@@ -117,6 +311,71 @@ public class TestFunctions
       System.out.println("\n\nTestRLT");
       Assert.assertTrue(testCorrectness("RLT"));
       //testSpeed("RLT");   
+      System.out.println("\n\nTestJOINT");
+      Assert.assertTrue(testCorrectness("JOINT"));
+      Assert.assertTrue(testJointColumn());
+      //testSpeed("JOINT");
+      System.out.println("\n\nTestBPE");
+      Assert.assertTrue(testCorrectness("BPE"));
+      Assert.assertTrue(testBPE());
+      //testSpeed("BPE");
+      System.out.println("\n\nTestLOGMAP");
+      Assert.assertTrue(testCorrectness("LOGMAP"));
+      Assert.assertTrue(testLogMap());
+      //testSpeed("LOGMAP");
+      System.out.println("\n\nTestCOUNTER");
+      Assert.assertTrue(testCorrectness("COUNTER"));
+      Assert.assertTrue(testCounterField());
+      System.out.println("\n\nTestADAPT");
+      Assert.assertTrue(testCorrectness("ADAPT"));
+      Assert.assertTrue(testAdaptive());
+      //testSpeed("ADAPT");
+      System.out.println("\n\nTestMTFZRLE");
+      Assert.assertTrue(testCorrectness("MTFZRLE"));
+      Assert.assertTrue(testMTFZeroRLE());
+      //testSpeed("MTFZRLE");
+      System.out.println("\n\nTestSIGNMAG");
+      Assert.assertTrue(testCorrectness("SIGNMAG"));
+      Assert.assertTrue(testSignMagnitude());
+      //testSpeed("SIGNMAG");
+      System.out.println("\n\nTestTSDELTA");
+      Assert.assertTrue(testCorrectness("TSDELTA"));
+      Assert.assertTrue(testTimestampDelta());
+      System.out.println("\n\nTestJSONNUM");
+      Assert.assertTrue(testCorrectness("JSONNUM"));
+      Assert.assertTrue(testJSONNumber());
+      System.out.println("\n\nTestDECIMAL");
+      Assert.assertTrue(testCorrectness("DECIMAL"));
+      Assert.assertTrue(testDecimalText());
+      System.out.println("\n\nTestIPFIELD");
+      Assert.assertTrue(testCorrectness("IPFIELD"));
+      Assert.assertTrue(testIPField());
+      System.out.println("\n\nTestTEMPLATE");
+      Assert.assertTrue(testCorrectness("TEMPLATE"));
+      Assert.assertTrue(testTemplate());
+      System.out.println("\n\nTestWSFOLD");
+      Assert.assertTrue(testCorrectness("WSFOLD"));
+      Assert.assertTrue(testWhitespaceFold());
+      System.out.println("\n\nTestSPARSE");
+      Assert.assertTrue(testCorrectness("SPARSE"));
+      Assert.assertTrue(testSparseMask());
+      System.out.println("\n\nTestLINEDEDUP");
+      Assert.assertTrue(testCorrectness("LINEDEDUP"));
+      Assert.assertTrue(testLineDedup());
+      System.out.println("\n\nTestTEXTBLOB");
+      Assert.assertTrue(testCorrectness("TEXTBLOB"));
+      Assert.assertTrue(testTextBlob());
+      System.out.println("\n\nTestUTF8REMAP");
+      Assert.assertTrue(testCorrectness("UTF8REMAP"));
+      Assert.assertTrue(testUTF8Remap());
+      System.out.println("\n\nTestFIXEDPOINT");
+      Assert.assertTrue(testCorrectness("FIXEDPOINT"));
+      Assert.assertTrue(testFixedPoint());
+      System.out.println("\n\nTestSORTEDSET");
+      Assert.assertTrue(testCorrectness("SORTEDSET"));
+      Assert.assertTrue(testSortedIntSet());
+      System.out.println("\n\nTestProfile");
+      Assert.assertTrue(testProfile());
    }
    
    
@@ -142,6 +401,63 @@ public class TestFunctions
          case "ROLZX":
             return new ROLZCodec(true);
 
+         case "JOINT":
+            return new JointColumnCodec("KKVVVV", true);
+
+         case "BPE":
+            return new BPECodec();
+
+         case "LOGMAP":
+            return new LogMapCodec(2);
+
+         case "COUNTER":
+            return new CounterFieldCodec(8, 0, 2);
+
+         case "ADAPT":
+            return new AdaptiveCodec();
+
+         case "MTFZRLE":
+            return new MTFZeroRLE();
+
+         case "SIGNMAG":
+            return new SignMagnitudeSplitCodec(2);
+
+         case "TSDELTA":
+            return new TimestampDeltaCodec(8, 0, 4);
+
+         case "JSONNUM":
+            return new JSONNumberExtractCodec();
+
+         case "DECIMAL":
+            return new DecimalTextCodec();
+
+         case "IPFIELD":
+            return new IPFieldCodec(8, 2, 4);
+
+         case "TEMPLATE":
+            return new TemplateCodec();
+
+         case "WSFOLD":
+            return new WhitespaceFoldCodec();
+
+         case "SPARSE":
+            return new SparseMaskCodec();
+
+         case "LINEDEDUP":
+            return new LineDedupCodec();
+
+         case "TEXTBLOB":
+            return new TextBlobDecodeCodec();
+
+         case "UTF8REMAP":
+            return new UTF8RemapCodec();
+
+         case "FIXEDPOINT":
+            return new FixedPointCodec();
+
+         case "SORTEDSET":
+            return new SortedIntSetCodec();
+
          default:
             System.out.println("No such byte function: "+name);
             return null;
@@ -357,110 +673,660 @@ public class TestFunctions
       return true;
    }
 
-    
-   public static void testSpeed(String name)
+
+   // Key/value records: 4 byte keys from a small set, 4 byte values that
+   // increase slowly for each key
+   public static boolean testJointColumn()
    {
-      // Test speed
-      byte[] input;
-      byte[] output;
-      byte[] reverse;
-      Random rnd = new Random();
-      final int iter = name.startsWith("ROLZ") ? 2000 : (name.equals("SRT") ? 4000 : 50000);
-      final int size = 50000;
-      System.out.println("\n\nSpeed test for " + name);
-      System.out.println("Iterations: " + iter);
-      System.out.println();
-      int range = name.equals("ZRLT") ? 5 : 256;
+      Random rnd = new Random(12345);
+      final int records = 50000;
+      final int nbKeys = 100;
+      int[] keys = new int[nbKeys];
+      int[] values = new int[nbKeys];
 
-      for (int jj=0; jj<3; jj++)
+      for (int i=0; i<nbKeys; i++)
       {
-         ByteFunction f = getByteFunction(name);
-         input = new byte[size];
-         output = new byte[f.getMaxEncodedLength(size)];
-         reverse = new byte[size];
+         keys[i] = rnd.nextInt();
+         values[i] = rnd.nextInt(1<<24);
+      }
+
+      byte[] input = new byte[records*8+3];
+
+      for (int n=0; n<records; n++)
+      {
+         final int k = rnd.nextInt(nbKeys);
+         values[k] += rnd.nextInt(16);
+         final int key = keys[k];
+         final int val = values[k];
+
+         for (int j=0; j<4; j++)
+         {
+            input[8*n+j] = (byte) (key >> (24-8*j));
+            input[8*n+4+j] = (byte) (val >> (24-8*j));
+         }
+      }
+
+      for (boolean delta : new boolean[] { false, true })
+      {
+         ByteFunction f = new JointColumnCodec("KKKKVVVV", delta);
+         byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+         byte[] reverse = new byte[input.length];
          SliceByteArray sa1 = new SliceByteArray(input, 0);
          SliceByteArray sa2 = new SliceByteArray(output, 0);
          SliceByteArray sa3 = new SliceByteArray(reverse, 0);
 
-         // Generate random data with runs
-         // Leave zeros at the beginning for ZRLT to succeed
-         int n = iter/20;
+         if (f.forward(sa1, sa2) == false)
+         {
+            System.out.println("Encoding error");
+            return false;
+         }
 
-         while (n < input.length)        
+         sa2.length = sa2.index;
+         sa2.index = 0;
+         f = new JointColumnCodec();
+
+         if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
          {
-            byte val = (byte) rnd.nextInt(range);
-            input[n++] = val;
-            int run = rnd.nextInt(256);
-            run -= 220;
+            System.out.println("Decoding error");
+            return false;
+         }
 
-            while ((--run > 0) && (n < input.length))       
-               input[n++] = val;
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after inverse (delta="+delta+")");
+            return false;
          }
+      }
 
-         long before, after;
-         long delta1 = 0;
-         long delta2 = 0;
+      System.out.println("Identical");
 
-         for (int ii = 0; ii < iter; ii++)
+      // Ratio demo
+      try
+      {
+         for (String transform : new String[] { "NONE", "JOINT" })
          {
-            f = getByteFunction(name);
-            sa1.index = 0;
-            sa2.index = 0;
-            before = System.nanoTime();
-
-            if (f.forward(sa1, sa2) == false)
+            for (boolean delta : new boolean[] { false, true })
             {
-               if ((sa1.index != input.length) || (sa2.index >= sa1.index))
-               {
-                  System.out.println("\nNo compression (ratio > 1.0), skip reverse");
+               if ((delta == true) && (transform.equals("NONE")))
                   continue;
-               }
 
-               System.out.println("\nEncoding error");
-               System.exit(1);
-            }
+               Map<String, Object> ctx = TestCompressedStream.createContext(transform, "ANS1", 1<<20, false);
+               ctx.put("jointGrouping", "KKKKVVVV");
+               ctx.put("jointDelta", delta);
+               byte[] output = TestCompressedStream.compress(input, ctx, null);
+               System.out.println(transform+((delta == true) ? "+delta" : "")+"&ANS1: "+
+                  input.length+" => "+output.length);
+               byte[] reverse = TestCompressedStream.decompress(output, ctx);
 
-            after = System.nanoTime();
-            delta1 += (after - before);
+               if (Arrays.equals(input, reverse) == false)
+               {
+                  System.out.println("Different output after decompression");
+                  return false;
+               }
+            }
          }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
 
-         for (int ii = 0; ii < iter; ii++)
+      return true;
+   }
+
+
+   public static boolean testBPE()
+   {
+      byte[] corpus = TestCompressedStream.generateText(100000, 1);
+      byte[] input = TestCompressedStream.generateText(100000, 2);
+      final int[] table = BPECodec.learn(corpus, 0, corpus.length, 64);
+      System.out.println("Pretrained table: " + (table.length/2) + " merges");
+
+      for (int j=0; j<2; j++)
+      {
+         ByteFunction f = (j == 0) ? new BPECodec() : new BPECodec(table);
+         byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+         byte[] reverse = new byte[input.length];
+         SliceByteArray sa1 = new SliceByteArray(input, 0);
+         SliceByteArray sa2 = new SliceByteArray(output, 0);
+         SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+         if (f.forward(sa1, sa2) == false)
          {
-            f = getByteFunction(name);
-            sa2.length = sa2.index;
-            sa3.index = 0;
-            sa2.index = 0;
-            before = System.nanoTime();
+            System.out.println("Encoding error");
+            return false;
+         }
 
-            if (f.inverse(sa2, sa3) == false)
-            {
-               System.out.println("Decoding error");
-               System.exit(1);
-            }
+         System.out.println(((j == 0) ? "Learned   : " : "Pretrained: ") + input.length + " => " + sa2.index);
+         sa2.length = sa2.index;
+         sa2.index = 0;
 
-            after = System.nanoTime();
-            delta2 += (after - before);
+         // The merge table is in the output: no parameter needed to invert
+         f = new BPECodec();
+
+         if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+         {
+            System.out.println("Decoding error");
+            return false;
          }
 
-         int idx = -1;
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after inverse");
+            return false;
+         }
+      }
 
-         // Sanity check
-         for (int i=0; i<sa1.index; i++)
+      System.out.println("Identical");
+
+      // A merge table provided in the context is validated like in the constructor
+      try
+      {
+         Map<String, Object> ctx = new HashMap<>();
+         ctx.put("bpeTable", new int[] { 'a', 'b', 300, 'c' });
+         new BPECodec(ctx);
+         System.out.println("Invalid merge table in context not detected");
+         return false;
+      }
+      catch (IllegalArgumentException e)
+      {
+         System.out.println("Expected error: " + e.getMessage());
+      }
+
+      // Ratio demo
+      try
+      {
+         for (int j=0; j<3; j++)
          {
-            if (sa1.array[i] != sa3.array[i])
+            Map<String, Object> ctx = TestCompressedStream.createContext((j == 0) ? "NONE" : "BPE", "ANS1", 1<<20, false);
+
+            if (j == 2)
+               ctx.put("bpeTable", table);
+
+            byte[] output = TestCompressedStream.compress(input, ctx, null);
+            System.out.println(((j == 0) ? "NONE" : ((j == 1) ? "BPE (learned)" : "BPE (pretrained)")) +
+               "&ANS1: " + input.length + " => " + output.length);
+            byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+            if (Arrays.equals(input, reverse) == false)
             {
-               idx = i;
-               break;
+               System.out.println("Different output after decompression");
+               return false;
             }
          }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
 
-         if (idx >= 0) {
-            System.out.println("Failure at index "+idx+" ("+sa1.array[idx]+"<->"+sa3.array[idx]+")");
-            for (int i=0; i<idx; i++)
-               System.out.println(i+" "+sa1.array[i]+" "+sa3.array[i]);
-            
-            System.out.println(idx+" "+sa1.array[idx]+"* "+sa3.array[idx]+"*");
-         }
+      return true;
+   }
+
+
+   public static boolean testLogMap()
+   {
+      Random rnd = new Random(12345);
+      final int[] sizes = { 2, 4, 8 };
+
+      for (int sz : sizes)
+      {
+         for (int t=0; t<2; t++)
+         {
+            // Small magnitudes, then large magnitudes (with exceptions for 8 byte elements)
+            final int n = 10000;
+            byte[] input = new byte[n*sz+sz-1];
+
+            for (int i=0; i<n; i++)
+            {
+               long v = (t == 0) ? rnd.nextInt(41) - 20 : rnd.nextLong();
+
+               if ((t == 1) && (i < 4))
+                  v = (i == 0) ? Long.MIN_VALUE : ((i == 1) ? Long.MAX_VALUE : ((i == 2) ? -1 : 0));
+
+               for (int j=0; j<sz; j++)
+                  input[i*sz+j] = (byte) (v >> (8*j));
+            }
+
+            for (int i=n*sz; i<input.length; i++)
+               input[i] = (byte) i;
+
+            ByteFunction f = new LogMapCodec(sz);
+            byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+            byte[] reverse = new byte[input.length];
+            SliceByteArray sa1 = new SliceByteArray(input, 0);
+            SliceByteArray sa2 = new SliceByteArray(output, 0);
+            SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+            if (f.forward(sa1, sa2) == false)
+            {
+               if (t == 0)
+               {
+                  System.out.println("Small values should be compacted");
+                  return false;
+               }
+
+               System.out.println("Element size " + sz + ", large values: skipped (no gain)");
+               continue;
+            }
+
+            System.out.println("Element size " + sz + ((t == 0) ? ", small" : ", large") +
+               " values: " + input.length + " => " + sa2.index);
+            sa2.length = sa2.index;
+            sa2.index = 0;
+            f = new LogMapCodec();
+
+            if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+            {
+               System.out.println("Decoding error");
+               return false;
+            }
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after inverse");
+               return false;
+            }
+         }
+      }
+
+      System.out.println("Identical");
+
+      // Ratio demo on heavy tailed data (Pareto like distribution, 4 byte elements)
+      byte[] input = new byte[4*100000];
+
+      for (int i=0; i<input.length; i+=4)
+      {
+         final int v = (int) Math.min(Integer.MAX_VALUE, 10.0 / Math.pow(1.0-rnd.nextDouble(), 1.5));
+
+         for (int j=0; j<4; j++)
+            input[i+j] = (byte) (v >> (8*j));
+      }
+
+      try
+      {
+         for (String transform : new String[] { "NONE", "LOGMAP" })
+         {
+            Map<String, Object> ctx = TestCompressedStream.createContext(transform, "ANS0", 1<<20, false);
+            ctx.put("logMapElemSize", 4);
+            byte[] output = TestCompressedStream.compress(input, ctx, null);
+            System.out.println(transform + "&ANS0: " + input.length + " => " + output.length);
+            byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after decompression");
+               return false;
+            }
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      return true;
+   }
+
+
+   public static boolean testCounterField()
+   {
+      Random rnd = new Random(6789);
+      final String[] names = { "Perfect counter", "Counter with gaps", "Wrapping counter", "Not a counter" };
+      final int stride = 12;
+      final int n = 5000;
+
+      for (int t=0; t<names.length; t++)
+      {
+         // Records of 12 bytes: 4 random bytes, counter field, random bytes
+         final int width = (t == 2) ? 2 : 4;
+         final int step = (t == 2) ? 7 : 1;
+         byte[] input = new byte[n*stride+5];
+         rnd.nextBytes(input);
+         long v = (t == 2) ? 65000 : 1000000;
+
+         for (int i=0; i<n; i++)
+         {
+            if ((t == 1) && (i % 97 == 96))
+               v += 1 + rnd.nextInt(50);
+
+            final long val = (t == 3) ? rnd.nextLong() : v;
+
+            for (int j=0; j<width; j++)
+               input[i*stride+4+j] = (byte) (val >> (8*(width-1-j)));
+
+            v += step;
+         }
+
+         ByteFunction f = new CounterFieldCodec(stride, 4, width);
+         byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+         byte[] reverse = new byte[input.length];
+         SliceByteArray sa1 = new SliceByteArray(input, 0);
+         SliceByteArray sa2 = new SliceByteArray(output, 0);
+         SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+         if (f.forward(sa1, sa2) == false)
+         {
+            if (t != 3)
+            {
+               System.out.println(names[t] + ": the counter should have been detected");
+               return false;
+            }
+
+            System.out.println(names[t] + ": skipped");
+            continue;
+         }
+
+         if (t == 3)
+         {
+            System.out.println(names[t] + ": the field should not be a counter");
+            return false;
+         }
+
+         System.out.println(names[t] + ": " + input.length + " => " + sa2.index);
+         sa2.length = sa2.index;
+         sa2.index = 0;
+         f = new CounterFieldCodec();
+
+         if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+         {
+            System.out.println("Decoding error");
+            return false;
+         }
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after inverse");
+            return false;
+         }
+      }
+
+      System.out.println("Identical");
+      return true;
+   }
+
+
+   public static boolean testAdaptive()
+   {
+      // Mixed file: text block, binary block (records), repetitive block
+      final int blockSize = 65536;
+      final String[] names = { "Text", "Binary", "Repetitive" };
+      final int[] expected = { AdaptiveCodec.BWT_PATH, AdaptiveCodec.LZ_PATH, AdaptiveCodec.LZ_PATH };
+      Random rnd = new Random(97531);
+      byte[] input = new byte[3*blockSize];
+      System.arraycopy(TestCompressedStream.generateText(blockSize, 1111), 0, input, 0, blockSize);
+
+      for (int i=0; i<blockSize; i+=16)
+      {
+         final int v = rnd.nextInt(8) * 1000;
+
+         for (int j=0; j<4; j++)
+         {
+            input[blockSize+i+j] = (byte) (i >> (8*j));
+            input[blockSize+i+4+j] = (byte) (v >> (8*j));
+         }
+      }
+
+      byte[] pattern = TestCompressedStream.generateText(3000, 2222);
+
+      for (int i=0; i<blockSize; i++)
+         input[2*blockSize+i] = pattern[i%pattern.length];
+
+      AdaptiveCodec codec = new AdaptiveCodec();
+
+      for (int i=0; i<3; i++)
+      {
+         final int path = codec.classify(input, i*blockSize, blockSize);
+         System.out.println(names[i] + " block: " + ((path == AdaptiveCodec.BWT_PATH) ? "BWT" : "LZ") + " path");
+
+         if (path != expected[i])
+         {
+            System.out.println("Unexpected path");
+            return false;
+         }
+
+         byte[] output = new byte[codec.getMaxEncodedLength(blockSize)];
+         SliceByteArray sa1 = new SliceByteArray(input, blockSize, i*blockSize);
+         SliceByteArray sa2 = new SliceByteArray(output, 0);
+
+         if ((codec.forward(sa1, sa2) == false) || (output[0] != expected[i]))
+         {
+            System.out.println("The path is not recorded in the output");
+            return false;
+         }
+      }
+
+      try
+      {
+         // Each block takes its own path and all decode
+         Map<String, Object> ctx = TestCompressedStream.createContext("ADAPT", "ANS0", blockSize, true);
+         byte[] output = TestCompressedStream.compress(input, ctx, null);
+         System.out.println("ADAPT&ANS0: " + input.length + " => " + output.length);
+         byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after decompression");
+            return false;
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      System.out.println("Identical");
+      return true;
+   }
+
+
+   // Compare the fused pass with the MTFT+ZRLT pipeline on BWT output
+   public static boolean testMTFZeroRLE()
+   {
+      final int size = 1<<20;
+      final int iter = 20;
+      byte[] input = TestCompressedStream.generateText(size, 8642);
+      byte[] bwt = new byte[size];
+      new BWT().forward(new SliceByteArray(input, 0), new SliceByteArray(bwt, 0));
+      byte[] mtf = new byte[size];
+      byte[] output1 = new byte[size];
+      byte[] output2 = new byte[size];
+      byte[] reverse = new byte[size];
+      long delta1 = 0, delta2 = 0, delta3 = 0, delta4 = 0;
+      int len1 = 0, len2 = 0;
+
+      for (int ii=0; ii<iter; ii++)
+      {
+         // Two stages
+         SliceByteArray sa1 = new SliceByteArray(bwt, 0);
+         SliceByteArray sa2 = new SliceByteArray(mtf, 0);
+         SliceByteArray sa3 = new SliceByteArray(output1, 0);
+         long before = System.nanoTime();
+         new SBRT(SBRT.MODE_MTF).forward(sa1, sa2);
+         sa2.length = sa2.index;
+         sa2.index = 0;
+
+         if (new ZRLT().forward(sa2, sa3) == false)
+         {
+            System.out.println("ZRLT encoding error");
+            return false;
+         }
+
+         long after = System.nanoTime();
+         delta1 += (after - before);
+         len1 = sa3.index;
+         sa3.length = len1;
+         sa3.index = 0;
+         sa2 = new SliceByteArray(mtf, 0);
+         SliceByteArray sa4 = new SliceByteArray(reverse, 0);
+         before = System.nanoTime();
+         new ZRLT().inverse(sa3, sa2);
+         sa2.length = sa2.index;
+         sa2.index = 0;
+         new SBRT(SBRT.MODE_MTF).inverse(sa2, sa4);
+         after = System.nanoTime();
+         delta3 += (after - before);
+
+         // Fused pass
+         sa1 = new SliceByteArray(bwt, 0);
+         sa2 = new SliceByteArray(output2, 0);
+         before = System.nanoTime();
+
+         if (new MTFZeroRLE().forward(sa1, sa2) == false)
+         {
+            System.out.println("MTFZeroRLE encoding error");
+            return false;
+         }
+
+         after = System.nanoTime();
+         delta2 += (after - before);
+         len2 = sa2.index;
+         sa2.length = len2;
+         sa2.index = 0;
+         sa4 = new SliceByteArray(reverse, 0);
+         before = System.nanoTime();
+
+         if ((new MTFZeroRLE().inverse(sa2, sa4) == false) || (sa4.index != size))
+         {
+            System.out.println("MTFZeroRLE decoding error");
+            return false;
+         }
+
+         after = System.nanoTime();
+         delta4 += (after - before);
+
+         if (Arrays.equals(bwt, reverse) == false)
+         {
+            System.out.println("Different output after inverse");
+            return false;
+         }
+      }
+
+      // Same format => same ratio
+      if ((len1 != len2) || (Arrays.equals(Arrays.copyOf(output1, len1), Arrays.copyOf(output2, len2)) == false))
+      {
+         System.out.println("The fused pass and MTFT+ZRLT produce different outputs");
+         return false;
+      }
+
+      final long prod = (long) iter * (long) size;
+      System.out.println("Size: " + size + " => " + len2 + " bytes");
+      System.out.println("MTFT+ZRLT  encoding: " + (prod*1000000L/delta1*1000L/(1024*1024)) + " MB/s" +
+         ", decoding: " + (prod*1000000L/delta3*1000L/(1024*1024)) + " MB/s");
+      System.out.println("MTFZeroRLE encoding: " + (prod*1000000L/delta2*1000L/(1024*1024)) + " MB/s" +
+         ", decoding: " + (prod*1000000L/delta4*1000L/(1024*1024)) + " MB/s");
+      System.out.println("Identical");
+      return true;
+   }
+
+
+   public static void testSpeed(String name)
+   {
+      // Test speed
+      byte[] input;
+      byte[] output;
+      byte[] reverse;
+      Random rnd = new Random();
+      final int iter = name.startsWith("ROLZ") ? 2000 : (name.equals("SRT") ? 4000 : ((name.equals("BPE") || name.equals("ADAPT")) ? 200 : 50000));
+      final int size = 50000;
+      System.out.println("\n\nSpeed test for " + name);
+      System.out.println("Iterations: " + iter);
+      System.out.println();
+      int range = name.equals("ZRLT") ? 5 : 256;
+
+      for (int jj=0; jj<3; jj++)
+      {
+         ByteFunction f = getByteFunction(name);
+         input = new byte[size];
+         output = new byte[f.getMaxEncodedLength(size)];
+         reverse = new byte[size];
+         SliceByteArray sa1 = new SliceByteArray(input, 0);
+         SliceByteArray sa2 = new SliceByteArray(output, 0);
+         SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+         // Generate random data with runs
+         // Leave zeros at the beginning for ZRLT to succeed
+         int n = iter/20;
+
+         while (n < input.length)        
+         {
+            byte val = (byte) rnd.nextInt(range);
+            input[n++] = val;
+            int run = rnd.nextInt(256);
+            run -= 220;
+
+            while ((--run > 0) && (n < input.length))       
+               input[n++] = val;
+         }
+
+         long before, after;
+         long delta1 = 0;
+         long delta2 = 0;
+
+         for (int ii = 0; ii < iter; ii++)
+         {
+            f = getByteFunction(name);
+            sa1.index = 0;
+            sa2.index = 0;
+            before = System.nanoTime();
+
+            if (f.forward(sa1, sa2) == false)
+            {
+               if ((sa1.index != input.length) || (sa2.index >= sa1.index))
+               {
+                  System.out.println("\nNo compression (ratio > 1.0), skip reverse");
+                  continue;
+               }
+
+               System.out.println("\nEncoding error");
+               System.exit(1);
+            }
+
+            after = System.nanoTime();
+            delta1 += (after - before);
+         }
+
+         for (int ii = 0; ii < iter; ii++)
+         {
+            f = getByteFunction(name);
+            sa2.length = sa2.index;
+            sa3.index = 0;
+            sa2.index = 0;
+            before = System.nanoTime();
+
+            if (f.inverse(sa2, sa3) == false)
+            {
+               System.out.println("Decoding error");
+               System.exit(1);
+            }
+
+            after = System.nanoTime();
+            delta2 += (after - before);
+         }
+
+         int idx = -1;
+
+         // Sanity check
+         for (int i=0; i<sa1.index; i++)
+         {
+            if (sa1.array[i] != sa3.array[i])
+            {
+               idx = i;
+               break;
+            }
+         }
+
+         if (idx >= 0) {
+            System.out.println("Failure at index "+idx+" ("+sa1.array[idx]+"<->"+sa3.array[idx]+")");
+            for (int i=0; i<idx; i++)
+               System.out.println(i+" "+sa1.array[i]+" "+sa3.array[i]);
+            
+            System.out.println(idx+" "+sa1.array[idx]+"* "+sa3.array[idx]+"*");
+         }
          
          final long prod = (long) iter * (long) size;
          System.out.println(name + " encoding [ms]: " + delta1 / 1000000);
@@ -469,4 +1335,1510 @@ public class TestFunctions
          System.out.println("Throughput [MB/s]: " + prod * 1000000L / delta2 * 1000L / (1024*1024));
       }
    }
+
+
+   public static boolean testSignMagnitude()
+   {
+      Random rnd = new Random(97531);
+      final int[] sizes = { 1, 2, 4, 8 };
+
+      for (int sz : sizes)
+      {
+         final int n = 10000;
+         byte[] input = new byte[n*sz+sz-1];
+         final int shift = 64 - 8*sz;
+
+         for (int i=0; i<n; i++)
+         {
+            // Random values with the minimum, maximum, -1 and 0 edge cases
+            long v = (rnd.nextLong() << shift) >> shift;
+
+            if (i < 4)
+               v = (i == 0) ? (Long.MIN_VALUE >> shift) : ((i == 1) ? (Long.MAX_VALUE >> shift) : ((i == 2) ? -1 : 0));
+            else if ((i & 1) == 0)
+               v = rnd.nextInt(201) - 100;
+
+            for (int j=0; j<sz; j++)
+               input[i*sz+j] = (byte) (v >> (8*j));
+         }
+
+         for (int i=n*sz; i<input.length; i++)
+            input[i] = (byte) i;
+
+         ByteFunction f = new SignMagnitudeSplitCodec(sz);
+         byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+         byte[] reverse = new byte[input.length];
+         SliceByteArray sa1 = new SliceByteArray(input, 0);
+         SliceByteArray sa2 = new SliceByteArray(output, 0);
+         SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+         if (f.forward(sa1, sa2) == false)
+         {
+            System.out.println("Encoding error");
+            return false;
+         }
+
+         System.out.println("Element size " + sz + ": " + input.length + " => " + sa2.index);
+         sa2.length = sa2.index;
+         sa2.index = 0;
+         f = new SignMagnitudeSplitCodec();
+
+         if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+         {
+            System.out.println("Decoding error");
+            return false;
+         }
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after inverse");
+            return false;
+         }
+      }
+
+      System.out.println("Identical");
+
+      // Ratio demo on signed residuals (Laplacian like distribution, 2 byte elements)
+      byte[] input = new byte[2*200000];
+
+      for (int i=0; i<input.length; i+=2)
+      {
+         final double u = rnd.nextDouble() - 0.5;
+         final int v = (int) (-40.0 * Math.signum(u) * Math.log(1.0-2.0*Math.abs(u)));
+         input[i] = (byte) v;
+         input[i+1] = (byte) (v >> 8);
+      }
+
+      try
+      {
+         for (String transform : new String[] { "NONE", "SIGNMAG" })
+         {
+            Map<String, Object> ctx = TestCompressedStream.createContext(transform, "ANS0", 1<<20, false);
+            ctx.put("signElemSize", 2);
+            byte[] output = TestCompressedStream.compress(input, ctx, null);
+            System.out.println(transform + "&ANS0: " + input.length + " => " + output.length);
+            byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after decompression");
+               return false;
+            }
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      return true;
+   }
+
+
+   public static boolean testTimestampDelta()
+   {
+      Random rnd = new Random(24680);
+      final String[] names = { "Monotonic timestamps", "Jittery timestamps", "Wrapping timestamps" };
+      final int stride = 24;
+      final int n = 5000;
+
+      for (int t=0; t<names.length; t++)
+      {
+         // Records of 24 bytes: 4 random bytes, timestamp field, random bytes
+         final int width = (t == 2) ? 2 : 8;
+         byte[] input = new byte[n*stride+7];
+         rnd.nextBytes(input);
+         long v = (t == 2) ? 65000 : 1500000000000L;
+
+         for (int i=0; i<n; i++)
+         {
+            // Out of order timestamps (up to 50 ms in the past) for jittery stream
+            final long val = (t == 1) ? v - rnd.nextInt(50) : v;
+
+            for (int j=0; j<width; j++)
+               input[i*stride+4+j] = (byte) (val >> (8*(width-1-j)));
+
+            v += rnd.nextInt(20);
+         }
+
+         ByteFunction f = new TimestampDeltaCodec(stride, 4, width);
+         byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+         byte[] reverse = new byte[input.length];
+         SliceByteArray sa1 = new SliceByteArray(input, 0);
+         SliceByteArray sa2 = new SliceByteArray(output, 0);
+         SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+         if (f.forward(sa1, sa2) == false)
+         {
+            System.out.println(names[t] + ": encoding error");
+            return false;
+         }
+
+         System.out.println(names[t] + ": " + input.length + " => " + sa2.index);
+         sa2.length = sa2.index;
+         sa2.index = 0;
+         f = new TimestampDeltaCodec();
+
+         if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+         {
+            System.out.println("Decoding error");
+            return false;
+         }
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after inverse");
+            return false;
+         }
+      }
+
+      System.out.println("Identical");
+      return true;
+   }
+
+
+   public static boolean testJSONNumber()
+   {
+      Random rnd = new Random(97531);
+      final String[] names = { "Numeric JSON", "Text JSON" };
+
+      for (int t=0; t<names.length; t++)
+      {
+         StringBuilder sb = new StringBuilder(100000);
+         sb.append("[\n");
+
+         for (int i=0; i<1000; i++)
+         {
+            if (t == 0)
+            {
+               // Ids, prices with trailing zeros, negative values, small
+               // fractions, exponents and placeholder bytes
+               sb.append("{\"id\":").append(100000+3*i).append(",\"price\":")
+                 .append(rnd.nextInt(1000)).append('.').append(rnd.nextInt(10)).append("0")
+                 .append(",\"delta\":-").append(rnd.nextInt(50))
+                 .append(",\"ratio\":0.00").append(rnd.nextInt(100))
+                 .append(",\"big\":12345678901234567890123,\"exp\":1.5e").append(rnd.nextInt(20))
+                 .append(",\"tag\":\"v1.2.3\u0001\u0002\"},\n");
+            }
+            else
+            {
+               sb.append("{\"name\":\"the quick brown fox jumps over the lazy dog\",")
+                 .append("\"text\":\"lorem ipsum dolor sit amet\"},\n");
+            }
+         }
+
+         sb.append("]\n");
+         byte[] input = sb.toString().getBytes();
+
+         // Raw placeholder and escape bytes
+         if (t == 0)
+         {
+            input[100] = 1;
+            input[200] = 2;
+         }
+
+         ByteFunction f = new JSONNumberExtractCodec();
+         byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+         byte[] reverse = new byte[input.length];
+         SliceByteArray sa1 = new SliceByteArray(input, 0);
+         SliceByteArray sa2 = new SliceByteArray(output, 0);
+         SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+         if (f.forward(sa1, sa2) == false)
+         {
+            // Expected for text without numbers
+            if (t == 1)
+            {
+               System.out.println(names[t] + ": skipped");
+               continue;
+            }
+
+            System.out.println(names[t] + ": encoding error");
+            return false;
+         }
+
+         if (t == 1)
+         {
+            System.out.println(names[t] + ": the transform should have been skipped");
+            return false;
+         }
+
+         System.out.println(names[t] + ": " + input.length + " => " + sa2.index);
+         sa2.length = sa2.index;
+         sa2.index = 0;
+         f = new JSONNumberExtractCodec();
+
+         if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+         {
+            System.out.println("Decoding error");
+            return false;
+         }
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after inverse");
+            return false;
+         }
+      }
+
+      System.out.println("Identical");
+      return true;
+   }
+
+
+   public static boolean testDecimalText()
+   {
+      Random rnd = new Random(112233);
+      final String[] names = { "Decimal CSV", "Edge cases", "Plain text" };
+      final String[] edgeCases = { "-0.00", "1e-5", "+3.25E+08", ".5", "5.", "-.75", "0.000",
+         "6.02e23", "1E5", "12e", "1.2.3", "v2.5", "2024-01-05", "--1.5", "1.5e+", "\u0001", "\u0002" };
+
+      for (int t=0; t<names.length; t++)
+      {
+         StringBuilder sb = new StringBuilder(100000);
+
+         for (int i=0; i<2000; i++)
+         {
+            if (t == 0)
+            {
+               // id, temperature, pressure (scientific notation), ratio
+               sb.append(i).append(',').append(rnd.nextInt(80)-40).append('.')
+                 .append(rnd.nextInt(10)).append(rnd.nextInt(10)).append(',')
+                 .append(1+rnd.nextInt(9)).append('.').append(rnd.nextInt(1000))
+                 .append((rnd.nextBoolean() == true) ? 'e' : 'E').append('-').append(rnd.nextInt(12))
+                 .append(",0.").append(rnd.nextInt(100000)).append('\n');
+            }
+            else if (t == 1)
+            {
+               sb.append(edgeCases[rnd.nextInt(edgeCases.length)]).append(' ')
+                 .append(rnd.nextInt(1000)).append('.').append(100000+rnd.nextInt(900000)).append('\n');
+            }
+            else
+            {
+               sb.append("the quick brown fox jumps over the lazy dog ").append(i).append('\n');
+            }
+         }
+
+         byte[] input = sb.toString().getBytes();
+         ByteFunction f = new DecimalTextCodec();
+         byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+         byte[] reverse = new byte[input.length];
+         SliceByteArray sa1 = new SliceByteArray(input, 0);
+         SliceByteArray sa2 = new SliceByteArray(output, 0);
+         SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+         if (f.forward(sa1, sa2) == false)
+         {
+            // Expected for text without decimal numbers
+            if (t == 2)
+            {
+               System.out.println(names[t] + ": skipped");
+               continue;
+            }
+
+            System.out.println(names[t] + ": encoding error");
+            return false;
+         }
+
+         if (t == 2)
+         {
+            System.out.println(names[t] + ": the transform should have been skipped");
+            return false;
+         }
+
+         System.out.println(names[t] + ": " + input.length + " => " + sa2.index);
+         sa2.length = sa2.index;
+         sa2.index = 0;
+         f = new DecimalTextCodec();
+
+         if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+         {
+            System.out.println("Decoding error");
+            return false;
+         }
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after inverse");
+            return false;
+         }
+      }
+
+      System.out.println("Identical");
+      return true;
+   }
+
+
+   public static boolean testIPField()
+   {
+      Random rnd = new Random(13579);
+      final String[] names = { "IPv4 records", "IPv4 records (no delta)", "IPv6 records" };
+      final int n = 4000;
+
+      for (int t=0; t<names.length; t++)
+      {
+         // Records: 6 random bytes, address, 6 random bytes
+         final int ipLen = (t == 2) ? 16 : 4;
+         final int stride = ipLen + 12;
+         byte[] input = new byte[n*stride+5];
+         rnd.nextBytes(input);
+         byte[] addr = new byte[ipLen];
+         rnd.nextBytes(addr);
+
+         for (int i=0; i<n; i++)
+         {
+            // Mostly consecutive hosts of a subnet, sometimes another subnet
+            if (rnd.nextInt(100) == 0)
+               rnd.nextBytes(addr);
+            else
+               addr[ipLen-1] += (byte) rnd.nextInt(3);
+
+            System.arraycopy(addr, 0, input, i*stride+6, ipLen);
+         }
+
+         ByteFunction f = new IPFieldCodec(stride, 6, ipLen, t != 1);
+         byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+         byte[] reverse = new byte[input.length];
+         SliceByteArray sa1 = new SliceByteArray(input, 0);
+         SliceByteArray sa2 = new SliceByteArray(output, 0);
+         SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+         if (f.forward(sa1, sa2) == false)
+         {
+            System.out.println(names[t] + ": encoding error");
+            return false;
+         }
+
+         // The address planes follow the header
+         int zeros = 0;
+
+         for (int i=10; i<10+n*ipLen; i++)
+            zeros += (output[i] == 0) ? 1 : 0;
+
+         System.out.println(names[t] + ": " + input.length + " => " + sa2.index +
+            " (" + (100*zeros/(n*ipLen)) + "% of zero address bytes)");
+         sa2.length = sa2.index;
+         sa2.index = 0;
+
+         // The field parameters are read from the header
+         f = new IPFieldCodec();
+
+         if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+         {
+            System.out.println("Decoding error");
+            return false;
+         }
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after inverse");
+            return false;
+         }
+      }
+
+      // Too few records
+      if (new IPFieldCodec(16, 0, 4).forward(new SliceByteArray(new byte[100], 0),
+         new SliceByteArray(new byte[200], 0)) == true)
+      {
+         System.out.println("Expected failure with too few records");
+         return false;
+      }
+
+      System.out.println("Identical");
+      return true;
+   }
+
+
+   // Log lines generated from a few templates with variable fields
+   static byte[] generateLogs(int nbLines, long seed)
+   {
+      final String[] templates = {
+         "INFO user %s logged in from %s",
+         "INFO user %s logged out after %s seconds",
+         "WARN disk %s usage at %s percent on host %s",
+         "ERROR request %s failed with status %s",
+         "DEBUG cache hit for key %s"
+      };
+      final String[] users = { "alice", "bob", "carol", "dave", "eve", "mallory" };
+      Random rnd = new Random(seed);
+      StringBuilder sb = new StringBuilder(nbLines*48);
+
+      for (int i=0; i<nbLines; i++)
+      {
+         sb.append(2017).append('-').append(10+rnd.nextInt(3)).append('-').append(10+rnd.nextInt(18));
+         sb.append(' ');
+
+         // Some lines without template
+         if (rnd.nextInt(50) == 0)
+         {
+            sb.append("unexpected  message ").append(Long.toHexString(rnd.nextLong())).append('\n');
+            continue;
+         }
+
+         String line = templates[rnd.nextInt(templates.length)];
+         String[] fields = new String[3];
+
+         for (int j=0; j<fields.length; j++)
+         {
+            switch (rnd.nextInt(3))
+            {
+               case 0:
+                  fields[j] = users[rnd.nextInt(users.length)];
+                  break;
+
+               case 1:
+                  fields[j] = "10.0." + rnd.nextInt(4) + "." + rnd.nextInt(256);
+                  break;
+
+               default:
+                  fields[j] = String.valueOf(rnd.nextInt(1000));
+            }
+         }
+
+         sb.append(String.format(line, (Object[]) fields)).append('\n');
+      }
+
+      return sb.toString().getBytes();
+   }
+
+
+   public static boolean testTemplate()
+   {
+      final String[] names = { "Templated logs", "Unterminated last line", "Short block" };
+
+      for (int t=0; t<names.length; t++)
+      {
+         byte[] input = generateLogs((t == 2) ? 200 : 20000, 8642+t);
+
+         if (t == 1)
+            input = Arrays.copyOf(input, input.length-1);
+
+         ByteFunction f = new TemplateCodec();
+         byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+         byte[] reverse = new byte[input.length];
+         SliceByteArray sa1 = new SliceByteArray(input, 0);
+         SliceByteArray sa2 = new SliceByteArray(output, 0);
+         SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+         if (f.forward(sa1, sa2) == false)
+         {
+            System.out.println(names[t] + ": encoding error");
+            return false;
+         }
+
+         System.out.println(names[t] + ": " + input.length + " => " + sa2.index);
+         sa2.length = sa2.index;
+         sa2.index = 0;
+         f = new TemplateCodec();
+
+         if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+         {
+            System.out.println("Decoding error");
+            return false;
+         }
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after inverse");
+            return false;
+         }
+      }
+
+      // No repeated template: lines of random words
+      Random rnd = new Random(97);
+      byte[] input = new byte[2000*45];
+
+      for (int i=0; i<input.length; i++)
+      {
+         final int r = i % 45;
+         input[i] = (byte) ((r == 44) ? '\n' : ((r % 9 == 8) ? ' ' : 'a'+rnd.nextInt(26)));
+      }
+
+      if (new TemplateCodec().forward(new SliceByteArray(input, 0),
+         new SliceByteArray(new byte[input.length], 0)) == true)
+      {
+         System.out.println("Expected failure without repeated templates");
+         return false;
+      }
+
+      System.out.println("Identical");
+
+      // Ratio demo
+      try
+      {
+         input = generateLogs(50000, 8642);
+
+         for (String transform : new String[] { "BWT", "TEMPLATE+BWT" })
+         {
+            Map<String, Object> ctx = TestCompressedStream.createContext(transform, "ANS0", 4<<20, false);
+            byte[] output = TestCompressedStream.compress(input, ctx, null);
+            System.out.println(transform+"&ANS0: "+input.length+" => "+output.length);
+            byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after decompression");
+               return false;
+            }
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      return true;
+   }
+
+
+   // Indented source code (indentation with spaces, tabs or both)
+   static byte[] generateCode(int nbLines, String indent, String eol, long seed)
+   {
+      final String[] statements = { "int i = 0;", "i++;", "return res;", "if (x == null)",
+         "{", "}", "for (int j=0; j<n; j++)", "buf[j] = (byte) j;", "// Comment", "" };
+      Random rnd = new Random(seed);
+      StringBuilder sb = new StringBuilder(nbLines*32);
+      int level = 1;
+
+      for (int i=0; i<nbLines; i++)
+      {
+         final String stmt = statements[rnd.nextInt(statements.length)];
+
+         if (stmt.equals("}") && (level > 1))
+            level--;
+
+         // Blank lines have no indentation, some lines have trailing spaces
+         if (stmt.isEmpty() == false)
+         {
+            for (int j=0; j<level; j++)
+               sb.append(indent);
+         }
+
+         sb.append(stmt);
+
+         if (rnd.nextInt(20) == 0)
+            sb.append("  ");
+
+         sb.append(eol);
+
+         if (stmt.equals("{") && (level < 6))
+            level++;
+      }
+
+      return sb.toString().getBytes();
+   }
+
+
+   public static boolean testWhitespaceFold()
+   {
+      final String[] names = { "Spaces", "Tabs", "Mixed tabs and spaces, CRLF" };
+      final String[] indents = { "   ", "\t", "\t  " };
+      final String[] eols = { "\n", "\n", "\r\n" };
+
+      for (int t=0; t<names.length; t++)
+      {
+         byte[] input = generateCode(20000, indents[t], eols[t], 1234+t);
+         ByteFunction f = new WhitespaceFoldCodec();
+         byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+         byte[] reverse = new byte[input.length];
+         SliceByteArray sa1 = new SliceByteArray(input, 0);
+         SliceByteArray sa2 = new SliceByteArray(output, 0);
+         SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+         if (f.forward(sa1, sa2) == false)
+         {
+            System.out.println(names[t] + ": encoding error");
+            return false;
+         }
+
+         System.out.println(names[t] + ": " + input.length + " => " + sa2.index);
+         sa2.length = sa2.index;
+         sa2.index = 0;
+         f = new WhitespaceFoldCodec();
+
+         if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+         {
+            System.out.println("Decoding error");
+            return false;
+         }
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after inverse");
+            return false;
+         }
+      }
+
+      // Sparse whitespace
+      byte[] input = new byte[100000];
+      Random rnd = new Random(55);
+
+      for (int i=0; i<input.length; i++)
+         input[i] = (byte) ((rnd.nextInt(50) == 0) ? ' ' : 'a'+rnd.nextInt(26));
+
+      if (new WhitespaceFoldCodec().forward(new SliceByteArray(input, 0),
+         new SliceByteArray(new byte[input.length], 0)) == true)
+      {
+         System.out.println("Expected failure with sparse whitespace");
+         return false;
+      }
+
+      System.out.println("Identical");
+
+      // Ratio demo
+      try
+      {
+         input = generateCode(100000, "   ", "\n", 1234);
+
+         for (String transform : new String[] { "BWT", "WSFOLD+BWT" })
+         {
+            Map<String, Object> ctx = TestCompressedStream.createContext(transform, "ANS0", 4<<20, false);
+            byte[] output = TestCompressedStream.compress(input, ctx, null);
+            System.out.println(transform+"&ANS0: "+input.length+" => "+output.length);
+            byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after decompression");
+               return false;
+            }
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      return true;
+   }
+
+
+   // Mostly zeros, non zero values at random positions
+   static byte[] generateSparse(int size, int density, long seed)
+   {
+      Random rnd = new Random(seed);
+      byte[] res = new byte[size];
+
+      for (int i=0; i<size; i++)
+      {
+         if (rnd.nextInt(1000) < density)
+            res[i] = (byte) (1+rnd.nextInt(255));
+      }
+
+      return res;
+   }
+
+
+   public static boolean testSparseMask()
+   {
+      // Density in 1/1000
+      final int[] densities = { 0, 1, 10, 100, 400 };
+      final int[] sizes = { 1000, 65536, 100003 };
+
+      for (int density : densities)
+      {
+         for (int size : sizes)
+         {
+            byte[] input = generateSparse(size, density, size+density);
+            ByteFunction f = new SparseMaskCodec();
+            byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+            byte[] reverse = new byte[input.length];
+            SliceByteArray sa1 = new SliceByteArray(input, 0);
+            SliceByteArray sa2 = new SliceByteArray(output, 0);
+            SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+            if (f.forward(sa1, sa2) == false)
+            {
+               System.out.println("Density " + density + "/1000, size " + size + ": encoding error");
+               return false;
+            }
+
+            System.out.println("Density " + density + "/1000: " + input.length + " => " + sa2.index);
+            sa2.length = sa2.index;
+            sa2.index = 0;
+            f = new SparseMaskCodec();
+
+            if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+            {
+               System.out.println("Decoding error");
+               return false;
+            }
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after inverse");
+               return false;
+            }
+         }
+      }
+
+      // Dense data
+      byte[] input = generateSparse(65536, 800, 99);
+
+      if (new SparseMaskCodec().forward(new SliceByteArray(input, 0),
+         new SliceByteArray(new byte[input.length], 0)) == true)
+      {
+         System.out.println("Expected failure with dense data");
+         return false;
+      }
+
+      System.out.println("Identical");
+
+      // Ratio demo
+      try
+      {
+         input = generateSparse(1<<20, 20, 4321);
+
+         for (String transform : new String[] { "RLT", "ZRLT", "SPARSE" })
+         {
+            Map<String, Object> ctx = TestCompressedStream.createContext(transform, "ANS0", 1<<20, false);
+            byte[] output = TestCompressedStream.compress(input, ctx, null);
+            System.out.println(transform+"&ANS0: "+input.length+" => "+output.length);
+            byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after decompression");
+               return false;
+            }
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      return true;
+   }
+
+
+   public static boolean testLZGreedy()
+   {
+      final byte[][] inputs = { generateLogs(20000, 7), TestCompressedStream.generateText(1<<20, 8),
+         generateCode(30000, "   ", "\n", 9) };
+      final String[] names = { "Logs", "Text", "Code" };
+
+      for (int n=0; n<inputs.length; n++)
+      {
+         final byte[] input = inputs[n];
+
+         for (int mode=0; mode<2; mode++)
+         {
+            final boolean greedy = mode == 1;
+            LZCodec f = new LZCodec();
+            f.setLazyMatching(greedy == false);
+            byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+            SliceByteArray sa1 = new SliceByteArray(input, 0);
+            SliceByteArray sa2 = new SliceByteArray(output, 0);
+            final long before = System.nanoTime();
+
+            if (f.forward(sa1, sa2) == false)
+            {
+               System.out.println("Encoding error");
+               return false;
+            }
+
+            final long delay = System.nanoTime() - before;
+            System.out.println(names[n] + ((greedy == true) ? " (greedy): " : " (lazy):   ") +
+               input.length + " => " + sa2.index + " in " + (delay/1000) + " us");
+
+            // Same inverse for both modes
+            byte[] reverse = new byte[input.length];
+            SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+            sa2.length = sa2.index;
+            sa2.index = 0;
+
+            if ((new LZCodec().inverse(sa2, sa3) == false) || (sa3.index != input.length))
+            {
+               System.out.println("Decoding error");
+               return false;
+            }
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after inverse");
+               return false;
+            }
+         }
+      }
+
+      System.out.println("Identical");
+      return true;
+   }
+
+
+   // Log lines drawn from a small set of distinct lines
+   static byte[] generateRepetitiveLogs(int nbLines, int nbDistinct, String eol, long seed)
+   {
+      Random rnd = new Random(seed);
+      String[] lines = new String[nbDistinct];
+
+      for (int i=0; i<nbDistinct; i++)
+         lines[i] = "WARN worker-" + rnd.nextInt(16) + " queue " + rnd.nextInt(1000) + " is full, retrying" + eol;
+
+      StringBuilder sb = new StringBuilder(nbLines*48);
+
+      for (int i=0; i<nbLines; i++)
+         sb.append(lines[rnd.nextInt(nbDistinct)]);
+
+      // Unterminated last line
+      sb.append("WARN shutdown");
+      return sb.toString().getBytes();
+   }
+
+
+   public static boolean testLineDedup()
+   {
+      final byte[][] inputs = { generateRepetitiveLogs(20000, 50, "\n", 11),
+         generateRepetitiveLogs(5000, 2000, "\r\n", 12), generateLogs(20000, 13) };
+      final String[] names = { "Repetitive", "Repetitive, CRLF", "Logs" };
+
+      for (int n=0; n<inputs.length; n++)
+      {
+         byte[] input = inputs[n];
+         ByteFunction f = new LineDedupCodec();
+         byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+         byte[] reverse = new byte[input.length];
+         SliceByteArray sa1 = new SliceByteArray(input, 0);
+         SliceByteArray sa2 = new SliceByteArray(output, 0);
+         SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+         if (f.forward(sa1, sa2) == false)
+         {
+            // Logs with distinct lines may not be compressible
+            if (n < 2)
+            {
+               System.out.println(names[n] + ": encoding error");
+               return false;
+            }
+
+            System.out.println(names[n] + ": no repeated lines, skip");
+            continue;
+         }
+
+         System.out.println(names[n] + ": " + input.length + " => " + sa2.index);
+         sa2.length = sa2.index;
+         sa2.index = 0;
+         f = new LineDedupCodec();
+
+         if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+         {
+            System.out.println("Decoding error");
+            return false;
+         }
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after inverse");
+            return false;
+         }
+      }
+
+      // Unique lines
+      StringBuilder sb = new StringBuilder();
+
+      for (int i=0; i<1000; i++)
+         sb.append("line ").append(i).append('\n');
+
+      byte[] input = sb.toString().getBytes();
+
+      if (new LineDedupCodec().forward(new SliceByteArray(input, 0),
+         new SliceByteArray(new byte[input.length], 0)) == true)
+      {
+         System.out.println("Expected failure with unique lines");
+         return false;
+      }
+
+      System.out.println("Identical");
+
+      // Ratio demo
+      try
+      {
+         input = generateRepetitiveLogs(100000, 200, "\n", 14);
+
+         for (String transform : new String[] { "LZ", "LINEDEDUP+LZ", "BWT", "LINEDEDUP+BWT" })
+         {
+            Map<String, Object> ctx = TestCompressedStream.createContext(transform, "ANS0", 4<<20, false);
+            byte[] output = TestCompressedStream.compress(input, ctx, null);
+            System.out.println(transform+"&ANS0: "+input.length+" => "+output.length);
+            byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after decompression");
+               return false;
+            }
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      return true;
+   }
+
+
+   // Text with embedded base64 (wrapped or not) and hexadecimal blobs
+   static byte[] generateBlobText(int nbBlobs, long seed)
+   {
+      Random rnd = new Random(seed);
+      StringBuilder sb = new StringBuilder();
+      final String[] eols = { "\n", "\r\n" };
+
+      for (int n=0; n<nbBlobs; n++)
+      {
+         byte[] data = new byte[16+rnd.nextInt(600)];
+         rnd.nextBytes(data);
+         sb.append("record ").append(n).append(": ");
+
+         switch (n % 5)
+         {
+            case 0:
+               sb.append("\"payload\": \"").append(Base64.getEncoder().encodeToString(data)).append("\"\n");
+               break;
+
+            case 1:
+               sb.append("-----BEGIN DATA-----\n");
+               sb.append(Base64.getMimeEncoder(64, eols[n&1].getBytes()).encodeToString(data));
+               sb.append(eols[n&1]).append("-----END DATA-----\n");
+               break;
+
+            case 2:
+               sb.append("token=").append(Base64.getUrlEncoder().withoutPadding().encodeToString(data)).append(";\n");
+               break;
+
+            case 3:
+            {
+               StringBuilder hex = new StringBuilder();
+
+               for (byte b : data)
+                  hex.append(String.format("%02x", b&0xFF));
+
+               sb.append("sha=").append(((n&1) == 0) ? hex.toString() : hex.toString().toUpperCase()).append('\n');
+               break;
+            }
+
+            default:
+               sb.append(Base64.getMimeEncoder().encodeToString(data)).append("\r\n");
+         }
+      }
+
+      return sb.toString().getBytes();
+   }
+
+
+   public static boolean testTextBlob()
+   {
+      // Non canonical base64 (non zero trailing bits), mixed case hexadecimal,
+      // odd length hexadecimal and a path
+      String odd = "key: QUJDREVGR0hJSktMTU5PUFFSU1RVVldYWVphYmNkZWZnaGlqa2xtbm9wcXJzdHV2d3h5eg== ; "+
+         "bad: QUJDREVGR0hJSktMTU5PUFFSU1RVVldYWVphYmNkZWZnaGlqa2xtbm9wcXJzdHV2d3h5eh== ; "+
+         "0123456789abcdefABCDEF0123456789abcdef ; 0123456789abcdef0123456789abcdef0 ; "+
+         "/usr/local/lib/jvm/java8/jre/lib/amd64/server/libjvm.so\n";
+      final byte[][] inputs = { generateBlobText(200, 246), generateBlobText(7, 247), odd.getBytes() };
+      final String[] names = { "Blobs", "Few blobs", "Edge cases" };
+
+      for (int n=0; n<inputs.length; n++)
+      {
+         byte[] input = inputs[n];
+         ByteFunction f = new TextBlobDecodeCodec();
+         byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+         byte[] reverse = new byte[input.length];
+         SliceByteArray sa1 = new SliceByteArray(input, 0);
+         SliceByteArray sa2 = new SliceByteArray(output, 0);
+         SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+         if (f.forward(sa1, sa2) == false)
+         {
+            System.out.println(names[n] + ": encoding error");
+            return false;
+         }
+
+         System.out.println(names[n] + ": " + input.length + " => " + sa2.index);
+         sa2.length = sa2.index;
+         sa2.index = 0;
+         f = new TextBlobDecodeCodec();
+
+         if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+         {
+            System.out.println("Decoding error");
+            return false;
+         }
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after inverse");
+            return false;
+         }
+      }
+
+      // No blob
+      byte[] input = TestCompressedStream.generateText(100000, 248);
+
+      if (new TextBlobDecodeCodec().forward(new SliceByteArray(input, 0),
+         new SliceByteArray(new byte[input.length], 0)) == true)
+      {
+         System.out.println("Expected failure without blobs");
+         return false;
+      }
+
+      System.out.println("Identical");
+
+      // Ratio demo
+      try
+      {
+         input = generateBlobText(2000, 249);
+
+         for (String transform : new String[] { "BWT", "TEXTBLOB+BWT", "LZ", "TEXTBLOB+LZ" })
+         {
+            Map<String, Object> ctx = TestCompressedStream.createContext(transform, "ANS0", 4<<20, false);
+            byte[] output = TestCompressedStream.compress(input, ctx, null);
+            System.out.println(transform+"&ANS0: "+input.length+" => "+output.length);
+            byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after decompression");
+               return false;
+            }
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      return true;
+   }
+
+
+   // Multilingual UTF-8 text, optionally with invalid byte sequences
+   static byte[] generateMultilingual(int nbWords, boolean invalid, long seed)
+   {
+      // English, Russian, Greek, Chinese, Japanese, symbols, accented latin
+      final String[] words = { "the ", "compression ",
+         "\u0434\u0430\u043d\u043d\u044b\u0435 ", "\u0441\u0436\u0430\u0442\u0438\u0435 ", "\u0431\u043b\u043e\u043a ",
+         "\u03b4\u03b5\u03b4\u03bf\u03bc\u03ad\u03bd\u03b1 ", "\u03c3\u03c5\u03bc\u03c0\u03af\u03b5\u03c3\u03b7 ",
+         "\u6570\u636e", "\u538b\u7f29", "\u6587\u4ef6\u3002", "\u30c7\u30fc\u30bf", "\u5727\u7e2e", "\u30d5\u30a1\u30a4\u30eb ",
+         "\ud83d\ude00 ", "\ud834\udd1e ", "na\u00efve ", "caf\u00e9 ", "Stra\u00dfe ", "\u017c\u00f3\u0142w ", "\n" };
+      final byte[][] bad = { { (byte) 0xFF }, { (byte) 0x80 }, { (byte) 0xC0, (byte) 0xAF },
+         { (byte) 0xE2, (byte) 0x82 }, { (byte) 0xED, (byte) 0xA0, (byte) 0x80 },
+         { (byte) 0xF4, (byte) 0x90, (byte) 0x80, (byte) 0x80 }, { (byte) 0xF0, (byte) 0x82, (byte) 0x82, (byte) 0xAC } };
+      Random rnd = new Random(seed);
+      ByteArrayOutputStream baos = new ByteArrayOutputStream();
+
+      for (int i=0; i<nbWords; i++)
+      {
+         byte[] w = words[rnd.nextInt(words.length)].getBytes(StandardCharsets.UTF_8);
+         baos.write(w, 0, w.length);
+
+         if ((invalid == true) && (rnd.nextInt(50) == 0))
+         {
+            byte[] b = bad[rnd.nextInt(bad.length)];
+            baos.write(b, 0, b.length);
+         }
+      }
+
+      return baos.toByteArray();
+   }
+
+
+   public static boolean testUTF8Remap()
+   {
+      final byte[][] inputs = { generateMultilingual(50000, false, 21), generateMultilingual(50000, true, 22),
+         generateMultilingual(40, true, 23) };
+      final String[] names = { "Multilingual", "Multilingual, invalid sequences", "Short" };
+
+      for (int n=0; n<inputs.length; n++)
+      {
+         byte[] input = inputs[n];
+         ByteFunction f = new UTF8RemapCodec();
+         byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+         byte[] reverse = new byte[input.length];
+         SliceByteArray sa1 = new SliceByteArray(input, 0);
+         SliceByteArray sa2 = new SliceByteArray(output, 0);
+         SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+         if (f.forward(sa1, sa2) == false)
+         {
+            System.out.println(names[n] + ": encoding error");
+            return false;
+         }
+
+         System.out.println(names[n] + ": " + input.length + " => " + sa2.index);
+         sa2.length = sa2.index;
+         sa2.index = 0;
+         f = new UTF8RemapCodec();
+
+         if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+         {
+            System.out.println("Decoding error");
+            return false;
+         }
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after inverse");
+            return false;
+         }
+      }
+
+      // ASCII only
+      byte[] input = TestCompressedStream.generateText(100000, 24);
+
+      if (new UTF8RemapCodec().forward(new SliceByteArray(input, 0),
+         new SliceByteArray(new byte[input.length], 0)) == true)
+      {
+         System.out.println("Expected failure with ASCII text");
+         return false;
+      }
+
+      System.out.println("Identical");
+
+      // Ratio demo
+      try
+      {
+         input = generateMultilingual(500000, true, 25);
+
+         for (String transform : new String[] { "BWT", "UTF8REMAP+BWT", "LZ", "UTF8REMAP+LZ" })
+         {
+            Map<String, Object> ctx = TestCompressedStream.createContext(transform, "ANS0", 4<<20, false);
+            byte[] output = TestCompressedStream.compress(input, ctx, null);
+            System.out.println(transform+"&ANS0: "+input.length+" => "+output.length);
+            byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after decompression");
+               return false;
+            }
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      return true;
+   }
+
+
+   // CSV of prices with the given number of decimal places
+   static byte[] generatePrices(int nbLines, int scale, long seed)
+   {
+      Random rnd = new Random(seed);
+      StringBuilder sb = new StringBuilder();
+      final String[] tickers = { "AAPL", "MSFT", "KNZ", "ACME" };
+      final String fmt = "%." + scale + "f";
+      double price = 100.0;
+
+      for (int i=0; i<nbLines; i++)
+      {
+         final double change = (rnd.nextInt(2001) - 1000) / 100.0;
+         price = Math.max(price + change, 0.01);
+         sb.append("2017-03-").append(String.format("%02d", 1+i%28)).append(',');
+         sb.append(tickers[rnd.nextInt(tickers.length)]).append(',');
+         sb.append(String.format(Locale.ROOT, fmt, price)).append(',');
+         sb.append(String.format(Locale.ROOT, fmt, change)).append(',');
+         sb.append(rnd.nextInt(100000)).append('\n');
+      }
+
+      return sb.toString().getBytes();
+   }
+
+
+   public static boolean testFixedPoint()
+   {
+      // Exceptions: other scales, leading zeros, negative zero, exponent
+      StringBuilder sb = new StringBuilder();
+
+      for (int i=0; i<20; i++)
+         sb.append("x 1.5 3.125 007.50 -0.00 0.00 -0.01 1.25e3 1.25. v1.50 -.50 --2.00 \u0001\u0002 12.34\n");
+
+      sb.append(new String(generatePrices(500, 2, 252)));
+      final byte[][] inputs = { generatePrices(10000, 2, 250), generatePrices(5000, 3, 251),
+         sb.toString().getBytes() };
+      final int[] scales = { 2, 3, 2 };
+      final String[] names = { "Prices, 2 dp", "Prices, 3 dp", "Exceptions" };
+
+      for (int n=0; n<inputs.length; n++)
+      {
+         byte[] input = inputs[n];
+         ByteFunction f = new FixedPointCodec(scales[n]);
+         byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+         byte[] reverse = new byte[input.length];
+         SliceByteArray sa1 = new SliceByteArray(input, 0);
+         SliceByteArray sa2 = new SliceByteArray(output, 0);
+         SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+         if (f.forward(sa1, sa2) == false)
+         {
+            System.out.println(names[n] + ": encoding error");
+            return false;
+         }
+
+         System.out.println(names[n] + ": " + input.length + " => " + sa2.index);
+         sa2.length = sa2.index;
+         sa2.index = 0;
+
+         // The scale is read from the stream
+         f = new FixedPointCodec(0);
+
+         if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+         {
+            System.out.println("Decoding error");
+            return false;
+         }
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after inverse");
+            return false;
+         }
+      }
+
+      // Wrong scale: too many exceptions
+      byte[] input = generatePrices(1000, 3, 253);
+
+      if (new FixedPointCodec(2).forward(new SliceByteArray(input, 0),
+         new SliceByteArray(new byte[input.length], 0)) == true)
+      {
+         System.out.println("Expected failure with a different scale");
+         return false;
+      }
+
+      System.out.println("Identical");
+
+      // Ratio demo
+      try
+      {
+         input = generatePrices(100000, 2, 254);
+
+         for (String transform : new String[] { "BWT", "FIXEDPOINT+BWT", "DECIMAL+BWT" })
+         {
+            Map<String, Object> ctx = TestCompressedStream.createContext(transform, "ANS0", 4<<20, false);
+            byte[] output = TestCompressedStream.compress(input, ctx, null);
+            System.out.println(transform+"&ANS0: "+input.length+" => "+output.length);
+            byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after decompression");
+               return false;
+            }
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      return true;
+   }
+
+
+   // Sorted set of 'n' ids (32 bit big endian): runs of dense ids (1 in
+   // 'density' on average) separated by gaps (up to 'maxGap')
+   private static byte[] generateIdSet(int n, int density, int maxGap, int seed)
+   {
+      Random rnd = new Random(seed);
+      byte[] res = new byte[4*n];
+      long id = rnd.nextInt(1000);
+
+      for (int i=0; i<n; i++)
+      {
+         id += 1 + rnd.nextInt(density);
+
+         if (rnd.nextInt(1000) == 0)
+            id += rnd.nextInt(maxGap+1);
+
+         res[4*i]   = (byte) (id>>24);
+         res[4*i+1] = (byte) (id>>16);
+         res[4*i+2] = (byte) (id>>8);
+         res[4*i+3] = (byte) id;
+      }
+
+      return res;
+   }
+
+
+   public static boolean testSortedIntSet()
+   {
+      // Ids at both ends of the range (0 to 2^32-1)
+      byte[] bounds = new byte[8000];
+
+      for (int i=0; i<1000; i++)
+      {
+         final int lo = 3 * i;
+         final int hi = -1 - 3*(999-i);
+         bounds[4*i+3] = (byte) lo;
+         bounds[4*i+2] = (byte) (lo>>8);
+         bounds[4000+4*i]   = (byte) (hi>>24);
+         bounds[4000+4*i+1] = (byte) (hi>>16);
+         bounds[4000+4*i+2] = (byte) (hi>>8);
+         bounds[4000+4*i+3] = (byte) hi;
+      }
+
+      final byte[][] inputs = { generateIdSet(100000, 1, 100, 254), generateIdSet(100000, 3, 100000, 255),
+         generateIdSet(20000, 5000, 1<<20, 256), generateIdSet(50000, 2, 1<<24, 257), bounds };
+      final String[] names = { "Dense", "Medium", "Sparse", "Mixed", "Bounds" };
+
+      for (int n=0; n<inputs.length; n++)
+      {
+         byte[] input = inputs[n];
+         ByteFunction f = new SortedIntSetCodec();
+         byte[] output = new byte[f.getMaxEncodedLength(input.length)];
+         byte[] reverse = new byte[input.length];
+         SliceByteArray sa1 = new SliceByteArray(input, 0);
+         SliceByteArray sa2 = new SliceByteArray(output, 0);
+         SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+         if (f.forward(sa1, sa2) == false)
+         {
+            System.out.println(names[n] + ": encoding error");
+            return false;
+         }
+
+         System.out.println(names[n] + ": " + input.length + " => " + sa2.index);
+         sa2.length = sa2.index;
+         sa2.index = 0;
+         f = new SortedIntSetCodec();
+
+         if ((f.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+         {
+            System.out.println("Decoding error");
+            return false;
+         }
+
+         if (Arrays.equals(input, reverse) == false)
+         {
+            System.out.println("Different output after inverse");
+            return false;
+         }
+      }
+
+      // Not a sorted set: unsorted values, duplicate value, ragged tail
+      byte[] unsorted = generateIdSet(1000, 3, 100, 258);
+      unsorted[2000] ^= 0x40;
+      byte[] duplicate = generateIdSet(1000, 3, 100, 259);
+      System.arraycopy(duplicate, 2000, duplicate, 2004, 4);
+      byte[] ragged = Arrays.copyOf(generateIdSet(1000, 3, 100, 260), 3999);
+
+      for (byte[] input : new byte[][] { unsorted, duplicate, ragged })
+      {
+         if (new SortedIntSetCodec().forward(new SliceByteArray(input, 0),
+            new SliceByteArray(new byte[input.length], 0)) == true)
+         {
+            System.out.println("Expected failure with data that is not a sorted set");
+            return false;
+         }
+      }
+
+      System.out.println("Identical");
+
+      // Ratio demo: adaptive encoding vs plain delta (varints)
+      try
+      {
+         for (int n=0; n<4; n++)
+         {
+            byte[] input = inputs[n];
+            byte[] delta = new byte[5*input.length/4];
+            int deltaLength = 0;
+            long prev = 0;
+
+            for (int i=0; i<input.length; i+=4)
+            {
+               final long val = (((input[i]&0xFFL)<<24) | ((input[i+1]&0xFF)<<16) |
+                  ((input[i+2]&0xFF)<<8) | (input[i+3]&0xFF));
+               long d = val - prev;
+               prev = val;
+
+               while (d >= 0x80)
+               {
+                  delta[deltaLength++] = (byte) (0x80|(d&0x7F));
+                  d >>>= 7;
+               }
+
+               delta[deltaLength++] = (byte) d;
+            }
+
+            delta = Arrays.copyOf(delta, deltaLength);
+            Map<String, Object> ctx = TestCompressedStream.createContext("NONE", "ANS0", 1<<20, false);
+            final int deltaSize = TestCompressedStream.compress(delta, ctx, null).length;
+            ctx = TestCompressedStream.createContext("SORTEDSET", "ANS0", 1<<20, false);
+            byte[] output = TestCompressedStream.compress(input, ctx, null);
+            System.out.println(names[n] + ": plain delta " + deltaLength + " (ANS0: " + deltaSize +
+               "), SORTEDSET&ANS0: " + output.length);
+            byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after decompression");
+               return false;
+            }
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      return true;
+   }
+
+
+   public static boolean testProfile()
+   {
+      byte[] sample = TestCompressedStream.generateText(65536, 13579);
+      List<String> names = ByteFunctionFactory.getTransformNames();
+      List<Profiler.ProfileResult> results = Profiler.profile(sample);
+
+      for (Profiler.ProfileResult res : results)
+         System.out.println(res);
+
+      if (results.size() != names.size())
+      {
+         System.out.println("Expected " + names.size() + " results, got " + results.size());
+         return false;
+      }
+
+      for (int i=0; i<names.size(); i++)
+      {
+         Profiler.ProfileResult res = results.get(i);
+
+         if (names.get(i).equals(res.name) == false)
+         {
+            System.out.println("Missing result for " + names.get(i));
+            return false;
+         }
+
+         if (res.roundTrip == false)
+         {
+            System.out.println(res.name + ": round trip failed");
+            return false;
+         }
+      }
+
+      // A sequence known to apply to text
+      Profiler.ProfileResult res = Profiler.profile("BWT+RANK+ZRLT", sample);
+      System.out.println(res);
+
+      if ((res.applied == false) || (res.roundTrip == false) || (res.getRatio() >= 0.5))
+      {
+         System.out.println("Unexpected result for BWT+RANK+ZRLT");
+         return false;
+      }
+
+      return true;
+   }
 }
@@ -15,12 +15,21 @@ limitations under the License.
 
 package kanzi.test;
 
+import java.io.IOException;
 import java.util.Arrays;
+import java.util.Map;
 import java.util.Random;
 import kanzi.ByteTransform;
 import kanzi.SliceByteArray;
+import kanzi.function.BoundedRLT;
 import kanzi.transform.BWTS;
+import kanzi.transform.HilbertCurveCodec;
+import kanzi.transform.LaneGroupCodec;
+import kanzi.transform.MortonOrderCodec;
 import kanzi.transform.SBRT;
+import kanzi.transform.StridedColumnDeltaCodec;
+import kanzi.transform.TransposeCodec;
+import kanzi.transform.ZigZagScanCodec;
 import org.junit.Assert;
 import org.junit.Test;
 
@@ -61,6 +70,48 @@ public class TestTransforms
                System.exit(1);
 
             testSpeed("BWTS");                            
+            System.out.println("\n\nTestBRLT");
+
+            if ((testCorrectness("BRLT") == false) || (testBoundedRLT() == false))
+               System.exit(1);
+
+            testSpeed("BRLT");
+            System.out.println("\n\nTestTRANSPOSE");
+
+            if ((testCorrectness("TRANSPOSE") == false) || (testTranspose() == false))
+               System.exit(1);
+
+            testSpeed("TRANSPOSE");
+            System.out.println("\n\nTestHILBERT");
+
+            if ((testCorrectness("HILBERT") == false) || (testHilbert() == false))
+               System.exit(1);
+
+            testSpeed("HILBERT");
+            System.out.println("\n\nTestCOLDELTA");
+
+            if ((testCorrectness("COLDELTA") == false) || (testColumnDelta() == false))
+               System.exit(1);
+
+            testSpeed("COLDELTA");
+            System.out.println("\n\nTestMORTON");
+
+            if ((testCorrectness("MORTON") == false) || (testMorton() == false))
+               System.exit(1);
+
+            testSpeed("MORTON");
+            System.out.println("\n\nTestLANEGROUP");
+
+            if ((testCorrectness("LANEGROUP") == false) || (testLaneGroup() == false))
+               System.exit(1);
+
+            testSpeed("LANEGROUP");
+            System.out.println("\n\nTestZIGZAG");
+
+            if ((testCorrectness("ZIGZAG") == false) || (testZigZag() == false))
+               System.exit(1);
+
+            testSpeed("ZIGZAG");
          }
          else
          {
@@ -87,6 +138,34 @@ public class TestTransforms
       System.out.println("\n\nTestBWTS");
       Assert.assertTrue(testCorrectness("BWTS"));
       //testSpeed("BWTS"); 
+      System.out.println("\n\nTestBRLT");
+      Assert.assertTrue(testCorrectness("BRLT"));
+      Assert.assertTrue(testBoundedRLT());
+      //testSpeed("BRLT"); 
+      System.out.println("\n\nTestTRANSPOSE");
+      Assert.assertTrue(testCorrectness("TRANSPOSE"));
+      Assert.assertTrue(testTranspose());
+      //testSpeed("TRANSPOSE");
+      System.out.println("\n\nTestHILBERT");
+      Assert.assertTrue(testCorrectness("HILBERT"));
+      Assert.assertTrue(testHilbert());
+      //testSpeed("HILBERT");
+      System.out.println("\n\nTestCOLDELTA");
+      Assert.assertTrue(testCorrectness("COLDELTA"));
+      Assert.assertTrue(testColumnDelta());
+      //testSpeed("COLDELTA");
+      System.out.println("\n\nTestMORTON");
+      Assert.assertTrue(testCorrectness("MORTON"));
+      Assert.assertTrue(testMorton());
+      //testSpeed("MORTON");
+      System.out.println("\n\nTestLANEGROUP");
+      Assert.assertTrue(testCorrectness("LANEGROUP"));
+      Assert.assertTrue(testLaneGroup());
+      //testSpeed("LANEGROUP");
+      System.out.println("\n\nTestZIGZAG");
+      Assert.assertTrue(testCorrectness("ZIGZAG"));
+      Assert.assertTrue(testZigZag());
+      //testSpeed("ZIGZAG");
    }
    
    
@@ -103,6 +182,27 @@ public class TestTransforms
          case "BWTS":
             return new BWTS();
 
+         case "BRLT":
+            return new BoundedRLT();
+
+         case "TRANSPOSE":
+            return new TransposeCodec(3, 5);
+
+         case "HILBERT":
+            return new HilbertCurveCodec(5, 3);
+
+         case "COLDELTA":
+            return new StridedColumnDeltaCodec(7);
+
+         case "MORTON":
+            return new MortonOrderCodec(5, 3);
+
+         case "LANEGROUP":
+            return new LaneGroupCodec(6);
+
+         case "ZIGZAG":
+            return new ZigZagScanCodec(3, 1);
+
          default:
             System.out.println("No such byte transform: "+name);
             return null;
@@ -314,7 +414,421 @@ public class TestTransforms
       return true;
    }
 
-    
+
+   // The bounded RLT must skip noisy data and compress data with runs
+   public static boolean testBoundedRLT()
+   {
+      Random rnd = new Random(12345);
+      byte[] noisy = new byte[65536];
+      byte[] runny = new byte[65536];
+      rnd.nextBytes(noisy);
+
+      for (int n=0; n<runny.length; )
+      {
+         final byte val = (byte) rnd.nextInt(256);
+         final int run = 1 + rnd.nextInt(64);
+
+         for (int i=0; (i<run) && (n<runny.length); i++)
+            runny[n++] = val;
+      }
+
+      // Output buffers are not larger than the input: the transform must never expand
+      SliceByteArray sa1 = new SliceByteArray(noisy, 0);
+      SliceByteArray sa2 = new SliceByteArray(new byte[noisy.length], 0);
+
+      if (new BoundedRLT().forward(sa1, sa2) == true)
+      {
+         System.out.println("Noisy data: transform should have been skipped");
+         return false;
+      }
+
+      if (sa2.index >= noisy.length)
+      {
+         System.out.println("Noisy data: output expanded to "+sa2.index+" bytes");
+         return false;
+      }
+
+      System.out.println("Noisy data: skipped");
+      sa1 = new SliceByteArray(runny, 0);
+      sa2 = new SliceByteArray(new byte[runny.length], 0);
+
+      if ((new BoundedRLT().forward(sa1, sa2) == false) || (sa2.index >= runny.length))
+      {
+         System.out.println("Data with runs: compression failed");
+         return false;
+      }
+
+      System.out.println("Data with runs: "+runny.length+" => "+sa2.index+" bytes");
+      byte[] reverse = new byte[runny.length];
+      sa2.length = sa2.index;
+      sa2.index = 0;
+      SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+
+      if ((new BoundedRLT().inverse(sa2, sa3) == false) || (sa3.index != runny.length))
+      {
+         System.out.println("Decoding error");
+         return false;
+      }
+
+      if (Arrays.equals(runny, reverse) == false)
+      {
+         System.out.println("Different output after inverse");
+         return false;
+      }
+
+      System.out.println("Identical");
+      return true;
+   }
+
+
+   public static boolean testTranspose()
+   {
+      Random rnd = new Random(24680);
+      final int[][] dims = { { 100, 8 }, { 7, 13 }, { 1, 64 }, { 64, 1 } };
+
+      for (int[] dim : dims)
+      {
+         final int size = dim[0] * dim[1];
+
+         // Without and with ragged tail
+         for (int tail=0; tail<2; tail++)
+         {
+            byte[] input = new byte[3*size + tail*(1+rnd.nextInt(size))];
+            rnd.nextBytes(input);
+            byte[] output = new byte[input.length];
+            byte[] reverse = new byte[input.length];
+            SliceByteArray sa1 = new SliceByteArray(input, 0);
+            SliceByteArray sa2 = new SliceByteArray(output, 0);
+            SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+            ByteTransform t = new TransposeCodec(dim[0], dim[1]);
+
+            if ((t.forward(sa1, sa2) == false) || (sa2.index != input.length))
+            {
+               System.out.println("Encoding error");
+               return false;
+            }
+
+            // Check the first matrix
+            for (int i=0; i<dim[0]; i++)
+            {
+               for (int j=0; j<dim[1]; j++)
+               {
+                  if (output[j*dim[0]+i] != input[i*dim[1]+j])
+                  {
+                     System.out.println("Incorrect transposition at ("+i+","+j+")");
+                     return false;
+                  }
+               }
+            }
+
+            sa2.length = sa2.index;
+            sa2.index = 0;
+
+            if ((t.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+            {
+               System.out.println("Decoding error");
+               return false;
+            }
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after inverse");
+               return false;
+            }
+
+            System.out.println(dim[0] + "x" + dim[1] + ((tail == 0) ? "" : " with ragged tail") +
+               ": " + input.length + " bytes, identical");
+         }
+      }
+
+      // Ratio demo: 2048 rows of 4 slowly varying 32 bit values (little endian)
+      final int rows = 2048;
+      final int cols = 16;
+      byte[] input = new byte[8*rows*cols];
+      int[] vals = { 1000000, 50000, 7000000, 300 };
+
+      for (int n=0; n<input.length; n+=4)
+      {
+         final int c = (n/4) & 3;
+         vals[c] += rnd.nextInt(64) - 24;
+
+         for (int j=0; j<4; j++)
+            input[n+j] = (byte) (vals[c] >> (8*j));
+      }
+
+      try
+      {
+         for (String transform : new String[] { "NONE", "TRANSPOSE" })
+         {
+            Map<String, Object> ctx = TestCompressedStream.createContext(transform, "ANS1", 1<<20, false);
+            ctx.put("transposeRows", rows);
+            ctx.put("transposeCols", cols);
+            byte[] output = TestCompressedStream.compress(input, ctx, null);
+            System.out.println(transform + "&ANS1: " + input.length + " => " + output.length);
+            byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after decompression");
+               return false;
+            }
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      return true;
+   }
+
+
+   public static boolean testHilbert()
+   {
+      Random rnd = new Random(13579);
+      final int[][] dims = { { 16, 16 }, { 5, 3 }, { 100, 37 }, { 1, 64 } };
+
+      for (int[] dim : dims)
+      {
+         final int size = dim[0] * dim[1];
+
+         // Without and with ragged tail
+         for (int tail=0; tail<2; tail++)
+         {
+            byte[] input = new byte[2*size + tail*(1+rnd.nextInt(size))];
+            rnd.nextBytes(input);
+
+            // First image: the value is the position (if it fits in a byte)
+            if (size <= 256)
+            {
+               for (int i=0; i<size; i++)
+                  input[i] = (byte) i;
+            }
+
+            byte[] output = new byte[input.length];
+            byte[] reverse = new byte[input.length];
+            SliceByteArray sa1 = new SliceByteArray(input, 0);
+            SliceByteArray sa2 = new SliceByteArray(output, 0);
+            SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+            ByteTransform t = new HilbertCurveCodec(dim[0], dim[1]);
+
+            if ((t.forward(sa1, sa2) == false) || (sa2.index != input.length))
+            {
+               System.out.println("Encoding error");
+               return false;
+            }
+
+            // Along the curve, consecutive points are neighbors (square power of 2 images)
+            if ((size <= 256) && (dim[0] == dim[1]))
+            {
+               for (int i=1; i<size; i++)
+               {
+                  final int p0 = output[i-1] & 0xFF;
+                  final int p1 = output[i] & 0xFF;
+                  final int dist = Math.abs(p0%dim[0] - p1%dim[0]) + Math.abs(p0/dim[0] - p1/dim[0]);
+
+                  if (dist != 1)
+                  {
+                     System.out.println("Points " + (i-1) + " and " + i + " are not neighbors");
+                     return false;
+                  }
+               }
+            }
+
+            sa2.length = sa2.index;
+            sa2.index = 0;
+
+            if ((t.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+            {
+               System.out.println("Decoding error");
+               return false;
+            }
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after inverse");
+               return false;
+            }
+
+            System.out.println(dim[0] + "x" + dim[1] + ((tail == 0) ? "" : " with ragged tail") +
+               ": " + input.length + " bytes, identical");
+         }
+      }
+
+      // Ratio demo: smooth 512x512 image with noise, delta coded in raster
+      // order and in Hilbert order
+      final int w = 512;
+      final int h = 512;
+      byte[] image = new byte[w*h];
+
+      for (int y=0; y<h; y++)
+      {
+         for (int x=0; x<w; x++)
+         {
+            final double v = 128 + 60*Math.sin(x/23.0)*Math.cos(y/17.0) + 30*Math.sin((x+y)/41.0);
+            image[y*w+x] = (byte) (v + rnd.nextInt(5) - 2);
+         }
+      }
+
+      byte[] hilbert = new byte[w*h];
+      new HilbertCurveCodec(w, h).forward(new SliceByteArray(image, 0), new SliceByteArray(hilbert, 0));
+
+      try
+      {
+         final String[] names = { "Raster", "Hilbert" };
+         final byte[][] data = { image, hilbert };
+
+         for (int n=0; n<names.length; n++)
+         {
+            byte[] delta = new byte[w*h];
+            delta[0] = data[n][0];
+
+            for (int i=1; i<delta.length; i++)
+               delta[i] = (byte) (data[n][i] - data[n][i-1]);
+
+            Map<String, Object> ctx = TestCompressedStream.createContext("NONE", "ANS0", 1<<20, false);
+            byte[] output = TestCompressedStream.compress(delta, ctx, null);
+            System.out.println(names[n] + " order + delta, ANS0: " + delta.length + " => " + output.length);
+         }
+
+         // Round trip through a stream
+         Map<String, Object> ctx = TestCompressedStream.createContext("HILBERT", "ANS0", 1<<20, false);
+         ctx.put("hilbertWidth", w);
+         ctx.put("hilbertHeight", h);
+         byte[] output = TestCompressedStream.compress(image, ctx, null);
+         byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+         if (Arrays.equals(image, reverse) == false)
+         {
+            System.out.println("Different output after decompression");
+            return false;
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      return true;
+   }
+
+
+   public static boolean testColumnDelta()
+   {
+      Random rnd = new Random(86420);
+      final int[] strides = { 1, 3, 8, 12, 100, 5000 };
+
+      for (int stride : strides)
+      {
+         // Without and with ragged final record
+         for (int tail=0; tail<2; tail++)
+         {
+            byte[] input = new byte[50*stride + tail*(1+rnd.nextInt(stride))];
+            rnd.nextBytes(input);
+            byte[] output = new byte[input.length];
+            byte[] reverse = new byte[input.length];
+            SliceByteArray sa1 = new SliceByteArray(input, 0);
+            SliceByteArray sa2 = new SliceByteArray(output, 0);
+            SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+            ByteTransform t = new StridedColumnDeltaCodec(stride);
+
+            if ((t.forward(sa1, sa2) == false) || (sa2.index != input.length))
+            {
+               System.out.println("Encoding error");
+               return false;
+            }
+
+            sa2.length = sa2.index;
+            sa2.index = 0;
+
+            if ((t.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+            {
+               System.out.println("Decoding error");
+               return false;
+            }
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after inverse");
+               return false;
+            }
+
+            System.out.println("Stride " + stride + ((tail == 0) ? "" : " with ragged final record") +
+               ": " + input.length + " bytes, identical");
+         }
+      }
+
+      // Ratio demo: table of records (4 byte id, 4 byte price, 2 byte quantity,
+      // 2 byte flags), little endian, delta coded whole block and column wise
+      final int stride = 12;
+      final int n = 50000;
+      byte[] table = new byte[n*stride];
+      int price = 100000;
+
+      for (int i=0; i<n; i++)
+      {
+         final int id = 1000000 + i;
+         price += rnd.nextInt(21) - 10;
+         final int qty = 100 + rnd.nextInt(8);
+         final int idx = i * stride;
+
+         for (int j=0; j<4; j++)
+         {
+            table[idx+j] = (byte) (id >> (8*j));
+            table[idx+4+j] = (byte) (price >> (8*j));
+         }
+
+         table[idx+8] = (byte) qty;
+         table[idx+9] = (byte) (qty >> 8);
+         table[idx+10] = (byte) ((i%16 == 0) ? 1 : 0);
+         table[idx+11] = 0;
+      }
+
+      byte[] delta = new byte[table.length];
+      delta[0] = table[0];
+
+      for (int i=1; i<delta.length; i++)
+         delta[i] = (byte) (table[i] - table[i-1]);
+
+      byte[] colDelta = new byte[table.length];
+      new StridedColumnDeltaCodec(stride).forward(new SliceByteArray(table, 0), new SliceByteArray(colDelta, 0));
+
+      try
+      {
+         final String[] names = { "Plain delta", "Column delta" };
+         final byte[][] data = { delta, colDelta };
+
+         for (int k=0; k<names.length; k++)
+         {
+            Map<String, Object> ctx = TestCompressedStream.createContext("NONE", "ANS0", 1<<20, false);
+            byte[] output = TestCompressedStream.compress(data[k], ctx, null);
+            System.out.println(names[k] + ", ANS0: " + data[k].length + " => " + output.length);
+         }
+
+         // Round trip through a stream
+         Map<String, Object> ctx = TestCompressedStream.createContext("COLDELTA", "ANS0", 1<<20, false);
+         ctx.put("columnStride", stride);
+         byte[] output = TestCompressedStream.compress(table, ctx, null);
+         byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+         if (Arrays.equals(table, reverse) == false)
+         {
+            System.out.println("Different output after decompression");
+            return false;
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      return true;
+   }
+
+
    public static void testSpeed(String name)
    {
       // Test speed
@@ -420,4 +934,407 @@ public class TestTransforms
          System.out.println("Throughput [MB/s]: " + prod * 1000000L / delta2 * 1000L / (1024*1024));
       }
    }
-} 
+
+
+   public static boolean testMorton()
+   {
+      Random rnd = new Random(97531);
+      final int[][] dims = { { 16, 16 }, { 5, 3 }, { 100, 37 }, { 1, 64 } };
+
+      for (int[] dim : dims)
+      {
+         final int size = dim[0] * dim[1];
+
+         // Without and with ragged tail
+         for (int tail=0; tail<2; tail++)
+         {
+            byte[] input = new byte[2*size + tail*(1+rnd.nextInt(size))];
+            rnd.nextBytes(input);
+
+            // First image: the value is the position (if it fits in a byte)
+            if (size <= 256)
+            {
+               for (int i=0; i<size; i++)
+                  input[i] = (byte) i;
+            }
+
+            byte[] output = new byte[input.length];
+            byte[] reverse = new byte[input.length];
+            SliceByteArray sa1 = new SliceByteArray(input, 0);
+            SliceByteArray sa2 = new SliceByteArray(output, 0);
+            SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+            ByteTransform t = new MortonOrderCodec(dim[0], dim[1]);
+
+            if ((t.forward(sa1, sa2) == false) || (sa2.index != input.length))
+            {
+               System.out.println("Encoding error");
+               return false;
+            }
+
+            // Along the curve, each group of 4 points is a 2x2 cell (square power of 2 images)
+            if ((size <= 256) && (dim[0] == dim[1]))
+            {
+               for (int i=0; i<size; i+=4)
+               {
+                  final int p = output[i] & 0xFF;
+
+                  if (((output[i+1]&0xFF) != p+1) || ((output[i+2]&0xFF) != p+dim[0]) ||
+                     ((output[i+3]&0xFF) != p+dim[0]+1))
+                  {
+                     System.out.println("Points " + i + " to " + (i+3) + " are not a 2x2 cell");
+                     return false;
+                  }
+               }
+            }
+
+            sa2.length = sa2.index;
+            sa2.index = 0;
+
+            if ((t.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+            {
+               System.out.println("Decoding error");
+               return false;
+            }
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after inverse");
+               return false;
+            }
+
+            System.out.println(dim[0] + "x" + dim[1] + ((tail == 0) ? "" : " with ragged tail") +
+               ": " + input.length + " bytes, identical");
+         }
+      }
+
+      // Speed and ratio demo: smooth 500x300 image (not a power of 2) with
+      // noise, delta coded in raster, Hilbert and Morton order
+      final int w = 500;
+      final int h = 300;
+      byte[] image = new byte[w*h];
+
+      for (int y=0; y<h; y++)
+      {
+         for (int x=0; x<w; x++)
+         {
+            final double v = 128 + 60*Math.sin(x/23.0)*Math.cos(y/17.0) + 30*Math.sin((x+y)/41.0);
+            image[y*w+x] = (byte) (v + rnd.nextInt(5) - 2);
+         }
+      }
+
+      try
+      {
+         final String[] names = { "Raster", "Hilbert", "Morton" };
+         final ByteTransform[] transforms = { null, new HilbertCurveCodec(w, h), new MortonOrderCodec(w, h) };
+
+         for (int n=0; n<names.length; n++)
+         {
+            byte[] data = image;
+            long delay = 0;
+
+            if (transforms[n] != null)
+            {
+               // The first call includes the computation of the order
+               data = new byte[w*h];
+               final long before = System.nanoTime();
+               transforms[n].forward(new SliceByteArray(image, 0), new SliceByteArray(data, 0));
+               delay = System.nanoTime() - before;
+            }
+
+            byte[] delta = new byte[w*h];
+            delta[0] = data[0];
+
+            for (int i=1; i<delta.length; i++)
+               delta[i] = (byte) (data[i] - data[i-1]);
+
+            Map<String, Object> ctx = TestCompressedStream.createContext("NONE", "ANS0", 1<<20, false);
+            byte[] output = TestCompressedStream.compress(delta, ctx, null);
+            System.out.println(names[n] + " order + delta, ANS0: " + delta.length + " => " + output.length +
+               " (reordering: " + (delay/1000) + " us)");
+         }
+
+         // Round trip through a stream
+         Map<String, Object> ctx = TestCompressedStream.createContext("MORTON", "ANS0", 1<<20, false);
+         ctx.put("mortonWidth", w);
+         ctx.put("mortonHeight", h);
+         byte[] output = TestCompressedStream.compress(image, ctx, null);
+         byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+         if (Arrays.equals(image, reverse) == false)
+         {
+            System.out.println("Different output after decompression");
+            return false;
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      return true;
+   }
+
+
+   public static boolean testLaneGroup()
+   {
+      Random rnd = new Random(25252);
+
+      for (int lanes : new int[] { 4, 8, 16 })
+      {
+         // Multiple of the number of lanes, ragged tails and short blocks
+         final int[] sizes = { 64*lanes, 64*lanes+1, 64*lanes+lanes-1, 1000+rnd.nextInt(1000), lanes-1, 1 };
+
+         for (int size : sizes)
+         {
+            byte[] input = new byte[size];
+            rnd.nextBytes(input);
+            byte[] output = new byte[size];
+            byte[] reverse = new byte[size];
+            SliceByteArray sa1 = new SliceByteArray(input, 0);
+            SliceByteArray sa2 = new SliceByteArray(output, 0);
+            SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+            ByteTransform t = new LaneGroupCodec(lanes);
+
+            if ((t.forward(sa1, sa2) == false) || (sa2.index != size))
+            {
+               System.out.println("Encoding error");
+               return false;
+            }
+
+            // Each lane is stored contiguously, the first lanes get the extra bytes
+            final int n = Math.min(lanes, size);
+            int idx = 0;
+
+            for (int k=0; k<n; k++)
+            {
+               for (int j=k; j<size; j+=n)
+               {
+                  if (output[idx++] != input[j])
+                  {
+                     System.out.println("Incorrect grouping of lane "+k+" at position "+j);
+                     return false;
+                  }
+               }
+            }
+
+            sa2.length = sa2.index;
+            sa2.index = 0;
+
+            if ((t.inverse(sa2, sa3) == false) || (sa3.index != size))
+            {
+               System.out.println("Decoding error");
+               return false;
+            }
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after inverse");
+               return false;
+            }
+
+            System.out.println(lanes + " lanes" + ((size % lanes == 0) ? "" : " with ragged tail") +
+               ": " + size + " bytes, identical");
+         }
+      }
+
+      // Ratio demo: 16 lanes of 8 bit samples, each lane a slowly varying
+      // signal with its own level (EG. interleaved SIMD channels)
+      final int lanes = 16;
+      byte[] input = new byte[lanes*32768+5];
+      int[] vals = new int[lanes];
+
+      for (int k=0; k<lanes; k++)
+         vals[k] = rnd.nextInt(256);
+
+      for (int i=0; i<input.length; i++)
+      {
+         final int k = i % lanes;
+         vals[k] += rnd.nextInt(5) - 2;
+         input[i] = (byte) vals[k];
+      }
+
+      try
+      {
+         for (String transform : new String[] { "NONE", "LANEGROUP", "COLDELTA", "LANEGROUP+COLDELTA" })
+         {
+            Map<String, Object> ctx = TestCompressedStream.createContext(transform, "ANS1", 1<<20, false);
+            ctx.put("laneCount", lanes);
+            ctx.put("columnStride", 1);
+            byte[] output = TestCompressedStream.compress(input, ctx, null);
+            System.out.println(transform + "&ANS1: " + input.length + " => " + output.length);
+            byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after decompression");
+               return false;
+            }
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      return true;
+   }
+
+
+   public static boolean testZigZag()
+   {
+      Random rnd = new Random(25600);
+
+      // JPEG zig-zag scan order (start of the 8x8 table)
+      final int[] jpeg = { 0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5, 12, 19, 26, 33, 40, 48 };
+      byte[] raster = new byte[64];
+
+      for (int i=0; i<64; i++)
+         raster[i] = (byte) i;
+
+      byte[] scan = new byte[64];
+      new ZigZagScanCodec(8, 1).forward(new SliceByteArray(raster, 0), new SliceByteArray(scan, 0));
+
+      for (int k=0; k<jpeg.length; k++)
+      {
+         if (scan[k] != jpeg[k])
+         {
+            System.out.println("Incorrect scan order at position " + k + ": " + scan[k]);
+            return false;
+         }
+      }
+
+      if (scan[63] != 63)
+      {
+         System.out.println("The last coefficient of the scan must be the highest frequency");
+         return false;
+      }
+
+      final int[][] params = { { 8, 2 }, { 8, 1 }, { 4, 4 }, { 16, 2 }, { 3, 1 }, { 1, 4 } };
+
+      for (int[] p : params)
+      {
+         final int size = p[0] * p[0] * p[1];
+
+         // Without and with ragged tail
+         for (int tail=0; tail<2; tail++)
+         {
+            byte[] input = new byte[5*size + tail*(1+rnd.nextInt(size))];
+            rnd.nextBytes(input);
+            byte[] output = new byte[input.length];
+            byte[] reverse = new byte[input.length];
+            SliceByteArray sa1 = new SliceByteArray(input, 0);
+            SliceByteArray sa2 = new SliceByteArray(output, 0);
+            SliceByteArray sa3 = new SliceByteArray(reverse, 0);
+            ByteTransform t = new ZigZagScanCodec(p[0], p[1]);
+
+            if ((t.forward(sa1, sa2) == false) || (sa2.index != input.length))
+            {
+               System.out.println("Encoding error");
+               return false;
+            }
+
+            sa2.length = sa2.index;
+            sa2.index = 0;
+
+            if ((t.inverse(sa2, sa3) == false) || (sa3.index != input.length))
+            {
+               System.out.println("Decoding error");
+               return false;
+            }
+
+            if (Arrays.equals(input, reverse) == false)
+            {
+               System.out.println("Different output after inverse");
+               return false;
+            }
+
+            System.out.println(p[0] + "x" + p[0] + ", " + p[1] + " byte(s) per coefficient" +
+               ((tail == 0) ? "" : " with ragged tail") + ": " + input.length + " bytes, identical");
+         }
+      }
+
+      // Ratio demo: quantized 8x8 DCT coefficients (16 bit, big endian) of a
+      // smooth 512x256 image with noise, in raster and zig-zag order
+      final int w = 512;
+      final int h = 256;
+      final double[][] cos = new double[8][8];
+
+      for (int x=0; x<8; x++)
+      {
+         for (int u=0; u<8; u++)
+            cos[x][u] = Math.cos((2*x+1)*u*Math.PI/16);
+      }
+
+      byte[] coeffs = new byte[2*w*h];
+      int idx = 0;
+
+      for (int by=0; by<h; by+=8)
+      {
+         for (int bx=0; bx<w; bx+=8)
+         {
+            final double[][] pix = new double[8][8];
+
+            for (int y=0; y<8; y++)
+            {
+               for (int x=0; x<8; x++)
+               {
+                  final int px = bx + x;
+                  final int py = by + y;
+                  pix[y][x] = 60*Math.sin(px/37.0)*Math.cos(py/23.0) + 30*Math.sin((px+py)/51.0) + rnd.nextInt(5) - 2;
+               }
+            }
+
+            for (int v=0; v<8; v++)
+            {
+               for (int u=0; u<8; u++)
+               {
+                  double sum = 0;
+
+                  for (int y=0; y<8; y++)
+                  {
+                     for (int x=0; x<8; x++)
+                        sum += pix[y][x] * cos[x][u] * cos[y][v];
+                  }
+
+                  final double cu = (u == 0) ? Math.sqrt(0.5) : 1;
+                  final double cv = (v == 0) ? Math.sqrt(0.5) : 1;
+                  final int q = (int) Math.round(0.25*cu*cv*sum / (1+2*(u+v)));
+                  coeffs[idx++] = (byte) (q>>8);
+                  coeffs[idx++] = (byte) q;
+               }
+            }
+         }
+      }
+
+      try
+      {
+         final String[][] configs = { { "NONE", "ANS1" }, { "ZIGZAG", "ANS1" }, { "ZRLT", "ANS0" },
+            { "ZIGZAG+ZRLT", "ANS0" } };
+
+         for (String[] config : configs)
+         {
+            Map<String, Object> ctx = TestCompressedStream.createContext(config[0], config[1], 1<<20, false);
+            ctx.put("zigzagDim", 8);
+            ctx.put("zigzagWidth", 2);
+            byte[] output = TestCompressedStream.compress(coeffs, ctx, null);
+            System.out.println(config[0] + "&" + config[1] + ": " + coeffs.length + " => " + output.length);
+            byte[] reverse = TestCompressedStream.decompress(output, ctx);
+
+            if (Arrays.equals(coeffs, reverse) == false)
+            {
+               System.out.println("Different output after decompression");
+               return false;
+            }
+         }
+      }
+      catch (IOException e)
+      {
+         System.out.println("Error: " + e.getMessage());
+         return false;
+      }
+
+      return true;
+   }
+}